@@ -0,0 +1,37 @@
+package modbus
+
+import "sync/atomic"
+
+// accountingEnabled turns on the counting of the goroutines, pooled
+// buffers and connections held by the package, so that its tests can
+// check they are all released; gateways run unattended for years, where
+// a slow leak is fatal. It is set by the tests and costs a branch per
+// event otherwise.
+var accountingEnabled bool
+
+// resources counts what the package holds.
+type resources struct {
+	goroutines  int64 // started for connections, and still running
+	buffers     int64 // taken from the bufio pools and not yet returned
+	serverConns int64 // accepted by a Server and still served
+	clientConns int64 // ClientConns whose connection has not yet failed
+}
+
+var accounted resources
+
+// account adds delta to the counter n of accounted.
+func account(n *int64, delta int64) {
+	if accountingEnabled {
+		atomic.AddInt64(n, delta)
+	}
+}
+
+// accountedResources returns the current counts.
+func accountedResources() resources {
+	return resources{
+		goroutines:  atomic.LoadInt64(&accounted.goroutines),
+		buffers:     atomic.LoadInt64(&accounted.buffers),
+		serverConns: atomic.LoadInt64(&accounted.serverConns),
+		clientConns: atomic.LoadInt64(&accounted.clientConns),
+	}
+}
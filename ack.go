@@ -0,0 +1,96 @@
+package modbus
+
+import (
+	"context"
+	"sync"
+)
+
+// A Program is a long running operation started by a request, such as
+// programming a device or running a calibration, whose outcome the
+// master learns by polling. The request it is passed is a copy the
+// Program may keep, with a context that is not cancelled when the
+// connection closes.
+type Program func(ctx context.Context, req *Frame) error
+
+// An Acknowledger serves requests that take longer to execute than a
+// master waits for a response, as the specification's Acknowledge
+// exception provides for. A request with a function code in Programs is
+// answered at once with an Acknowledge exception, and its Program run in
+// the background. Until the Program returns, further Program requests,
+// and PollProgramComplete requests, are answered with SlaveBusy; once it
+// has returned, a PollProgramComplete request is answered with an empty
+// response, or with NegativeAcknowledge if the Program failed. Other
+// requests are passed to Handler.
+type Acknowledger struct {
+	// Handler serves the requests that are not Programs. If nil, they
+	// are answered with IllegalFunction.
+	Handler Handler
+
+	// Programs maps function codes to the Programs they start.
+	Programs map[FunctionCode]Program
+
+	// Exclusive causes requests for Handler to be answered with
+	// SlaveBusy while a Program runs, as devices that are being
+	// programmed do.
+	Exclusive bool
+
+	mu      sync.Mutex // guards the following
+	running bool
+	err     error // of the last Program
+}
+
+func (a *Acknowledger) ServeModbus(w ResponseWriter, r *Frame) {
+	fcode := FunctionCode(r.header.Fcode)
+	if program, ok := a.Programs[fcode]; ok {
+		a.start(w, r, program)
+		return
+	}
+	a.mu.Lock()
+	running, err := a.running, a.err
+	a.mu.Unlock()
+	switch {
+	case fcode == PollProgramComplete && running:
+		w.WriteException(SlaveBusy)
+	case fcode == PollProgramComplete && err != nil:
+		w.WriteException(NegativeAcknowledge)
+	case fcode == PollProgramComplete:
+		w.Write(nil)
+	case running && a.Exclusive:
+		w.WriteException(SlaveBusy)
+	case a.Handler != nil:
+		a.Handler.ServeModbus(w, r)
+	default:
+		w.WriteException(IllegalFunction)
+	}
+}
+
+// start answers r with Acknowledge and runs program in the background,
+// unless a Program is already running.
+func (a *Acknowledger) start(w ResponseWriter, r *Frame, program Program) {
+	a.mu.Lock()
+	if a.running {
+		a.mu.Unlock()
+		w.WriteException(SlaveBusy)
+		return
+	}
+	a.running, a.err = true, nil
+	a.mu.Unlock()
+
+	// the request's data is reused for the next request on the connection
+	req := NewFrame(r.header, append([]byte(nil), r.data...))
+	req.ctx = context.WithoutCancel(r.Context())
+	w.WriteException(Acknowledge)
+	go func() {
+		err := program(req.ctx, req)
+		a.mu.Lock()
+		a.running, a.err = false, err
+		a.mu.Unlock()
+	}()
+}
+
+// Running reports whether a Program is running.
+func (a *Acknowledger) Running() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.running
+}
@@ -0,0 +1,66 @@
+package modbus
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAcknowledger(t *testing.T) {
+	const program = 0x41
+	release := make(chan error)
+	var got []byte
+	a := &Acknowledger{
+		Handler: &RegisterHandler{Holdings: make([]uint16, 1)},
+		Programs: map[FunctionCode]Program{
+			program: func(ctx context.Context, req *Frame) error {
+				got = req.Data()
+				return <-release
+			},
+		},
+		Exclusive: true,
+	}
+	addr := startTestServer(t, &Server{Handler: a})
+
+	start := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x04, 0x01, program, 0xAB, 0xCD}
+	ack := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x03, 0x01, 0x80 | program, byte(Acknowledge)}
+	if resp := exchange(t, addr, start, len(ack)); !bytes.Equal(resp, ack) {
+		t.Errorf("response % X; want % X", resp, ack)
+	}
+
+	poll := []byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x02, 0x01, byte(PollProgramComplete)}
+	busy := []byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x03, 0x01, byte(0x80 | PollProgramComplete), byte(SlaveBusy)}
+	if resp := exchange(t, addr, poll, len(busy)); !bytes.Equal(resp, busy) {
+		t.Errorf("poll while running: response % X; want % X", resp, busy)
+	}
+	read := []byte{0x00, 0x03, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x01}
+	readBusy := []byte{0x00, 0x03, 0x00, 0x00, 0x00, 0x03, 0x01, 0x83, byte(SlaveBusy)}
+	if resp := exchange(t, addr, read, len(readBusy)); !bytes.Equal(resp, readBusy) {
+		t.Errorf("read while running: response % X; want % X", resp, readBusy)
+	}
+
+	release <- nil
+	for a.Running() {
+		time.Sleep(time.Millisecond)
+	}
+	if !bytes.Equal(got, []byte{0xAB, 0xCD}) {
+		t.Errorf("program got data % X", got)
+	}
+	complete := []byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x02, 0x01, byte(PollProgramComplete)}
+	if resp := exchange(t, addr, poll, len(complete)); !bytes.Equal(resp, complete) {
+		t.Errorf("poll when complete: response % X; want % X", resp, complete)
+	}
+
+	// a failed program is negatively acknowledged
+	exchange(t, addr, start, len(ack))
+	release <- errors.New("calibration failed")
+	for a.Running() {
+		time.Sleep(time.Millisecond)
+	}
+	nak := []byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x03, 0x01, byte(0x80 | PollProgramComplete), byte(NegativeAcknowledge)}
+	if resp := exchange(t, addr, poll, len(nak)); !bytes.Equal(resp, nak) {
+		t.Errorf("poll after failure: response % X; want % X", resp, nak)
+	}
+}
@@ -0,0 +1,84 @@
+package modbus
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// An Addressing relates the addresses devices document to the protocol
+// addresses carried by requests. Each field is the documented address of
+// protocol address 0 of a table, so that a documented address is the
+// protocol address plus the table's base. The zero value is protocol
+// addressing, every table starting at 0.
+//
+// Addressings are taken by Client, RegisterHandler and RegisterMap, whose
+// addresses are then documented ones.
+type Addressing struct {
+	Coils            uint16 `json:"coils"`
+	DiscreteInputs   uint16 `json:"discrete_inputs"`
+	InputRegisters   uint16 `json:"input_registers"`
+	HoldingRegisters uint16 `json:"holding_registers"`
+}
+
+var (
+	// OneBasedAddressing numbers the coils and registers of every table
+	// from 1, as the data model of the specification does.
+	OneBasedAddressing = Addressing{1, 1, 1, 1}
+
+	// ModiconAddressing is the five digit convention of many device
+	// manuals: coils from 00001, discrete inputs from 10001, input
+	// registers from 30001 and holding registers from 40001.
+	ModiconAddressing = Addressing{1, 10001, 30001, 40001}
+)
+
+// Base returns the documented address of protocol address 0 of table t.
+func (a Addressing) Base(t Table) uint16 {
+	switch t {
+	case CoilTable:
+		return a.Coils
+	case DiscreteInputTable:
+		return a.DiscreteInputs
+	case InputRegisterTable:
+		return a.InputRegisters
+	}
+	return a.HoldingRegisters
+}
+
+// Protocol returns the protocol address of the documented address addr
+// of table t, failing for addresses below the table's base.
+func (a Addressing) Protocol(t Table, addr uint16) (uint16, error) {
+	base := a.Base(t)
+	if addr < base {
+		return 0, fmt.Errorf("modbus: %v address %d below base %d", t, addr, base)
+	}
+	return addr - base, nil
+}
+
+// Documented returns the documented address of the protocol address addr
+// of table t. It may exceed the range of a uint16.
+func (a Addressing) Documented(t Table, addr uint16) int {
+	return int(addr) + int(a.Base(t))
+}
+
+var addressingName = map[string]Addressing{
+	"protocol":  {},
+	"one-based": OneBasedAddressing,
+	"modicon":   ModiconAddressing,
+}
+
+// UnmarshalJSON decodes an Addressing given as an object of the bases of
+// the tables, or as the name of a convention: "protocol", "one-based" or
+// "modicon".
+func (a *Addressing) UnmarshalJSON(data []byte) error {
+	var name string
+	if json.Unmarshal(data, &name) == nil {
+		v, ok := addressingName[name]
+		if !ok {
+			return fmt.Errorf("modbus: unknown addressing %q", name)
+		}
+		*a = v
+		return nil
+	}
+	type bases Addressing // without the method
+	return json.Unmarshal(data, (*bases)(a))
+}
@@ -0,0 +1,132 @@
+package modbus
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAddressing(t *testing.T) {
+	a := ModiconAddressing
+	for _, tt := range []struct {
+		t          Table
+		documented uint16
+		protocol   uint16
+	}{
+		{CoilTable, 1, 0},
+		{DiscreteInputTable, 10005, 4},
+		{InputRegisterTable, 30001, 0},
+		{HoldingRegisterTable, 40108, 107},
+	} {
+		addr, err := a.Protocol(tt.t, tt.documented)
+		if err != nil || addr != tt.protocol {
+			t.Errorf("Protocol(%v, %d) = %d, %v; want %d", tt.t, tt.documented, addr, err, tt.protocol)
+		}
+		if d := a.Documented(tt.t, tt.protocol); d != int(tt.documented) {
+			t.Errorf("Documented(%v, %d) = %d; want %d", tt.t, tt.protocol, d, tt.documented)
+		}
+	}
+	if _, err := a.Protocol(HoldingRegisterTable, 30001); err == nil {
+		t.Errorf("Protocol of a holding register below the base succeeded")
+	}
+	if d := a.Documented(HoldingRegisterTable, 0xFFFF); d != 0xFFFF+40001 {
+		t.Errorf("Documented of the last holding register = %d", d)
+	}
+
+	for s, want := range map[string]Addressing{
+		`"modicon"`:                   ModiconAddressing,
+		`"one-based"`:                 OneBasedAddressing,
+		`"protocol"`:                  {},
+		`{"holding_registers": 1000}`: {HoldingRegisters: 1000},
+	} {
+		var got Addressing
+		if err := json.Unmarshal([]byte(s), &got); err != nil || got != want {
+			t.Errorf("Unmarshal(%s) = %v, %v; want %v", s, got, err, want)
+		}
+	}
+	var got Addressing
+	if err := json.Unmarshal([]byte(`"two-based"`), &got); err == nil {
+		t.Errorf("unknown addressing decoded as %v", got)
+	}
+}
+
+func TestClientAddressing(t *testing.T) {
+	h := &RegisterHandler{Holdings: []uint16{1, 2, 3}, Coils: make([]bool, 2)}
+	c := dialTestServer(t, h)
+	c.Addressing = ModiconAddressing
+	ctx := context.Background()
+
+	values, err := c.ReadHoldingRegisters(ctx, 1, 40002, 2)
+	if err != nil || !reflect.DeepEqual(values, []uint16{2, 3}) {
+		t.Errorf("ReadHoldingRegisters(40002) = %v, %v; want [2 3]", values, err)
+	}
+	if err := c.WriteSingleCoil(ctx, 1, 2, true); err != nil || !h.Coils[1] {
+		t.Errorf("WriteSingleCoil(2) = %v; coils %v", err, h.Coils)
+	}
+	if _, err := c.ReadHoldingRegisters(ctx, 1, 2, 1); err == nil {
+		t.Errorf("read of holding register 2 below base 40001 succeeded")
+	}
+}
+
+func TestRegisterMapAddressing(t *testing.T) {
+	h, err := LoadRegisterMap(strings.NewReader(`{
+		"addressing": "modicon",
+		"holding_registers": [{"start": 40001, "values": [7, 8]}],
+		"points": [{"name": "level", "table": "input registers", "address": 30003, "type": "uint16", "value": 9}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []uint16{7, 8}; !reflect.DeepEqual(h.Holdings, expected) {
+		t.Errorf("holding registers %v; want %v", h.Holdings, expected)
+	}
+	if expected := []uint16{0, 0, 9}; !reflect.DeepEqual(h.Inputs, expected) {
+		t.Errorf("input registers %v; want %v", h.Inputs, expected)
+	}
+	if h.Addressing != ModiconAddressing {
+		t.Errorf("handler addressing %v; want %v", h.Addressing, ModiconAddressing)
+	}
+
+	var buf bytes.Buffer
+	if err := SaveRegisterMap(&buf, h); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := LoadRegisterMap(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Addressing != ModiconAddressing || !reflect.DeepEqual(saved.Holdings, h.Holdings) || !reflect.DeepEqual(saved.Inputs, h.Inputs) {
+		t.Errorf("saved map loads with addressing %v, holding registers %v, input registers %v",
+			saved.Addressing, saved.Holdings, saved.Inputs)
+	}
+
+	_, err = LoadRegisterMap(strings.NewReader(`{
+		"addressing": "modicon",
+		"holding_registers": [{"start": 0, "count": 1}]
+	}`))
+	if err == nil {
+		t.Errorf("map with a holding register below 40001 loaded")
+	}
+}
+
+func TestProtectDocumented(t *testing.T) {
+	h := &RegisterHandler{Holdings: make([]uint16, 4), Addressing: ModiconAddressing}
+	h.Protect(HoldingRegisterTable, 1, 1) // protocol addresses still
+	if err := h.ProtectDocumented(HoldingRegisterTable, 40003, 40003); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.ProtectDocumented(HoldingRegisterTable, 0, 40003); err == nil {
+		t.Errorf("ProtectDocumented below the base succeeded")
+	}
+	c := dialTestServer(t, h)
+	ctx := context.Background()
+	for addr, want := range map[uint16]error{0: nil, 1: ErrIllegalDataAddress, 2: ErrIllegalDataAddress, 3: nil} {
+		if err := c.WriteSingleRegister(ctx, 1, addr, 5); !errors.Is(err, want) {
+			t.Errorf("WriteSingleRegister(%d) = %v; want %v", addr, err, want)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package modbus
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Bits of an alarm's status register.
+const (
+	AlarmActive       = 1 << 0 // the alarm condition holds
+	AlarmAcknowledged = 1 << 1 // the last activation has been acknowledged
+)
+
+// An Alarm is a condition over a coil, input or register of a device,
+// presented to masters in two holding registers: a status register
+// holding AlarmActive and AlarmAcknowledged, and a count of activations.
+//
+// An alarm becoming active is unacknowledged until a master writes a
+// value with the AlarmAcknowledged bit set to its status register, or
+// Acknowledge is called; it stays unacknowledged after the condition
+// clears, so that short lived alarms are not missed. Other writes to the
+// status register are ignored. The count wraps at 65535 and may be reset
+// by writing it.
+type Alarm struct {
+	Name string `json:"name"`
+
+	// Source is the watched coil, discrete input or register.
+	Source Location `json:"source"`
+
+	// A register source is active while its value is above High or
+	// below Low; either may be nil. A coil or discrete input source is
+	// active while set.
+	High *uint16 `json:"high,omitempty"`
+	Low  *uint16 `json:"low,omitempty"`
+
+	// Status and Count are the holding registers presenting the alarm.
+	Status uint16 `json:"status"`
+	Count  uint16 `json:"count"`
+}
+
+func (a *Alarm) isActive(v uint16) bool {
+	if a.Source.Table == CoilTable || a.Source.Table == DiscreteInputTable {
+		return v != 0
+	}
+	return a.High != nil && v > *a.High || a.Low != nil && v < *a.Low
+}
+
+// An AlarmState is the state of an alarm.
+type AlarmState struct {
+	Active       bool
+	Acknowledged bool
+	Count        uint16
+}
+
+func (s AlarmState) status() uint16 {
+	var v uint16
+	if s.Active {
+		v |= AlarmActive
+	}
+	if s.Acknowledged {
+		v |= AlarmAcknowledged
+	}
+	return v
+}
+
+type alarm struct {
+	Alarm
+	state AlarmState
+}
+
+// An AlarmStore is a DataStore maintaining the status and count
+// registers of alarms over the DataStore it wraps. Sources are evaluated
+// as values are written through the AlarmStore, including writes by
+// masters when it serves a RegisterHandler. Writes made to the wrapped
+// store directly are not seen.
+//
+// Sources in the input tables are only updated when written with
+// WriteDiscreteInputs or WriteInputRegisters, which require the wrapped
+// store to implement InputWriter.
+type AlarmStore struct {
+	DataStore
+
+	mu     sync.Mutex
+	alarms []*alarm
+}
+
+// NewAlarmStore returns an AlarmStore raising alarms over s. It
+// evaluates every alarm and writes its registers, failing if a source or
+// register does not exist or two alarms share a name or register.
+func NewAlarmStore(ctx context.Context, s DataStore, alarms ...Alarm) (*AlarmStore, error) {
+	as := &AlarmStore{DataStore: s}
+	names := make(map[string]bool)
+	regs := make(map[uint16]bool)
+	for _, a := range alarms {
+		if names[a.Name] {
+			return nil, fmt.Errorf("modbus: duplicate alarm %s", a.Name)
+		}
+		if a.Status == a.Count || regs[a.Status] || regs[a.Count] {
+			return nil, fmt.Errorf("modbus: alarm %s shares a register", a.Name)
+		}
+		names[a.Name], regs[a.Status], regs[a.Count] = true, true, true
+
+		v, err := readValue(ctx, s, a.Source)
+		if err != nil {
+			return nil, fmt.Errorf("modbus: alarm %s source: %w", a.Name, err)
+		}
+		al := &alarm{Alarm: a, state: AlarmState{Acknowledged: true}}
+		if a.isActive(v) {
+			al.state = AlarmState{Active: true, Count: 1}
+		}
+		if err := as.present(ctx, al); err != nil {
+			return nil, fmt.Errorf("modbus: alarm %s registers: %w", a.Name, err)
+		}
+		as.alarms = append(as.alarms, al)
+	}
+	return as, nil
+}
+
+// readValue returns the value at loc, 0 or 1 for bits.
+func readValue(ctx context.Context, s DataStore, loc Location) (uint16, error) {
+	p := Point{Table: loc.Table, Address: loc.Address, Type: Uint16}
+	if loc.Table == CoilTable || loc.Table == DiscreteInputTable {
+		p.Type = Bool
+	}
+	v, err := p.get(ctx, s)
+	return uint16(v), err
+}
+
+// present writes the status and count registers of a.
+func (s *AlarmStore) present(ctx context.Context, a *alarm) error {
+	if err := s.DataStore.WriteHoldingRegisters(ctx, a.Status, []uint16{a.state.status()}); err != nil {
+		return err
+	}
+	return s.DataStore.WriteHoldingRegisters(ctx, a.Count, []uint16{a.state.Count})
+}
+
+// State returns the state of the alarm called name.
+func (s *AlarmStore) State(name string) (AlarmState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, a := range s.alarms {
+		if a.Name == name {
+			return a.state, true
+		}
+	}
+	return AlarmState{}, false
+}
+
+// Acknowledge acknowledges the alarm called name.
+func (s *AlarmStore) Acknowledge(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, a := range s.alarms {
+		if a.Name == name {
+			a.state.Acknowledged = true
+			return s.present(ctx, a)
+		}
+	}
+	return fmt.Errorf("modbus: unknown alarm %s", name)
+}
+
+// written updates the alarms after values were written at addr of
+// table t.
+func (s *AlarmStore) written(ctx context.Context, t Table, addr uint16, values []uint16) error {
+	r := AddressRange{t, addr, addr + uint16(len(values)-1)}
+	for _, a := range s.alarms {
+		changed := false
+		if t == HoldingRegisterTable {
+			if r.Contains(t, a.Status, 1) {
+				if values[a.Status-addr]&AlarmAcknowledged != 0 {
+					a.state.Acknowledged = true
+				}
+				changed = true // restore the status register
+			}
+			if r.Contains(t, a.Count, 1) {
+				a.state.Count = values[a.Count-addr]
+			}
+		}
+		if r.Contains(a.Source.Table, a.Source.Address, 1) {
+			v, err := readValue(ctx, s.DataStore, a.Source)
+			if err != nil {
+				return err
+			}
+			if active := a.isActive(v); active != a.state.Active {
+				a.state.Active = active
+				if active {
+					a.state.Acknowledged = false
+					a.state.Count++
+				}
+				changed = true
+			}
+		}
+		if changed {
+			if err := s.present(ctx, a); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *AlarmStore) WriteCoils(ctx context.Context, addr uint16, values []bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.DataStore.WriteCoils(ctx, addr, values); err != nil {
+		return err
+	}
+	return s.written(ctx, CoilTable, addr, bitsToValues(values))
+}
+
+func (s *AlarmStore) WriteHoldingRegisters(ctx context.Context, addr uint16, values []uint16) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.DataStore.WriteHoldingRegisters(ctx, addr, values); err != nil {
+		return err
+	}
+	return s.written(ctx, HoldingRegisterTable, addr, values)
+}
+
+func (s *AlarmStore) WriteDiscreteInputs(ctx context.Context, addr uint16, values []bool) error {
+	iw, ok := s.DataStore.(InputWriter)
+	if !ok {
+		return ErrInputsReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := iw.WriteDiscreteInputs(ctx, addr, values); err != nil {
+		return err
+	}
+	return s.written(ctx, DiscreteInputTable, addr, bitsToValues(values))
+}
+
+func (s *AlarmStore) WriteInputRegisters(ctx context.Context, addr uint16, values []uint16) error {
+	iw, ok := s.DataStore.(InputWriter)
+	if !ok {
+		return ErrInputsReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := iw.WriteInputRegisters(ctx, addr, values); err != nil {
+		return err
+	}
+	return s.written(ctx, InputRegisterTable, addr, values)
+}
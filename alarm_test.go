@@ -0,0 +1,75 @@
+package modbus
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestAlarmStore(t *testing.T) {
+	ctx := context.Background()
+	high := uint16(100)
+	h := &RegisterHandler{Inputs: make([]uint16, 2), Holdings: make([]uint16, 4)}
+	s, err := NewAlarmStore(ctx, h.DataStore(), Alarm{
+		Name:   "temperature",
+		Source: Location{InputRegisterTable, 1},
+		High:   &high,
+		Status: 2,
+		Count:  3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Store = s
+
+	check := func(step string, status, count uint16) {
+		t.Helper()
+		regs, _ := s.ReadHoldingRegisters(ctx, 2, 2)
+		if expected := []uint16{status, count}; !reflect.DeepEqual(regs, expected) {
+			t.Errorf("%s: registers %v; want %v", step, regs, expected)
+		}
+	}
+	check("initially", AlarmAcknowledged, 0)
+
+	s.WriteInputRegisters(ctx, 0, []uint16{0, 120})
+	check("raised", AlarmActive, 1)
+
+	// writes without the acknowledge bit are ignored
+	s.WriteHoldingRegisters(ctx, 2, []uint16{0xFFFF &^ AlarmAcknowledged})
+	check("ignored write", AlarmActive, 1)
+
+	s.WriteInputRegisters(ctx, 1, []uint16{90})
+	check("cleared", 0, 1)
+
+	s.WriteHoldingRegisters(ctx, 2, []uint16{AlarmAcknowledged})
+	check("acknowledged", AlarmAcknowledged, 1)
+
+	s.WriteInputRegisters(ctx, 1, []uint16{101})
+	check("raised again", AlarmActive, 2)
+	if err := s.Acknowledge(ctx, "temperature"); err != nil {
+		t.Fatal(err)
+	}
+	check("acknowledged by the slave", AlarmActive|AlarmAcknowledged, 2)
+	if state, _ := s.State("temperature"); state != (AlarmState{Active: true, Acknowledged: true, Count: 2}) {
+		t.Errorf("State = %+v", state)
+	}
+
+	// the count may be reset
+	s.WriteHoldingRegisters(ctx, 3, []uint16{0})
+	check("count reset", AlarmActive|AlarmAcknowledged, 0)
+}
+
+func TestAlarmStoreInvalid(t *testing.T) {
+	ctx := context.Background()
+	h := &RegisterHandler{Coils: make([]bool, 1), Holdings: make([]uint16, 4)}
+	for _, alarms := range [][]Alarm{
+		{{Name: "a", Source: Location{CoilTable, 5}, Status: 0, Count: 1}},
+		{{Name: "a", Source: Location{CoilTable, 0}, Status: 0, Count: 9}},
+		{{Name: "a", Source: Location{CoilTable, 0}, Status: 0, Count: 1},
+			{Name: "b", Source: Location{CoilTable, 0}, Status: 1, Count: 2}},
+	} {
+		if _, err := NewAlarmStore(ctx, h.DataStore(), alarms...); err == nil {
+			t.Errorf("NewAlarmStore(%+v) should fail", alarms)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// A Location addresses a single coil or register of a device.
+type Location struct {
+	Table   Table
+	Address uint16
+}
+
+func (l Location) String() string {
+	return fmt.Sprintf("%v[%d]", l.Table, l.Address)
+}
+
+// less orders locations by table then address.
+func (l Location) less(o Location) bool {
+	if l.Table != o.Table {
+		return l.Table < o.Table
+	}
+	return l.Address < o.Address
+}
+
+// A Configuration describes the desired state of a device's coils and
+// holding registers.
+type Configuration struct {
+	// Values maps each location to its desired value. Coils are
+	// switched on by any non zero value. Only CoilTable and
+	// HoldingRegisterTable locations may be configured.
+	Values map[Location]uint16
+
+	// Requires lists, for a location, the locations that must be
+	// written before it, e.g. an unlock register ahead of protected
+	// setpoints. Locations absent from Values are ignored.
+	Requires map[Location][]Location
+}
+
+// An ApplyResult records the outcome of configuring a single location.
+type ApplyResult struct {
+	Location Location
+	Old      uint16 // value read from the device before applying
+	New      uint16 // desired value
+	Written  bool   // a write was issued because Old differed from New
+	Err      error  // read, write or verification failure
+}
+
+// An ApplyReport lists the outcome for every configured location in the
+// order the locations were applied.
+type ApplyReport struct {
+	Results []ApplyResult
+}
+
+// Failed returns the results that carry an error.
+func (r *ApplyReport) Failed() []ApplyResult {
+	var failed []ApplyResult
+	for _, res := range r.Results {
+		if res.Err != nil {
+			failed = append(failed, res)
+		}
+	}
+	return failed
+}
+
+var (
+	// ErrVerifyFailed is recorded when a value read back after a write
+	// differs from the value written.
+	ErrVerifyFailed = errors.New("modbus: value read back differs from value written")
+
+	// ErrDependencyFailed is recorded for a location that was not
+	// written because a location it requires could not be applied.
+	ErrDependencyFailed = errors.New("modbus: required location failed")
+)
+
+// ApplyConfiguration brings unit uid into the state described by cfg. It
+// reads each configured location, writes those that differ from the
+// desired value in dependency order, reading every write back to verify
+// it, and returns a report of what was done.
+//
+// The returned error is non nil only when cfg itself is invalid; per
+// location failures are recorded in the report and do not stop the
+// remaining, independent, locations from being applied.
+func (c *Client) ApplyConfiguration(ctx context.Context, uid uint8, cfg *Configuration) (*ApplyReport, error) {
+	order, err := cfg.order()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ApplyReport{}
+	failed := make(map[Location]bool)
+	for _, loc := range order {
+		res := ApplyResult{Location: loc, New: cfg.Values[loc]}
+		if loc.Table == CoilTable && res.New != 0 {
+			res.New = 1
+		}
+
+		for _, dep := range cfg.Requires[loc] {
+			if failed[dep] {
+				res.Err = ErrDependencyFailed
+			}
+		}
+		if res.Err == nil {
+			res.Old, res.Err = c.readLocation(ctx, uid, loc)
+		}
+		if res.Err == nil && res.Old != res.New {
+			res.Written = true
+			res.Err = c.writeLocation(ctx, uid, loc, res.New)
+			if res.Err == nil {
+				var v uint16
+				if v, res.Err = c.readLocation(ctx, uid, loc); res.Err == nil && v != res.New {
+					res.Err = ErrVerifyFailed
+				}
+			}
+		}
+
+		if res.Err != nil {
+			failed[loc] = true
+		}
+		report.Results = append(report.Results, res)
+	}
+	return report, nil
+}
+
+func (c *Client) readLocation(ctx context.Context, uid uint8, loc Location) (uint16, error) {
+	if loc.Table == CoilTable {
+		coils, err := c.ReadCoils(ctx, uid, loc.Address, 1)
+		if err != nil || !coils[0] {
+			return 0, err
+		}
+		return 1, nil
+	}
+	values, err := c.ReadHoldingRegisters(ctx, uid, loc.Address, 1)
+	if err != nil {
+		return 0, err
+	}
+	return values[0], nil
+}
+
+func (c *Client) writeLocation(ctx context.Context, uid uint8, loc Location, value uint16) error {
+	if loc.Table == CoilTable {
+		return c.WriteSingleCoil(ctx, uid, loc.Address, value != 0)
+	}
+	return c.WriteSingleRegister(ctx, uid, loc.Address, value)
+}
+
+// order returns the configured locations sorted so that every location
+// follows the locations it requires. Independent locations are ordered
+// by table and address.
+func (cfg *Configuration) order() ([]Location, error) {
+	pending := make(map[Location]int) // number of unapplied requirements
+	dependents := make(map[Location][]Location)
+	for loc := range cfg.Values {
+		if loc.Table != CoilTable && loc.Table != HoldingRegisterTable {
+			return nil, fmt.Errorf("modbus: %v is not writable", loc)
+		}
+		n := 0
+		for _, dep := range cfg.Requires[loc] {
+			if _, ok := cfg.Values[dep]; ok {
+				n++
+				dependents[dep] = append(dependents[dep], loc)
+			}
+		}
+		pending[loc] = n
+	}
+
+	var ready, order []Location
+	for loc, n := range pending {
+		if n == 0 {
+			ready = append(ready, loc)
+		}
+	}
+	for len(ready) > 0 {
+		sort.Slice(ready, func(i, j int) bool { return ready[i].less(ready[j]) })
+		loc := ready[0]
+		ready = ready[1:]
+		order = append(order, loc)
+		for _, d := range dependents[loc] {
+			if pending[d]--; pending[d] == 0 {
+				ready = append(ready, d)
+			}
+		}
+	}
+
+	if len(order) != len(pending) {
+		return nil, errors.New("modbus: configuration requirements contain a cycle")
+	}
+	return order, nil
+}
@@ -0,0 +1,98 @@
+package modbus
+
+import (
+	"context"
+	"testing"
+)
+
+// unlockHandler only accepts writes to holding registers above 0 while
+// holding register 0 contains the unlock code 0xA5A5.
+type unlockHandler struct {
+	*RegisterHandler
+}
+
+func (h unlockHandler) ServeModbus(w ResponseWriter, r *Frame) {
+	if FunctionCode(r.header.Fcode) == WriteSingleRegister && r.data[1] != 0 && h.Holdings[0] != 0xA5A5 {
+		w.WriteException(IllegalDataAddress)
+		return
+	}
+	h.RegisterHandler.ServeModbus(w, r)
+}
+
+func TestApplyConfiguration(t *testing.T) {
+	h := &RegisterHandler{Coils: make([]bool, 4), Holdings: []uint16{0, 0x0010, 0x0020}}
+	c := dialTestServer(t, unlockHandler{h})
+
+	unlock := Location{HoldingRegisterTable, 0}
+	cfg := &Configuration{
+		Values: map[Location]uint16{
+			{HoldingRegisterTable, 2}: 0x0020,
+			{HoldingRegisterTable, 1}: 0x1234,
+			{CoilTable, 3}:            1,
+			unlock:                    0xA5A5,
+		},
+		Requires: map[Location][]Location{
+			{HoldingRegisterTable, 1}: {unlock},
+			{HoldingRegisterTable, 2}: {unlock},
+		},
+	}
+
+	report, err := c.ApplyConfiguration(context.Background(), 0xFF, cfg)
+	if err != nil {
+		t.Fatalf("err should be nil not %v", err)
+	}
+	if failed := report.Failed(); len(failed) != 0 {
+		t.Fatalf("no location should fail: %v", failed)
+	}
+
+	expected := []Location{{CoilTable, 3}, unlock, {HoldingRegisterTable, 1}, {HoldingRegisterTable, 2}}
+	for i, res := range report.Results {
+		if res.Location != expected[i] {
+			t.Errorf("location %d should be %v not %v", i, expected[i], res.Location)
+		}
+	}
+	if report.Results[3].Written {
+		t.Errorf("unchanged register should not be written")
+	}
+	if h.Holdings[1] != 0x1234 || !h.Coils[3] {
+		t.Errorf("configuration not applied")
+	}
+}
+
+func TestApplyConfigurationDependencyFailed(t *testing.T) {
+	h := &RegisterHandler{Holdings: []uint16{0, 0x0010}}
+	c := dialTestServer(t, unlockHandler{h})
+
+	cfg := &Configuration{
+		Values: map[Location]uint16{
+			{HoldingRegisterTable, 1}: 0x1234,
+			{HoldingRegisterTable, 5}: 0xA5A5,
+		},
+		Requires: map[Location][]Location{
+			{HoldingRegisterTable, 1}: {{HoldingRegisterTable, 5}},
+		},
+	}
+
+	report, err := c.ApplyConfiguration(context.Background(), 0xFF, cfg)
+	if err != nil {
+		t.Fatalf("err should be nil not %v", err)
+	}
+	if report.Results[1].Err != ErrDependencyFailed {
+		t.Errorf("dependent location should fail with ErrDependencyFailed not %v", report.Results[1].Err)
+	}
+	if h.Holdings[1] != 0x0010 {
+		t.Errorf("dependent register should not be written")
+	}
+}
+
+func TestApplyConfigurationCycle(t *testing.T) {
+	a, b := Location{HoldingRegisterTable, 1}, Location{HoldingRegisterTable, 2}
+	cfg := &Configuration{
+		Values:   map[Location]uint16{a: 1, b: 2},
+		Requires: map[Location][]Location{a: {b}, b: {a}},
+	}
+
+	if _, err := (&Client{}).ApplyConfiguration(context.Background(), 0xFF, cfg); err == nil {
+		t.Errorf("cyclic configuration should fail")
+	}
+}
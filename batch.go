@@ -0,0 +1,203 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"sort"
+)
+
+// An Operation is a read or write of a batch passed to Client.Do.
+type Operation struct {
+	Unit  uint8
+	Table Table
+	Addr  uint16
+
+	// Quantity is the number of values a read returns.
+	Quantity uint16
+
+	// Values, if non nil, makes the Operation a write of Values at
+	// Addr. Coils are switched on by any non zero value. Only CoilTable
+	// and HoldingRegisterTable may be written.
+	Values []uint16
+}
+
+func (op *Operation) isWrite() bool { return op.Values != nil }
+
+// end returns the address following the operation's range.
+func (op *Operation) end() int {
+	if op.isWrite() {
+		return int(op.Addr) + len(op.Values)
+	}
+	return int(op.Addr) + int(op.Quantity)
+}
+
+// A Result is the outcome of an Operation of a batch.
+type Result struct {
+	Values []uint16 // values read, coils and discrete inputs being 0 or 1
+	Err    error
+}
+
+// Do executes a batch of operations in as few requests as it can. Reads
+// of the same unit and table that overlap or adjoin are merged into
+// single requests within the protocol's limits, as are writes of a range
+// continuing the previous write. Writes are made in the order of the
+// batch, and reads are never moved across writes, so a read following a
+// write in the batch sees its value.
+//
+// Do returns a Result for every Operation, in the order of the batch. A
+// merged read failing with an exception, e.g. because it spans
+// addresses the device does not have, is retried as the separate reads
+// it merged, so that each Operation reports its own exception.
+func (c *Client) Do(ctx context.Context, batch []Operation) []Result {
+	results := make([]Result, len(batch))
+	for i := 0; i < len(batch); {
+		j := i + 1
+		for j < len(batch) && batch[j].isWrite() == batch[i].isWrite() {
+			j++
+		}
+		if batch[i].isWrite() {
+			c.doWrites(ctx, batch, results, i, j)
+		} else {
+			c.doReads(ctx, batch, results, i, j)
+		}
+		i = j
+	}
+	return results
+}
+
+// A batchRequest is a request serving some operations of a batch.
+type batchRequest struct {
+	unit      uint8
+	table     Table
+	addr, end int
+	ops       []int // indices of the operations served
+}
+
+func maxBatchQuantity(t Table, write bool) int {
+	switch {
+	case write && t == CoilTable:
+		return MaxWriteBits
+	case write:
+		return MaxWriteRegisters
+	}
+	return maxPollQuantity(t)
+}
+
+// doReads executes the reads batch[i:j].
+func (c *Client) doReads(ctx context.Context, batch []Operation, results []Result, i, j int) {
+	ops := make([]int, 0, j-i)
+	for k := i; k < j; k++ {
+		op := &batch[k]
+		if op.Quantity == 0 || int(op.Quantity) > maxPollQuantity(op.Table) {
+			results[k].Err = illegalValue("modbus: batch read quantity %d", op.Quantity)
+			continue
+		}
+		ops = append(ops, k)
+	}
+	sort.SliceStable(ops, func(a, b int) bool {
+		x, y := &batch[ops[a]], &batch[ops[b]]
+		if x.Unit != y.Unit {
+			return x.Unit < y.Unit
+		}
+		if x.Table != y.Table {
+			return x.Table < y.Table
+		}
+		return x.Addr < y.Addr
+	})
+
+	var reqs []batchRequest
+	for _, k := range ops {
+		op := &batch[k]
+		if n := len(reqs); n > 0 {
+			r := &reqs[n-1]
+			end := r.end
+			if op.end() > end {
+				end = op.end()
+			}
+			if r.unit == op.Unit && r.table == op.Table && int(op.Addr) <= r.end &&
+				end-r.addr <= maxPollQuantity(op.Table) {
+				r.end = end
+				r.ops = append(r.ops, k)
+				continue
+			}
+		}
+		reqs = append(reqs, batchRequest{op.Unit, op.Table, int(op.Addr), op.end(), []int{k}})
+	}
+
+	for _, r := range reqs {
+		values, err := c.readTable(ctx, r.unit, r.table, uint16(r.addr), uint16(r.end-r.addr))
+		var e *ModbusError
+		if len(r.ops) > 1 && errors.As(err, &e) {
+			for _, k := range r.ops {
+				op := &batch[k]
+				results[k].Values, results[k].Err = c.readTable(ctx, op.Unit, op.Table, op.Addr, op.Quantity)
+			}
+			continue
+		}
+		for _, k := range r.ops {
+			if err != nil {
+				results[k].Err = err
+				continue
+			}
+			off := int(batch[k].Addr) - r.addr
+			results[k].Values = append([]uint16(nil), values[off:off+int(batch[k].Quantity)]...)
+		}
+	}
+}
+
+// doWrites executes the writes batch[i:j].
+func (c *Client) doWrites(ctx context.Context, batch []Operation, results []Result, i, j int) {
+	var reqs []batchRequest
+	for k := i; k < j; k++ {
+		op := &batch[k]
+		max := maxBatchQuantity(op.Table, true)
+		if op.Table != CoilTable && op.Table != HoldingRegisterTable {
+			results[k].Err = illegalValue("modbus: batch write of %v", op.Table)
+			continue
+		}
+		if len(op.Values) == 0 || len(op.Values) > max {
+			results[k].Err = illegalValue("modbus: batch write of %d values", len(op.Values))
+			continue
+		}
+		if n := len(reqs); n > 0 {
+			r := &reqs[n-1]
+			if r.unit == op.Unit && r.table == op.Table && int(op.Addr) == r.end &&
+				op.end()-r.addr <= max {
+				r.end = op.end()
+				r.ops = append(r.ops, k)
+				continue
+			}
+		}
+		reqs = append(reqs, batchRequest{op.Unit, op.Table, int(op.Addr), op.end(), []int{k}})
+	}
+
+	for _, r := range reqs {
+		values := make([]uint16, 0, r.end-r.addr)
+		for _, k := range r.ops {
+			values = append(values, batch[k].Values...)
+		}
+		err := c.writeTable(ctx, r.unit, r.table, uint16(r.addr), values)
+		for _, k := range r.ops {
+			results[k].Err = err
+		}
+	}
+}
+
+// writeTable writes values to table t starting at addr, with a single
+// write function if there is one value.
+func (c *Client) writeTable(ctx context.Context, uid uint8, t Table, addr uint16, values []uint16) error {
+	if t == CoilTable {
+		bits := make([]bool, len(values))
+		for i, v := range values {
+			bits[i] = v != 0
+		}
+		if len(bits) == 1 {
+			return c.WriteSingleCoil(ctx, uid, addr, bits[0])
+		}
+		return c.WriteMultipleCoils(ctx, uid, addr, bits)
+	}
+	if len(values) == 1 {
+		return c.WriteSingleRegister(ctx, uid, addr, values[0])
+	}
+	return c.WriteMultipleRegisters(ctx, uid, addr, values)
+}
@@ -0,0 +1,64 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestClientDo(t *testing.T) {
+	h := &RegisterHandler{Holdings: []uint16{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, Coils: make([]bool, 8)}
+	var mu sync.Mutex
+	var fcodes []FunctionCode
+	c := dialTestServer(t, testHandlerFunc(func(w ResponseWriter, r *Frame) {
+		mu.Lock()
+		fcodes = append(fcodes, FunctionCode(r.Header().Fcode))
+		mu.Unlock()
+		h.ServeModbus(w, r)
+	}))
+
+	results := c.Do(context.Background(), []Operation{
+		{Unit: 1, Table: HoldingRegisterTable, Addr: 4, Quantity: 2},
+		{Unit: 1, Table: HoldingRegisterTable, Addr: 0, Quantity: 3},
+		{Unit: 1, Table: HoldingRegisterTable, Addr: 2, Quantity: 2},
+		{Unit: 1, Table: HoldingRegisterTable, Addr: 1, Values: []uint16{10, 20}},
+		{Unit: 1, Table: HoldingRegisterTable, Addr: 3, Values: []uint16{30}},
+		{Unit: 1, Table: CoilTable, Addr: 6, Values: []uint16{1}},
+		{Unit: 1, Table: HoldingRegisterTable, Addr: 0, Quantity: 4},
+		{Unit: 1, Table: InputRegisterTable, Addr: 0, Values: []uint16{1}},
+	})
+	want := []Result{
+		{Values: []uint16{4, 5}},
+		{Values: []uint16{0, 1, 2}},
+		{Values: []uint16{2, 3}},
+		{}, {}, {},
+		{Values: []uint16{0, 10, 20, 30}},
+	}
+	for i, w := range want {
+		if !reflect.DeepEqual(results[i], w) {
+			t.Errorf("result %d = %+v; want %+v", i, results[i], w)
+		}
+	}
+	if !errors.Is(results[7].Err, ErrIllegalDataValue) {
+		t.Errorf("write of input registers = %v; want %v", results[7].Err, ErrIllegalDataValue)
+	}
+	wantFcodes := []FunctionCode{ReadHoldingRegisters, WriteMultipleRegisters, WriteSingleCoil, ReadHoldingRegisters}
+	if !reflect.DeepEqual(fcodes, wantFcodes) {
+		t.Errorf("requests %v; want %v", fcodes, wantFcodes)
+	}
+
+	// a merged read failing is retried as the reads it merged
+	fcodes = nil
+	results = c.Do(context.Background(), []Operation{
+		{Unit: 1, Table: HoldingRegisterTable, Addr: 8, Quantity: 2},
+		{Unit: 1, Table: HoldingRegisterTable, Addr: 10, Quantity: 1},
+	})
+	if !reflect.DeepEqual(results[0].Values, []uint16{8, 9}) || !errors.Is(results[1].Err, ErrIllegalDataAddress) {
+		t.Errorf("results %+v", results)
+	}
+	if len(fcodes) != 3 {
+		t.Errorf("%d requests; want 3", len(fcodes))
+	}
+}
@@ -0,0 +1,40 @@
+package modbus
+
+// PackBits packs bits into bytes as Modbus does for coils and discrete
+// inputs: the first bit is the least significant bit of the first byte.
+// The unused high bits of the last byte are zero.
+func PackBits(bits []bool) []byte {
+	return PackBitsInto(make([]byte, (len(bits)+7)/8), bits)
+}
+
+// PackBitsInto packs bits into dst, as PackBits does, and returns the
+// bytes written, dst[:(len(bits)+7)/8]. It panics if dst is too short.
+func PackBitsInto(dst []byte, bits []bool) []byte {
+	dst = dst[:(len(bits)+7)/8]
+	for i := range dst {
+		dst[i] = 0
+	}
+	for i, b := range bits {
+		if b {
+			dst[i/8] |= 1 << uint(i%8)
+		}
+	}
+	return dst
+}
+
+// UnpackBits returns the first count bits packed in b, the reverse of
+// PackBits. It panics if count exceeds 8*len(b).
+func UnpackBits(b []byte, count int) []bool {
+	bits := make([]bool, count)
+	UnpackBitsInto(bits, b)
+	return bits
+}
+
+// UnpackBitsInto fills bits with the first len(bits) bits packed in b. It
+// panics if b is too short.
+func UnpackBitsInto(bits []bool, b []byte) {
+	b = b[:(len(bits)+7)/8]
+	for i := range bits {
+		bits[i] = b[i/8]>>uint(i%8)&1 == 1
+	}
+}
@@ -0,0 +1,47 @@
+package modbus
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestPackBits(t *testing.T) {
+	bits := []bool{true, false, true, true, false, false, false, false, false, true}
+	expected := []byte{0x0D, 0x02}
+	if b := PackBits(bits); !bytes.Equal(b, expected) {
+		t.Errorf("PackBits = % X; want % X", b, expected)
+	}
+	if b := PackBits(nil); len(b) != 0 {
+		t.Errorf("PackBits(nil) = % X", b)
+	}
+
+	dst := []byte{0xFF, 0xFF, 0xFF}
+	if b := PackBitsInto(dst, bits); !bytes.Equal(b, expected) {
+		t.Errorf("PackBitsInto = % X; want % X", b, expected)
+	}
+	if dst[2] != 0xFF {
+		t.Errorf("PackBitsInto wrote beyond the packed bytes")
+	}
+}
+
+func TestUnpackBits(t *testing.T) {
+	expected := []bool{true, false, true, true, false, false, false, false, false, true}
+	if bits := UnpackBits([]byte{0x0D, 0xFE}, 10); !reflect.DeepEqual(bits, expected) {
+		t.Errorf("UnpackBits = %v; want %v", bits, expected)
+	}
+
+	bits := make([]bool, 3)
+	UnpackBitsInto(bits, []byte{0x06})
+	if !reflect.DeepEqual(bits, []bool{false, true, true}) {
+		t.Errorf("UnpackBitsInto = %v", bits)
+	}
+	UnpackBitsInto(nil, nil)
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("UnpackBits of too few bytes did not panic")
+		}
+	}()
+	UnpackBits([]byte{0x01}, 9)
+}
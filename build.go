@@ -0,0 +1,101 @@
+package modbus
+
+import "encoding/binary"
+
+// The following functions build request frames for unit uid. The
+// transaction identifier is left zero for the transport to assign.
+
+func newRequestFrame(uid uint8, fcode FunctionCode, data []byte) *Frame {
+	return NewFrame(Header{Pid: TcpPid, Uid: uid, Fcode: byte(fcode)}, data)
+}
+
+// addrQuantity encodes the address / quantity pair shared by the read
+// requests.
+func addrQuantity(addr, quantity uint16) []byte {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint16(data[0:2], addr)
+	binary.BigEndian.PutUint16(data[2:4], quantity)
+	return data
+}
+
+// NewReadCoilsFrame builds a Read Coils (0x01) request.
+func NewReadCoilsFrame(uid uint8, addr, quantity uint16) *Frame {
+	return newRequestFrame(uid, ReadCoils, addrQuantity(addr, quantity))
+}
+
+// NewReadDiscreteInputsFrame builds a Read Discrete Inputs (0x02) request.
+func NewReadDiscreteInputsFrame(uid uint8, addr, quantity uint16) *Frame {
+	return newRequestFrame(uid, ReadDiscreteInputs, addrQuantity(addr, quantity))
+}
+
+// NewReadHoldingRegistersFrame builds a Read Holding Registers (0x03)
+// request.
+func NewReadHoldingRegistersFrame(uid uint8, addr, quantity uint16) *Frame {
+	return newRequestFrame(uid, ReadHoldingRegisters, addrQuantity(addr, quantity))
+}
+
+// NewReadInputRegistersFrame builds a Read Input Registers (0x04) request.
+func NewReadInputRegistersFrame(uid uint8, addr, quantity uint16) *Frame {
+	return newRequestFrame(uid, ReadInputRegisters, addrQuantity(addr, quantity))
+}
+
+// NewWriteSingleCoilFrame builds a Write Single Coil (0x05) request.
+func NewWriteSingleCoilFrame(uid uint8, addr uint16, value bool) *Frame {
+	var v uint16
+	if value {
+		v = 0xFF00
+	}
+	return newRequestFrame(uid, WriteSingleCoil, addrQuantity(addr, v))
+}
+
+// NewWriteSingleRegisterFrame builds a Write Single Register (0x06)
+// request.
+func NewWriteSingleRegisterFrame(uid uint8, addr, value uint16) *Frame {
+	return newRequestFrame(uid, WriteSingleRegister, addrQuantity(addr, value))
+}
+
+// NewReadExceptionStatusFrame builds a Read Exception Status (0x07)
+// request.
+func NewReadExceptionStatusFrame(uid uint8) *Frame {
+	return newRequestFrame(uid, ReadExceptionStatus, nil)
+}
+
+// NewWriteMultipleCoilsFrame builds a Write Multiple Coils (0x0F) request.
+func NewWriteMultipleCoilsFrame(uid uint8, addr uint16, values []bool) *Frame {
+	b := PackBits(values)
+	data := append(addrQuantity(addr, uint16(len(values))), byte(len(b)))
+	return newRequestFrame(uid, WriteMultipleCoils, append(data, b...))
+}
+
+// NewWriteMultipleRegistersFrame builds a Write Multiple Registers (0x10)
+// request.
+func NewWriteMultipleRegistersFrame(uid uint8, addr uint16, values []uint16) *Frame {
+	data := append(addrQuantity(addr, uint16(len(values))), byte(2*len(values)))
+	for _, v := range values {
+		data = append(data, byte(v>>8), byte(v))
+	}
+	return newRequestFrame(uid, WriteMultipleRegisters, data)
+}
+
+// NewReportSlaveIdFrame builds a Report Slave ID (0x11) request.
+func NewReportSlaveIdFrame(uid uint8) *Frame {
+	return newRequestFrame(uid, ReportSlaveId, nil)
+}
+
+// NewMaskWriteRegisterFrame builds a Mask Write Register (0x16) request.
+func NewMaskWriteRegisterFrame(uid uint8, addr, andMask, orMask uint16) *Frame {
+	data := append(addrQuantity(addr, andMask), byte(orMask>>8), byte(orMask))
+	return newRequestFrame(uid, MaskWriteRegister, data)
+}
+
+// NewWriteAndReadRegistersFrame builds a Read/Write Multiple Registers
+// (0x17) request reading quantity registers at raddr after writing values
+// at waddr.
+func NewWriteAndReadRegistersFrame(uid uint8, raddr, quantity, waddr uint16, values []uint16) *Frame {
+	data := append(addrQuantity(raddr, quantity), addrQuantity(waddr, uint16(len(values)))...)
+	data = append(data, byte(2*len(values)))
+	for _, v := range values {
+		data = append(data, byte(v>>8), byte(v))
+	}
+	return newRequestFrame(uid, WriteAndReadRegisters, data)
+}
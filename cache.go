@@ -0,0 +1,167 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// A CachingTransport is a RoundTripper sharing the responses to reads
+// between callers, for applications where many goroutines poll the same
+// points. Identical reads made while one is in flight wait for its
+// response instead of being sent again, and with a TTL its response is
+// reused for further identical reads until it expires.
+//
+// Every request other than Read Coils, Read Discrete Inputs, Read
+// Holding Registers and Read Input Registers is passed through, and
+// forgets the responses kept for its unit, as it may have changed them.
+// Exception responses and errors are not kept.
+//
+// The exported fields must not be changed after the first call to
+// RoundTrip.
+type CachingTransport struct {
+	// Transport carries the requests to the slave.
+	Transport RoundTripper
+
+	// TTL is the time a response is reused for. If zero, only reads
+	// in flight at the same time share a response.
+	TTL time.Duration
+
+	mu      sync.Mutex // guards the following
+	entries map[string]*cacheEntry
+	gen     uint64    // incremented by every request that is not a read
+	swept   time.Time // last removal of expired entries
+}
+
+// A cacheEntry is a read in flight, or its response.
+type cacheEntry struct {
+	done    chan struct{} // closed once resp and err are set
+	resp    *Frame
+	err     error
+	expires time.Time
+}
+
+// isCacheable reports whether requests with function code fcode read
+// the slave without changing it.
+func isCacheable(fcode FunctionCode) bool {
+	switch fcode {
+	case ReadCoils, ReadDiscreteInputs, ReadHoldingRegisters, ReadInputRegisters:
+		return true
+	}
+	return false
+}
+
+func cacheKey(req *Frame) string {
+	return string(append([]byte{req.header.Uid, req.header.Fcode}, req.data...))
+}
+
+// RoundTrip sends req, or answers it with a response kept or in flight.
+func (t *CachingTransport) RoundTrip(ctx context.Context, req *Frame) (*Frame, error) {
+	if !isCacheable(FunctionCode(req.header.Fcode)) {
+		t.forget(req.header.Uid)
+		defer t.forget(req.header.Uid)
+		return t.Transport.RoundTrip(ctx, req)
+	}
+	key := cacheKey(req)
+	for {
+		t.mu.Lock()
+		e, ok := t.entries[key]
+		if ok && e.expired(time.Now()) {
+			delete(t.entries, key)
+			ok = false
+		}
+		if !ok {
+			return t.send(ctx, req, key)
+		}
+		t.mu.Unlock()
+
+		select {
+		case <-e.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if errors.Is(e.err, context.Canceled) || errors.Is(e.err, context.DeadlineExceeded) {
+			// the caller sending it gave up; try again with ours
+			continue
+		}
+		if e.err != nil {
+			return nil, e.err
+		}
+		return copyFrame(e.resp), nil
+	}
+}
+
+// send sends req as the read in flight for key, with t.mu held, and
+// keeps its response if no other request was made meanwhile.
+func (t *CachingTransport) send(ctx context.Context, req *Frame, key string) (*Frame, error) {
+	e := &cacheEntry{done: make(chan struct{})}
+	if t.entries == nil {
+		t.entries = make(map[string]*cacheEntry)
+	}
+	if now := time.Now(); now.Sub(t.swept) > t.TTL {
+		t.sweep(now)
+	}
+	t.entries[key] = e
+	gen := t.gen
+	t.mu.Unlock()
+
+	resp, err := t.Transport.RoundTrip(ctx, req)
+
+	t.mu.Lock()
+	e.resp, e.err = resp, err
+	keep := err == nil && t.TTL > 0 && t.gen == gen && resp.header.Fcode&0x80 == 0
+	if keep {
+		e.expires = time.Now().Add(t.TTL)
+	} else if t.entries[key] == e {
+		delete(t.entries, key)
+	}
+	t.mu.Unlock()
+	close(e.done)
+	if err != nil {
+		return nil, err
+	}
+	return copyFrame(resp), nil
+}
+
+// expired reports whether the response of e is no longer to be used at
+// now. Reads in flight never expire.
+func (e *cacheEntry) expired(now time.Time) bool {
+	select {
+	case <-e.done:
+		return !now.Before(e.expires)
+	default:
+		return false
+	}
+}
+
+// sweep drops the expired responses, with t.mu held.
+func (t *CachingTransport) sweep(now time.Time) {
+	t.swept = now
+	for key, e := range t.entries {
+		if e.expired(now) {
+			delete(t.entries, key)
+		}
+	}
+}
+
+// forget drops the responses kept for unit uid.
+func (t *CachingTransport) forget(uid uint8) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.gen++
+	for key := range t.entries {
+		if key[0] == uid {
+			// reads in flight are dropped too, so that later ones are
+			// sent afresh; their responses are not kept
+			delete(t.entries, key)
+		}
+	}
+}
+
+// copyFrame returns a copy of f that its recipient may modify.
+func copyFrame(f *Frame) *Frame {
+	c := *f
+	c.data = append([]byte(nil), f.data...)
+	return &c
+}
@@ -0,0 +1,91 @@
+package modbus
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCachingTransport(t *testing.T) {
+	var reads int32
+	release := make(chan struct{})
+	h := &RegisterHandler{Holdings: []uint16{7}}
+	c := dialTestServer(t, testHandlerFunc(func(w ResponseWriter, r *Frame) {
+		if FunctionCode(r.Header().Fcode) == ReadHoldingRegisters {
+			atomic.AddInt32(&reads, 1)
+			<-release
+		}
+		h.ServeModbus(w, r)
+	}))
+	c.Transport = &CachingTransport{Transport: c.Transport, TTL: time.Hour}
+	ctx := context.Background()
+
+	// concurrent identical reads are sent once
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := c.ReadHoldingRegisters(ctx, 1, 0, 1); err != nil || v[0] != 7 {
+				t.Errorf("read = %v, %v", v, err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := atomic.LoadInt32(&reads); n != 1 {
+		t.Errorf("%d reads sent; want 1", n)
+	}
+
+	// and later ones answered from the cache
+	if v, err := c.ReadHoldingRegisters(ctx, 1, 0, 1); err != nil || v[0] != 7 {
+		t.Errorf("cached read = %v, %v", v, err)
+	}
+	if n := atomic.LoadInt32(&reads); n != 1 {
+		t.Errorf("%d reads sent; want 1", n)
+	}
+
+	// until a write to the unit
+	if err := c.WriteSingleRegister(ctx, 1, 0, 8); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if v, err := c.ReadHoldingRegisters(ctx, 1, 0, 1); err != nil || v[0] != 8 {
+		t.Errorf("read after write = %v, %v", v, err)
+	}
+	if n := atomic.LoadInt32(&reads); n != 2 {
+		t.Errorf("%d reads sent; want 2", n)
+	}
+
+	// exceptions are not kept
+	for i := 0; i < 2; i++ {
+		if _, err := c.ReadHoldingRegisters(ctx, 1, 5, 1); err == nil {
+			t.Errorf("read of a missing register succeeded")
+		}
+	}
+	if n := atomic.LoadInt32(&reads); n != 4 {
+		t.Errorf("%d reads sent; want 4", n)
+	}
+}
+
+func TestCachingTransportExpiry(t *testing.T) {
+	var reads int32
+	h := &RegisterHandler{Holdings: []uint16{7}}
+	c := dialTestServer(t, testHandlerFunc(func(w ResponseWriter, r *Frame) {
+		atomic.AddInt32(&reads, 1)
+		h.ServeModbus(w, r)
+	}))
+	c.Transport = &CachingTransport{Transport: c.Transport, TTL: 20 * time.Millisecond}
+	ctx := context.Background()
+
+	c.ReadHoldingRegisters(ctx, 1, 0, 1)
+	c.ReadHoldingRegisters(ctx, 1, 0, 1)
+	c.ReadHoldingRegisters(ctx, 2, 0, 1) // another unit
+	time.Sleep(30 * time.Millisecond)
+	c.ReadHoldingRegisters(ctx, 1, 0, 1)
+	if n := atomic.LoadInt32(&reads); n != 3 {
+		t.Errorf("%d reads sent; want 3", n)
+	}
+}
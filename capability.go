@@ -0,0 +1,35 @@
+package modbus
+
+import "sort"
+
+// A Capability is an optional subsystem. Each is compiled in unless
+// excluded with its build tag, so that embedded users can build minimal
+// binaries:
+//
+//	modbus_notls     Modbus/TCP Security: ServeTLS, ListenAndServeTLS and
+//	                 Dialer.TLSConfig fail
+//	modbus_noexpvar  ExpvarStore, whose expvar package links net/http
+//	modbus_noserial  the ports of package serial
+//
+// YAML register maps, which depend on gopkg.in/yaml.v3, are instead
+// only compiled in with the modbus_yaml build tag.
+type Capability string
+
+const (
+	CapabilityTLS    Capability = "tls"
+	CapabilityExpvar Capability = "expvar"
+	CapabilitySerial Capability = "serial" // also requires a supported platform
+	CapabilityYAML   Capability = "yaml"
+)
+
+// capabilities is appended to by the init functions of the optional
+// subsystems compiled in.
+var capabilities []Capability
+
+// Capabilities returns the optional subsystems compiled into the
+// binary, sorted.
+func Capabilities() []Capability {
+	c := append([]Capability(nil), capabilities...)
+	sort.Slice(c, func(i, j int) bool { return c[i] < c[j] })
+	return c
+}
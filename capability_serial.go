@@ -0,0 +1,10 @@
+//go:build linux && (386 || amd64 || arm || arm64 || loong64 || riscv64 || s390x) && !modbus_noserial
+
+package modbus
+
+// The build constraint matches that of the port implementation of
+// package serial.
+
+func init() {
+	capabilities = append(capabilities, CapabilitySerial)
+}
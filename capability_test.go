@@ -0,0 +1,26 @@
+package modbus
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestCapabilities(t *testing.T) {
+	c := Capabilities()
+	if !sort.SliceIsSorted(c, func(i, j int) bool { return c[i] < c[j] }) {
+		t.Errorf("Capabilities %q not sorted", c)
+	}
+	seen := make(map[Capability]bool)
+	for _, name := range c {
+		if seen[name] {
+			t.Errorf("Capabilities %q lists %s twice", c, name)
+		}
+		seen[name] = true
+	}
+	if len(c) > 0 {
+		c[0] = "changed"
+		if Capabilities()[0] == "changed" {
+			t.Errorf("Capabilities returned its own slice")
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package modbus
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// A CaptureRecord is a request and the response to it, as recorded by a
+// Recorder. Captures are stored as JSON, one record per line; frames are
+// complete, MBAP header included, and base64 encoded.
+type CaptureRecord struct {
+	Time     time.Time     `json:"time"`
+	Elapsed  time.Duration `json:"elapsed"`  // taken by the handler
+	Request  []byte        `json:"request"`  // as received
+	Response []byte        `json:"response"` // nil if unanswered
+}
+
+// A Recorder records the requests and responses passing through its
+// Middleware, e.g. to capture the behaviour of a real device behind a
+// gateway for replay by a Replayer.
+type Recorder struct {
+	// Now, if non nil, returns the times recorded in place of
+	// time.Now, so that tests can produce identical captures run to
+	// run.
+	Now func() time.Time
+
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+// NewRecorder returns a Recorder writing records to w.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{enc: json.NewEncoder(w)}
+}
+
+// Err returns the first error writing a record. Later records are
+// dropped.
+func (rec *Recorder) Err() error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.err
+}
+
+func (rec *Recorder) now() time.Time {
+	if rec.Now != nil {
+		return rec.Now()
+	}
+	return time.Now()
+}
+
+func (rec *Recorder) write(r *CaptureRecord) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.err == nil {
+		rec.err = rec.enc.Encode(r)
+	}
+}
+
+// frameBytes returns the encoding of a frame of header h and data.
+func frameBytes(h Header, data []byte) []byte {
+	h.Length = uint16(len(data) + 2)
+	b := make([]byte, headerSize, headerSize+len(data))
+	h.encode(b)
+	return append(b, data...)
+}
+
+// Middleware is a Middleware recording each request and response.
+func (rec *Recorder) Middleware(h Handler) Handler {
+	return HandlerFunc(func(w ResponseWriter, r *Frame) {
+		// the handler answers in the request's header, so copy it now
+		record := &CaptureRecord{Time: rec.now(), Request: frameBytes(r.header, r.data)}
+		cw := &captureWriter{ResponseWriter: w}
+		h.ServeModbus(cw, r)
+		record.Elapsed = rec.now().Sub(record.Time)
+		if cw.wrote {
+			record.Response = frameBytes(cw.header, cw.data)
+		}
+		rec.write(record)
+	})
+}
+
+// captureWriter collects the response written through it.
+type captureWriter struct {
+	ResponseWriter
+	wrote  bool
+	header Header
+	data   []byte
+}
+
+func (w *captureWriter) Write(data []byte) (int, error) {
+	if !w.wrote {
+		w.wrote = true
+		w.header = *w.Header()
+	}
+	w.data = append(w.data, data...)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *captureWriter) WriteException(code ExceptionCode) error {
+	err := w.ResponseWriter.WriteException(code)
+	if err == nil {
+		w.wrote = true
+		w.header = *w.Header()
+		w.data = []byte{byte(code)}
+	}
+	return err
+}
+
+func (w *captureWriter) Flush() error {
+	if f, ok := w.ResponseWriter.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+func (w *captureWriter) CloseNotify() <-chan bool {
+	if cn, ok := w.ResponseWriter.(CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return nil
+}
+
+func (w *captureWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("modbus: ResponseWriter does not implement Hijacker")
+}
+
+// ReadCapture reads the records written by a Recorder from r.
+func ReadCapture(r io.Reader) ([]CaptureRecord, error) {
+	var records []CaptureRecord
+	dec := json.NewDecoder(r)
+	for {
+		var rec CaptureRecord
+		err := dec.Decode(&rec)
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rec.Request) < headerSize {
+			return nil, errors.New("modbus: capture record without a request")
+		}
+		records = append(records, rec)
+	}
+}
+
+// A Replayer is a Handler answering requests with the responses recorded
+// for identical requests, so that masters can be regression tested
+// against the behaviour of a real device. Requests are compared by unit
+// identifier, function code and data. A request recorded several times
+// is answered with its responses in turn, the last being repeated once
+// all have been used; requests recorded unanswered are left unanswered.
+// Unrecorded requests are answered with the exception Unmatched.
+type Replayer struct {
+	// Unmatched is the exception code answering unrecorded requests.
+	// If zero, IllegalFunction is used.
+	Unmatched ExceptionCode
+
+	mu        sync.Mutex
+	responses map[string][][]byte // by request, minus the transaction and length
+	next      map[string]int
+}
+
+// NewReplayer returns a Replayer of records.
+func NewReplayer(records []CaptureRecord) *Replayer {
+	rp := &Replayer{responses: make(map[string][][]byte), next: make(map[string]int)}
+	for _, rec := range records {
+		key := replayKey(rec.Request)
+		rp.responses[key] = append(rp.responses[key], rec.Response)
+	}
+	return rp
+}
+
+// replayKey returns the identity of a request frame: its unit identifier,
+// function code and data.
+func replayKey(frame []byte) string {
+	return string(frame[6:])
+}
+
+func (rp *Replayer) ServeModbus(w ResponseWriter, r *Frame) {
+	key := replayKey(frameBytes(r.header, r.data))
+	rp.mu.Lock()
+	responses, ok := rp.responses[key]
+	var resp []byte
+	if ok {
+		i := rp.next[key]
+		resp = responses[i]
+		if i < len(responses)-1 {
+			rp.next[key] = i + 1
+		}
+	}
+	rp.mu.Unlock()
+
+	switch {
+	case !ok:
+		code := rp.Unmatched
+		if code == 0 {
+			code = IllegalFunction
+		}
+		w.WriteException(code)
+	case len(resp) >= headerSize:
+		var h Header
+		h.decode(resp)
+		w.Header().Fcode = h.Fcode
+		w.Write(resp[headerSize:])
+	}
+}
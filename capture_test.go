@@ -0,0 +1,167 @@
+package modbus
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCaptureReplay(t *testing.T) {
+	var buf bytes.Buffer
+	rec := NewRecorder(&buf)
+	srv := &Server{Handler: &RegisterHandler{Holdings: []uint16{1, 2}}}
+	srv.Use(rec.Middleware)
+	addr := startTestServer(t, srv)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// session reads, changes, and reads again the same registers
+	session := func(c *Client) ([][]uint16, error) {
+		var reads [][]uint16
+		for _, write := range []bool{false, true, false} {
+			if write {
+				if err := c.WriteSingleRegister(ctx, 1, 1, 7); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			values, err := c.ReadHoldingRegisters(ctx, 1, 0, 2)
+			if err != nil {
+				return nil, err
+			}
+			reads = append(reads, values)
+		}
+		if _, err := c.ReadHoldingRegisters(ctx, 1, 5, 1); !errors.Is(err, ErrIllegalDataAddress) {
+			t.Errorf("err should be ErrIllegalDataAddress not %v", err)
+		}
+		return reads, nil
+	}
+
+	c, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded, err := session(c)
+	c.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := ReadCapture(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 4 {
+		t.Fatalf("%d records; want 4", len(records))
+	}
+	expected := []byte{0, 0, 0x00, 0x00, 0x00, 0x03, 0x01, 0x83, 0x02}
+	if resp := records[3].Response; len(resp) != len(expected) || !bytes.Equal(resp[2:], expected[2:]) {
+		t.Errorf("exception recorded as % X; want % X", resp, expected)
+	}
+	if records[0].Time.IsZero() || records[3].Time.Before(records[0].Time) {
+		t.Errorf("record times %v, %v", records[0].Time, records[3].Time)
+	}
+
+	replayer := NewReplayer(records)
+	addr = startTestServer(t, &Server{Handler: replayer})
+	c, err = Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	replayed, err := session(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(replayed, recorded) {
+		t.Errorf("replayed %v; recorded %v", replayed, recorded)
+	}
+	// the last response of a request is repeated
+	if values, err := c.ReadHoldingRegisters(ctx, 1, 0, 2); err != nil || !reflect.DeepEqual(values, []uint16{1, 7}) {
+		t.Errorf("ReadHoldingRegisters = %v, %v", values, err)
+	}
+	if _, err := c.ReadHoldingRegisters(ctx, 2, 0, 2); !errors.Is(err, ErrIllegalFunction) {
+		t.Errorf("unrecorded request: err should be ErrIllegalFunction not %v", err)
+	}
+}
+
+func TestReplayerUnanswered(t *testing.T) {
+	req := frameBytes(Header{Tid: 9, Uid: 1, Fcode: byte(ReadCoils)}, []byte{0, 0, 0, 1})
+	replayer := NewReplayer([]CaptureRecord{{Request: req}})
+	replayer.Unmatched = SlaveFailure
+	var buf bytes.Buffer
+	w := &testResponseWriter{w: bufio.NewWriter(&buf)}
+	w.req = NewFrame(Header{Tid: 3, Uid: 1, Fcode: byte(ReadCoils)}, []byte{0, 0, 0, 1})
+	replayer.ServeModbus(w, w.req)
+	w.req = NewFrame(Header{Tid: 4, Uid: 1, Fcode: byte(ReadCoils)}, []byte{0, 0, 0, 2})
+	replayer.ServeModbus(w, w.req)
+	w.w.Flush()
+	expected := []byte{0x00, 0x04, 0x00, 0x00, 0x00, 0x03, 0x01, 0x81, 0x04}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("responses % X; want % X", buf.Bytes(), expected)
+	}
+}
+
+// TestCaptureGolden checks that with a fixed clock and transaction
+// identifiers, captures of the same traffic are identical run to run.
+func TestCaptureGolden(t *testing.T) {
+	run := func() []byte {
+		var buf bytes.Buffer
+		var mu sync.Mutex
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		rec := NewRecorder(&buf)
+		rec.Now = func() time.Time {
+			mu.Lock()
+			defer mu.Unlock()
+			now = now.Add(time.Millisecond)
+			return now
+		}
+		srv := &Server{Handler: &RegisterHandler{Holdings: []uint16{1, 2}}}
+		srv.Use(rec.Middleware)
+		addr := startTestServer(t, srv)
+
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cc := NewClientConn(conn)
+		tid := uint16(0x0100)
+		cc.NextTid = func() uint16 { tid += 2; return tid }
+		c := &Client{Transport: cc}
+		defer c.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		c.WriteSingleRegister(ctx, 1, 0, 9)
+		c.ReadHoldingRegisters(ctx, 1, 0, 2)
+		c.ReadHoldingRegisters(ctx, 1, 4, 1)
+		if err := rec.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	first, second := run(), run()
+	if !bytes.Equal(first, second) {
+		t.Errorf("captures differ:\n%s\n%s", first, second)
+	}
+	records, err := ReadCapture(bytes.NewReader(first))
+	if err != nil || len(records) != 3 {
+		t.Fatalf("ReadCapture = %d records, %v", len(records), err)
+	}
+	if tid := binary.BigEndian.Uint16(records[2].Request); tid != 0x0106 {
+		t.Errorf("transaction identifier 0x%04X; want 0x0106", tid)
+	}
+	if records[0].Elapsed != time.Millisecond {
+		t.Errorf("elapsed %v; want 1ms", records[0].Elapsed)
+	}
+}
@@ -0,0 +1,65 @@
+package modbus
+
+// crcTable holds the CRC of every byte value, for CRC16Modbus.
+var crcTable = func() (t [256]uint16) {
+	for i := range t {
+		crc := uint16(i)
+		for bit := 0; bit < 8; bit++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+		t[i] = crc
+	}
+	return t
+}()
+
+// CRC16Modbus returns the CRC of data as Modbus RTU frames carry it: the
+// reflected polynomial 0xA001, starting from 0xFFFF. Frames append it
+// least significant byte first.
+func CRC16Modbus(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc = crc>>8 ^ crcTable[byte(crc)^b]
+	}
+	return crc
+}
+
+// AppendCRC16 appends the CRC of frame to it, least significant byte
+// first, as RTU frames end.
+func AppendCRC16(frame []byte) []byte {
+	crc := CRC16Modbus(frame)
+	return append(frame, byte(crc), byte(crc>>8))
+}
+
+// VerifyCRC16 reports whether the RTU frame ends in the CRC of the bytes
+// before it.
+func VerifyCRC16(frame []byte) bool {
+	n := len(frame) - 2
+	if n < 0 {
+		return false
+	}
+	crc := CRC16Modbus(frame[:n])
+	return frame[n] == byte(crc) && frame[n+1] == byte(crc>>8)
+}
+
+// LRC returns the longitudinal redundancy check of data as Modbus ASCII
+// frames carry it: the two's complement of the sum of the bytes. It is
+// computed over the binary bytes, before their encoding as hexadecimal
+// characters.
+func LRC(data []byte) byte {
+	var sum byte
+	for _, b := range data {
+		sum += b
+	}
+	return -sum
+}
+
+// VerifyLRC reports whether the binary ASCII frame message ends in the LRC
+// of the bytes before it.
+func VerifyLRC(message []byte) bool {
+	n := len(message) - 1
+	return n >= 0 && message[n] == LRC(message[:n])
+}
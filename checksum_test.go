@@ -0,0 +1,45 @@
+package modbus
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCRC16Modbus(t *testing.T) {
+	frame := []byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x0A}
+	if crc := CRC16Modbus(frame); crc != 0xCDC5 {
+		t.Errorf("CRC16Modbus = 0x%04X; want 0xCDC5", crc)
+	}
+	framed := AppendCRC16(frame)
+	if expected := []byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x0A, 0xC5, 0xCD}; !bytes.Equal(framed, expected) {
+		t.Errorf("AppendCRC16 = % X; want % X", framed, expected)
+	}
+	if !VerifyCRC16(framed) {
+		t.Errorf("VerifyCRC16 rejected % X", framed)
+	}
+	framed[2] ^= 0x01
+	if VerifyCRC16(framed) || VerifyCRC16([]byte{0xFF}) {
+		t.Errorf("VerifyCRC16 accepted a corrupt frame")
+	}
+}
+
+func TestLRC(t *testing.T) {
+	message := []byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x01}
+	if lrc := LRC(message); lrc != 0xFB {
+		t.Errorf("LRC = 0x%02X; want 0xFB", lrc)
+	}
+	if !VerifyLRC(append(message, 0xFB)) {
+		t.Errorf("VerifyLRC rejected a valid message")
+	}
+	if VerifyLRC(append(message, 0xFA)) || VerifyLRC(nil) {
+		t.Errorf("VerifyLRC accepted a corrupt message")
+	}
+}
+
+func BenchmarkCRC16Modbus(b *testing.B) {
+	frame := make([]byte, 256)
+	b.SetBytes(int64(len(frame)))
+	for i := 0; i < b.N; i++ {
+		CRC16Modbus(frame)
+	}
+}
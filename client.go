@@ -0,0 +1,676 @@
+package modbus
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A RoundTripper executes a single Modbus transaction, returning the
+// response Frame for the request Frame.
+type RoundTripper interface {
+	RoundTrip(ctx context.Context, req *Frame) (*Frame, error)
+}
+
+// A Client is a Modbus client / master. Its methods build request
+// frames, hand them to Transport and decode the responses.
+//
+// A Client is safe for concurrent use by multiple goroutines when its
+// Transport is, as ClientConn and ClientPool are. SharedClient bundles a
+// Client with a ClientConn for that purpose.
+type Client struct {
+	// Transport carries requests to the slave.
+	Transport RoundTripper
+
+	// VerifyUpdates causes UpdateRegister to read a register back after
+	// writing it when the slave does not support Mask Write Register.
+	VerifyUpdates bool
+
+	// UpdateAttempts bounds the number of times UpdateRegister retries
+	// after a verification conflict. If zero, 3 attempts are made.
+	UpdateAttempts int
+
+	// DryRun causes write requests to be validated and logged but not
+	// transmitted. Valid writes are reported as successful, or fail with
+	// DryRunException if it is non zero. Reads are transmitted as usual.
+	DryRun          bool
+	DryRunException ExceptionCode
+
+	// Profile names the model of the slave, whose registered Quirks
+	// are applied to its responses. Clients sharing a Transport may
+	// have different profiles, e.g. for the units behind a gateway.
+	Profile DeviceProfile
+
+	// Addressing relates the addresses passed to the Client's methods,
+	// and those of the Points it reads and writes, to protocol
+	// addresses. The zero value passes addresses on unchanged.
+	Addressing Addressing
+
+	// Retry, if non nil, retries reads failing with the errors it
+	// names. Their errors are then returned as a *RetryError.
+	Retry *RetryPolicy
+
+	// ErrorLog specifies an optional logger for dry run requests and
+	// unexpected behaviour of the slave. If nil, logging goes to
+	// os.Stderr via the log package's standard logger.
+	ErrorLog *log.Logger
+
+	mu         sync.Mutex
+	noMaskUnit map[uint8]bool // units that rejected Mask Write Register
+}
+
+// ErrUpdateConflict is returned by UpdateRegister when the register kept
+// changing underneath it for every attempt.
+var ErrUpdateConflict = errors.New("modbus: register changed during update")
+
+// Dial connects to the Modbus TCP slave at addr.
+func Dial(addr string) (*Client, error) {
+	return DialContext(context.Background(), addr)
+}
+
+// DialContext is Dial, giving up on connecting once ctx is done. A
+// Dialer gives control over how the connection is made.
+func DialContext(ctx context.Context, addr string) (*Client, error) {
+	return new(Dialer).Dial(ctx, addr)
+}
+
+// Close closes the client's Transport if it is an io.Closer.
+func (c *Client) Close() error {
+	if cl, ok := c.Transport.(io.Closer); ok {
+		return cl.Close()
+	}
+	return nil
+}
+
+func (c *Client) logf(format string, args ...interface{}) {
+	if c.ErrorLog != nil {
+		c.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// send issues the request and returns the payload of a non exception
+// response. Exception responses are returned as a *ModbusError, wrapped
+// in a *RetryError for requests retried under c.Retry.
+func (c *Client) send(ctx context.Context, req *Frame) ([]byte, error) {
+	fcode := FunctionCode(req.header.Fcode)
+	if c.DryRun && isWriteFunction(fcode) {
+		return c.dryRun(ctx, req)
+	}
+	if c.Retry != nil && isRetryable(fcode) {
+		return c.Retry.do(ctx, func() ([]byte, error) { return c.sendOnce(ctx, req) })
+	}
+	return c.sendOnce(ctx, req)
+}
+
+// sendOnce is send without dry runs and retries.
+func (c *Client) sendOnce(ctx context.Context, req *Frame) ([]byte, error) {
+	fcode := req.header.Fcode
+	resp, err := c.Transport.RoundTrip(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	applyQuirks(c.Profile, req, resp)
+	switch resp.header.Fcode {
+	case fcode:
+		return resp.data, nil
+	case fcode | 0x80:
+		if len(resp.data) < 1 {
+			return nil, errors.New("modbus: malformed exception response")
+		}
+		return nil, &ModbusError{FunctionCode(fcode), ExceptionCode(resp.data[0])}
+	}
+	return nil, fmt.Errorf("modbus: response function %v does not match request %v",
+		FunctionCode(resp.header.Fcode), FunctionCode(fcode))
+}
+
+// ReadCoils reads quantity coils starting at addr.
+func (c *Client) ReadCoils(ctx context.Context, uid uint8, addr, quantity uint16) ([]bool, error) {
+	addr, err := c.Addressing.Protocol(CoilTable, addr)
+	if err != nil {
+		return nil, err
+	}
+	return c.readCoils(ctx, uid, addr, quantity)
+}
+
+// readCoils is ReadCoils at the protocol address addr.
+func (c *Client) readCoils(ctx context.Context, uid uint8, addr, quantity uint16) ([]bool, error) {
+	data, err := c.send(ctx, NewReadCoilsFrame(uid, addr, quantity))
+	if err != nil {
+		return nil, err
+	}
+	var resp ReadCoilsResponse
+	if err = resp.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	if len(resp.Values) != 8*((int(quantity)+7)/8) {
+		return nil, errMalformedResponse
+	}
+	return resp.Values[:quantity], nil
+}
+
+// ReadHoldingRegisters reads quantity holding registers starting at addr.
+func (c *Client) ReadHoldingRegisters(ctx context.Context, uid uint8, addr, quantity uint16) ([]uint16, error) {
+	addr, err := c.Addressing.Protocol(HoldingRegisterTable, addr)
+	if err != nil {
+		return nil, err
+	}
+	return c.readHoldingRegisters(ctx, uid, addr, quantity)
+}
+
+// readHoldingRegisters is ReadHoldingRegisters at the protocol address addr.
+func (c *Client) readHoldingRegisters(ctx context.Context, uid uint8, addr, quantity uint16) ([]uint16, error) {
+	data, err := c.send(ctx, NewReadHoldingRegistersFrame(uid, addr, quantity))
+	if err != nil {
+		return nil, err
+	}
+	var resp ReadHoldingRegistersResponse
+	if err = resp.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	if len(resp.Values) != int(quantity) {
+		return nil, errMalformedResponse
+	}
+	return resp.Values, nil
+}
+
+// ReadDiscreteInputs reads quantity discrete inputs starting at addr.
+func (c *Client) ReadDiscreteInputs(ctx context.Context, uid uint8, addr, quantity uint16) ([]bool, error) {
+	addr, err := c.Addressing.Protocol(DiscreteInputTable, addr)
+	if err != nil {
+		return nil, err
+	}
+	return c.readDiscreteInputs(ctx, uid, addr, quantity)
+}
+
+// readDiscreteInputs is ReadDiscreteInputs at the protocol address addr.
+func (c *Client) readDiscreteInputs(ctx context.Context, uid uint8, addr, quantity uint16) ([]bool, error) {
+	data, err := c.send(ctx, NewReadDiscreteInputsFrame(uid, addr, quantity))
+	if err != nil {
+		return nil, err
+	}
+	var resp ReadDiscreteInputsResponse
+	if err = resp.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	if len(resp.Values) != 8*((int(quantity)+7)/8) {
+		return nil, errMalformedResponse
+	}
+	return resp.Values[:quantity], nil
+}
+
+// ReadInputRegisters reads quantity input registers starting at addr.
+func (c *Client) ReadInputRegisters(ctx context.Context, uid uint8, addr, quantity uint16) ([]uint16, error) {
+	addr, err := c.Addressing.Protocol(InputRegisterTable, addr)
+	if err != nil {
+		return nil, err
+	}
+	return c.readInputRegisters(ctx, uid, addr, quantity)
+}
+
+// readInputRegisters is ReadInputRegisters at the protocol address addr.
+func (c *Client) readInputRegisters(ctx context.Context, uid uint8, addr, quantity uint16) ([]uint16, error) {
+	data, err := c.send(ctx, NewReadInputRegistersFrame(uid, addr, quantity))
+	if err != nil {
+		return nil, err
+	}
+	var resp ReadInputRegistersResponse
+	if err = resp.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	if len(resp.Values) != int(quantity) {
+		return nil, errMalformedResponse
+	}
+	return resp.Values, nil
+}
+
+// WriteSingleCoil sets the coil at addr to value.
+func (c *Client) WriteSingleCoil(ctx context.Context, uid uint8, addr uint16, value bool) error {
+	addr, err := c.Addressing.Protocol(CoilTable, addr)
+	if err != nil {
+		return err
+	}
+	_, err = c.send(ctx, NewWriteSingleCoilFrame(uid, addr, value))
+	return err
+}
+
+// WriteSingleRegister writes value to the holding register at addr.
+func (c *Client) WriteSingleRegister(ctx context.Context, uid uint8, addr, value uint16) error {
+	addr, err := c.Addressing.Protocol(HoldingRegisterTable, addr)
+	if err != nil {
+		return err
+	}
+	_, err = c.send(ctx, NewWriteSingleRegisterFrame(uid, addr, value))
+	return err
+}
+
+// WriteMultipleCoils sets the coils starting at addr to values.
+func (c *Client) WriteMultipleCoils(ctx context.Context, uid uint8, addr uint16, values []bool) error {
+	addr, err := c.Addressing.Protocol(CoilTable, addr)
+	if err != nil {
+		return err
+	}
+	_, err = c.send(ctx, NewWriteMultipleCoilsFrame(uid, addr, values))
+	return err
+}
+
+// WriteMultipleRegisters writes values to the holding registers starting
+// at addr.
+func (c *Client) WriteMultipleRegisters(ctx context.Context, uid uint8, addr uint16, values []uint16) error {
+	addr, err := c.Addressing.Protocol(HoldingRegisterTable, addr)
+	if err != nil {
+		return err
+	}
+	_, err = c.send(ctx, NewWriteMultipleRegistersFrame(uid, addr, values))
+	return err
+}
+
+// readTable reads quantity values of table t starting at addr, coils and
+// discrete inputs having the values 0 and 1.
+func (c *Client) readTable(ctx context.Context, uid uint8, t Table, addr, quantity uint16) ([]uint16, error) {
+	addr, err := c.Addressing.Protocol(t, addr)
+	if err != nil {
+		return nil, err
+	}
+	return c.readProtocolTable(ctx, uid, t, addr, quantity)
+}
+
+// readProtocolTable is readTable at the protocol address addr, whatever
+// c.Addressing.
+func (c *Client) readProtocolTable(ctx context.Context, uid uint8, t Table, addr, quantity uint16) ([]uint16, error) {
+	var bits []bool
+	var err error
+	switch t {
+	case CoilTable:
+		bits, err = c.readCoils(ctx, uid, addr, quantity)
+	case DiscreteInputTable:
+		bits, err = c.readDiscreteInputs(ctx, uid, addr, quantity)
+	case InputRegisterTable:
+		return c.readInputRegisters(ctx, uid, addr, quantity)
+	default:
+		return c.readHoldingRegisters(ctx, uid, addr, quantity)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bitsToValues(bits), nil
+}
+
+// bitsToValues converts bits to the values 0 and 1.
+func bitsToValues(bits []bool) []uint16 {
+	values := make([]uint16, len(bits))
+	for i, b := range bits {
+		if b {
+			values[i] = 1
+		}
+	}
+	return values
+}
+
+// MaskWriteRegister modifies the holding register at addr to
+// (current AND andMask) OR (orMask AND NOT andMask).
+func (c *Client) MaskWriteRegister(ctx context.Context, uid uint8, addr, andMask, orMask uint16) error {
+	addr, err := c.Addressing.Protocol(HoldingRegisterTable, addr)
+	if err != nil {
+		return err
+	}
+	_, err = c.send(ctx, NewMaskWriteRegisterFrame(uid, addr, andMask, orMask))
+	return err
+}
+
+// UpdateRegister performs a read-modify-write of the holding register at
+// addr, replacing its value old with fn(old).
+//
+// When the slave supports Mask Write Register only the bits changed by fn
+// are written, leaving bits modified concurrently by other masters
+// intact; fn should therefore only set or clear bits. Otherwise the new
+// value is written with Write Single Register and, if VerifyUpdates is
+// set, read back. A read back differing from the written value means
+// another master wrote the register in between, and the whole update is
+// retried up to UpdateAttempts times before ErrUpdateConflict is
+// returned.
+func (c *Client) UpdateRegister(ctx context.Context, uid uint8, addr uint16, fn func(old uint16) uint16) error {
+	attempts := c.UpdateAttempts
+	if attempts <= 0 {
+		attempts = 3
+	}
+
+	for i := 0; i < attempts; i++ {
+		values, err := c.ReadHoldingRegisters(ctx, uid, addr, 1)
+		if err != nil {
+			return err
+		}
+		old := values[0]
+		value := fn(old)
+		if value == old {
+			return nil
+		}
+
+		if c.maskWriteSupported(uid) {
+			changed := old ^ value
+			err = c.MaskWriteRegister(ctx, uid, addr, ^changed, value&changed)
+			if !errors.Is(err, ErrIllegalFunction) {
+				return err
+			}
+			c.setMaskWriteUnsupported(uid)
+		}
+
+		if err = c.WriteSingleRegister(ctx, uid, addr, value); err != nil {
+			return err
+		}
+		if !c.VerifyUpdates {
+			return nil
+		}
+		if values, err = c.ReadHoldingRegisters(ctx, uid, addr, 1); err != nil {
+			return err
+		}
+		if values[0] == value {
+			return nil
+		}
+	}
+	return ErrUpdateConflict
+}
+
+func (c *Client) maskWriteSupported(uid uint8) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !c.noMaskUnit[uid]
+}
+
+func (c *Client) setMaskWriteUnsupported(uid uint8) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.noMaskUnit == nil {
+		c.noMaskUnit = make(map[uint8]bool)
+	}
+	c.noMaskUnit[uid] = true
+}
+
+// A ClientConn is a RoundTripper carrying transactions over a single
+// Modbus TCP connection. Each transaction is given its own transaction
+// identifier and responses are matched back to their requests by it, so
+// several transactions may be outstanding at once.
+//
+// Transactions waiting for one to complete are sent in order of the
+// Priority carried by their context, see WithPriority.
+//
+// The exported fields may be set after NewClientConn returns, before the
+// first call to RoundTrip.
+type ClientConn struct {
+	// MaxInFlight bounds the number of transactions outstanding at
+	// once; further calls to RoundTrip wait for a response to arrive.
+	// Many slaves handle a single transaction at a time, so if zero, 1
+	// is used.
+	MaxInFlight int
+
+	// MaxOvertakes is the number of times a waiting transaction may be
+	// overtaken by transactions of higher priority before it is sent
+	// next regardless. If zero, 8 is used.
+	MaxOvertakes int
+
+	// Timeout, if positive, bounds the duration of each transaction,
+	// in addition to any deadline of the context passed to RoundTrip.
+	Timeout time.Duration
+
+	// NextTid, if non nil, returns the transaction identifier of each
+	// request in place of a counter starting at 1, so that tests can
+	// produce identical traffic run to run. If it returns an
+	// identifier still in use, the following free one is used.
+	NextTid func() uint16
+
+	// Lenient makes the connection repair malformed responses, as
+	// some gateways send, instead of failing their transactions:
+	// trailing garbage within or after a frame is dropped, and data
+	// left outside a frame by a short MBAP Length is taken back into
+	// it. Each repair is logged to ErrorLog.
+	Lenient bool
+
+	// ErrorLog specifies an optional logger for the repairs of
+	// Lenient. If nil, logging goes to os.Stderr via the log package's
+	// standard logger.
+	ErrorLog *log.Logger
+
+	conn net.Conn
+	br   *bufio.Reader
+
+	wmu sync.Mutex // serialises writes to bw
+	bw  *bufio.Writer
+
+	queueOnce sync.Once
+	queue     *sendQueue  // admits transactions up to MaxInFlight
+	lenient   atomic.Bool // Lenient, published to readLoop by the first RoundTrip
+
+	mu        sync.Mutex // guards the following
+	tid       uint16     // last transaction identifier used
+	pending   map[uint16]chan *Frame
+	abandoned []uint16 // transactions given up on after being sent, oldest first
+	err       error    // set once the connection has failed
+}
+
+// maxAbandoned bounds the number of abandoned transactions whose
+// identifiers are kept from reuse until their late responses arrive.
+const maxAbandoned = 256
+
+// ErrClientConnClosed is returned by RoundTrip once the connection has
+// been closed or has failed.
+var ErrClientConnClosed = errors.New("modbus: client connection closed")
+
+// NewClientConn returns a ClientConn using conn.
+func NewClientConn(conn net.Conn) *ClientConn {
+	cc := &ClientConn{
+		conn:    conn,
+		br:      bufio.NewReader(conn),
+		bw:      bufio.NewWriter(conn),
+		pending: make(map[uint16]chan *Frame),
+	}
+	account(&accounted.clientConns, 1)
+	account(&accounted.goroutines, 1)
+	go cc.readLoop()
+	return cc
+}
+
+// readLoop delivers responses to the waiting transactions. Responses
+// carrying unknown transaction identifiers, or those of abandoned
+// transactions, are discarded.
+func (cc *ClientConn) readLoop() {
+	defer account(&accounted.goroutines, -1)
+	defer account(&accounted.clientConns, -1)
+	for {
+		resp, err := ReadFrame(cc.br)
+		if err != nil {
+			cc.fail(err)
+			return
+		}
+		if cc.lenient.Load() {
+			cc.normalize(resp)
+		}
+		cc.mu.Lock()
+		ch := cc.pending[resp.header.Tid]
+		delete(cc.pending, resp.header.Tid)
+		cc.mu.Unlock()
+		if ch != nil {
+			ch <- resp
+		}
+	}
+}
+
+// fail records err as the reason the connection is unusable, closes it
+// and wakes every waiting transaction.
+func (cc *ClientConn) fail(err error) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.err == nil {
+		cc.err = fmt.Errorf("%w: %v", ErrClientConnClosed, err)
+	}
+	cc.conn.Close()
+	for tid, ch := range cc.pending {
+		if ch != nil {
+			close(ch)
+		}
+		delete(cc.pending, tid)
+	}
+	cc.abandoned = nil
+}
+
+// register allocates a free transaction identifier and a channel for its
+// response.
+func (cc *ClientConn) register() (uint16, chan *Frame, error) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.err != nil {
+		return 0, nil, cc.err
+	}
+	if cc.NextTid != nil {
+		cc.tid = cc.NextTid()
+	} else {
+		cc.tid++
+	}
+	for {
+		if _, ok := cc.pending[cc.tid]; !ok {
+			break
+		}
+		cc.tid++
+	}
+	ch := make(chan *Frame, 1)
+	cc.pending[cc.tid] = ch
+	return cc.tid, ch, nil
+}
+
+func (cc *ClientConn) unregister(tid uint16) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	delete(cc.pending, tid)
+}
+
+// abandon gives up on the sent transaction tid. Its identifier is not
+// reused until its response arrives, so that a late response cannot be
+// taken for that of a later transaction, unless maxAbandoned later
+// transactions are abandoned first.
+func (cc *ClientConn) abandon(tid uint16) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if _, ok := cc.pending[tid]; !ok {
+		return // answered or failed meanwhile
+	}
+	cc.pending[tid] = nil
+	cc.abandoned = append(cc.abandoned, tid)
+	if len(cc.abandoned) > maxAbandoned {
+		if old := cc.abandoned[0]; cc.pending[old] == nil {
+			delete(cc.pending, old)
+		}
+		cc.abandoned = cc.abandoned[1:]
+	}
+}
+
+func (cc *ClientConn) write(ctx context.Context, f *Frame) error {
+	cc.wmu.Lock()
+	defer cc.wmu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	cc.conn.SetWriteDeadline(deadline)
+	interrupted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		cc.conn.SetWriteDeadline(aLongTimeAgo)
+		close(interrupted)
+	})
+	err := WriteFrame(f, cc.bw)
+	if err == nil {
+		err = cc.bw.Flush()
+	}
+	if !stop() {
+		// keep the deadline from being reset under a later write
+		<-interrupted
+		if err != nil {
+			err = ctx.Err()
+		}
+	}
+	if err != nil {
+		// a partly written frame leaves the stream unusable
+		cc.fail(err)
+	}
+	return err
+}
+
+// RoundTrip sends req with the next free transaction identifier, once
+// its turn in the send queue comes, and waits for the matching response,
+// the end of the transaction's Timeout, or ctx to be done. A request
+// whose sending is cut short by ctx fails the connection, as the stream
+// is left mid frame; a transaction given up on after its request was
+// sent leaves the connection usable, its late response discarded.
+func (cc *ClientConn) RoundTrip(ctx context.Context, req *Frame) (*Frame, error) {
+	if cc.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cc.Timeout)
+		defer cancel()
+	}
+
+	cc.queueOnce.Do(func() {
+		q := &sendQueue{max: cc.MaxInFlight, maxOvertakes: cc.MaxOvertakes}
+		if q.max <= 0 {
+			q.max = 1
+		}
+		if q.maxOvertakes <= 0 {
+			q.maxOvertakes = 8
+		}
+		cc.queue = q
+		cc.lenient.Store(cc.Lenient)
+	})
+	if err := cc.queue.acquire(ctx, priorityFrom(ctx)); err != nil {
+		return nil, err
+	}
+	defer cc.queue.release()
+
+	tid, ch, err := cc.register()
+	if err != nil {
+		return nil, err
+	}
+
+	f := &Frame{header: req.header, data: req.data}
+	f.header.Tid = tid
+	f.header.Pid = TcpPid
+	f.header.Length = uint16(len(f.data) + 2)
+	trace := ContextClientTrace(ctx)
+	err = cc.write(ctx, f)
+	if trace != nil && trace.FrameWritten != nil {
+		trace.FrameWritten(f.header, err)
+	}
+	if err != nil {
+		cc.unregister(tid)
+		return nil, err
+	}
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			cc.mu.Lock()
+			defer cc.mu.Unlock()
+			return nil, cc.err
+		}
+		if trace != nil {
+			trace.response(resp)
+		}
+		return resp, nil
+	case <-ctx.Done():
+		cc.abandon(tid)
+		return nil, ctx.Err()
+	}
+}
+
+// Close closes the underlying connection. Outstanding and later
+// transactions fail with ErrClientConnClosed.
+func (cc *ClientConn) Close() error {
+	err := cc.conn.Close()
+	cc.fail(errors.New("closed by client"))
+	return err
+}
@@ -0,0 +1,247 @@
+package modbus
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// noMaskHandler answers Mask Write Register with IllegalFunction and
+// optionally clobbers every register written, imitating another master.
+type noMaskHandler struct {
+	*RegisterHandler
+	clobber bool
+}
+
+func (h noMaskHandler) ServeModbus(w ResponseWriter, r *Frame) {
+	switch FunctionCode(r.header.Fcode) {
+	case MaskWriteRegister:
+		w.WriteException(IllegalFunction)
+	case WriteSingleRegister:
+		h.RegisterHandler.ServeModbus(w, r)
+		if h.clobber {
+			h.Holdings[r.data[1]] ^= 0x8000
+		}
+	default:
+		h.RegisterHandler.ServeModbus(w, r)
+	}
+}
+
+func dialTestServer(t testing.TB, h Handler) *Client {
+	addr := startTestServer(t, &Server{Handler: h})
+	c, err := Dial(addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestClientReadHoldingRegisters(t *testing.T) {
+	h := &RegisterHandler{}
+	h.Holdings = append(make([]uint16, 0x6B), []uint16{0x022B, 0x0001, 0x0064}...)
+	c := dialTestServer(t, h)
+
+	values, err := c.ReadHoldingRegisters(context.Background(), 0xFF, 0x6B, 3)
+	if err != nil {
+		t.Fatalf("err should be nil not %v", err)
+	}
+	for i, v := range []uint16{0x022B, 0x0001, 0x0064} {
+		if values[i] != v {
+			t.Errorf("0x%04X not 0x%04X", values[i], v)
+		}
+	}
+
+	_, err = c.ReadHoldingRegisters(context.Background(), 0xFF, 0x6C, 3)
+	if !errors.Is(err, ErrIllegalDataAddress) {
+		t.Errorf("expected IllegalDataAddress exception not %v", err)
+	}
+}
+
+func TestClientUpdateRegisterMaskWrite(t *testing.T) {
+	h := &RegisterHandler{Holdings: []uint16{0x00F0, 0x1234}}
+	c := dialTestServer(t, h)
+
+	err := c.UpdateRegister(context.Background(), 0xFF, 1, func(old uint16) uint16 { return old | 0x0001 })
+	if err != nil {
+		t.Fatalf("err should be nil not %v", err)
+	}
+	if h.Holdings[1] != 0x1235 {
+		t.Errorf("0x%04X not 0x%04X", h.Holdings[1], 0x1235)
+	}
+}
+
+func TestClientUpdateRegisterFallback(t *testing.T) {
+	h := &RegisterHandler{Holdings: []uint16{0x00F0, 0x1234}}
+	c := dialTestServer(t, noMaskHandler{RegisterHandler: h})
+	c.VerifyUpdates = true
+
+	err := c.UpdateRegister(context.Background(), 0xFF, 0, func(old uint16) uint16 { return old &^ 0x0010 })
+	if err != nil {
+		t.Fatalf("err should be nil not %v", err)
+	}
+	if h.Holdings[0] != 0x00E0 {
+		t.Errorf("0x%04X not 0x%04X", h.Holdings[0], 0x00E0)
+	}
+	if c.maskWriteSupported(0xFF) {
+		t.Errorf("mask write should be marked unsupported")
+	}
+}
+
+func TestClientUpdateRegisterConflict(t *testing.T) {
+	h := &RegisterHandler{Holdings: []uint16{0x00F0, 0x1234}}
+	c := dialTestServer(t, noMaskHandler{RegisterHandler: h, clobber: true})
+	c.VerifyUpdates = true
+
+	err := c.UpdateRegister(context.Background(), 0xFF, 0, func(old uint16) uint16 { return old + 1 })
+	if err != ErrUpdateConflict {
+		t.Errorf("err should be ErrUpdateConflict not %v", err)
+	}
+}
+
+// reorderingSlave accepts one connection, reads n requests and answers
+// them in reverse order, each with a single register holding the
+// request's transaction identifier.
+func reorderingSlave(t *testing.T, n int) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		br := bufio.NewReader(c)
+		var reqs []*Frame
+		for len(reqs) < n {
+			f, err := ReadFrame(br)
+			if err != nil {
+				return
+			}
+			reqs = append(reqs, f)
+		}
+		bw := bufio.NewWriter(c)
+		for i := len(reqs) - 1; i >= 0; i-- {
+			h := reqs[i].header
+			WriteFrame(NewFrame(h, []byte{0x02, byte(h.Tid >> 8), byte(h.Tid)}), bw)
+		}
+		bw.Flush()
+		io.Copy(io.Discard, c)
+	}()
+	return l.Addr().String()
+}
+
+func TestClientConnPipelined(t *testing.T) {
+	const n = 3
+	conn, err := net.Dial("tcp", reorderingSlave(t, n))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	cc := NewClientConn(conn)
+	cc.MaxInFlight = n
+	defer cc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := cc.RoundTrip(ctx, NewReadHoldingRegistersFrame(1, 0, 1))
+			if err != nil {
+				t.Errorf("RoundTrip: %v", err)
+				return
+			}
+			if tid := uint16(resp.data[1])<<8 | uint16(resp.data[2]); tid != resp.header.Tid {
+				t.Errorf("response for transaction %d delivered to %d", tid, resp.header.Tid)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestClientConnTimeout(t *testing.T) {
+	// the slave waits for two requests before answering either
+	conn, err := net.Dial("tcp", reorderingSlave(t, 2))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	cc := NewClientConn(conn)
+	cc.Timeout = 50 * time.Millisecond
+	defer cc.Close()
+
+	_, err = cc.RoundTrip(context.Background(), NewReadHoldingRegistersFrame(1, 0, 1))
+	if err != context.DeadlineExceeded {
+		t.Fatalf("RoundTrip = %v; want %v", err, context.DeadlineExceeded)
+	}
+
+	// the late response to the first request is discarded
+	resp, err := cc.RoundTrip(context.Background(), NewReadHoldingRegistersFrame(1, 0, 1))
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+	if resp.header.Tid != 2 || resp.data[2] != 2 {
+		t.Errorf("response for transaction %d, want 2", resp.data[2])
+	}
+
+	cc.Close()
+	if _, err = cc.RoundTrip(context.Background(), NewReadHoldingRegistersFrame(1, 0, 1)); !errors.Is(err, ErrClientConnClosed) {
+		t.Errorf("RoundTrip after Close = %v; want %v", err, ErrClientConnClosed)
+	}
+}
+
+func TestClientConnAbandonedTid(t *testing.T) {
+	conn, err := net.Dial("tcp", reorderingSlave(t, 2))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	cc := NewClientConn(conn)
+	cc.NextTid = func() uint16 { return 1 }
+	defer cc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := cc.RoundTrip(ctx, NewReadHoldingRegistersFrame(1, 0, 1)); err != context.DeadlineExceeded {
+		t.Fatalf("RoundTrip = %v; want %v", err, context.DeadlineExceeded)
+	}
+
+	// the identifier of the abandoned transaction is not reused until
+	// its late response arrives
+	resp, err := cc.RoundTrip(context.Background(), NewReadHoldingRegistersFrame(1, 0, 1))
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+	if resp.header.Tid != 2 || resp.data[2] != 2 {
+		t.Errorf("response for transaction %d delivered to %d; want 2", resp.data[2], resp.header.Tid)
+	}
+}
+
+func TestDialContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := DialContext(ctx, startTestServer(t, &Server{Handler: &RegisterHandler{}})); !errors.Is(err, context.Canceled) {
+		t.Errorf("DialContext with a cancelled context = %v; want %v", err, context.Canceled)
+	}
+}
+
+func BenchmarkClientReadHoldingRegisters(b *testing.B) {
+	h := &RegisterHandler{Holdings: make([]uint16, MaxReadRegisters)}
+	c := dialTestServer(b, h)
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.ReadHoldingRegisters(ctx, 1, 0, MaxReadRegisters); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// Command modbus-slave serves a Modbus TCP slave whose registers are
+// described by a register map file, for quick integration testing of
+// masters. Each unit identifier served gets its own copy of the map.
+//
+// Usage:
+//
+//	modbus-slave [flags] map.json
+//
+// The register map is a JSON file, or a YAML file named *.yaml or *.yml
+// when built with the modbus_yaml tag. It gives the ranges of each
+// table and named points with their initial values, see
+// modbus.RegisterMap:
+//
+//	{
+//		"holding_registers": [{"start": 0, "count": 100}],
+//		"points": [
+//			{"name": "setpoint", "table": "holding registers", "address": 0,
+//			 "type": "float32", "value": 21.5},
+//			{"name": "running", "table": "coils", "address": 3,
+//			 "type": "bool", "value": 1}
+//		]
+//	}
+//
+// Latency, exceptions and lost responses may be injected to exercise
+// the masters' timeout and error handling.
+//
+// Under systemd socket activation the sockets passed are served in place
+// of -addr.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	modbus "github.com/mubeta06/gomodbus"
+	"github.com/mubeta06/gomodbus/simulator"
+)
+
+var (
+	addr          = flag.String("addr", ":502", "TCP address to listen on")
+	units         = flag.String("units", "1", "unit identifiers served, e.g. 1,2,10-20")
+	latency       = flag.Duration("latency", 0, "delay before every response")
+	jitter        = flag.Duration("jitter", 0, "maximum random delay added to the latency")
+	exceptionRate = flag.Float64("exception-rate", 0, "fraction of requests answered with an injected exception")
+	exceptionCode = flag.String("exception", "SlaveBusy", "exception code injected, by name or number")
+	dropRate      = flag.Float64("drop-rate", 0, "fraction of requests served but left unanswered")
+	verbose       = flag.Bool("v", false, "log every request, decoded")
+	seed          = flag.Int64("seed", 0, "seed of the random latency and exceptions; if zero, the time is used")
+)
+
+func main() {
+	log.SetPrefix("modbus-slave: ")
+	log.SetFlags(0)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: modbus-slave [flags] map.json|map.yaml\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	m, err := os.ReadFile(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	uids, err := parseUnits(*units)
+	if err != nil {
+		log.Fatal(err)
+	}
+	mux := modbus.NewServeMux()
+	for _, uid := range uids {
+		h, err := loadMap(flag.Arg(0), m)
+		if err != nil {
+			log.Fatalf("%s: %v", flag.Arg(0), err)
+		}
+		mux.Handle(uid, h)
+	}
+
+	code, err := modbus.ParseExceptionCode(*exceptionCode)
+	if err != nil {
+		log.Fatal(err)
+	}
+	faults := &simulator.Faults{
+		ExceptionRate: *exceptionRate,
+		Exception:     code,
+		DropRate:      *dropRate,
+		Seed:          *seed,
+	}
+	if *latency > 0 || *jitter > 0 {
+		faults.Latency = simulator.UniformLatency(*latency, *latency+*jitter)
+	}
+	srv := &modbus.Server{Addr: *addr, Handler: mux}
+	if *verbose {
+		srv.Use(logRequests)
+	}
+	srv.Use(faults.Middleware)
+	listeners, err := modbus.SystemdListeners()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(listeners) > 0 {
+		log.Printf("serving units %s on %d activated sockets", *units, len(listeners))
+		log.Fatal(srv.ServeAll(listeners...))
+	}
+	log.Printf("serving units %s on %s", *units, *addr)
+	log.Fatal(srv.ListenAndServe())
+}
+
+// loadYAML is modbus.LoadRegisterMapYAML when built with the
+// modbus_yaml tag.
+var loadYAML func(io.Reader) (*modbus.RegisterHandler, error)
+
+// loadMap loads the register map m read from the file name, as YAML if
+// name has a YAML extension or else as JSON.
+func loadMap(name string, m []byte) (*modbus.RegisterHandler, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		if loadYAML == nil {
+			return nil, errors.New("YAML register maps require building with the modbus_yaml tag")
+		}
+		return loadYAML(bytes.NewReader(m))
+	}
+	return modbus.LoadRegisterMap(bytes.NewReader(m))
+}
+
+// logRequests logs the decoding of every request.
+func logRequests(h modbus.Handler) modbus.Handler {
+	return modbus.HandlerFunc(func(w modbus.ResponseWriter, r *modbus.Frame) {
+		info, _ := modbus.ContextConnInfo(r.Context())
+		log.Printf("%v: %v", info.RemoteAddr, r)
+		h.ServeModbus(w, r)
+	})
+}
+
+// parseUnits parses a comma separated list of unit identifiers and
+// inclusive ranges of them.
+func parseUnits(s string) ([]uint8, error) {
+	var uids []uint8
+	seen := make(map[uint8]bool)
+	for _, field := range strings.Split(s, ",") {
+		lo, hi, isRange := strings.Cut(strings.TrimSpace(field), "-")
+		first, err := strconv.ParseUint(lo, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("bad unit identifier %q", field)
+		}
+		last := first
+		if isRange {
+			if last, err = strconv.ParseUint(hi, 10, 8); err != nil || last < first {
+				return nil, fmt.Errorf("bad unit identifier range %q", field)
+			}
+		}
+		for uid := first; uid <= last; uid++ {
+			if !seen[uint8(uid)] {
+				seen[uint8(uid)] = true
+				uids = append(uids, uint8(uid))
+			}
+		}
+	}
+	return uids, nil
+}
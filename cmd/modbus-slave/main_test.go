@@ -0,0 +1,32 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseUnits(t *testing.T) {
+	uids, err := parseUnits("1, 5-7,6,255")
+	if err != nil || !reflect.DeepEqual(uids, []uint8{1, 5, 6, 7, 255}) {
+		t.Errorf("parseUnits = %v, %v", uids, err)
+	}
+	for _, s := range []string{"", "256", "7-5", "a", "1-x"} {
+		if _, err := parseUnits(s); err == nil {
+			t.Errorf("parseUnits(%q) succeeded", s)
+		}
+	}
+}
+
+func TestLoadMap(t *testing.T) {
+	if h, err := loadMap("map.json", []byte(`{"holding_registers": [{"start": 0, "values": [7]}]}`)); err != nil || !reflect.DeepEqual(h.Holdings, []uint16{7}) {
+		t.Errorf("loadMap of JSON = %v, %v", h, err)
+	}
+	h, err := loadMap("map.YML", []byte("holding_registers:\n  - start: 0\n    values: [7]\n"))
+	if loadYAML == nil {
+		if err == nil {
+			t.Errorf("loadMap of YAML succeeded without the modbus_yaml tag")
+		}
+	} else if err != nil || !reflect.DeepEqual(h.Holdings, []uint16{7}) {
+		t.Errorf("loadMap of YAML = %v, %v", h, err)
+	}
+}
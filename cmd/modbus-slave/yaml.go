@@ -0,0 +1,9 @@
+//go:build modbus_yaml
+
+package main
+
+import modbus "github.com/mubeta06/gomodbus"
+
+func init() {
+	loadYAML = modbus.LoadRegisterMapYAML
+}
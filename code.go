@@ -0,0 +1,106 @@
+package modbus
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A FunctionCode identifies the function of a Modbus request, such as
+// ReadHoldingRegisters. It is named in logs and errors. The function
+// code of a Header is a byte and compares to a constant once converted:
+// FunctionCode(h.Fcode) == ReadCoils.
+type FunctionCode uint8
+
+// An ExceptionCode is the code of an exception response, such as
+// IllegalDataAddress.
+type ExceptionCode uint8
+
+var functionNames = map[FunctionCode]string{
+	ReadCoils:              "ReadCoils",
+	ReadDiscreteInputs:     "ReadDiscreteInputs",
+	ReadHoldingRegisters:   "ReadHoldingRegisters",
+	ReadInputRegisters:     "ReadInputRegisters",
+	WriteSingleCoil:        "WriteSingleCoil",
+	WriteSingleRegister:    "WriteSingleRegister",
+	ReadExceptionStatus:    "ReadExceptionStatus",
+	PollProgramComplete:    "PollProgramComplete",
+	WriteMultipleCoils:     "WriteMultipleCoils",
+	WriteMultipleRegisters: "WriteMultipleRegisters",
+	ReportSlaveId:          "ReportSlaveId",
+	MaskWriteRegister:      "MaskWriteRegister",
+	WriteAndReadRegisters:  "WriteAndReadRegisters",
+}
+
+var exceptionNames = map[ExceptionCode]string{
+	IllegalFunction:        "IllegalFunction",
+	IllegalDataAddress:     "IllegalDataAddress",
+	IllegalDataValue:       "IllegalDataValue",
+	SlaveFailure:           "SlaveFailure",
+	Acknowledge:            "Acknowledge",
+	SlaveBusy:              "SlaveBusy",
+	NegativeAcknowledge:    "NegativeAcknowledge",
+	MemoryParityError:      "MemoryParityError",
+	NotDefined:             "NotDefined",
+	GatewayPathUnavailable: "GatewayPathUnavailable",
+	GatewayTargetFailed:    "GatewayTargetFailed",
+}
+
+// String returns the name of the function, such as
+// "ReadHoldingRegisters". The function code of an exception response,
+// with its high bit set, is named after the function with an
+// "Exception" suffix. Unknown codes are rendered as "FunctionCode(0x41)".
+func (c FunctionCode) String() string {
+	if name, ok := functionNames[c]; ok {
+		return name
+	}
+	if name, ok := functionNames[c&^0x80]; ok {
+		return name + "Exception"
+	}
+	return fmt.Sprintf("FunctionCode(0x%02X)", uint8(c))
+}
+
+// IsException reports whether c is the function code of an exception
+// response.
+func (c FunctionCode) IsException() bool {
+	return c&0x80 != 0
+}
+
+// String returns the name of the exception, such as "IllegalDataAddress".
+// Unknown codes are rendered as "ExceptionCode(0x0C)".
+func (c ExceptionCode) String() string {
+	if name, ok := exceptionNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("ExceptionCode(0x%02X)", uint8(c))
+}
+
+// ParseFunctionCode parses a function code given by its name, in any
+// case, or by its number in Go syntax, such as "3" or "0x03".
+func ParseFunctionCode(s string) (FunctionCode, error) {
+	for c, name := range functionNames {
+		if strings.EqualFold(s, name) {
+			return c, nil
+		}
+	}
+	n, err := strconv.ParseUint(s, 0, 8)
+	if err != nil {
+		return 0, fmt.Errorf("modbus: unknown function code %q", s)
+	}
+	return FunctionCode(n), nil
+}
+
+// ParseExceptionCode parses an exception code given by its name, in any
+// case, or by its number in Go syntax, such as "2" or "0x02".
+func ParseExceptionCode(s string) (ExceptionCode, error) {
+	for c, name := range exceptionNames {
+		if strings.EqualFold(s, name) {
+			return c, nil
+		}
+	}
+	n, err := strconv.ParseUint(s, 0, 8)
+	if err != nil {
+		return 0, fmt.Errorf("modbus: unknown exception code %q", s)
+	}
+	return ExceptionCode(n), nil
+}
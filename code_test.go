@@ -0,0 +1,46 @@
+package modbus
+
+import "testing"
+
+func TestCodeString(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{FunctionCode(ReadHoldingRegisters).String(), "ReadHoldingRegisters"},
+		{FunctionCode(0x80 | WriteMultipleCoils).String(), "WriteMultipleCoilsException"},
+		{FunctionCode(0x41).String(), "FunctionCode(0x41)"},
+		{ExceptionCode(IllegalDataAddress).String(), "IllegalDataAddress"},
+		{ExceptionCode(0x0C).String(), "ExceptionCode(0x0C)"},
+		{(&ModbusError{ExceptionCode: SlaveBusy}).Error(), "modbus: SlaveBusy"},
+	}
+	for _, tt := range tests {
+		if tt.s != tt.want {
+			t.Errorf("got %q; want %q", tt.s, tt.want)
+		}
+	}
+}
+
+func TestParseCode(t *testing.T) {
+	for s, want := range map[string]FunctionCode{
+		"ReadHoldingRegisters": ReadHoldingRegisters,
+		"writesinglecoil":      WriteSingleCoil,
+		"0x17":                 WriteAndReadRegisters,
+		"65":                   0x41,
+	} {
+		if c, err := ParseFunctionCode(s); c != want || err != nil {
+			t.Errorf("ParseFunctionCode(%q) = %v, %v; want %v", s, c, err, want)
+		}
+	}
+	if c, err := ParseExceptionCode("gatewaytargetfailed"); c != GatewayTargetFailed || err != nil {
+		t.Errorf("ParseExceptionCode = %v, %v", c, err)
+	}
+	for _, s := range []string{"", "ReadEverything", "0x100", "-1"} {
+		if _, err := ParseFunctionCode(s); err == nil {
+			t.Errorf("ParseFunctionCode(%q) succeeded", s)
+		}
+		if _, err := ParseExceptionCode(s); err == nil {
+			t.Errorf("ParseExceptionCode(%q) succeeded", s)
+		}
+	}
+}
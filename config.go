@@ -0,0 +1,600 @@
+package modbus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mubeta06/gomodbus/serial"
+)
+
+// A Config describes a server and gateway: the addresses it listens on,
+// the timeouts of its connections, and the backend of each unit it
+// serves. Its JSON form, as read by LoadConfig, is
+//
+//	{
+//		"listen": [":502"],
+//		"read_timeout": "30s",
+//		"units": [
+//			{"unit": 1, "registers": {"holding_registers": [{"start": 0, "count": 100}]}},
+//			{"unit": 2, "target": "10.0.0.7:502", "slave": 1, "timeout": "500ms"},
+//			{"unit": 3, "device": "/dev/ttyUSB0", "baud_rate": 9600, "parity": "N", "slave": 5}
+//		]
+//	}
+//
+// A ConfigServer serves a Config and applies changes to it.
+type Config struct {
+	// Listen are the TCP addresses listened on. If empty, ":502" is.
+	Listen []string `json:"listen,omitempty"`
+
+	// Timeouts of the connections, see the fields of Server of the
+	// same names.
+	ReadTimeout      Duration `json:"read_timeout,omitempty"`
+	WriteTimeout     Duration `json:"write_timeout,omitempty"`
+	IdleTimeout      Duration `json:"idle_timeout,omitempty"`
+	InterByteTimeout Duration `json:"inter_byte_timeout,omitempty"`
+
+	Units []UnitConfig `json:"units,omitempty"`
+}
+
+// A UnitConfig describes the backend of a unit: registers simulated in
+// process, a Modbus TCP slave, or a slave on an RTU serial line. Exactly
+// one of Registers, Target and Device is set.
+type UnitConfig struct {
+	Unit uint8 `json:"unit"`
+
+	// Registers are the tables of a simulated device.
+	Registers *RegisterMap `json:"registers,omitempty"`
+
+	// Target is the TCP address of a slave requests are forwarded to.
+	Target string `json:"target,omitempty"`
+
+	// Device is the serial port of an RTU line requests are forwarded
+	// over, with its BaudRate and Parity, "E", "O" or "N". Units on
+	// the same line must agree on them. If zero, 19200 baud and even
+	// parity are used.
+	Device   string `json:"device,omitempty"`
+	BaudRate int    `json:"baud_rate,omitempty"`
+	Parity   string `json:"parity,omitempty"`
+
+	// Slave, if non zero, is the unit identifier, or RTU address,
+	// forwarded requests are sent to. Otherwise they keep Unit.
+	Slave uint8 `json:"slave,omitempty"`
+
+	// Timeout bounds the time the slave is given to respond. If zero,
+	// 1s is used.
+	Timeout Duration `json:"timeout,omitempty"`
+}
+
+// A Duration is a time.Duration read from JSON as a string such as
+// "1.5s", or as a number of nanoseconds.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		n, err := strconv.ParseInt(string(b), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid duration %s", b)
+		}
+		*d = Duration(n)
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// LoadConfig reads a Config from r and validates it.
+func LoadConfig(r io.Reader) (*Config, error) {
+	var c Config
+	d := json.NewDecoder(r)
+	d.DisallowUnknownFields()
+	if err := d.Decode(&c); err != nil {
+		return nil, fmt.Errorf("modbus: config: %w", err)
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// validate reports the first inconsistency of c.
+func (c *Config) validate() error {
+	seen := make(map[uint8]bool)
+	lines := make(map[string]serial.Config)
+	for _, u := range c.Units {
+		if seen[u.Unit] {
+			return fmt.Errorf("modbus: config: unit %d configured twice", u.Unit)
+		}
+		seen[u.Unit] = true
+		n := 0
+		for _, set := range []bool{u.Registers != nil, u.Target != "", u.Device != ""} {
+			if set {
+				n++
+			}
+		}
+		if n != 1 {
+			return fmt.Errorf("modbus: config: unit %d needs one of registers, target and device", u.Unit)
+		}
+		if u.Device == "" {
+			continue
+		}
+		l, err := u.line()
+		if err != nil {
+			return fmt.Errorf("modbus: config: unit %d: %w", u.Unit, err)
+		}
+		if other, ok := lines[u.Device]; ok && other != l {
+			return fmt.Errorf("modbus: config: unit %d: settings of %s differ from its other units'", u.Unit, u.Device)
+		}
+		lines[u.Device] = l
+	}
+	return nil
+}
+
+// line returns the configuration of the serial line of u.
+func (u *UnitConfig) line() (serial.Config, error) {
+	c := serial.Config{Address: u.Device, BaudRate: u.BaudRate, Parity: serial.ParityEven}
+	if c.BaudRate == 0 {
+		c.BaudRate = 19200
+	}
+	switch u.Parity {
+	case "", "E":
+	case "O":
+		c.Parity = serial.ParityOdd
+	case "N":
+		c.Parity = serial.ParityNone
+	default:
+		return c, fmt.Errorf("invalid parity %q", u.Parity)
+	}
+	return c, nil
+}
+
+// A ConfigServer serves a Config, read from the file Path, with a Server
+// whose Handler is a Gateway routing each unit to its backend. Reload
+// applies changes to the file without dropping established connections
+// where possible:
+//
+//   - units whose configuration is unchanged keep their backend, and
+//     simulated registers their values;
+//   - addresses no longer listened on stop accepting connections, but
+//     their connections are served until they close;
+//   - changed timeouts apply to new connections, which are accepted by
+//     a new Server, while established ones keep their Server.
+//
+// Connections to TCP slaves and serial lines are closed once no unit
+// uses them, failing the requests they were forwarding. A Server given
+// a MaxConnections by Setup is only handed off from once it accepts
+// again.
+type ConfigServer struct {
+	// Path is the file of the configuration.
+	Path string
+
+	// Setup, if non nil, is called with each Server made before it
+	// serves, e.g. to set its ErrorLog and add middleware.
+	Setup func(*Server)
+
+	// OpenSerial opens serial lines. If nil, serial.Open is used. A
+	// device whose settings change is opened again before the port
+	// opened with its old settings is closed.
+	OpenSerial func(serial.Config) (serial.Port, error)
+
+	// ErrorLog specifies an optional logger for errors serving the
+	// listeners. If nil, logging goes to os.Stderr via the log
+	// package's standard logger.
+	ErrorLog *log.Logger
+
+	mu        sync.Mutex
+	cfg       *Config
+	srv       *Server
+	gw        Gateway
+	listeners map[string]*configListener
+	units     map[uint8]*configUnit
+	lines     map[string]*configLine
+	closed    bool
+}
+
+// A configUnit is the backend of a unit and the configuration it was
+// made from.
+type configUnit struct {
+	cfg   UnitConfig
+	h     Handler
+	close func() error // closes the backend's pool, if any
+}
+
+// A configLine is an open serial line.
+type configLine struct {
+	cfg serial.Config
+	t   *RTUTransport
+}
+
+// Reload reads the configuration file and applies it. The first call
+// starts serving. On error, the configuration served is unchanged,
+// except for addresses that failed to be listened on.
+func (cs *ConfigServer) Reload() error {
+	f, err := os.Open(cs.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	c, err := LoadConfig(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", cs.Path, err)
+	}
+	return cs.Apply(c)
+}
+
+// Apply applies c as Reload does.
+func (cs *ConfigServer) Apply(c *Config) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.closed {
+		return errors.New("modbus: config server closed")
+	}
+	if err := cs.applyUnits(c); err != nil {
+		return err
+	}
+	if cs.srv == nil || !sameTimeouts(cs.cfg, c) {
+		cs.newServer(c)
+	}
+	cs.cfg = c
+	return cs.applyListeners(c)
+}
+
+// Gateway returns the Gateway the units are routed by. Routes may be
+// added to it, but are replaced by those of the configuration for the
+// units it configures.
+func (cs *ConfigServer) Gateway() *Gateway {
+	return &cs.gw
+}
+
+// Addrs returns the addresses listened on, in the order configured.
+func (cs *ConfigServer) Addrs() []net.Addr {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	var addrs []net.Addr
+	if cs.cfg != nil {
+		for _, a := range listenAddrs(cs.cfg) {
+			if l, ok := cs.listeners[a]; ok {
+				addrs = append(addrs, l.Addr())
+			}
+		}
+	}
+	return addrs
+}
+
+// Close stops listening and closes the connections to the backends.
+// Established connections of masters are served until they close.
+func (cs *ConfigServer) Close() error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.closed = true
+	var first error
+	keep := func(err error) {
+		if first == nil {
+			first = err
+		}
+	}
+	for a, l := range cs.listeners {
+		keep(l.close())
+		delete(cs.listeners, a)
+	}
+	for uid, u := range cs.units {
+		cs.gw.Route(uid, nil)
+		if u.close != nil {
+			keep(u.close())
+		}
+		delete(cs.units, uid)
+	}
+	for dev, l := range cs.lines {
+		keep(l.t.Port.Close())
+		delete(cs.lines, dev)
+	}
+	return first
+}
+
+func (cs *ConfigServer) logf(format string, args ...interface{}) {
+	if cs.ErrorLog != nil {
+		cs.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// applyUnits makes the backends of the units of c that changed and
+// routes them, closing those no longer used.
+func (cs *ConfigServer) applyUnits(c *Config) error {
+	lines, err := cs.openLines(c)
+	if err != nil {
+		return err
+	}
+	units := make(map[uint8]*configUnit, len(c.Units))
+	for _, uc := range c.Units {
+		if u, ok := cs.units[uc.Unit]; ok && reflect.DeepEqual(u.cfg, uc) && cs.sameLine(uc, lines) {
+			units[uc.Unit] = u
+			continue
+		}
+		u, err := newConfigUnit(uc, lines)
+		if err != nil {
+			for uid, u := range units {
+				if cs.units[uid] != u && u.close != nil {
+					u.close()
+				}
+			}
+			cs.discardLines(lines)
+			return fmt.Errorf("modbus: config: unit %d: %w", uc.Unit, err)
+		}
+		units[uc.Unit] = u
+	}
+
+	for uid, u := range units {
+		if cs.units[uid] != u {
+			cs.gw.Route(uid, u.h)
+		}
+	}
+	for uid, u := range cs.units {
+		if units[uid] != u {
+			if _, ok := units[uid]; !ok {
+				cs.gw.Route(uid, nil)
+			}
+			if u.close != nil {
+				u.close()
+			}
+		}
+	}
+	for dev, l := range cs.lines {
+		if lines[dev] != l {
+			l.t.Port.Close()
+		}
+	}
+	cs.units = units
+	cs.lines = lines
+	return nil
+}
+
+// openLines returns the serial lines of c, reusing those open with the
+// same settings, and opening the others. Lines open with other settings
+// are left open, to be closed once the lines returned are in use.
+func (cs *ConfigServer) openLines(c *Config) (map[string]*configLine, error) {
+	lines := make(map[string]*configLine)
+	for _, uc := range c.Units {
+		if uc.Device == "" || lines[uc.Device] != nil {
+			continue
+		}
+		sc, _ := uc.line()
+		if l, ok := cs.lines[uc.Device]; ok && l.cfg == sc {
+			lines[uc.Device] = l
+			continue
+		}
+		p, err := cs.openSerial(sc)
+		if err != nil {
+			cs.discardLines(lines)
+			return nil, fmt.Errorf("modbus: config: %w", err)
+		}
+		lines[uc.Device] = &configLine{cfg: sc, t: &RTUTransport{Port: p, Config: sc}}
+	}
+	return lines, nil
+}
+
+// sameLine reports whether the line of uc is still the one open.
+func (cs *ConfigServer) sameLine(uc UnitConfig, lines map[string]*configLine) bool {
+	return uc.Device == "" || cs.lines[uc.Device] == lines[uc.Device]
+}
+
+func (cs *ConfigServer) openSerial(c serial.Config) (serial.Port, error) {
+	if cs.OpenSerial != nil {
+		return cs.OpenSerial(c)
+	}
+	return serial.Open(c)
+}
+
+// discardLines closes the lines of lines not open in cs, opened for a
+// configuration that failed to apply. As opening a device applies its
+// settings to the line, devices still open in cs with other settings
+// are opened again with theirs, and closed.
+func (cs *ConfigServer) discardLines(lines map[string]*configLine) {
+	for dev, l := range lines {
+		old, ok := cs.lines[dev]
+		if old == l {
+			continue
+		}
+		l.t.Port.Close()
+		if !ok {
+			continue
+		}
+		p, err := cs.openSerial(old.cfg)
+		if err != nil {
+			cs.logf("modbus: config: restoring the settings of %s: %v", dev, err)
+			continue
+		}
+		p.Close()
+	}
+}
+
+// newConfigUnit makes the backend of uc.
+func newConfigUnit(uc UnitConfig, lines map[string]*configLine) (*configUnit, error) {
+	u := &configUnit{cfg: uc}
+	switch {
+	case uc.Registers != nil:
+		h, err := uc.Registers.Handler()
+		if err != nil {
+			return nil, err
+		}
+		u.h = h
+	case uc.Target != "":
+		pool := &ClientPool{Addr: uc.Target, Lenient: true}
+		u.h = &Forwarder{Transport: pool, Unit: uc.Slave, Timeout: time.Duration(uc.Timeout)}
+		u.close = pool.Close
+	default:
+		u.h = &Forwarder{Transport: lines[uc.Device].t, Unit: uc.Slave, Timeout: time.Duration(uc.Timeout)}
+	}
+	return u, nil
+}
+
+// sameTimeouts reports whether a and b configure the same timeouts.
+func sameTimeouts(a, b *Config) bool {
+	return a.ReadTimeout == b.ReadTimeout && a.WriteTimeout == b.WriteTimeout &&
+		a.IdleTimeout == b.IdleTimeout && a.InterByteTimeout == b.InterByteTimeout
+}
+
+// newServer makes the Server of c and hands the listeners over to it.
+func (cs *ConfigServer) newServer(c *Config) {
+	srv := &Server{
+		Handler:          &cs.gw,
+		ReadTimeout:      time.Duration(c.ReadTimeout),
+		WriteTimeout:     time.Duration(c.WriteTimeout),
+		IdleTimeout:      time.Duration(c.IdleTimeout),
+		InterByteTimeout: time.Duration(c.InterByteTimeout),
+		ErrorLog:         cs.ErrorLog,
+	}
+	if cs.Setup != nil {
+		cs.Setup(srv)
+	}
+	cs.srv = srv
+	for _, l := range cs.listeners {
+		l.handOff(cs, srv)
+	}
+}
+
+// applyListeners listens on the addresses of c not yet listened on,
+// and stops listening on the others.
+func (cs *ConfigServer) applyListeners(c *Config) error {
+	want := make(map[string]bool)
+	var first error
+	for _, a := range listenAddrs(c) {
+		want[a] = true
+		if _, ok := cs.listeners[a]; ok {
+			continue
+		}
+		ln, err := net.Listen("tcp", a)
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		l := &configListener{tcp: ln.(*net.TCPListener)}
+		if cs.listeners == nil {
+			cs.listeners = make(map[string]*configListener)
+		}
+		cs.listeners[a] = l
+		l.serve(cs, cs.srv)
+	}
+	for a, l := range cs.listeners {
+		if !want[a] {
+			l.close()
+			delete(cs.listeners, a)
+		}
+	}
+	return first
+}
+
+// listenAddrs returns the addresses c listens on.
+func listenAddrs(c *Config) []string {
+	if len(c.Listen) == 0 {
+		return []string{":502"}
+	}
+	addrs := append([]string(nil), c.Listen...)
+	sort.Strings(addrs)
+	return addrs
+}
+
+// A configListener is a TCP listener of a ConfigServer, served by one
+// Server at a time. It is handed over from one Server to the next
+// without being closed, so that connections waiting to be accepted are
+// not refused.
+type configListener struct {
+	tcp  *net.TCPListener
+	gen  *handOffListener // served by the current Server
+	done chan struct{}    // closed once gen is no longer served
+}
+
+func (l *configListener) Addr() net.Addr { return l.tcp.Addr() }
+
+// serve serves l with srv.
+func (l *configListener) serve(cs *ConfigServer, srv *Server) {
+	gen := &handOffListener{TCPListener: l.tcp}
+	done := make(chan struct{})
+	l.gen, l.done = gen, done
+	go func() {
+		defer close(done)
+		if err := srv.Serve(gen); err != nil && !gen.handingOff() && !errors.Is(err, net.ErrClosed) {
+			cs.logf("modbus: serving %v: %v", l.tcp.Addr(), err)
+		}
+	}()
+}
+
+// handOff stops the Server serving l and has srv serve it.
+func (l *configListener) handOff(cs *ConfigServer, srv *Server) {
+	l.gen.handOff()
+	<-l.done
+	l.tcp.SetDeadline(time.Time{})
+	l.serve(cs, srv)
+}
+
+// close stops listening.
+func (l *configListener) close() error {
+	err := l.tcp.Close()
+	<-l.done
+	return err
+}
+
+// errHandedOff ends the serving of a handOffListener.
+var errHandedOff = errors.New("modbus: listener handed off")
+
+// A handOffListener is the listener of a configListener served by a
+// Server. Handing it off ends the Server's Accept loop without closing
+// the TCP listener.
+type handOffListener struct {
+	*net.TCPListener
+	mu     sync.Mutex
+	handed bool
+}
+
+func (l *handOffListener) handOff() {
+	l.mu.Lock()
+	l.handed = true
+	l.mu.Unlock()
+	l.TCPListener.SetDeadline(aLongTimeAgo)
+}
+
+func (l *handOffListener) handingOff() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.handed
+}
+
+func (l *handOffListener) Accept() (net.Conn, error) {
+	c, err := l.TCPListener.Accept()
+	if err != nil && l.handingOff() {
+		// not a net.Error, which a Server would retry if temporary
+		return nil, errHandedOff
+	}
+	return c, err
+}
+
+func (l *handOffListener) Close() error {
+	if l.handingOff() {
+		return nil
+	}
+	return l.TCPListener.Close()
+}
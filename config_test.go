@@ -0,0 +1,220 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mubeta06/gomodbus/serial"
+)
+
+func TestLoadConfig(t *testing.T) {
+	c, err := LoadConfig(strings.NewReader(`{
+		"listen": [":1502"],
+		"read_timeout": "30s",
+		"idle_timeout": 1000000000,
+		"units": [
+			{"unit": 1, "registers": {"holding_registers": [{"start": 0, "count": 10}]}},
+			{"unit": 2, "target": "10.0.0.7:502", "slave": 1},
+			{"unit": 3, "device": "/dev/ttyUSB0", "parity": "N", "slave": 5},
+			{"unit": 4, "device": "/dev/ttyUSB0", "baud_rate": 19200, "parity": "N"}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ReadTimeout != Duration(30*time.Second) || c.IdleTimeout != Duration(time.Second) || len(c.Units) != 4 {
+		t.Errorf("LoadConfig = %+v", c)
+	}
+
+	for _, s := range []string{
+		`{"units": [{"unit": 1}]}`,
+		`{"units": [{"unit": 1, "target": "a:502", "device": "/dev/ttyS0"}]}`,
+		`{"units": [{"unit": 1, "target": "a:502"}, {"unit": 1, "target": "b:502"}]}`,
+		`{"units": [{"unit": 1, "device": "/dev/ttyS0", "parity": "X"}]}`,
+		`{"units": [{"unit": 1, "device": "/dev/ttyS0"}, {"unit": 2, "device": "/dev/ttyS0", "baud_rate": 9600}]}`,
+		`{"read_timeout": "soon"}`,
+		`{"listen": ":502"}`,
+		`{"port": 502}`,
+	} {
+		if _, err := LoadConfig(strings.NewReader(s)); err == nil {
+			t.Errorf("LoadConfig(%s) succeeded", s)
+		}
+	}
+}
+
+func TestConfigServerReload(t *testing.T) {
+	mux := NewServeMux()
+	mux.Handle(1, &RegisterHandler{Holdings: []uint16{20}})
+	slave := startTestServer(t, &Server{Handler: mux})
+
+	var opened []serial.Config
+	path := filepath.Join(t.TempDir(), "config.json")
+	cs := &ConfigServer{
+		Path: path,
+		OpenSerial: func(c serial.Config) (serial.Port, error) {
+			opened = append(opened, c)
+			return newFakeRTUPort(rtuSlaves), nil
+		},
+	}
+	defer cs.Close()
+	write := func(config string) {
+		if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := cs.Reload(); err != nil {
+			t.Fatalf("Reload: %v", err)
+		}
+	}
+	write(`{
+		"listen": ["127.0.0.1:0"],
+		"units": [
+			{"unit": 1, "registers": {"holding_registers": [{"start": 0, "values": [10]}]}},
+			{"unit": 2, "target": "` + slave + `", "slave": 1},
+			{"unit": 3, "device": "/dev/ttyS0"}
+		]
+	}`)
+
+	addrs := cs.Addrs()
+	if len(addrs) != 1 {
+		t.Fatalf("Addrs = %v", addrs)
+	}
+	c, err := Dial(addrs[0].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.WriteSingleRegister(ctx, 1, 0, 11); err != nil {
+		t.Fatal(err)
+	}
+	for uid, want := range map[uint8]uint16{2: 20, 3: 0x1234} {
+		if v, err := c.ReadHoldingRegisters(ctx, uid, 0, 1); err != nil || v[0] != want {
+			t.Errorf("ReadHoldingRegisters of unit %d = %v, %v; want [%d]", uid, v, err, want)
+		}
+	}
+
+	// New timeouts, unit 2 removed, unit 4 added: the connection is
+	// kept, and so are the registers of unit 1 and the serial line.
+	write(`{
+		"listen": ["127.0.0.1:0"],
+		"read_timeout": "10s",
+		"units": [
+			{"unit": 1, "registers": {"holding_registers": [{"start": 0, "values": [10]}]}},
+			{"unit": 3, "device": "/dev/ttyS0"},
+			{"unit": 4, "device": "/dev/ttyS0", "slave": 7}
+		]
+	}`)
+	if got := cs.Addrs(); len(got) != 1 || got[0].String() != addrs[0].String() {
+		t.Errorf("Addrs after reload = %v; want %v", got, addrs)
+	}
+	if v, err := c.ReadHoldingRegisters(ctx, 1, 0, 1); err != nil || v[0] != 11 {
+		t.Errorf("ReadHoldingRegisters of unit 1 after reload = %v, %v; want [11]", v, err)
+	}
+	if _, err := c.ReadHoldingRegisters(ctx, 2, 0, 1); !errors.Is(err, ErrGatewayPathUnavailable) {
+		t.Errorf("ReadHoldingRegisters of removed unit 2 = %v", err)
+	}
+	if _, err := c.ReadHoldingRegisters(ctx, 4, 0, 1); !errors.Is(err, ErrIllegalDataAddress) {
+		t.Errorf("ReadHoldingRegisters of added unit 4 = %v", err)
+	}
+	if len(opened) != 1 {
+		t.Errorf("serial line opened %d times; want 1", len(opened))
+	}
+
+	// The listener is served by the new Server.
+	c2, err := Dial(addrs[0].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+	if v, err := c2.ReadHoldingRegisters(ctx, 1, 0, 1); err != nil || v[0] != 11 {
+		t.Errorf("ReadHoldingRegisters on a new connection = %v, %v", v, err)
+	}
+
+	// An invalid configuration is not applied.
+	if err := os.WriteFile(path, []byte(`{"units": [{"unit": 1}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cs.Reload(); err == nil {
+		t.Errorf("Reload of an invalid configuration succeeded")
+	}
+	if _, err := c.ReadHoldingRegisters(ctx, 3, 0, 1); err != nil {
+		t.Errorf("ReadHoldingRegisters after a failed reload: %v", err)
+	}
+}
+
+// closablePort is a fakeRTUPort failing writes once closed.
+type closablePort struct {
+	*fakeRTUPort
+	closed atomic.Bool
+}
+
+func (p *closablePort) Write(b []byte) (int, error) {
+	if p.closed.Load() {
+		return 0, errors.New("port closed")
+	}
+	return p.fakeRTUPort.Write(b)
+}
+
+func (p *closablePort) Close() error {
+	p.closed.Store(true)
+	return nil
+}
+
+func TestConfigServerFailedReloadKeepsLines(t *testing.T) {
+	var opened []serial.Config
+	cs := &ConfigServer{
+		OpenSerial: func(c serial.Config) (serial.Port, error) {
+			if c.Address == "/dev/missing" {
+				return nil, errors.New("no such device")
+			}
+			opened = append(opened, c)
+			return &closablePort{fakeRTUPort: newFakeRTUPort(rtuSlaves)}, nil
+		},
+	}
+	defer cs.Close()
+	apply := func(config string) error {
+		c, err := LoadConfig(strings.NewReader(config))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cs.Apply(c)
+	}
+	if err := apply(`{"units": [{"unit": 3, "device": "/dev/ttyS0", "timeout": "100ms"}]}`); err != nil {
+		t.Fatal(err)
+	}
+	for _, config := range []string{
+		// the new port of ttyS0 opens, the one of a later unit fails
+		`{"units": [
+			{"unit": 3, "device": "/dev/ttyS0", "baud_rate": 9600, "timeout": "100ms"},
+			{"unit": 4, "device": "/dev/missing"}
+		]}`,
+		// a later unit fails to be built
+		`{"units": [
+			{"unit": 3, "device": "/dev/ttyS0", "baud_rate": 9600, "timeout": "100ms"},
+			{"unit": 4, "registers": {"addressing": "modicon", "holding_registers": [{"start": 0, "count": 1}]}}
+		]}`,
+	} {
+		if err := apply(config); err == nil {
+			t.Fatalf("Apply(%s) succeeded", config)
+		}
+		h := cs.Gateway().Backend(3)
+		if h == nil {
+			t.Fatalf("unit 3 unrouted after a failed Apply")
+		}
+		c := dialTestServer(t, h)
+		if v, err := c.ReadHoldingRegisters(context.Background(), 3, 0, 1); err != nil || v[0] != 0x1234 {
+			t.Errorf("ReadHoldingRegisters of unit 3 after a failed Apply = %v, %v", v, err)
+		}
+	}
+	// each failure opened ttyS0 with the new settings, then the old
+	if len(opened) != 5 || opened[4].BaudRate == 9600 {
+		t.Errorf("ports opened: %+v", opened)
+	}
+}
@@ -0,0 +1,80 @@
+package modbus
+
+import "sync"
+
+// A ServerConn describes a connection served by a Server to its ConnEvent
+// hook, so that monitoring can track the masters misbehaving. Its
+// counters are kept up to date as requests are served, and may be read
+// at any time.
+type ServerConn struct {
+	mu    sync.Mutex // guards the following
+	info  ConnInfo
+	stats ConnStats
+	units [4]uint64 // set of unit identifiers seen
+}
+
+// ConnStats holds the counters a Server keeps for each connection.
+type ConnStats struct {
+	Requests     uint64 // number of requests read
+	Exceptions   uint64 // number of exception responses written
+	BytesRead    uint64 // size of the requests, MBAP headers included
+	BytesWritten uint64 // size of the responses, MBAP headers included
+}
+
+// Info returns the description of the connection. Its TLS and Role are
+// set once the Modbus/TCP Security handshake completes.
+func (sc *ServerConn) Info() ConnInfo {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.info
+}
+
+// Stats returns the counters of the connection.
+func (sc *ServerConn) Stats() ConnStats {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.stats
+}
+
+// Units returns the unit identifiers the connection's requests were
+// addressed to, in increasing order.
+func (sc *ServerConn) Units() []uint8 {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	var units []uint8
+	for uid := 0; uid < 256; uid++ {
+		if sc.units[uid/64]&(1<<(uid%64)) != 0 {
+			units = append(units, uint8(uid))
+		}
+	}
+	return units
+}
+
+func (sc *ServerConn) setInfo(info ConnInfo) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.info = info
+}
+
+// record counts the request served by w.
+func (sc *ServerConn) record(w *response) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	uid := w.req.header.Uid
+	sc.units[uid/64] |= 1 << (uid % 64)
+	sc.stats.Requests++
+	sc.stats.BytesRead += uint64(w.req.Size())
+	if w.wroteHeader {
+		sc.stats.BytesWritten += uint64(6 + int(w.header.Length))
+		if w.header.Fcode&0x80 != 0 {
+			sc.stats.Exceptions++
+		}
+	}
+}
+
+// connEvent runs the ConnEvent hook, if any.
+func (s *Server) connEvent(sc *ServerConn, state ConnState) {
+	if hook := s.ConnEvent; hook != nil {
+		hook(sc, state)
+	}
+}
@@ -0,0 +1,62 @@
+package modbus
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestServerConnEvent(t *testing.T) {
+	events := make(chan ConnState, 20)
+	closed := make(chan *ServerConn, 1)
+	srv := &Server{
+		Handler: &RegisterHandler{Holdings: []uint16{0x1234}},
+		ConnEvent: func(sc *ServerConn, state ConnState) {
+			events <- state
+			if state == StateClosed {
+				closed <- sc
+			}
+		},
+	}
+	addr := startTestServer(t, srv)
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	for _, req := range [][]byte{
+		{0, 1, 0, 0, 0, 6, 1, 0x03, 0, 0, 0, 1}, // 11 byte response
+		{0, 2, 0, 0, 0, 6, 3, 0x03, 0, 5, 0, 1}, // 9 byte exception
+	} {
+		c.Write(req)
+		c.Read(make([]byte, 16))
+	}
+	c.Close()
+
+	var sc *ServerConn
+	select {
+	case sc = <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("StateClosed not reported")
+	}
+	if expected := (ConnStats{Requests: 2, Exceptions: 1, BytesRead: 24, BytesWritten: 20}); sc.Stats() != expected {
+		t.Errorf("Stats = %+v; want %+v", sc.Stats(), expected)
+	}
+	if units := sc.Units(); !reflect.DeepEqual(units, []uint8{1, 3}) {
+		t.Errorf("Units = %v; want [1 3]", units)
+	}
+	if sc.Info().RemoteAddr.String() != c.LocalAddr().String() {
+		t.Errorf("RemoteAddr %v; want %v", sc.Info().RemoteAddr, c.LocalAddr())
+	}
+	close(events)
+	var states []ConnState
+	for s := range events {
+		states = append(states, s)
+	}
+	expected := []ConnState{StateNew, StateActive, StateIdle, StateActive, StateException, StateIdle, StateActive, StateClosed}
+	if !reflect.DeepEqual(states, expected) {
+		t.Errorf("events %v; want %v", states, expected)
+	}
+}
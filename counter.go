@@ -0,0 +1,245 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/mubeta06/gomodbus/values"
+)
+
+// A CounterStore is a DataStore holding accumulating counters, such as
+// energy or pulse counters, in pairs or quads of registers of the
+// DataStore it wraps.
+//
+// Masters reading a counter with a single request always see a
+// consistent value. Masters reading it a word at a time are protected by
+// freeze-on-read: a read of a counter's first register, but not all of
+// its registers, freezes the counter's value, and reads of its other
+// registers alone return the frozen words until the first register is
+// read again. Counters are frozen store wide, not per master.
+type CounterStore struct {
+	DataStore
+
+	mu       sync.Mutex
+	counters []*Counter
+}
+
+// A Counter is an unsigned counter of 32 or 64 bits held in 2 or 4
+// consecutive input or holding registers of a CounterStore. It wraps to
+// zero when it exceeds its maximum, as hardware counters do, so masters
+// computing differences modulo 2^32 or 2^64 see the correct increase
+// across a rollover.
+type Counter struct {
+	s     *CounterStore
+	table Table
+	addr  uint16
+	words int
+	order values.Order
+
+	value  uint64
+	frozen []uint16 // words presented to word at a time reads, if frozen
+}
+
+// NewCounterStore returns a CounterStore wrapping s.
+func NewCounterStore(s DataStore) *CounterStore {
+	return &CounterStore{DataStore: s}
+}
+
+// ErrCounterOverlap is returned by NewCounter for counters sharing
+// registers.
+var ErrCounterOverlap = errors.New("modbus: counters overlap")
+
+// NewCounter returns the counter of bits 32 or 64 held at addr of table
+// t, which must be InputRegisterTable or HoldingRegisterTable, with its
+// words laid out in order. Its initial value is read from the store.
+// Counters in the input registers can only be added to if the wrapped
+// store implements InputWriter.
+func (s *CounterStore) NewCounter(ctx context.Context, t Table, addr uint16, bits int, order values.Order) (*Counter, error) {
+	if t != InputRegisterTable && t != HoldingRegisterTable {
+		panic("modbus: counters must be held in registers")
+	}
+	if bits != 32 && bits != 64 {
+		panic("modbus: counters have 32 or 64 bits")
+	}
+	c := &Counter{s: s, table: t, addr: addr, words: bits / 16, order: order}
+	if int(addr)+c.words > 0x10000 {
+		return nil, ErrIllegalDataAddress
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, other := range s.counters {
+		if other.span().Overlaps(t, addr, uint16(c.words)) {
+			return nil, ErrCounterOverlap
+		}
+	}
+	if err := c.load(ctx); err != nil {
+		return nil, err
+	}
+	s.counters = append(s.counters, c)
+	return c, nil
+}
+
+func (c *Counter) span() AddressRange {
+	return AddressRange{c.table, c.addr, c.addr + uint16(c.words-1)}
+}
+
+func (c *Counter) encode(v uint64) []uint16 {
+	regs := make([]uint16, c.words)
+	if c.words == 2 {
+		c.order.PutUint32(regs, uint32(v))
+	} else {
+		c.order.PutUint64(regs, v)
+	}
+	return regs
+}
+
+// load sets the counter's value from the store.
+func (c *Counter) load(ctx context.Context) error {
+	var regs []uint16
+	var err error
+	if c.table == InputRegisterTable {
+		regs, err = c.s.DataStore.ReadInputRegisters(ctx, c.addr, uint16(c.words))
+	} else {
+		regs, err = c.s.DataStore.ReadHoldingRegisters(ctx, c.addr, uint16(c.words))
+	}
+	if err != nil {
+		return err
+	}
+	if c.words == 2 {
+		c.value = uint64(c.order.Uint32(regs))
+	} else {
+		c.value = c.order.Uint64(regs)
+	}
+	return nil
+}
+
+// Value returns the counter's value.
+func (c *Counter) Value() uint64 {
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+	return c.value
+}
+
+// Add adds n to the counter, wrapping at its maximum, and writes the
+// new value to the store.
+func (c *Counter) Add(ctx context.Context, n uint64) error {
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+	v := c.value + n
+	if c.words == 2 {
+		v = uint64(uint32(v))
+	}
+	regs := c.encode(v)
+	var err error
+	if c.table == InputRegisterTable {
+		iw, ok := c.s.DataStore.(InputWriter)
+		if !ok {
+			return ErrInputsReadOnly
+		}
+		err = iw.WriteInputRegisters(ctx, c.addr, regs)
+	} else {
+		err = c.s.DataStore.WriteHoldingRegisters(ctx, c.addr, regs)
+	}
+	if err != nil {
+		return err
+	}
+	c.value = v
+	return nil
+}
+
+// read applies freeze-on-read to values read at addr of table t.
+func (s *CounterStore) read(t Table, addr uint16, values []uint16) {
+	n := uint16(len(values))
+	if n == 0 {
+		return
+	}
+	r := AddressRange{t, addr, addr + n - 1}
+	for _, c := range s.counters {
+		span := c.span()
+		if !span.Overlaps(t, addr, n) {
+			continue
+		}
+		if r.Contains(t, c.addr, uint16(c.words)) {
+			// a consistent read of the whole counter
+			c.frozen = nil
+			continue
+		}
+		if r.Contains(t, c.addr, 1) {
+			c.frozen = c.encode(c.value)
+			continue
+		}
+		if c.frozen != nil {
+			for i := range values {
+				if a := addr + uint16(i); span.Contains(t, a, 1) {
+					values[i] = c.frozen[a-c.addr]
+				}
+			}
+		}
+	}
+}
+
+func (s *CounterStore) ReadInputRegisters(ctx context.Context, addr, quantity uint16) ([]uint16, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	values, err := s.DataStore.ReadInputRegisters(ctx, addr, quantity)
+	if err == nil {
+		s.read(InputRegisterTable, addr, values)
+	}
+	return values, err
+}
+
+func (s *CounterStore) ReadHoldingRegisters(ctx context.Context, addr, quantity uint16) ([]uint16, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	values, err := s.DataStore.ReadHoldingRegisters(ctx, addr, quantity)
+	if err == nil {
+		s.read(HoldingRegisterTable, addr, values)
+	}
+	return values, err
+}
+
+// written reloads counters overlapping the num registers written at addr
+// of table t, so that masters may preset or reset them.
+func (s *CounterStore) written(ctx context.Context, t Table, addr, num uint16) error {
+	for _, c := range s.counters {
+		if c.span().Overlaps(t, addr, num) {
+			c.frozen = nil
+			if err := c.load(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *CounterStore) WriteHoldingRegisters(ctx context.Context, addr uint16, values []uint16) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.DataStore.WriteHoldingRegisters(ctx, addr, values); err != nil {
+		return err
+	}
+	return s.written(ctx, HoldingRegisterTable, addr, uint16(len(values)))
+}
+
+func (s *CounterStore) WriteDiscreteInputs(ctx context.Context, addr uint16, values []bool) error {
+	iw, ok := s.DataStore.(InputWriter)
+	if !ok {
+		return ErrInputsReadOnly
+	}
+	return iw.WriteDiscreteInputs(ctx, addr, values)
+}
+
+func (s *CounterStore) WriteInputRegisters(ctx context.Context, addr uint16, values []uint16) error {
+	iw, ok := s.DataStore.(InputWriter)
+	if !ok {
+		return ErrInputsReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := iw.WriteInputRegisters(ctx, addr, values); err != nil {
+		return err
+	}
+	return s.written(ctx, InputRegisterTable, addr, uint16(len(values)))
+}
@@ -0,0 +1,69 @@
+package modbus
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/mubeta06/gomodbus/values"
+)
+
+func TestCounterRollover(t *testing.T) {
+	ctx := context.Background()
+	h := &RegisterHandler{Holdings: []uint16{0xFFFF, 0xFFFE, 0, 0, 0, 0}}
+	s := NewCounterStore(h.DataStore())
+	c32, err := s.NewCounter(ctx, HoldingRegisterTable, 0, 32, values.ABCD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c64, err := s.NewCounter(ctx, HoldingRegisterTable, 2, 64, values.CDAB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.NewCounter(ctx, HoldingRegisterTable, 1, 32, values.ABCD); err != ErrCounterOverlap {
+		t.Errorf("err should be ErrCounterOverlap not %v", err)
+	}
+	if c32.Value() != 0xFFFFFFFE {
+		t.Errorf("initial value 0x%X", c32.Value())
+	}
+
+	c32.Add(ctx, 3)
+	c64.Add(ctx, 0x100000002)
+	if c32.Value() != 1 {
+		t.Errorf("32 bit counter should wrap to 1 not 0x%X", c32.Value())
+	}
+	regs, _ := s.ReadHoldingRegisters(ctx, 0, 6)
+	if expected := []uint16{0, 1, 2, 0, 1, 0}; !reflect.DeepEqual(regs, expected) {
+		t.Errorf("registers %v; want %v", regs, expected)
+	}
+}
+
+func TestCounterFreezeOnRead(t *testing.T) {
+	ctx := context.Background()
+	h := &RegisterHandler{Holdings: make([]uint16, 2)}
+	s := NewCounterStore(h.DataStore())
+	c, _ := s.NewCounter(ctx, HoldingRegisterTable, 0, 32, values.ABCD)
+	c.Add(ctx, 0xFFFF)
+
+	high, _ := s.ReadHoldingRegisters(ctx, 0, 1)
+	c.Add(ctx, 1) // the low word rolls over between the reads
+	low, _ := s.ReadHoldingRegisters(ctx, 1, 1)
+	if high[0] != 0 || low[0] != 0xFFFF {
+		t.Errorf("word reads gave 0x%04X 0x%04X; want the frozen 0x0000 0xFFFF", high[0], low[0])
+	}
+
+	regs, _ := s.ReadHoldingRegisters(ctx, 0, 2)
+	if !reflect.DeepEqual(regs, []uint16{1, 0}) {
+		t.Errorf("whole read gave %v", regs)
+	}
+	low, _ = s.ReadHoldingRegisters(ctx, 1, 1)
+	if low[0] != 0 {
+		t.Errorf("unfrozen low word 0x%04X", low[0])
+	}
+
+	// masters may preset the counter
+	s.WriteHoldingRegisters(ctx, 0, []uint16{0, 7})
+	if c.Value() != 7 {
+		t.Errorf("preset value %d", c.Value())
+	}
+}
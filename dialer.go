@@ -0,0 +1,64 @@
+package modbus
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+)
+
+// A Dialer opens connections to Modbus TCP slaves, with control over how
+// they are made: through a SOCKS proxy or an SSH tunnel, as segmented
+// OT networks often require, from a given source address, or secured
+// with Modbus/TCP Security.
+//
+// Its DialConn method may be used as the Dial function of a ClientPool.
+type Dialer struct {
+	// DialContext, if non nil, opens the underlying connection instead
+	// of a net.Dialer, for instance the DialContext method of a proxy
+	// dialer or the Dial method of an SSH client.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// LocalAddr is the local address to dial from when DialContext is
+	// nil. If nil, one is chosen automatically.
+	LocalAddr net.Addr
+
+	// TLSConfig, if non nil, causes Modbus/TCP Security to be spoken
+	// over the connection. If its ServerName is empty, the host of the
+	// address dialled is used.
+	TLSConfig *tls.Config
+}
+
+// Dial connects to the Modbus TCP slave at addr and returns a Client
+// using the connection.
+func (d *Dialer) Dial(ctx context.Context, addr string) (*Client, error) {
+	conn, err := d.DialConn(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{Transport: NewClientConn(conn)}, nil
+}
+
+// DialConn connects to addr on the named network, completing the TLS
+// handshake before it returns if d.TLSConfig is set.
+func (d *Dialer) DialConn(ctx context.Context, network, addr string) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	if d.DialContext != nil {
+		conn, err = d.DialContext(ctx, network, addr)
+	} else {
+		nd := net.Dialer{LocalAddr: d.LocalAddr}
+		conn, err = nd.DialContext(ctx, network, addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if d.TLSConfig == nil {
+		return conn, nil
+	}
+	tc, err := clientTLS(ctx, conn, d.TLSConfig, addr)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tc, nil
+}
@@ -0,0 +1,43 @@
+package modbus
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestDialer(t *testing.T) {
+	h := &RegisterHandler{Holdings: []uint16{0xBEEF}}
+	addr := startTestServer(t, &Server{Handler: h})
+
+	var dialled []string
+	local := &net.TCPAddr{IP: net.ParseIP("127.0.0.1")}
+	d := &Dialer{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialled = append(dialled, network+" "+addr)
+			nd := net.Dialer{LocalAddr: local}
+			return nd.DialContext(ctx, network, addr)
+		},
+	}
+	c, err := d.Dial(context.Background(), addr)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+	if v, err := c.ReadHoldingRegisters(context.Background(), 1, 0, 1); err != nil || v[0] != 0xBEEF {
+		t.Errorf("ReadHoldingRegisters = %v, %v", v, err)
+	}
+	if len(dialled) != 1 || dialled[0] != "tcp "+addr {
+		t.Errorf("dialled %q; want tcp %s", dialled, addr)
+	}
+
+	d = &Dialer{LocalAddr: local}
+	conn, err := d.DialConn(context.Background(), "tcp", addr)
+	if err != nil {
+		t.Fatalf("DialConn: %v", err)
+	}
+	defer conn.Close()
+	if ip := conn.LocalAddr().(*net.TCPAddr).IP; !ip.Equal(local.IP) {
+		t.Errorf("local address %v; want %v", ip, local.IP)
+	}
+}
@@ -0,0 +1,204 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A DiscoveredUnit is a unit that answered the probes of Discover.
+type DiscoveredUnit struct {
+	Addr string // TCP address of the device or gateway
+	Unit uint8
+
+	// SlaveID is the data of the unit's Report Slave ID response, its
+	// vendor specific identity, or nil if it did not report it.
+	SlaveID []byte
+
+	// Exception is the exception the unit answered the read of
+	// holding register 0 with, if it did not report its identity and
+	// answered the read with an exception. A unit answering with an
+	// exception is there, though it may have no register 0.
+	Exception *ModbusError
+}
+
+// A Discoverer probes networks for Modbus TCP devices. The zero value
+// is usable.
+type Discoverer struct {
+	// Dialer opens the connections. If nil, the zero Dialer is used.
+	Dialer *Dialer
+
+	// Timeout bounds each connection attempt and each probe of a unit.
+	// If zero, 1s is used.
+	Timeout time.Duration
+
+	// Concurrency is the number of hosts probed at once. If zero, 64
+	// is used.
+	Concurrency int
+
+	// MaxHosts bounds the size of the networks probed. If zero, 65536
+	// is used.
+	MaxHosts int
+}
+
+// Discover probes the hosts of the network cidr on TCP port with the
+// default Discoverer, see Discoverer.Discover.
+func Discover(ctx context.Context, cidr string, port int, units []uint8) ([]DiscoveredUnit, error) {
+	return new(Discoverer).Discover(ctx, cidr, port, units)
+}
+
+// Discover probes the hosts of the network cidr, e.g. "192.168.1.0/24",
+// that accept connections on TCP port for each of units. Each unit is
+// asked to Report Slave ID, and failing an answer to read holding
+// register 0. Units answering either, if only with an exception, are
+// returned ordered by address and unit; units a gateway reports as
+// unreachable with a gateway exception are not. The network and
+// broadcast addresses of IPv4 networks are skipped.
+//
+// Discover returns once every host is probed, or with the units found
+// so far and ctx's error once ctx is done.
+func (d *Discoverer) Discover(ctx context.Context, cidr string, port int, units []uint8) ([]DiscoveredUnit, error) {
+	hosts, err := d.hosts(cidr)
+	if err != nil {
+		return nil, err
+	}
+	n := d.Concurrency
+	if n <= 0 {
+		n = 64
+	}
+
+	var (
+		mu    sync.Mutex
+		found []DiscoveredUnit
+		wg    sync.WaitGroup
+		sem   = make(chan struct{}, n)
+	)
+	for _, host := range hosts {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			du := d.probeHost(ctx, addr, units)
+			mu.Lock()
+			found = append(found, du...)
+			mu.Unlock()
+		}(net.JoinHostPort(host.String(), strconv.Itoa(port)))
+	}
+	wg.Wait()
+
+	sort.Slice(found, func(i, j int) bool {
+		a, b := found[i], found[j]
+		if a.Addr != b.Addr {
+			ha, _ := netip.ParseAddrPort(a.Addr)
+			hb, _ := netip.ParseAddrPort(b.Addr)
+			return ha.Addr().Less(hb.Addr())
+		}
+		return a.Unit < b.Unit
+	})
+	return found, ctx.Err()
+}
+
+func (d *Discoverer) timeout() time.Duration {
+	if d.Timeout <= 0 {
+		return time.Second
+	}
+	return d.Timeout
+}
+
+// hosts returns the addresses of the network cidr to probe.
+func (d *Discoverer) hosts(cidr string) ([]netip.Addr, error) {
+	p, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("modbus: discover: %w", err)
+	}
+	p = p.Masked()
+	max := d.MaxHosts
+	if max <= 0 {
+		max = 65536
+	}
+	if bits := p.Addr().BitLen() - p.Bits(); bits > 30 || 1<<bits > max {
+		return nil, fmt.Errorf("modbus: discover: network %v has more than %d hosts", p, max)
+	}
+	var hosts []netip.Addr
+	for a := p.Addr(); a.IsValid() && p.Contains(a); a = a.Next() {
+		hosts = append(hosts, a)
+	}
+	if p.Addr().Is4() && p.Bits() < 31 {
+		hosts = hosts[1 : len(hosts)-1]
+	}
+	return hosts, nil
+}
+
+// probeHost returns the units answering at addr.
+func (d *Discoverer) probeHost(ctx context.Context, addr string, units []uint8) []DiscoveredUnit {
+	dialer := d.Dialer
+	if dialer == nil {
+		dialer = new(Dialer)
+	}
+	dctx, cancel := context.WithTimeout(ctx, d.timeout())
+	c, err := dialer.Dial(dctx, addr)
+	cancel()
+	if err != nil {
+		return nil
+	}
+	defer c.Close()
+
+	var found []DiscoveredUnit
+	for _, uid := range units {
+		if ctx.Err() != nil {
+			break
+		}
+		if du, ok := d.probeUnit(ctx, c, uid); ok {
+			du.Addr = addr
+			found = append(found, du)
+		}
+	}
+	return found
+}
+
+// probeUnit reports whether unit uid answers through c.
+func (d *Discoverer) probeUnit(ctx context.Context, c *Client, uid uint8) (DiscoveredUnit, bool) {
+	du := DiscoveredUnit{Unit: uid}
+	pctx, cancel := context.WithTimeout(ctx, d.timeout())
+	id, err := c.send(pctx, NewReportSlaveIdFrame(uid))
+	cancel()
+	if err == nil {
+		du.SlaveID = append([]byte(nil), id...)
+		return du, true
+	}
+	if isGatewayError(err) {
+		return du, false
+	}
+
+	pctx, cancel = context.WithTimeout(ctx, d.timeout())
+	_, err = c.send(pctx, NewReadHoldingRegistersFrame(uid, 0, 1))
+	cancel()
+	var e *ModbusError
+	switch {
+	case err == nil:
+		return du, true
+	case isGatewayError(err) || !errors.As(err, &e):
+		return du, false
+	}
+	du.Exception = e
+	return du, true
+}
+
+// isGatewayError reports whether err is a gateway exception, reporting
+// that the unit addressed is not reachable.
+func isGatewayError(err error) bool {
+	return errors.Is(err, ErrGatewayPathUnavailable) || errors.Is(err, ErrGatewayTargetFailed)
+}
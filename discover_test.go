@@ -0,0 +1,45 @@
+package modbus
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDiscover(t *testing.T) {
+	mux := NewServeMux()
+	mux.Handle(1, testHandlerFunc(func(w ResponseWriter, r *Frame) {
+		if FunctionCode(r.Header().Fcode) == ReportSlaveId {
+			w.Write([]byte{0x02, 0x42, 0xFF})
+			return
+		}
+		w.WriteException(IllegalFunction)
+	}))
+	mux.Handle(3, &RegisterHandler{})
+	addr := startTestServer(t, &Server{Handler: mux})
+	_, port, _ := net.SplitHostPort(addr)
+	p, _ := strconv.Atoi(port)
+
+	d := &Discoverer{Timeout: 100 * time.Millisecond}
+	found, err := d.Discover(context.Background(), "127.0.0.1/32", p, []uint8{1, 2, 3})
+	if err != nil {
+		t.Fatalf("Discover: %v", err)
+	}
+	want := []DiscoveredUnit{
+		{Addr: addr, Unit: 1, SlaveID: []byte{0x02, 0x42, 0xFF}},
+		{Addr: addr, Unit: 3, Exception: &ModbusError{ReadHoldingRegisters, IllegalDataAddress}},
+	}
+	if !reflect.DeepEqual(found, want) {
+		t.Errorf("found %+v; want %+v", found, want)
+	}
+
+	if _, err := d.Discover(context.Background(), "10.0.0.0/8", p, []uint8{1}); err == nil {
+		t.Errorf("Discover of a /8 succeeded")
+	}
+	if hosts, _ := d.hosts("192.168.1.0/30"); len(hosts) != 2 {
+		t.Errorf("hosts of a /30 = %v; want 2", hosts)
+	}
+}
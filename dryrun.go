@@ -0,0 +1,136 @@
+package modbus
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// isWriteFunction reports whether fcode modifies slave state.
+func isWriteFunction(fcode FunctionCode) bool {
+	switch fcode {
+	case WriteSingleCoil, WriteSingleRegister, WriteMultipleCoils,
+		WriteMultipleRegisters, MaskWriteRegister, WriteAndReadRegisters:
+		return true
+	}
+	return false
+}
+
+// validateWrite checks the request payload of a write function the way a
+// conforming slave would, returning an error wrapping ErrIllegalDataValue
+// if it is malformed.
+func validateWrite(fcode FunctionCode, data []byte) error {
+	if p := newRequestPDU(fcode); p != nil {
+		return p.UnmarshalBinary(data)
+	}
+	return nil
+}
+
+// dryRunResponse returns the response payload a slave would send after
+// successfully applying the write request data.
+func dryRunResponse(fcode FunctionCode, data []byte) []byte {
+	switch fcode {
+	case WriteMultipleCoils, WriteMultipleRegisters:
+		return data[0:4]
+	}
+	return data
+}
+
+// dryRun answers a write request without transmitting it. Write And Read
+// Registers is replaced by a plain read of its read range.
+func (c *Client) dryRun(ctx context.Context, req *Frame) ([]byte, error) {
+	uid, fcode, data := req.header.Uid, FunctionCode(req.header.Fcode), req.data
+	c.logf("modbus: dry run: uid=%d fc=%v data=% X", uid, fcode, data)
+
+	if err := validateWrite(fcode, data); err != nil {
+		return nil, fmt.Errorf("%v: %w", err, &ModbusError{FunctionCode: fcode, ExceptionCode: IllegalDataValue})
+	}
+	if code := c.DryRunException; code != 0 {
+		return nil, &ModbusError{fcode, code}
+	}
+	if fcode != WriteAndReadRegisters {
+		return dryRunResponse(fcode, data), nil
+	}
+
+	raddr := binary.BigEndian.Uint16(data[0:2])
+	quantity := binary.BigEndian.Uint16(data[2:4])
+	resp, err := c.send(ctx, NewReadHoldingRegistersFrame(uid, raddr, quantity))
+	var e *ModbusError
+	if errors.As(err, &e) {
+		return nil, &ModbusError{FunctionCode: FunctionCode(fcode), ExceptionCode: e.ExceptionCode}
+	}
+	return resp, err
+}
+
+// A DryRunHandler wraps a Handler so that write requests are validated
+// and logged but never reach it. Valid writes are answered as if they had
+// succeeded, or with Exception if it is non zero; Write And Read
+// Registers requests are answered from a plain read. All other requests
+// are passed through.
+type DryRunHandler struct {
+	Handler   Handler
+	Exception ExceptionCode
+
+	// ErrorLog receives a line for every write request. If nil, logging
+	// goes to the log package's standard logger.
+	ErrorLog *log.Logger
+}
+
+func (h *DryRunHandler) ServeModbus(w ResponseWriter, r *Frame) {
+	fcode := FunctionCode(r.header.Fcode)
+	if !isWriteFunction(fcode) {
+		h.Handler.ServeModbus(w, r)
+		return
+	}
+
+	h.logf("modbus: dry run: uid=%d fc=%v data=% X", r.header.Uid, FunctionCode(fcode), r.data)
+
+	if err := validateWrite(fcode, r.data); err != nil {
+		WriteError(w, err)
+		return
+	}
+	if h.Exception != 0 {
+		w.WriteException(h.Exception)
+		return
+	}
+	if fcode == WriteAndReadRegisters {
+		read := &Frame{header: r.header, data: r.data[0:4], ctx: r.ctx}
+		read.header.Fcode = byte(ReadHoldingRegisters)
+		read.header.Length = 6
+		h.Handler.ServeModbus(&fcodeWriter{w, fcode}, read)
+		return
+	}
+	w.Write(dryRunResponse(fcode, r.data))
+}
+
+func (h *DryRunHandler) logf(format string, args ...interface{}) {
+	if h.ErrorLog != nil {
+		h.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// fcodeWriter presents a substituted request to a handler while answering
+// with the original function code.
+type fcodeWriter struct {
+	ResponseWriter
+	fcode FunctionCode
+}
+
+func (w *fcodeWriter) Write(data []byte) (int, error) {
+	w.fix()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *fcodeWriter) WriteException(code ExceptionCode) error {
+	w.fix()
+	return w.ResponseWriter.WriteException(code)
+}
+
+func (w *fcodeWriter) fix() {
+	h := w.ResponseWriter.Header()
+	h.Fcode = byte(w.fcode) | h.Fcode&0x80
+}
@@ -0,0 +1,92 @@
+package modbus
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"testing"
+)
+
+func TestClientDryRun(t *testing.T) {
+	h := &RegisterHandler{Coils: make([]bool, 2), Holdings: []uint16{0x1234, 0x5678}}
+	c := dialTestServer(t, h)
+	c.DryRun = true
+	c.ErrorLog = log.New(io.Discard, "", 0)
+
+	if err := c.WriteSingleRegister(context.Background(), 0xFF, 0, 0xBEEF); err != nil {
+		t.Errorf("err should be nil not %v", err)
+	}
+	if err := c.WriteSingleCoil(context.Background(), 0xFF, 1, true); err != nil {
+		t.Errorf("err should be nil not %v", err)
+	}
+
+	// reads are still transmitted
+	values, err := c.ReadHoldingRegisters(context.Background(), 0xFF, 0, 2)
+	if err != nil {
+		t.Fatalf("err should be nil not %v", err)
+	}
+	if values[0] != 0x1234 || h.Coils[1] {
+		t.Errorf("dry run writes should not be applied")
+	}
+
+	c.DryRunException = SlaveBusy
+	if err := c.WriteSingleRegister(context.Background(), 0xFF, 0, 0xBEEF); !errors.Is(err, ErrSlaveBusy) {
+		t.Errorf("err should be ErrSlaveBusy not %v", err)
+	}
+}
+
+func TestDryRunHandler(t *testing.T) {
+	req := []byte{0x00, 0x0F, 0x00, 0x00, 0x00, 0x0D, 0xFF, 0x10, 0x00, 0x6B,
+		0x00, 0x03, 0x06, 0x02, 0x2B, 0x00, 0x01, 0x00, 0x64}
+	expected := []byte{0x00, 0x0F, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x10, 0x00,
+		0x6B, 0x00, 0x03}
+
+	rh := &RegisterHandler{}
+	rh.Holdings = make([]uint16, 0x6B+0x03)
+	h := &DryRunHandler{Handler: rh, ErrorLog: log.New(io.Discard, "", 0)}
+	br := bufio.NewReader(bytes.NewReader(req))
+	bw := bytes.Buffer{}
+	r, _ := ReadFrame(br)
+	w := &testResponseWriter{req: r, w: bufio.NewWriter(&bw)}
+
+	h.ServeModbus(w, r)
+	w.w.Flush()
+
+	if !bytes.Equal(bw.Bytes(), expected) {
+		t.Errorf("Incorrect Response")
+	}
+	for _, v := range rh.Holdings {
+		if v != 0 {
+			t.Errorf("dry run write should not be applied")
+		}
+	}
+}
+
+func TestDryRunHandlerWriteAndRead(t *testing.T) {
+	req := []byte{0x00, 0x12, 0x00, 0x00, 0x00, 0x0F, 0xFF, 0x17, 0x00, 0x6B,
+		0x00, 0x03, 0x00, 0x6C, 0x00, 0x02, 0x04, 0x12, 0x34, 0x56, 0x78}
+	expected := []byte{0x00, 0x12, 0x00, 0x00, 0x00, 0x09, 0xFF, 0x17, 0x06,
+		0x02, 0x2B, 0x00, 0x00, 0x00, 0x00}
+
+	rh := &RegisterHandler{}
+	rh.Holdings = make([]uint16, 0x6B+0x03)
+	rh.Holdings[0x6B] = 0x022B
+	h := &DryRunHandler{Handler: rh, ErrorLog: log.New(io.Discard, "", 0)}
+	br := bufio.NewReader(bytes.NewReader(req))
+	bw := bytes.Buffer{}
+	r, _ := ReadFrame(br)
+	w := &testResponseWriter{req: r, w: bufio.NewWriter(&bw)}
+
+	h.ServeModbus(w, r)
+	w.w.Flush()
+
+	if !bytes.Equal(bw.Bytes(), expected) {
+		t.Errorf("Incorrect Response % X", bw.Bytes())
+	}
+	if rh.Holdings[0x6C] != 0 {
+		t.Errorf("dry run write should not be applied")
+	}
+}
@@ -0,0 +1,68 @@
+package modbus
+
+import (
+	"errors"
+	"fmt"
+)
+
+// A ModbusError is a Modbus exception: the function code of a request and
+// the exception code it was answered with. Clients return a *ModbusError
+// for every exception response, and handlers may pass one to WriteError.
+type ModbusError struct {
+	FunctionCode  FunctionCode
+	ExceptionCode ExceptionCode
+}
+
+func (e *ModbusError) Error() string {
+	if e.FunctionCode == 0 {
+		return "modbus: " + e.ExceptionCode.String()
+	}
+	return fmt.Sprintf("modbus: %v: %v", e.FunctionCode, e.ExceptionCode)
+}
+
+// Is reports whether target is a *ModbusError with the same exception
+// code. A target with a zero FunctionCode matches any function code, so
+// errors.Is(err, ErrIllegalDataAddress) holds for every illegal data
+// address exception.
+func (e *ModbusError) Is(target error) bool {
+	t, ok := target.(*ModbusError)
+	if !ok {
+		return false
+	}
+	return t.ExceptionCode == e.ExceptionCode &&
+		(t.FunctionCode == 0 || t.FunctionCode == e.FunctionCode)
+}
+
+// Exception errors matching any function code, for use with errors.Is
+// and as return values of handler and store code.
+var (
+	ErrIllegalFunction        = &ModbusError{ExceptionCode: IllegalFunction}
+	ErrIllegalDataAddress     = &ModbusError{ExceptionCode: IllegalDataAddress}
+	ErrIllegalDataValue       = &ModbusError{ExceptionCode: IllegalDataValue}
+	ErrSlaveFailure           = &ModbusError{ExceptionCode: SlaveFailure}
+	ErrAcknowledge            = &ModbusError{ExceptionCode: Acknowledge}
+	ErrSlaveBusy              = &ModbusError{ExceptionCode: SlaveBusy}
+	ErrNegativeAcknowledge    = &ModbusError{ExceptionCode: NegativeAcknowledge}
+	ErrMemoryParityError      = &ModbusError{ExceptionCode: MemoryParityError}
+	ErrGatewayPathUnavailable = &ModbusError{ExceptionCode: GatewayPathUnavailable}
+	ErrGatewayTargetFailed    = &ModbusError{ExceptionCode: GatewayTargetFailed}
+)
+
+// WriteError replies to the request with the exception mapError
+// chooses for err.
+func WriteError(w ResponseWriter, err error) error {
+	return w.WriteException(mapError(err))
+}
+
+// mapError returns the exception code answering a request that failed
+// with err. Requests failing to parse or validate carry a *ModbusError,
+// IllegalDataValue or IllegalDataAddress as the specification requires,
+// whose code is used; any other error is a failure of the slave or its
+// back end, answered with SlaveFailure.
+func mapError(err error) ExceptionCode {
+	var e *ModbusError
+	if errors.As(err, &e) {
+		return e.ExceptionCode
+	}
+	return SlaveFailure
+}
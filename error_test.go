@@ -0,0 +1,60 @@
+package modbus
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestModbusErrorIs(t *testing.T) {
+	err := fmt.Errorf("poll: %w", &ModbusError{FunctionCode: ReadHoldingRegisters, ExceptionCode: IllegalDataAddress})
+
+	if !errors.Is(err, ErrIllegalDataAddress) {
+		t.Errorf("error should match ErrIllegalDataAddress")
+	}
+	if errors.Is(err, ErrIllegalDataValue) {
+		t.Errorf("error should not match ErrIllegalDataValue")
+	}
+	if !errors.Is(err, &ModbusError{ReadHoldingRegisters, IllegalDataAddress}) {
+		t.Errorf("error should match its own function code")
+	}
+	if errors.Is(err, &ModbusError{ReadCoils, IllegalDataAddress}) {
+		t.Errorf("error should not match another function code")
+	}
+
+	var e *ModbusError
+	if !errors.As(err, &e) || e.ExceptionCode != IllegalDataAddress {
+		t.Errorf("error should unwrap to *ModbusError")
+	}
+	if e.Error() != "modbus: ReadHoldingRegisters: IllegalDataAddress" {
+		t.Errorf("unexpected message %q", e.Error())
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	req := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x03, 0x00, 0x6B, 0x00, 0x03}
+
+	for _, tt := range []struct {
+		err  error
+		code ExceptionCode
+	}{
+		{ErrIllegalDataValue, IllegalDataValue},
+		{fmt.Errorf("store: %w", ErrSlaveBusy), SlaveBusy},
+		{errors.New("disk on fire"), SlaveFailure},
+	} {
+		br := bufio.NewReader(bytes.NewReader(req))
+		bw := bytes.Buffer{}
+		r, _ := ReadFrame(br)
+		w := &testResponseWriter{req: r, w: bufio.NewWriter(&bw)}
+
+		WriteError(w, tt.err)
+		w.w.Flush()
+
+		expected := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x03, 0xFF, 0x83, byte(tt.code)}
+		if !bytes.Equal(bw.Bytes(), expected) {
+			t.Errorf("Incorrect Response for %v", tt.err)
+		}
+	}
+}
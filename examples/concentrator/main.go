@@ -0,0 +1,135 @@
+// Concentrator polls blocks of holding registers of several slaves and
+// serves them together as the input registers of a single unit, so that
+// a SCADA master reads a whole site in one request. Discrete input i
+// reports whether the last poll of device i succeeded.
+//
+// Usage:
+//
+//	concentrator -addr :502 -count 10 -interval 1s 10.0.0.7:502/1 10.0.0.8:502/3
+//
+// Device i's registers 0 to count-1 appear at input registers i*count
+// onwards.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	modbus "github.com/mubeta06/gomodbus"
+)
+
+var (
+	addr     = flag.String("addr", ":502", "TCP address to listen on")
+	count    = flag.Int("count", 10, "holding registers polled per device")
+	interval = flag.Duration("interval", time.Second, "time between polls")
+)
+
+func main() {
+	log.SetPrefix("concentrator: ")
+	log.SetFlags(0)
+	flag.Parse()
+	var devices []device
+	for _, arg := range flag.Args() {
+		d, err := parseDevice(arg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		devices = append(devices, d)
+	}
+	if len(devices) == 0 {
+		log.Fatal("no devices")
+	}
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Fatal(run(context.Background(), l, devices, *count, *interval))
+}
+
+// A device is a slave polled by the concentrator.
+type device struct {
+	addr string
+	unit uint8
+}
+
+// parseDevice parses a device given as host:port/unit.
+func parseDevice(s string) (device, error) {
+	addr, unit, ok := strings.Cut(s, "/")
+	if !ok {
+		return device{}, fmt.Errorf("device %q lacks a unit identifier", s)
+	}
+	uid, err := strconv.ParseUint(unit, 10, 8)
+	if err != nil {
+		return device{}, fmt.Errorf("bad unit identifier in %q", s)
+	}
+	return device{addr, uint8(uid)}, nil
+}
+
+// run polls devices and serves their registers on l until ctx is done.
+func run(ctx context.Context, l net.Listener, devices []device, count int, interval time.Duration) error {
+	h := &modbus.RegisterHandler{
+		DiscreteInputs: make([]bool, len(devices)),
+		Inputs:         make([]uint16, len(devices)*count),
+	}
+	store := h.DataStore().(modbus.InputWriter)
+
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	var clients []*modbus.Client
+	defer func() {
+		cancel()
+		wg.Wait()
+		for _, c := range clients {
+			c.Close()
+		}
+	}()
+	addrs := make([]uint16, count)
+	for i := range addrs {
+		addrs[i] = uint16(i)
+	}
+	for i, d := range devices {
+		c := &modbus.Client{Transport: &modbus.ClientPool{Addr: d.addr, Timeout: interval}}
+		clients = append(clients, c)
+		p := modbus.NewPoller(c)
+		base := uint16(i * count)
+		status := uint16(i)
+		p.Add(&modbus.PollGroup{
+			Unit:      d.unit,
+			Table:     modbus.HoldingRegisterTable,
+			Addresses: addrs,
+			Interval:  interval,
+			Handler: func(r modbus.PollResult) {
+				store.WriteDiscreteInputs(ctx, status, []bool{r.Err == nil})
+				if r.Err != nil {
+					return
+				}
+				values := make([]uint16, count)
+				for addr, v := range r.Values {
+					values[addr] = v
+				}
+				store.WriteInputRegisters(ctx, base, values)
+			},
+		})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.Run(ctx)
+		}()
+	}
+
+	srv := &modbus.Server{Handler: h}
+	stop := context.AfterFunc(ctx, func() { l.Close() })
+	defer stop()
+	err := srv.Serve(l)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	modbus "github.com/mubeta06/gomodbus"
+)
+
+func TestParseDevice(t *testing.T) {
+	d, err := parseDevice("10.0.0.7:502/3")
+	if err != nil || d != (device{"10.0.0.7:502", 3}) {
+		t.Errorf("parseDevice = %+v, %v", d, err)
+	}
+	for _, s := range []string{"10.0.0.7:502", "10.0.0.7:502/256", "host/x"} {
+		if _, err := parseDevice(s); err == nil {
+			t.Errorf("parseDevice(%q) succeeded", s)
+		}
+	}
+}
+
+func startSlave(t *testing.T, holdings ...uint16) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go (&modbus.Server{Handler: &modbus.RegisterHandler{Holdings: holdings}}).Serve(l)
+	return l.Addr().String()
+}
+
+func TestConcentrator(t *testing.T) {
+	devices := []device{
+		{startSlave(t, 1, 2), 1},
+		{startSlave(t, 3, 4), 1},
+		{"127.0.0.1:1", 1}, // unreachable
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- run(ctx, l, devices, 2, 20*time.Millisecond) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != context.Canceled {
+			t.Errorf("run = %v; want %v", err, context.Canceled)
+		}
+	}()
+
+	c, err := modbus.Dial(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	rctx, rcancel := context.WithTimeout(ctx, 5*time.Second)
+	defer rcancel()
+	want := []uint16{1, 2, 3, 4, 0, 0}
+	for {
+		values, err := c.ReadInputRegisters(rctx, 1, 0, 6)
+		if err != nil {
+			t.Fatal(err)
+		}
+		status, err := c.ReadDiscreteInputs(rctx, 1, 0, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reflect.DeepEqual(values, want) && reflect.DeepEqual(status, []bool{true, true, false}) {
+			break
+		}
+		select {
+		case <-rctx.Done():
+			t.Fatalf("concentrated %v, status %v; want %v, [true true false]", values, status, want)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
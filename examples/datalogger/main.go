@@ -0,0 +1,123 @@
+// Datalogger reads the points of a slave at a fixed interval and writes
+// them as CSV, one row per reading, for trending in a spreadsheet. The
+// points are those of a register map file, as served by modbus-slave;
+// their values there are ignored.
+//
+// Usage:
+//
+//	datalogger -target 10.0.0.7:502 -unit 1 -interval 10s map.json > log.csv
+//
+// The first column is the time of the reading. Points that could not be
+// read are left empty.
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	modbus "github.com/mubeta06/gomodbus"
+)
+
+var (
+	target   = flag.String("target", "", "TCP address of the slave")
+	unit     = flag.Uint("unit", 1, "unit identifier of the slave")
+	interval = flag.Duration("interval", 10*time.Second, "time between readings")
+)
+
+func main() {
+	log.SetPrefix("datalogger: ")
+	log.SetFlags(0)
+	flag.Parse()
+	if *target == "" || flag.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: datalogger -target host:port [flags] map.json\n")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	points, addressing, err := loadPoints(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("%s: %v", flag.Arg(0), err)
+	}
+	c := &modbus.Client{Transport: &modbus.ClientPool{Addr: *target}, Addressing: addressing}
+	defer c.Close()
+	log.Fatal(run(context.Background(), c, uint8(*unit), points, *interval, os.Stdout))
+}
+
+// loadPoints returns the points of the register map read from r, and the
+// addressing of their addresses.
+func loadPoints(r io.Reader) ([]modbus.Point, modbus.Addressing, error) {
+	var m modbus.RegisterMap
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, modbus.Addressing{}, err
+	}
+	if len(m.Points) == 0 {
+		return nil, modbus.Addressing{}, fmt.Errorf("no points")
+	}
+	points := make([]modbus.Point, len(m.Points))
+	for i := range m.Points {
+		points[i] = m.Points[i].Point()
+	}
+	var a modbus.Addressing
+	if m.Addressing != nil {
+		a = *m.Addressing
+	}
+	return points, a, nil
+}
+
+// run writes a header and then a row of readings of points every
+// interval to w, until ctx is done.
+func run(ctx context.Context, c *modbus.Client, uid uint8, points []modbus.Point, interval time.Duration, w io.Writer) error {
+	m, err := modbus.NewMapping(points...)
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	row := []string{"time"}
+	for _, p := range points {
+		row = append(row, p.Name)
+	}
+	cw.Write(row)
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return err
+		}
+		select {
+		case now := <-t.C:
+			row = append(row[:0], now.UTC().Format(time.RFC3339))
+			for _, p := range points {
+				rctx, cancel := context.WithTimeout(ctx, interval)
+				v, err := c.ReadPoint(rctx, uid, m, p.Name)
+				cancel()
+				if ctx.Err() != nil {
+					// the reading was cut short; drop its row
+					return ctx.Err()
+				}
+				if err != nil {
+					log.Printf("reading %s: %v", p.Name, err)
+					row = append(row, "")
+					continue
+				}
+				row = append(row, strconv.FormatFloat(v, 'g', -1, 64))
+			}
+			cw.Write(row)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
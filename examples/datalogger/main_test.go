@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	modbus "github.com/mubeta06/gomodbus"
+)
+
+const registerMap = `{
+	"holding_registers": [{"start": 0, "count": 4}],
+	"points": [
+		{"name": "flow", "table": "holding registers", "address": 0, "type": "float32", "value": 12.5},
+		{"name": "level", "table": "holding registers", "address": 2, "type": "uint16", "scale": 0.1, "value": 42}
+	]
+}`
+
+// A rowCounter calls full once rows lines have been written to it.
+type rowCounter struct {
+	bytes.Buffer
+	rows int
+	full func()
+}
+
+func (w *rowCounter) Write(p []byte) (int, error) {
+	n, err := w.Buffer.Write(p)
+	if bytes.Count(w.Bytes(), []byte("\n")) >= w.rows {
+		w.full()
+	}
+	return n, err
+}
+
+func TestDatalogger(t *testing.T) {
+	h, err := modbus.LoadRegisterMap(strings.NewReader(registerMap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go (&modbus.Server{Handler: h}).Serve(l)
+
+	points, _, err := loadPoints(strings.NewReader(registerMap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a point the slave does not have
+	points = append(points, modbus.Point{Name: "missing", Table: modbus.HoldingRegisterTable, Address: 50, Type: modbus.Uint16})
+
+	c, err := modbus.Dial(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out := &rowCounter{rows: 4, full: cancel} // the header and 3 readings
+	if err := run(ctx, c, 1, points, 50*time.Millisecond, out); err != context.Canceled {
+		t.Errorf("run = %v; want %v", err, context.Canceled)
+	}
+
+	rows, err := csv.NewReader(&out.Buffer).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 4 {
+		t.Fatalf("%d rows: %q", len(rows), rows)
+	}
+	if got := strings.Join(rows[0], ","); got != "time,flow,level,missing" {
+		t.Errorf("header %q", got)
+	}
+	for _, row := range rows[1:] {
+		if _, err := time.Parse(time.RFC3339, row[0]); err != nil {
+			t.Errorf("row %q: %v", row, err)
+		}
+		if row[1] != "12.5" || row[2] != "42" || row[3] != "" {
+			t.Errorf("row %q; want 12.5, 42 and an empty cell", row)
+		}
+	}
+}
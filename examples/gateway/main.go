@@ -0,0 +1,211 @@
+// Gateway forwards the requests of Modbus TCP masters to slaves over
+// pools of pipelined connections, so that many masters may share slaves
+// accepting few connections, or over RTU serial lines. Malformed
+// responses of TCP slaves are repaired where possible, and the traffic
+// may be captured for replay.
+//
+// Each -route sends the requests of a unit to a TCP slave, or to the
+// slave of the given address on a serial line; requests of other units
+// go to -target, if given. Alternatively a -config file, see
+// modbus.Config, gives the addresses, timeouts and routes, and is
+// reloaded on SIGHUP.
+//
+// Usage:
+//
+//	gateway -addr :502 -target 10.0.0.7:502 -conns 2 -capture traffic.jsonl
+//	gateway -route 1=10.0.0.7:502 -route 2=rtu:/dev/ttyUSB0:5 -baud 9600
+//	gateway -config gateway.json
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	modbus "github.com/mubeta06/gomodbus"
+	"github.com/mubeta06/gomodbus/serial"
+)
+
+var (
+	addr    = flag.String("addr", ":502", "TCP address to listen on")
+	target  = flag.String("target", "", "TCP address of the slave")
+	conns   = flag.Int("conns", 1, "connections kept to the slave")
+	timeout = flag.Duration("timeout", time.Second, "time the slave is given to respond")
+	capture = flag.String("capture", "", "file the exchanges are appended to, as JSON lines")
+	baud    = flag.Int("baud", 19200, "baud rate of the serial lines")
+	parity  = flag.String("parity", "E", "parity of the serial lines: E, O or N")
+	config  = flag.String("config", "", "configuration file, reloaded on SIGHUP, replacing the flags but -capture")
+	routes  routeFlags
+)
+
+func init() {
+	flag.Var(&routes, "route", "`unit=target` routing a unit to a TCP address or to rtu:device:address; repeatable")
+}
+
+// routeFlags are the values of the -route flags.
+type routeFlags []string
+
+func (f *routeFlags) String() string { return strings.Join(*f, ",") }
+
+func (f *routeFlags) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+func main() {
+	log.SetPrefix("gateway: ")
+	log.SetFlags(0)
+	flag.Parse()
+	var use []modbus.Middleware
+	if *capture != "" {
+		f, err := os.OpenFile(*capture, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		use = append(use, modbus.NewRecorder(f).Middleware)
+	}
+	if *config != "" {
+		log.Fatal(serveConfig(*config, use))
+	}
+	if *target == "" && len(routes) == 0 {
+		log.Fatal("no -target nor -route")
+	}
+	if len(*parity) != 1 {
+		log.Fatalf("bad -parity %q", *parity)
+	}
+	b := &backends{
+		line:  serial.Config{BaudRate: *baud, Parity: serial.Parity((*parity)[0])},
+		lines: make(map[string]*modbus.RTUTransport),
+	}
+	gw := new(modbus.Gateway)
+	if *target != "" {
+		gw.Default = b.tcp(*target)
+	}
+	for _, r := range routes {
+		uid, h, err := b.parse(r)
+		if err != nil {
+			log.Fatalf("-route %s: %v", r, err)
+		}
+		gw.Route(uid, h)
+	}
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv := newServer(gw)
+	srv.Use(use...)
+	log.Printf("forwarding %s to units %v", *addr, gw.Units())
+	log.Fatal(run(context.Background(), srv, l))
+}
+
+// serveConfig serves the configuration file path, reloading it on
+// SIGHUP, with the middleware use.
+func serveConfig(path string, use []modbus.Middleware) error {
+	cs := &modbus.ConfigServer{
+		Path:  path,
+		Setup: func(srv *modbus.Server) { srv.Use(use...) },
+	}
+	defer cs.Close()
+	if err := cs.Reload(); err != nil {
+		return err
+	}
+	log.Printf("forwarding %v to units %v", cs.Addrs(), cs.Gateway().Units())
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := cs.Reload(); err != nil {
+			log.Printf("reload: %v", err)
+			continue
+		}
+		log.Printf("reloaded %s", path)
+	}
+	return nil
+}
+
+// newServer returns a server routing requests through gw.
+func newServer(gw *modbus.Gateway) *modbus.Server {
+	return &modbus.Server{Handler: gw}
+}
+
+// backends makes the backends of routes, sharing the transport of each
+// serial line among its slaves.
+type backends struct {
+	line  serial.Config // settings of the serial lines
+	lines map[string]*modbus.RTUTransport
+}
+
+// parse parses a route, unit=target.
+func (b *backends) parse(route string) (uint8, modbus.Handler, error) {
+	unit, target, ok := strings.Cut(route, "=")
+	if !ok {
+		return 0, nil, fmt.Errorf("no target")
+	}
+	uid, err := strconv.ParseUint(unit, 10, 8)
+	if err != nil {
+		return 0, nil, fmt.Errorf("bad unit identifier %q", unit)
+	}
+	dev, ok := strings.CutPrefix(target, "rtu:")
+	if !ok {
+		return uint8(uid), b.tcp(target), nil
+	}
+	i := strings.LastIndexByte(dev, ':')
+	if i < 0 {
+		return 0, nil, fmt.Errorf("no slave address in %q", target)
+	}
+	slave, err := strconv.ParseUint(dev[i+1:], 10, 8)
+	if err != nil || slave == 0 || slave > 247 {
+		return 0, nil, fmt.Errorf("bad slave address %q", dev[i+1:])
+	}
+	t, err := b.rtu(dev[:i])
+	if err != nil {
+		return 0, nil, err
+	}
+	return uint8(uid), &modbus.Forwarder{Transport: t, Unit: uint8(slave), Timeout: *timeout}, nil
+}
+
+// tcp returns a backend forwarding requests to the TCP slave at addr.
+func (b *backends) tcp(addr string) modbus.Handler {
+	pool := &modbus.ClientPool{
+		Addr:        addr,
+		Size:        *conns,
+		MaxInFlight: 4,
+		Lenient:     true,
+	}
+	return &modbus.Forwarder{Transport: pool, Timeout: *timeout}
+}
+
+// rtu returns the transport of the serial line of device dev.
+func (b *backends) rtu(dev string) (*modbus.RTUTransport, error) {
+	if t, ok := b.lines[dev]; ok {
+		return t, nil
+	}
+	c := b.line
+	c.Address = dev
+	p, err := serial.Open(c)
+	if err != nil {
+		return nil, err
+	}
+	t := &modbus.RTUTransport{Port: p, Config: c, Timeout: *timeout}
+	b.lines[dev] = t
+	return t, nil
+}
+
+// run serves l with srv until ctx is done.
+func run(ctx context.Context, srv *modbus.Server, l net.Listener) error {
+	stop := context.AfterFunc(ctx, func() { l.Close() })
+	defer stop()
+	err := srv.Serve(l)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	modbus "github.com/mubeta06/gomodbus"
+)
+
+// serve serves srv on a loopback address until the test ends.
+func serve(t *testing.T, srv *modbus.Server) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		run(ctx, srv, l)
+	}()
+	t.Cleanup(func() { cancel(); <-done })
+	return l.Addr().String()
+}
+
+func TestGateway(t *testing.T) {
+	slave := serve(t, &modbus.Server{Handler: &modbus.RegisterHandler{Holdings: []uint16{1, 2, 3}}})
+	pool := &modbus.ClientPool{Addr: slave, Size: 2, MaxInFlight: 4, Lenient: true}
+	defer pool.Close()
+	srv := newServer(&modbus.Gateway{Default: &modbus.Forwarder{Transport: pool}})
+	var capture bytes.Buffer
+	rec := modbus.NewRecorder(&capture)
+	srv.Use(rec.Middleware)
+	gw := serve(t, srv)
+
+	c, err := modbus.Dial(gw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.WriteSingleRegister(ctx, 1, 2, 30); err != nil {
+		t.Fatal(err)
+	}
+	values, err := c.ReadHoldingRegisters(ctx, 1, 0, 3)
+	if err != nil || !reflect.DeepEqual(values, []uint16{1, 2, 30}) {
+		t.Errorf("ReadHoldingRegisters through the gateway = %v, %v", values, err)
+	}
+	if _, err := c.ReadHoldingRegisters(ctx, 1, 3, 1); !errors.Is(err, modbus.ErrIllegalDataAddress) {
+		t.Errorf("exception of the slave = %v; want %v", err, modbus.ErrIllegalDataAddress)
+	}
+
+	records, err := modbus.ReadCapture(&capture)
+	if err != nil || len(records) != 3 {
+		t.Errorf("captured %d records, %v; want 3", len(records), err)
+	}
+}
+
+func TestGatewayTargetFailed(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := l.Addr().String()
+	l.Close()
+	pool := &modbus.ClientPool{Addr: dead}
+	defer pool.Close()
+	gw := serve(t, newServer(&modbus.Gateway{
+		Default: &modbus.Forwarder{Transport: pool, Timeout: 100 * time.Millisecond},
+	}))
+
+	c, err := modbus.Dial(gw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := c.ReadHoldingRegisters(ctx, 1, 0, 1); !errors.Is(err, modbus.ErrGatewayTargetFailed) {
+		t.Errorf("read of a dead slave = %v; want %v", err, modbus.ErrGatewayTargetFailed)
+	}
+}
+
+func TestParseRoute(t *testing.T) {
+	b := &backends{}
+	uid, h, err := b.parse("3=10.0.0.7:502")
+	if f, ok := h.(*modbus.Forwarder); err != nil || uid != 3 || !ok || f.Transport.(*modbus.ClientPool).Addr != "10.0.0.7:502" {
+		t.Errorf("parse of a TCP route = %d, %v, %v", uid, h, err)
+	}
+	for _, r := range []string{"3", "x=10.0.0.7:502", "256=10.0.0.7:502", "3=rtu:/dev/ttyUSB0", "3=rtu:/dev/ttyUSB0:0", "3=rtu:/dev/ttyUSB0:x"} {
+		if _, _, err := b.parse(r); err == nil {
+			t.Errorf("parse(%q) succeeded", r)
+		}
+	}
+}
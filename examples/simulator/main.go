@@ -0,0 +1,109 @@
+// Simulator serves a simulated boiler for testing SCADA masters without
+// the plant. Its temperature and pressure follow signal generators, and
+// its setpoint, written by masters, is kept in a state file across
+// restarts. Every change of the setpoint is logged.
+//
+// Usage:
+//
+//	simulator -addr :502 -state boiler.state
+//
+// Points, all of unit 1:
+//
+//	temperature  input registers 0-1  float32, °C
+//	pressure     input register 2     uint16, hundredths of a bar
+//	setpoint     holding registers 0-1  float32, °C
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"math"
+	"net"
+	"time"
+
+	modbus "github.com/mubeta06/gomodbus"
+	"github.com/mubeta06/gomodbus/simulator"
+)
+
+var (
+	addr  = flag.String("addr", ":502", "TCP address to listen on")
+	state = flag.String("state", "boiler.state", "file keeping the setpoint")
+)
+
+var (
+	temperature = modbus.Point{Name: "temperature", Table: modbus.InputRegisterTable, Address: 0, Type: modbus.Float32}
+	pressure    = modbus.Point{Name: "pressure", Table: modbus.InputRegisterTable, Address: 2, Type: modbus.Uint16, Scale: 0.01}
+	setpoint    = modbus.Point{Name: "setpoint", Table: modbus.HoldingRegisterTable, Address: 0, Type: modbus.Float32}
+)
+
+func main() {
+	log.SetPrefix("simulator: ")
+	log.SetFlags(0)
+	flag.Parse()
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Fatal(run(context.Background(), l, *state))
+}
+
+// run serves the boiler on l until ctx is done.
+func run(ctx context.Context, l net.Listener, state string) error {
+	h := &modbus.RegisterHandler{Inputs: make([]uint16, 3), Holdings: make([]uint16, 2)}
+	ps, err := modbus.OpenPersistentStore(ctx, h.DataStore(), state, modbus.PersistOptions{
+		HoldingRegisters: 2,
+		WAL:              true,
+	})
+	if err != nil {
+		return err
+	}
+	defer ps.Close()
+	watch := modbus.NewWatchStore(ps)
+	h.Store = watch
+
+	sim, err := simulator.New(h,
+		simulator.Signal{
+			Point:     temperature,
+			Generator: simulator.Sum(simulator.Sine(60, 5, 10*time.Minute), simulator.Noise(0, 0.5, 1)),
+			Interval:  100 * time.Millisecond,
+		},
+		simulator.Signal{
+			Point:     pressure,
+			Generator: simulator.Ramp(1.2, 1.8, time.Minute),
+			Interval:  100 * time.Millisecond,
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go sim.Run(ctx)
+	changes := watch.Subscribe(modbus.HoldingRegisterTable, setpoint.Address, 2)
+	defer watch.Unsubscribe(changes)
+	go logSetpoint(changes)
+
+	srv := &modbus.Server{Handler: sim}
+	stop := context.AfterFunc(ctx, func() { l.Close() })
+	defer stop()
+	err = srv.Serve(l)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// logSetpoint logs the setpoint written by each event of changes,
+// until the channel is closed.
+func logSetpoint(changes <-chan modbus.ChangeEvent) {
+	for e := range changes {
+		if e.Address != setpoint.Address || len(e.Values) != 2 {
+			log.Printf("setpoint partly written")
+			continue
+		}
+		v := math.Float32frombits(uint32(e.Values[0])<<16 | uint32(e.Values[1]))
+		log.Printf("setpoint changed to %.1f °C", v)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	modbus "github.com/mubeta06/gomodbus"
+)
+
+// start runs the boiler on a loopback address, returning a client of
+// it and a function stopping it.
+func start(t *testing.T, state string) (*modbus.Client, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- run(ctx, l, state) }()
+	c, err := modbus.Dial(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, func() {
+		c.Close()
+		cancel()
+		if err := <-done; err != context.Canceled {
+			t.Errorf("run = %v; want %v", err, context.Canceled)
+		}
+	}
+}
+
+func TestSimulator(t *testing.T) {
+	m, err := modbus.NewMapping(temperature, pressure, setpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := filepath.Join(t.TempDir(), "boiler.state")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, stop := start(t, state)
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		v, err := c.ReadPoint(ctx, 1, m, "temperature")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v >= 54 && v <= 66 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("temperature %v", v)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if v, err := c.ReadPoint(ctx, 1, m, "pressure"); err != nil || v < 1.2 || v > 1.8 {
+		t.Errorf("pressure %v, %v", v, err)
+	}
+	if err := c.WritePoint(ctx, 1, m, "setpoint", 72.5); err != nil {
+		t.Fatal(err)
+	}
+	stop()
+
+	// the setpoint survives a restart
+	c, stop = start(t, state)
+	defer stop()
+	if v, err := c.ReadPoint(ctx, 1, m, "setpoint"); err != nil || v != 72.5 {
+		t.Errorf("setpoint after restart %v, %v; want 72.5", v, err)
+	}
+}
@@ -0,0 +1,156 @@
+//go:build !modbus_noexpvar
+
+package modbus
+
+import (
+	"context"
+	"expvar"
+	"math"
+	"sync"
+)
+
+// An ExpvarStore is a DataStore publishing selected coils, inputs and
+// registers of the DataStore it wraps as expvar variables, so that they
+// show on existing dashboards reading /debug/vars. The variables are
+// set when published and updated as values are written through the
+// ExpvarStore, including writes by masters when it serves a
+// RegisterHandler. Writes made to the wrapped store directly are not
+// seen.
+//
+// Input tables are only updated when written with WriteDiscreteInputs
+// or WriteInputRegisters, which require the wrapped store to implement
+// InputWriter.
+type ExpvarStore struct {
+	DataStore
+
+	mu   sync.Mutex
+	vars []*expvarEntry
+}
+
+type expvarEntry struct {
+	point Point
+	i     *expvar.Int   // set for PublishRegister
+	f     *expvar.Float // set for PublishPoint
+}
+
+func init() {
+	capabilities = append(capabilities, CapabilityExpvar)
+}
+
+// NewExpvarStore returns an ExpvarStore wrapping s.
+func NewExpvarStore(s DataStore) *ExpvarStore {
+	return &ExpvarStore{DataStore: s}
+}
+
+// PublishRegister publishes the coil, input or register at addr of table
+// t as the expvar.Int called name. Coils and discrete inputs have the
+// values 0 and 1. Like expvar.NewInt, it panics if name is already
+// published.
+func (s *ExpvarStore) PublishRegister(name string, t Table, addr uint16) (*expvar.Int, error) {
+	typ := Uint16
+	if t == CoilTable || t == DiscreteInputTable {
+		typ = Bool
+	}
+	e := &expvarEntry{point: Point{Name: name, Table: t, Address: addr, Type: typ}}
+	if err := s.publish(e); err != nil {
+		return nil, err
+	}
+	return e.i, nil
+}
+
+// PublishPoint publishes the value of p, as decoded by a Mapping, as the
+// expvar.Float called p.Name. Like expvar.NewFloat, it panics if the
+// name is already published.
+func (s *ExpvarStore) PublishPoint(p Point) (*expvar.Float, error) {
+	if int(p.Address)+p.Type.size() > 0x10000 {
+		return nil, ErrIllegalDataAddress
+	}
+	e := &expvarEntry{point: p}
+	if err := s.publish(e); err != nil {
+		return nil, err
+	}
+	return e.f, nil
+}
+
+// publish reads the initial value of e and publishes its variable.
+func (s *ExpvarStore) publish(e *expvarEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, err := e.point.get(context.Background(), s.DataStore)
+	if err != nil {
+		return err
+	}
+	if e.point.Type == Uint16 || e.point.Type == Bool {
+		e.i = expvar.NewInt(e.point.Name)
+	} else {
+		e.f = expvar.NewFloat(e.point.Name)
+	}
+	e.set(v)
+	s.vars = append(s.vars, e)
+	return nil
+}
+
+func (e *expvarEntry) set(v float64) {
+	if e.i != nil {
+		e.i.Set(int64(math.Round(v)))
+	} else {
+		e.f.Set(v)
+	}
+}
+
+// update refreshes the variables overlapping the num addresses written
+// at addr of table t.
+func (s *ExpvarStore) update(ctx context.Context, t Table, addr, num uint16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.vars {
+		p := &e.point
+		r := AddressRange{p.Table, p.Address, p.Address + uint16(p.Type.size()-1)}
+		if !r.Overlaps(t, addr, num) {
+			continue
+		}
+		if v, err := p.get(ctx, s.DataStore); err == nil {
+			e.set(v)
+		}
+	}
+}
+
+func (s *ExpvarStore) WriteCoils(ctx context.Context, addr uint16, values []bool) error {
+	if err := s.DataStore.WriteCoils(ctx, addr, values); err != nil {
+		return err
+	}
+	s.update(ctx, CoilTable, addr, uint16(len(values)))
+	return nil
+}
+
+func (s *ExpvarStore) WriteHoldingRegisters(ctx context.Context, addr uint16, values []uint16) error {
+	if err := s.DataStore.WriteHoldingRegisters(ctx, addr, values); err != nil {
+		return err
+	}
+	s.update(ctx, HoldingRegisterTable, addr, uint16(len(values)))
+	return nil
+}
+
+func (s *ExpvarStore) WriteDiscreteInputs(ctx context.Context, addr uint16, values []bool) error {
+	iw, ok := s.DataStore.(InputWriter)
+	if !ok {
+		return ErrInputsReadOnly
+	}
+	if err := iw.WriteDiscreteInputs(ctx, addr, values); err != nil {
+		return err
+	}
+	s.update(ctx, DiscreteInputTable, addr, uint16(len(values)))
+	return nil
+}
+
+func (s *ExpvarStore) WriteInputRegisters(ctx context.Context, addr uint16, values []uint16) error {
+	iw, ok := s.DataStore.(InputWriter)
+	if !ok {
+		return ErrInputsReadOnly
+	}
+	if err := iw.WriteInputRegisters(ctx, addr, values); err != nil {
+		return err
+	}
+	s.update(ctx, InputRegisterTable, addr, uint16(len(values)))
+	return nil
+}
@@ -0,0 +1,58 @@
+//go:build !modbus_noexpvar
+
+package modbus
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+var expvarTestRuns int32
+
+func TestExpvarStore(t *testing.T) {
+	// expvar names may only be published once per process
+	prefix := fmt.Sprintf("test.expvar%d.", atomic.AddInt32(&expvarTestRuns, 1))
+	ctx := context.Background()
+	h := &RegisterHandler{Coils: make([]bool, 4), Holdings: []uint16{7, 0, 0, 0}}
+	s := NewExpvarStore(h.DataStore())
+	h.Store = s
+
+	reg, err := s.PublishRegister(prefix+"holding0", HoldingRegisterTable, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coil, err := s.PublishRegister(prefix+"coil2", CoilTable, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	power, err := s.PublishPoint(Point{Name: prefix + "power", Table: HoldingRegisterTable, Address: 2, Type: Uint32, Scale: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.PublishRegister(prefix+"missing", HoldingRegisterTable, 10); err == nil {
+		t.Errorf("publishing a missing register should fail")
+	}
+	if reg.Value() != 7 || coil.Value() != 0 || power.Value() != 0 {
+		t.Errorf("initial values %d %d %v", reg.Value(), coil.Value(), power.Value())
+	}
+
+	if err := s.WriteHoldingRegisters(ctx, 0, []uint16{9, 1, 0x0001, 0x0000}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteCoils(ctx, 1, []bool{true, true}); err != nil {
+		t.Fatal(err)
+	}
+	if reg.Value() != 9 || coil.Value() != 1 || power.Value() != 0x10000*0.5 {
+		t.Errorf("values after writes %d %d %v", reg.Value(), coil.Value(), power.Value())
+	}
+
+	// a write to the second register of the point updates it
+	if err := s.WriteHoldingRegisters(ctx, 3, []uint16{4}); err != nil {
+		t.Fatal(err)
+	}
+	if power.Value() != 0x10004*0.5 {
+		t.Errorf("power = %v", power.Value())
+	}
+}
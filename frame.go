@@ -2,39 +2,43 @@ package modbus
 
 import (
 	"bufio"
+	"context"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 )
 
 const (
 	TcpPid uint16 = 0x0000
 
-	// Function codes
-	ReadCoils              uint8 = 0x01
-	ReadDiscreteInputs     uint8 = 0x02
-	ReadHoldingRegisters   uint8 = 0x03
-	ReadInputRegisters     uint8 = 0x04
-	WriteSingleCoil        uint8 = 0x05
-	WriteSingleRegister    uint8 = 0x06
-	ReadExceptionStatus    uint8 = 0x07
-	WriteMultipleCoils     uint8 = 0x0F
-	WriteMultipleRegisters uint8 = 0x10
-	ReportSlaveId          uint8 = 0x11
-	WriteAndReadRegisters  uint8 = 0x17
-
-	// Exception Codes
-	IllegalFunction        uint8 = 0x01
-	IllegalDataAddress     uint8 = 0x02
-	IllegalDataValue       uint8 = 0x03
-	SlaveFailure           uint8 = 0x04
-	Acknowledge            uint8 = 0x05
-	SlaveBusy              uint8 = 0x06
-	NegativeAcknowledge    uint8 = 0x07
-	MemoryParityError      uint8 = 0x08
-	NotDefined             uint8 = 0x09
-	GatewayPathUnavailable uint8 = 0x0A
-	GatewayTargetFailed    uint8 = 0x0B
+	// Function codes, see FunctionCode
+	ReadCoils              FunctionCode = 0x01
+	ReadDiscreteInputs     FunctionCode = 0x02
+	ReadHoldingRegisters   FunctionCode = 0x03
+	ReadInputRegisters     FunctionCode = 0x04
+	WriteSingleCoil        FunctionCode = 0x05
+	WriteSingleRegister    FunctionCode = 0x06
+	ReadExceptionStatus    FunctionCode = 0x07
+	PollProgramComplete    FunctionCode = 0x0E // legacy Modicon, see Acknowledger
+	WriteMultipleCoils     FunctionCode = 0x0F
+	WriteMultipleRegisters FunctionCode = 0x10
+	ReportSlaveId          FunctionCode = 0x11
+	MaskWriteRegister      FunctionCode = 0x16
+	WriteAndReadRegisters  FunctionCode = 0x17
+
+	// Exception Codes, see ExceptionCode
+	IllegalFunction        ExceptionCode = 0x01
+	IllegalDataAddress     ExceptionCode = 0x02
+	IllegalDataValue       ExceptionCode = 0x03
+	SlaveFailure           ExceptionCode = 0x04
+	Acknowledge            ExceptionCode = 0x05
+	SlaveBusy              ExceptionCode = 0x06
+	NegativeAcknowledge    ExceptionCode = 0x07
+	MemoryParityError      ExceptionCode = 0x08
+	NotDefined             ExceptionCode = 0x09
+	GatewayPathUnavailable ExceptionCode = 0x0A
+	GatewayTargetFailed    ExceptionCode = 0x0B
 )
 
 // A Frame represents an Modbus request received by a server / slave
@@ -46,6 +50,9 @@ type Frame struct {
 
 	// Data bytes - Data as reponse or commands
 	data []byte
+
+	// ctx is the request's context, see Context and WithContext.
+	ctx context.Context
 }
 
 type Header struct {
@@ -61,6 +68,78 @@ type Header struct {
 	Fcode byte
 }
 
+// headerSize is the encoded size of a Header.
+const headerSize = 8
+
+// encode writes the big endian encoding of h to b.
+func (h *Header) encode(b []byte) {
+	_ = b[headerSize-1]
+	binary.BigEndian.PutUint16(b[0:], h.Tid)
+	binary.BigEndian.PutUint16(b[2:], h.Pid)
+	binary.BigEndian.PutUint16(b[4:], h.Length)
+	b[6] = h.Uid
+	b[7] = h.Fcode
+}
+
+// decode sets h from its big endian encoding in b.
+func (h *Header) decode(b []byte) {
+	_ = b[headerSize-1]
+	h.Tid = binary.BigEndian.Uint16(b[0:])
+	h.Pid = binary.BigEndian.Uint16(b[2:])
+	h.Length = binary.BigEndian.Uint16(b[4:])
+	h.Uid = b[6]
+	h.Fcode = b[7]
+}
+
+// NewFrame returns a Frame with the given header and data. The header's
+// Length field is set to match data.
+func NewFrame(header Header, data []byte) *Frame {
+	f := &Frame{header: header}
+	f.SetData(data)
+	return f
+}
+
+// Header returns the Frame's MBAP header and function code.
+func (f *Frame) Header() *Header {
+	return &f.header
+}
+
+// Data returns the Frame's data bytes, the PDU following the function
+// code.
+func (f *Frame) Data() []byte {
+	return f.data
+}
+
+// SetData replaces the Frame's data bytes and updates the header's Length
+// field accordingly.
+func (f *Frame) SetData(data []byte) {
+	f.data = data
+	f.header.Length = uint16(len(data) + 2)
+}
+
+// Context returns the request's context. For incoming server requests
+// the context is cancelled when the connection closes, when the request
+// exceeds the server's WriteTimeout, or when ServeModbus returns. It is
+// never nil; it defaults to the background context.
+func (f *Frame) Context() context.Context {
+	if f.ctx != nil {
+		return f.ctx
+	}
+	return context.Background()
+}
+
+// WithContext returns a shallow copy of f with its context changed to
+// ctx. The provided ctx must be non-nil.
+func (f *Frame) WithContext(ctx context.Context) *Frame {
+	if ctx == nil {
+		panic("nil context")
+	}
+	f2 := new(Frame)
+	*f2 = *f
+	f2.ctx = ctx
+	return f2
+}
+
 // A wrapper for Modbus Frame representing a Register Request
 type Request struct {
 	*Frame
@@ -83,36 +162,125 @@ func (r *Request) Offset() uint16 {
 }
 
 func (r *Request) Number() uint16 {
-	if r.header.Fcode == WriteSingleCoil || r.header.Fcode == WriteSingleRegister {
+	if FunctionCode(r.header.Fcode) == WriteSingleCoil || FunctionCode(r.header.Fcode) == WriteSingleRegister {
 		return 1
 	}
 	return binary.BigEndian.Uint16(r.data[2:4])
 }
 
-// ReadRequest reads and parses an incoming request from b.
+// DefaultMaxFrameBytes is the size of the largest Modbus TCP frame
+// permitted by the specification: a 7 byte MBAP header followed by a
+// PDU of at most 253 bytes.
+const DefaultMaxFrameBytes = 260
+
+// ErrFrameTooLarge is returned when reading a frame whose header claims
+// more bytes than permitted. The frame's data is not read.
+var ErrFrameTooLarge = errors.New("modbus: frame too large")
+
+// Errors reported by a FrameError.
+var (
+	ErrFrameLength    = errors.New("modbus: frame length too small")
+	ErrFrameTruncated = errors.New("modbus: frame truncated")
+	ErrFrameTrailing  = errors.New("modbus: trailing data after frame")
+)
+
+// A FrameError reports a frame that cannot be parsed. Err is
+// ErrFrameLength, ErrFrameTruncated, ErrFrameTrailing or
+// ErrFrameTooLarge.
+type FrameError struct {
+	Header Header // header of the frame, as far as it was decoded
+	Err    error
+}
+
+func (e *FrameError) Error() string {
+	return fmt.Sprintf("%v (length %d, %v)", e.Err, e.Header.Length, FunctionCode(e.Header.Fcode))
+}
+
+func (e *FrameError) Unwrap() error { return e.Err }
+
+// ParseFrame parses b as exactly one frame, MBAP header included, such as
+// a datagram or a captured packet. Frames larger than DefaultMaxFrameBytes
+// are rejected. The frame's data is copied out of b. Every malformed
+// frame is reported with a *FrameError; ParseFrame never panics, whatever
+// b holds.
+func ParseFrame(b []byte) (*Frame, error) {
+	f := new(Frame)
+	if len(b) < headerSize {
+		return nil, &FrameError{Err: ErrFrameTruncated}
+	}
+	f.header.decode(b)
+	switch {
+	case f.header.Length < 2:
+		return nil, &FrameError{f.header, ErrFrameLength}
+	case f.Size() > DefaultMaxFrameBytes:
+		return nil, &FrameError{f.header, ErrFrameTooLarge}
+	case len(b) < f.Size():
+		return nil, &FrameError{f.header, ErrFrameTruncated}
+	case len(b) > f.Size():
+		return nil, &FrameError{f.header, ErrFrameTrailing}
+	}
+	f.data = append([]byte(nil), b[headerSize:]...)
+	return f, nil
+}
+
+// ReadRequest reads and parses an incoming request from b. Frames larger
+// than DefaultMaxFrameBytes are rejected with ErrFrameTooLarge.
 func ReadFrame(b *bufio.Reader) (req *Frame, err error) {
 	req = new(Frame)
+	err = ReadFrameInto(b, req)
+	return
+}
+
+// ReadFrameInto reads and parses an incoming frame from b into f,
+// replacing its header, data and context. The data is read into f's
+// existing data buffer when it has the capacity, so a caller reading
+// many frames can reuse one Frame to avoid an allocation per frame; it
+// must then be done with the previous frame's data before the call. A
+// header whose length is too small is reported with a *FrameError, and
+// a larger frame than permitted with ErrFrameTooLarge.
+func ReadFrameInto(b *bufio.Reader, f *Frame) error {
+	return readFrameInto(b, f, DefaultMaxFrameBytes)
+}
+
+// readFrameInto is ReadFrameInto for frames of at most max bytes. On
+// ErrFrameTooLarge, f holds the frame's header.
+func readFrameInto(b *bufio.Reader, f *Frame, max int) (err error) {
+	f.ctx = nil
 
 	// read the header
-	err = binary.Read(b, binary.BigEndian, &req.header)
+	hb, err := b.Peek(headerSize)
 	if err != nil {
+		if len(hb) > 0 && err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		b.Discard(len(hb))
+		f.data = f.data[:0]
 		return
 	}
+	f.header.decode(hb)
+	b.Discard(headerSize)
+	if f.header.Length < 2 {
+		f.data = f.data[:0]
+		return &FrameError{f.header, ErrFrameLength}
+	}
+	if f.Size() > max {
+		f.data = f.data[:0]
+		return ErrFrameTooLarge
+	}
 
 	// now read the data
-	req.data = make([]byte, req.header.Length-2)
-
-	lr := io.LimitReader(b, int64(req.header.Length-2)).(*io.LimitedReader)
-	_, err = lr.Read(req.data)
-
-	if err != nil {
-		return
-	} else if lr.N != 0 {
-		err = errors.New("modbus: request too small")
-		return
+	n := int(f.header.Length - 2)
+	if cap(f.data) >= n {
+		f.data = f.data[:n]
+	} else {
+		f.data = make([]byte, n)
 	}
 
-	return req, nil
+	// the data may arrive in several reads
+	if _, err = io.ReadFull(b, f.data); err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
 }
 
 // The calling code should prepare the buffer size accordingly
@@ -127,12 +295,12 @@ func WriteFrame(f *Frame, b *bufio.Writer) (err error) {
 	}
 
 	// write Frame
-	err = binary.Write(b, binary.BigEndian, f.header)
-	if err != nil {
+	var hb [headerSize]byte
+	f.header.encode(hb[:])
+	if _, err = b.Write(hb[:]); err != nil {
 		return
 	}
-	err = binary.Write(b, binary.BigEndian, f.data)
-	if err != nil {
+	if _, err = b.Write(f.data); err != nil {
 		return
 	}
 
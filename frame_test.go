@@ -3,8 +3,11 @@ package modbus
 import (
 	"bufio"
 	"bytes"
+	"errors"
 //	"fmt"
+	"io"
 	"testing"
+	"testing/iotest"
 )
 
 func TestReadFrame(t *testing.T) {
@@ -24,7 +27,7 @@ func TestReadFrame(t *testing.T) {
 	if f.header.Uid != 0xFF {
 		t.Errorf("Unit identifier should be %v not %v", 0xFF, f.header.Uid)
 	}
-	if f.header.Fcode != ReadInputRegisters {
+	if FunctionCode(f.header.Fcode) != ReadInputRegisters {
 		t.Errorf("Function code should be %v not %v", ReadInputRegisters, f.header.Uid)
 	}
 }
@@ -134,3 +137,213 @@ func TestWriteFrame(t *testing.T) {
 	//f, err := ReadFrame(b)
 	*/
 }
+
+func TestNewFrame(t *testing.T) {
+	f := NewFrame(Header{Tid: 0x0001, Uid: 0xFF, Fcode: byte(ReadInputRegisters)}, []byte{0x02, 0x00, 0x0A})
+	if f.Header().Length != 0x0005 {
+		t.Errorf("Length should be %v not %v", 0x0005, f.Header().Length)
+	}
+
+	f.SetData([]byte{0x02})
+	if f.Header().Length != 0x0003 || !bytes.Equal(f.Data(), []byte{0x02}) {
+		t.Errorf("SetData should replace data and update Length")
+	}
+}
+
+func TestFrameBuilders(t *testing.T) {
+	for _, tt := range []struct {
+		f        *Frame
+		expected []byte
+	}{
+		{NewReadCoilsFrame(0xFF, 0x13, 0x25),
+			[]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x01, 0x00, 0x13, 0x00, 0x25}},
+		{NewReadInputRegistersFrame(0x01, 0x08, 0x01),
+			[]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x06, 0x01, 0x04, 0x00, 0x08, 0x00, 0x01}},
+		{NewWriteSingleCoilFrame(0xFF, 0x0A, true),
+			[]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x05, 0x00, 0x0A, 0xFF, 0x00}},
+		{NewWriteMultipleCoilsFrame(0xFF, 0x13, BytesToBools([]byte{0xCD, 0x6B, 0xB2, 0x0E, 0x1B})[:0x25]),
+			[]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x0C, 0xFF, 0x0F, 0x00, 0x13,
+				0x00, 0x25, 0x05, 0xCD, 0x6B, 0xB2, 0x0E, 0x1B}},
+		{NewWriteMultipleRegistersFrame(0xFF, 0x6B, []uint16{0x022B, 0x0001, 0x0064}),
+			[]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x0D, 0xFF, 0x10, 0x00, 0x6B,
+				0x00, 0x03, 0x06, 0x02, 0x2B, 0x00, 0x01, 0x00, 0x64}},
+		{NewMaskWriteRegisterFrame(0xFF, 0x04, 0x00F2, 0x0025),
+			[]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x08, 0xFF, 0x16, 0x00, 0x04, 0x00, 0xF2, 0x00, 0x25}},
+		{NewWriteAndReadRegistersFrame(0xFF, 0x6B, 0x03, 0x6C, []uint16{0x0000, 0x0000}),
+			[]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x0F, 0xFF, 0x17, 0x00, 0x6B,
+				0x00, 0x03, 0x00, 0x6C, 0x00, 0x02, 0x04, 0x00, 0x00, 0x00, 0x00}},
+	} {
+		buf := &bytes.Buffer{}
+		bw := bufio.NewWriter(buf)
+		if err := WriteFrame(tt.f, bw); err != nil {
+			t.Fatalf("err should be nil not %v", err)
+		}
+		bw.Flush()
+		if !bytes.Equal(buf.Bytes(), tt.expected) {
+			t.Errorf("Incorrect Frame % X", buf.Bytes())
+		}
+	}
+}
+
+func BenchmarkReadFrame(b *testing.B) {
+	req := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x03, 0x00, 0x6B, 0x00, 0x7D}
+	r := bytes.NewReader(req)
+	br := bufio.NewReader(r)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r.Reset(req)
+		br.Reset(r)
+		if _, err := ReadFrame(br); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWriteFrame(b *testing.B) {
+	f := NewReadHoldingRegistersFrame(0xFF, 0x6B, 0x7D)
+	bw := bufio.NewWriter(io.Discard)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := WriteFrame(f, bw); err != nil {
+			b.Fatal(err)
+		}
+		bw.Flush()
+	}
+}
+
+func TestReadFrameInto(t *testing.T) {
+	reqs := []byte{
+		0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x03, 0x00, 0x6B, 0x00, 0x03,
+		0x00, 0x02, 0x00, 0x00, 0x00, 0x04, 0xFF, 0x07, 0xAA, 0xBB,
+	}
+	b := bufio.NewReader(bytes.NewReader(reqs))
+
+	var f Frame
+	if err := ReadFrameInto(b, &f); err != nil {
+		t.Fatalf("err should be nil not %v", err)
+	}
+	if f.header.Tid != 1 || !bytes.Equal(f.data, []byte{0x00, 0x6B, 0x00, 0x03}) {
+		t.Errorf("first frame %+v % X", f.header, f.data)
+	}
+	first := &f.data[0]
+
+	if err := ReadFrameInto(b, &f); err != nil {
+		t.Fatalf("err should be nil not %v", err)
+	}
+	if f.header.Tid != 2 || f.header.Fcode != 0x07 || !bytes.Equal(f.data, []byte{0xAA, 0xBB}) {
+		t.Errorf("second frame %+v % X", f.header, f.data)
+	}
+	if &f.data[0] != first {
+		t.Errorf("data buffer not reused")
+	}
+
+	if err := ReadFrameInto(b, &f); err != io.EOF {
+		t.Errorf("err should be io.EOF not %v", err)
+	}
+}
+
+func BenchmarkReadFrameInto(b *testing.B) {
+	req := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x03, 0x00, 0x6B, 0x00, 0x7D}
+	r := bytes.NewReader(req)
+	br := bufio.NewReader(r)
+	var f Frame
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r.Reset(req)
+		br.Reset(r)
+		if err := ReadFrameInto(br, &f); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestReadFrameTooLarge(t *testing.T) {
+	req := []byte{0x00, 0x01, 0x00, 0x00, 0xFF, 0xFF, 0xFF, 0x03}
+	b := bufio.NewReader(bytes.NewReader(req))
+	f, err := ReadFrame(b)
+
+	if err != ErrFrameTooLarge {
+		t.Errorf("err should be ErrFrameTooLarge not %v", err)
+	}
+	if f.header.Length != 0xFFFF || cap(f.data) != 0 {
+		t.Errorf("header %+v, data buffer of %d bytes", f.header, cap(f.data))
+	}
+
+	req = []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0xFF}
+	b = bufio.NewReader(bytes.NewReader(append(req, 0x03)))
+	if _, err := ReadFrame(b); err == nil {
+		t.Errorf("err should not be nil for a length of 1")
+	}
+}
+
+func TestReadFramePartialReads(t *testing.T) {
+	req := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x03, 0x00, 0x00, 0x00, 0x0A}
+	b := bufio.NewReader(iotest.OneByteReader(bytes.NewReader(req)))
+	f, err := ReadFrame(b)
+	if err != nil {
+		t.Fatalf("ReadFrame: %v", err)
+	}
+	if !bytes.Equal(f.data, req[8:]) {
+		t.Errorf("data % X; want % X", f.data, req[8:])
+	}
+
+	b = bufio.NewReader(iotest.OneByteReader(bytes.NewReader(req[:10])))
+	if _, err := ReadFrame(b); err != io.ErrUnexpectedEOF {
+		t.Errorf("err for a truncated frame = %v; want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestParseFrame(t *testing.T) {
+	req := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x03, 0x00, 0x00, 0x00, 0x0A}
+	f, err := ParseFrame(req)
+	if err != nil {
+		t.Fatalf("ParseFrame: %v", err)
+	}
+	if f.header.Tid != 1 || FunctionCode(f.header.Fcode) != ReadHoldingRegisters || !bytes.Equal(f.data, req[8:]) {
+		t.Errorf("header %+v, data % X", f.header, f.data)
+	}
+
+	tests := []struct {
+		b   []byte
+		err error
+	}{
+		{req[:5], ErrFrameTruncated},
+		{req[:10], ErrFrameTruncated},
+		{append(req[:len(req):len(req)], 0x00), ErrFrameTrailing},
+		{[]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0xFF, 0x03}, ErrFrameLength},
+		{[]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0xFF, 0x03}, ErrFrameLength},
+		{[]byte{0x00, 0x01, 0x00, 0x00, 0xFF, 0xFF, 0xFF, 0x03}, ErrFrameTooLarge},
+	}
+	for _, tt := range tests {
+		_, err := ParseFrame(tt.b)
+		var fe *FrameError
+		if !errors.As(err, &fe) || !errors.Is(err, tt.err) {
+			t.Errorf("ParseFrame(% X) = %v; want a FrameError for %v", tt.b, err, tt.err)
+		}
+	}
+
+	b := bufio.NewReader(bytes.NewReader([]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0xFF, 0x03}))
+	if _, err := ReadFrame(b); !errors.Is(err, ErrFrameLength) {
+		t.Errorf("ReadFrame of a zero length = %v; want ErrFrameLength", err)
+	}
+}
+
+func FuzzParseFrame(f *testing.F) {
+	f.Add([]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x03, 0x00, 0x00, 0x00, 0x0A})
+	f.Add([]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0xFF, 0x03})
+	f.Add([]byte{0x00, 0x01, 0x00, 0x00, 0xFF, 0xFF, 0xFF, 0x03})
+	f.Fuzz(func(t *testing.T, b []byte) {
+		fr, err := ParseFrame(b)
+		if err != nil {
+			return
+		}
+		if fr.Size() != len(b) {
+			t.Errorf("frame of %d bytes parsed from %d", fr.Size(), len(b))
+		}
+		// ReadFrame agrees with ParseFrame on valid frames
+		rf, err := ReadFrame(bufio.NewReader(bytes.NewReader(b)))
+		if err != nil || rf.header != fr.header || !bytes.Equal(rf.data, fr.data) {
+			t.Errorf("ReadFrame(% X) = %+v, %v", b, rf, err)
+		}
+	})
+}
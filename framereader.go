@@ -0,0 +1,140 @@
+package modbus
+
+import (
+	"bufio"
+	"io"
+	"time"
+)
+
+// A FrameReader reads Modbus/TCP frames from a stream that may be
+// unreliable, such as a TCP connection to a flaky serial gateway. Unlike
+// ReadFrame it can bound the time the bytes of a frame may take to
+// arrive, and skip bytes that cannot start a frame instead of failing on
+// them.
+type FrameReader struct {
+	// InterByteTimeout, if positive, is the maximum time to wait for
+	// each further read once the first byte of a frame has arrived. It
+	// requires the underlying reader to have a SetReadDeadline method,
+	// as a net.Conn does; the read deadline is cleared after each frame.
+	// Waiting for the first byte is bounded only by the caller's own
+	// deadline.
+	InterByteTimeout time.Duration
+
+	// Resync causes bytes that cannot start a frame to be skipped one
+	// at a time until a plausible header is found: one with a protocol
+	// identifier of zero and a length of at least 2 bytes, within
+	// MaxFrameBytes. Without it, such headers are returned as errors.
+	Resync bool
+
+	// MaxFrameBytes is the size of the largest frame read, MBAP header
+	// included. If zero, DefaultMaxFrameBytes is used.
+	MaxFrameBytes int
+
+	ib      interByteReader
+	br      *bufio.Reader
+	skipped int64
+}
+
+// NewFrameReader returns a FrameReader reading from r.
+func NewFrameReader(r io.Reader) *FrameReader {
+	fr := &FrameReader{ib: interByteReader{r: r}}
+	fr.ib.conn, _ = r.(readDeadliner)
+	fr.br = bufio.NewReader(&fr.ib)
+	return fr
+}
+
+// Skipped returns the number of bytes skipped so far to resynchronize
+// on a frame header.
+func (fr *FrameReader) Skipped() int64 {
+	return fr.skipped
+}
+
+// ReadFrame reads the next frame.
+func (fr *FrameReader) ReadFrame() (*Frame, error) {
+	f := new(Frame)
+	if err := fr.ReadFrameInto(f); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// ReadFrameInto reads the next frame into f, reusing its data buffer
+// when it has the capacity.
+func (fr *FrameReader) ReadFrameInto(f *Frame) error {
+	max := fr.MaxFrameBytes
+	if max <= 0 {
+		max = DefaultMaxFrameBytes
+	}
+	if fr.InterByteTimeout > 0 && fr.ib.conn != nil {
+		if _, err := fr.br.Peek(1); err != nil {
+			return err
+		}
+		fr.ib.arm(fr.InterByteTimeout, time.Time{})
+		defer fr.ib.disarm(time.Time{})
+	}
+	if fr.Resync {
+		if err := fr.resync(max); err != nil {
+			return err
+		}
+	}
+	return readFrameInto(fr.br, f, max)
+}
+
+// resync discards bytes until the buffered input starts with a
+// plausible frame header.
+func (fr *FrameReader) resync(max int) error {
+	var h Header
+	for {
+		hb, err := fr.br.Peek(headerSize)
+		if err != nil {
+			if len(hb) > 0 && err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		h.decode(hb)
+		if h.Pid == TcpPid && h.Length >= 2 && 6+int(h.Length) <= max {
+			return nil
+		}
+		fr.br.Discard(1)
+		fr.skipped++
+	}
+}
+
+// readDeadliner is implemented by connections whose reads can time out.
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// An interByteReader renews the read deadline of conn before each read
+// from r while it is armed, so that a frame whose bytes stop arriving
+// times out without bounding the time the frame as a whole may take.
+type interByteReader struct {
+	r       io.Reader
+	conn    readDeadliner
+	timeout time.Duration
+	limit   time.Time // deadline the renewals may not pass, if not zero
+	armed   bool
+}
+
+// arm starts renewing the read deadline by timeout, up to limit.
+func (ir *interByteReader) arm(timeout time.Duration, limit time.Time) {
+	ir.timeout, ir.limit, ir.armed = timeout, limit, true
+}
+
+// disarm stops renewing the read deadline and sets it to deadline.
+func (ir *interByteReader) disarm(deadline time.Time) {
+	ir.armed = false
+	ir.conn.SetReadDeadline(deadline)
+}
+
+func (ir *interByteReader) Read(p []byte) (int, error) {
+	if ir.armed {
+		t := time.Now().Add(ir.timeout)
+		if !ir.limit.IsZero() && ir.limit.Before(t) {
+			t = ir.limit
+		}
+		ir.conn.SetReadDeadline(t)
+	}
+	return ir.r.Read(p)
+}
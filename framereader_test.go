@@ -0,0 +1,64 @@
+package modbus
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestFrameReaderResync(t *testing.T) {
+	req := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x03, 0x00, 0x00, 0x00, 0x0A}
+	garbage := []byte{0xFF, 0x00, 0x01, 0x00, 0x07}
+	stream := append(append(append([]byte(nil), req...), garbage...), req...)
+
+	fr := NewFrameReader(iotest.OneByteReader(bytes.NewReader(stream)))
+	fr.Resync = true
+	for i := 0; i < 2; i++ {
+		f, err := fr.ReadFrame()
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if f.header.Tid != 1 || !bytes.Equal(f.data, req[8:]) {
+			t.Errorf("frame %d: header %+v, data % X", i, f.header, f.data)
+		}
+	}
+	if n := fr.Skipped(); n != int64(len(garbage)) {
+		t.Errorf("Skipped = %d; want %d", n, len(garbage))
+	}
+
+	fr = NewFrameReader(bytes.NewReader(append(garbage, req...)))
+	if _, err := fr.ReadFrame(); err == nil {
+		t.Errorf("ReadFrame without Resync read a frame after garbage")
+	}
+}
+
+func TestFrameReaderInterByteTimeout(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	fr := NewFrameReader(c1)
+	fr.InterByteTimeout = 50 * time.Millisecond
+	req := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x03, 0x00, 0x00, 0x00, 0x0A}
+	go func() {
+		// a slow first byte is waited for, as are bytes trickling in
+		time.Sleep(100 * time.Millisecond)
+		for _, b := range req {
+			c2.Write([]byte{b})
+			time.Sleep(10 * time.Millisecond)
+		}
+		// a frame that stalls after its header times out
+		c2.Write(req[:8])
+	}()
+
+	if _, err := fr.ReadFrame(); err != nil {
+		t.Fatalf("ReadFrame: %v", err)
+	}
+	if _, err := fr.ReadFrame(); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("ReadFrame of a stalled frame = %v; want a timeout", err)
+	}
+}
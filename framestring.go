@@ -0,0 +1,65 @@
+package modbus
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// String returns a human readable decoding of f for logs, such as
+//
+//	tid=1 uid=255 ReadHoldingRegisters {Addr:107 Quantity:3}
+//
+// The data of the functions this package implements is decoded as a
+// request if it is well formed as one, and as a response otherwise;
+// exceptions are named, and other data is rendered in hexadecimal.
+func (f *Frame) String() string {
+	var b strings.Builder
+	h := f.header
+	fmt.Fprintf(&b, "tid=%d ", h.Tid)
+	if h.Pid != TcpPid {
+		fmt.Fprintf(&b, "pid=%d ", h.Pid)
+	}
+	fmt.Fprintf(&b, "uid=%d %v", h.Uid, FunctionCode(h.Fcode))
+	if FunctionCode(h.Fcode).IsException() && len(f.data) == 1 {
+		fmt.Fprintf(&b, " %v", ExceptionCode(f.data[0]))
+	} else if p := decodePDU(f); p != nil {
+		b.WriteString(" " + strings.TrimPrefix(fmt.Sprintf("%+v", p), "&"))
+	} else if len(f.data) > 0 {
+		fmt.Fprintf(&b, " data=% X", f.data)
+	}
+	return b.String()
+}
+
+// frameJSON is the JSON encoding of a Frame.
+type frameJSON struct {
+	Tid       uint16 `json:"tid"`
+	Pid       uint16 `json:"pid,omitempty"`
+	Uid       uint8  `json:"uid"`
+	Fcode     uint8  `json:"fcode"`
+	Function  string `json:"function"`
+	Exception string `json:"exception,omitempty"`
+	PDU       PDU    `json:"pdu,omitempty"`
+	Data      []byte `json:"data,omitempty"` // if not decoded
+}
+
+// MarshalJSON encodes the decoding of f that String describes, for
+// logs and capture files: the header fields, the function's name, and
+// the exception's name or the decoded PDU's fields, or else the raw
+// data.
+func (f *Frame) MarshalJSON() ([]byte, error) {
+	h := f.header
+	j := frameJSON{
+		Tid:      h.Tid,
+		Pid:      h.Pid,
+		Uid:      h.Uid,
+		Fcode:    h.Fcode,
+		Function: FunctionCode(h.Fcode).String(),
+	}
+	if FunctionCode(h.Fcode).IsException() && len(f.data) == 1 {
+		j.Exception = ExceptionCode(f.data[0]).String()
+	} else if j.PDU = decodePDU(f); j.PDU == nil {
+		j.Data = f.data
+	}
+	return json.Marshal(j)
+}
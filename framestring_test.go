@@ -0,0 +1,47 @@
+package modbus
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestFrameString(t *testing.T) {
+	tests := []struct {
+		frame []byte
+		want  string
+		json  string
+	}{
+		{
+			[]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x03, 0x00, 0x6B, 0x00, 0x03},
+			"tid=1 uid=255 ReadHoldingRegisters {Addr:107 Quantity:3}",
+			`{"tid":1,"uid":255,"fcode":3,"function":"ReadHoldingRegisters","pdu":{"Addr":107,"Quantity":3}}`,
+		},
+		{
+			[]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x07, 0xFF, 0x03, 0x04, 0x02, 0x2B, 0x00, 0x00},
+			"tid=1 uid=255 ReadHoldingRegisters {Values:[555 0]}",
+			`{"tid":1,"uid":255,"fcode":3,"function":"ReadHoldingRegisters","pdu":{"Values":[555,0]}}`,
+		},
+		{
+			[]byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x03, 0x01, 0x83, 0x02},
+			"tid=2 uid=1 ReadHoldingRegistersException IllegalDataAddress",
+			`{"tid":2,"uid":1,"fcode":131,"function":"ReadHoldingRegistersException","exception":"IllegalDataAddress"}`,
+		},
+		{
+			[]byte{0x00, 0x03, 0x00, 0x07, 0x00, 0x04, 0x01, 0x41, 0xAB, 0xCD},
+			"tid=3 pid=7 uid=1 FunctionCode(0x41) data=AB CD",
+			`{"tid":3,"pid":7,"uid":1,"fcode":65,"function":"FunctionCode(0x41)","data":"q80="}`,
+		},
+	}
+	for _, tt := range tests {
+		f, err := ParseFrame(tt.frame)
+		if err != nil {
+			t.Fatalf("ParseFrame(% X): %v", tt.frame, err)
+		}
+		if s := f.String(); s != tt.want {
+			t.Errorf("String = %q; want %q", s, tt.want)
+		}
+		if b, err := json.Marshal(f); err != nil || string(b) != tt.json {
+			t.Errorf("MarshalJSON = %s, %v; want %s", b, err, tt.json)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package modbus
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A Gateway is a Handler routing the requests of each unit to a backend
+// Handler: an in-process one, such as a RegisterHandler, or a Forwarder
+// relaying them to a slave over Modbus TCP or an RTU serial line. Unlike
+// a ServeMux, its routes may be changed while it serves.
+//
+// The zero value is a Gateway without routes, ready to use.
+type Gateway struct {
+	// Default, if non nil, handles the requests of units without a
+	// route. Otherwise they are answered by NotFound.
+	Default Handler
+
+	mu     sync.RWMutex
+	routes map[uint8]Handler
+}
+
+// Route routes the requests of unit uid to backend, replacing any route
+// of uid. A nil backend removes the route.
+func (g *Gateway) Route(uid uint8, backend Handler) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if backend == nil {
+		delete(g.routes, uid)
+		return
+	}
+	if g.routes == nil {
+		g.routes = make(map[uint8]Handler)
+	}
+	g.routes[uid] = backend
+}
+
+// Backend returns the backend of unit uid, or nil if it has no route.
+func (g *Gateway) Backend(uid uint8) Handler {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.routes[uid]
+}
+
+// Units returns the routed unit identifiers in ascending order.
+func (g *Gateway) Units() []uint8 {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	uids := make([]uint8, 0, len(g.routes))
+	for uid := range g.routes {
+		uids = append(uids, uid)
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	return uids
+}
+
+// ServeModbus dispatches the request to the backend of its unit.
+func (g *Gateway) ServeModbus(w ResponseWriter, r *Frame) {
+	h := g.Backend(r.header.Uid)
+	if h == nil {
+		h = g.Default
+	}
+	if h == nil {
+		NotFound(w, r)
+		return
+	}
+	h.ServeModbus(w, r)
+}
+
+// A Forwarder is a Handler relaying requests to a slave through
+// Transport and answering with the slave's responses. Requests the slave
+// fails to answer, including while it cannot be reached, are answered
+// with a GatewayTargetFailed exception.
+//
+// A ClientPool makes a Transport to a Modbus TCP slave, and an
+// RTUTransport one to the slaves of a serial line.
+type Forwarder struct {
+	Transport RoundTripper
+
+	// Unit, if non zero, replaces the unit identifier of the requests
+	// relayed, e.g. with the address of a slave on an RTU line.
+	Unit uint8
+
+	// Timeout bounds the time the slave is given to respond. If zero,
+	// 1s is used.
+	Timeout time.Duration
+}
+
+func (f *Forwarder) ServeModbus(w ResponseWriter, r *Frame) {
+	timeout := f.Timeout
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	req := NewFrame(r.header, r.data)
+	if f.Unit != 0 {
+		req.header.Uid = f.Unit
+	}
+	resp, err := f.Transport.RoundTrip(ctx, req)
+	switch {
+	case err != nil:
+		w.WriteException(GatewayTargetFailed)
+	case resp.header.Fcode&0x80 != 0 && len(resp.data) > 0:
+		w.WriteException(ExceptionCode(resp.data[0]))
+	default:
+		w.Write(resp.data)
+	}
+}
@@ -0,0 +1,59 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGateway(t *testing.T) {
+	// unit 9 of the TCP slave is reached as unit 2 of the gateway
+	mux := NewServeMux()
+	mux.Handle(9, &RegisterHandler{Holdings: []uint16{90}})
+	pool := &ClientPool{Addr: startTestServer(t, &Server{Handler: mux})}
+	defer pool.Close()
+	rtu := &RTUTransport{Port: newFakeRTUPort(rtuSlaves), Timeout: 20 * time.Millisecond}
+
+	gw := new(Gateway)
+	gw.Route(1, &RegisterHandler{Holdings: []uint16{10}})
+	gw.Route(2, &Forwarder{Transport: pool, Unit: 9})
+	gw.Route(3, &Forwarder{Transport: rtu})
+	gw.Route(4, &Forwarder{Transport: rtu, Unit: 7})
+	gw.Route(5, &Forwarder{Transport: rtu, Unit: 8})
+	c := dialTestServer(t, gw)
+	ctx := context.Background()
+
+	for uid, want := range map[uint8]uint16{1: 10, 2: 90, 3: 0x1234} {
+		if v, err := c.ReadHoldingRegisters(ctx, uid, 0, 1); err != nil || !reflect.DeepEqual(v, []uint16{want}) {
+			t.Errorf("ReadHoldingRegisters of unit %d = %v, %v; want [%d]", uid, v, err, want)
+		}
+	}
+	for uid, want := range map[uint8]error{
+		4: ErrIllegalDataAddress,     // exception of the RTU slave
+		5: ErrGatewayTargetFailed,    // no RTU slave
+		6: ErrGatewayPathUnavailable, // no route
+	} {
+		if _, err := c.ReadHoldingRegisters(ctx, uid, 0, 1); !errors.Is(err, want) {
+			t.Errorf("ReadHoldingRegisters of unit %d = %v; want %v", uid, err, want)
+		}
+	}
+
+	gw.Route(1, nil)
+	gw.Route(6, &RegisterHandler{Holdings: []uint16{60}})
+	if _, err := c.ReadHoldingRegisters(ctx, 1, 0, 1); !errors.Is(err, ErrGatewayPathUnavailable) {
+		t.Errorf("ReadHoldingRegisters of removed unit 1 = %v", err)
+	}
+	if v, err := c.ReadHoldingRegisters(ctx, 6, 0, 1); err != nil || v[0] != 60 {
+		t.Errorf("ReadHoldingRegisters of added unit 6 = %v, %v", v, err)
+	}
+	if got := gw.Units(); !reflect.DeepEqual(got, []uint8{2, 3, 4, 5, 6}) {
+		t.Errorf("Units = %v", got)
+	}
+
+	gw.Default = &RegisterHandler{Holdings: []uint16{1}}
+	if v, err := c.ReadHoldingRegisters(ctx, 1, 0, 1); err != nil || v[0] != 1 {
+		t.Errorf("ReadHoldingRegisters of default unit 1 = %v, %v", v, err)
+	}
+}
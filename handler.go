@@ -1,23 +1,259 @@
 package modbus
 
 import (
-	"bytes"
-	"encoding/binary"
+	"context"
+	"sync"
 )
 
 // A RegisterHandler implements the modbus.Handler interface, servicing
 // Modbus request in accordance with http://www.modbus.org/docs/Modbus_Application_Protocol_V1_1b3.pdf
 type RegisterHandler struct {
+	// Store holds the data served. If nil, the slices below are served.
+	Store DataStore
+
 	Coils          []bool
 	DiscreteInputs []bool
 	Inputs         []uint16
 	Holdings       []uint16
+
+	// ReadOnly causes every write request to be rejected.
+	ReadOnly bool
+
+	// ProtectedException is the exception code returned for writes
+	// rejected because of ReadOnly or a Protect range. If zero,
+	// IllegalDataAddress is used.
+	ProtectedException ExceptionCode
+
+	// FullByteCount causes Read Coils and Read Discrete Inputs responses
+	// to carry the byte count implied by the requested quantity even
+	// when the Store returns fewer values, the missing bits reading as
+	// zero, as some conformance testers expect. Otherwise the byte
+	// count follows the values returned.
+	FullByteCount bool
+
+	// OnWrite, if non nil, is called before every write of a master to
+	// coils or holding registers, with the table and first address
+	// written, and the values held and to be written from there: []bool
+	// for CoilTable and []uint16 for HoldingRegisterTable. If it returns
+	// an error the write is not made, and the request is answered as
+	// WriteError does, so that returning ErrIllegalDataValue rejects
+	// values out of range. It must not modify or retain the slices.
+	// Writes checked by OnWrite are serialised with Mask Write Register
+	// requests, so that old holds the values being replaced.
+	OnWrite func(t Table, addr uint16, old, new interface{}) error
+
+	// AtomicWrites causes the writes of masters to be made by
+	// ApplyAtomic, so that a write failing part way, as those of stores
+	// spanning several backends may, is undone rather than left half
+	// made. Writes to a Store that is not a Transactor then read the
+	// values they replace first. Atomic writes are serialised with Mask
+	// Write Register requests.
+	AtomicWrites bool
+
+	// OnRead, if non nil, is called with the values of every read of a
+	// master before they are sent, with the table and first address
+	// read: []bool for coils and discrete inputs, []uint16 for
+	// registers. It may replace values in place, computing registers
+	// such as a clock or a live sensor reading at request time rather
+	// than storing them. If it returns an error the request is answered
+	// as WriteError does. The values of Read/Write Multiple registers
+	// requests are passed to OnRead too.
+	OnRead func(t Table, addr uint16, values interface{}) error
+
+	// Addressing relates the addresses given to ProtectDocumented to
+	// protocol addresses. OnWrite and OnRead are passed protocol addresses,
+	// which Addressing.Documented converts.
+	Addressing Addressing
+
+	protected []AddressRange
+
+	mu       sync.RWMutex // guards the slices when Store is nil, and migrated
+	migrated DataStore    // store the slices were moved to, see Migrate
+	rmw      sync.Mutex   // serialises Mask Write Register requests, OnWrite checks and atomic writes
+}
+
+// DataStore returns the store h serves: Store, or if it is nil a store
+// backed by h's slices.
+func (h *RegisterHandler) DataStore() DataStore {
+	if h.Store != nil {
+		return h.Store
+	}
+	return sliceStore{h}
+}
+
+// A Table identifies one of the four tables of the Modbus data model.
+type Table uint8
+
+const (
+	CoilTable Table = iota
+	DiscreteInputTable
+	InputRegisterTable
+	HoldingRegisterTable
+)
+
+var tableName = map[Table]string{
+	CoilTable:            "coils",
+	DiscreteInputTable:   "discrete inputs",
+	InputRegisterTable:   "input registers",
+	HoldingRegisterTable: "holding registers",
+}
+
+func (t Table) String() string {
+	return tableName[t]
+}
+
+// An AddressRange is an inclusive range of addresses within a Table.
+type AddressRange struct {
+	Table      Table
+	Start, End uint16
+}
+
+// Overlaps reports whether any of the num addresses starting at addr in
+// table t fall within the range.
+func (r AddressRange) Overlaps(t Table, addr, num uint16) bool {
+	last := int(addr) + int(num) - 1
+	return r.Table == t && num > 0 && int(addr) <= int(r.End) && last >= int(r.Start)
+}
+
+// Contains reports whether all of the num addresses starting at addr in
+// table t fall within the range.
+func (r AddressRange) Contains(t Table, addr, num uint16) bool {
+	last := int(addr) + int(num) - 1
+	return r.Table == t && addr >= r.Start && last <= int(r.End)
+}
+
+// Protect write protects the addresses start through end inclusive of
+// table t. Write requests touching any protected address are answered
+// with ProtectedException and leave the handler's state unchanged. Only
+// CoilTable and HoldingRegisterTable are writable by a master.
+func (h *RegisterHandler) Protect(t Table, start, end uint16) {
+	h.protected = append(h.protected, AddressRange{t, start, end})
+}
+
+// ProtectDocumented is like Protect, but start and end are documented
+// addresses under h.Addressing. It fails for addresses below the table's
+// base, protecting nothing.
+func (h *RegisterHandler) ProtectDocumented(t Table, start, end uint16) error {
+	start, err := h.Addressing.Protocol(t, start)
+	if err != nil {
+		return err
+	}
+	end, err = h.Addressing.Protocol(t, end)
+	if err != nil {
+		return err
+	}
+	h.Protect(t, start, end)
+	return nil
+}
+
+// writeProtected reports whether a write of num values at offset in
+// table t is forbidden.
+func (h *RegisterHandler) writeProtected(t Table, offset, num uint16) bool {
+	if h.ReadOnly {
+		return true
+	}
+	for _, p := range h.protected {
+		if p.Overlaps(t, offset, num) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *RegisterHandler) protectedException() ExceptionCode {
+	if h.ProtectedException != 0 {
+		return h.ProtectedException
+	}
+	return IllegalDataAddress
+}
+
+// writeCoils writes values at addr to the store, if OnWrite allows.
+func (h *RegisterHandler) writeCoils(ctx context.Context, addr uint16, values []bool) error {
+	store := h.DataStore()
+	if h.OnWrite == nil && !h.AtomicWrites {
+		return store.WriteCoils(ctx, addr, values)
+	}
+	h.rmw.Lock()
+	defer h.rmw.Unlock()
+	if h.OnWrite != nil {
+		old, err := store.ReadCoils(ctx, addr, uint16(len(values)))
+		if err != nil {
+			return err
+		}
+		if len(old) > len(values) {
+			old = old[:len(values)]
+		}
+		if err := h.OnWrite(CoilTable, addr, old, values); err != nil {
+			return err
+		}
+	}
+	if h.AtomicWrites {
+		return ApplyAtomic(ctx, store, writeChanges(CoilTable, addr, bitsToValues(values)))
+	}
+	return store.WriteCoils(ctx, addr, values)
+}
+
+// writeHoldings writes values at addr to the store, if OnWrite allows.
+func (h *RegisterHandler) writeHoldings(ctx context.Context, addr uint16, values []uint16) error {
+	store := h.DataStore()
+	if h.OnWrite == nil && !h.AtomicWrites {
+		return store.WriteHoldingRegisters(ctx, addr, values)
+	}
+	h.rmw.Lock()
+	defer h.rmw.Unlock()
+	if h.OnWrite != nil {
+		old, err := store.ReadHoldingRegisters(ctx, addr, uint16(len(values)))
+		if err != nil {
+			return err
+		}
+		if len(old) > len(values) {
+			old = old[:len(values)]
+		}
+		if err := h.OnWrite(HoldingRegisterTable, addr, old, values); err != nil {
+			return err
+		}
+	}
+	if h.AtomicWrites {
+		return ApplyAtomic(ctx, store, writeChanges(HoldingRegisterTable, addr, values))
+	}
+	return store.WriteHoldingRegisters(ctx, addr, values)
+}
+
+// onReadBits returns the quantity bits values read at addr of table t as
+// OnRead leaves them.
+func (h *RegisterHandler) onReadBits(t Table, addr, quantity uint16, values []bool) ([]bool, error) {
+	if h.OnRead == nil {
+		return values, nil
+	}
+	if len(values) > int(quantity) {
+		values = values[:quantity]
+	}
+	values = append([]bool(nil), values...) // the store's may be shared
+	if err := h.OnRead(t, addr, values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// onReadRegisters is onReadBits for registers.
+func (h *RegisterHandler) onReadRegisters(t Table, addr, quantity uint16, values []uint16) ([]uint16, error) {
+	if h.OnRead == nil {
+		return values, nil
+	}
+	if len(values) > int(quantity) {
+		values = values[:quantity]
+	}
+	values = append([]uint16(nil), values...)
+	if err := h.OnRead(t, addr, values); err != nil {
+		return nil, err
+	}
+	return values, nil
 }
 
 func (h *RegisterHandler) ServeModbus(w ResponseWriter, r *Frame) {
 
 	// interrogate Request Frame's Function Code
-	switch r.header.Fcode {
+	switch FunctionCode(r.header.Fcode) {
 	case ReadCoils:
 		h.ReadCoils(w, r)
 	case ReadDiscreteInputs:
@@ -34,239 +270,207 @@ func (h *RegisterHandler) ServeModbus(w ResponseWriter, r *Frame) {
 		h.WriteMultipleCoils(w, r)
 	case WriteMultipleRegisters:
 		h.WriteMultipleRegisters(w, r)
+	case MaskWriteRegister:
+		h.MaskWriteRegister(w, r)
 	case WriteAndReadRegisters:
 		h.WriteAndReadRegisters(w, r)
 	case ReadExceptionStatus: // serial only
 	case ReportSlaveId: // serial only
 	default:
 		// Unknown Function Code
-		w.Header().Fcode += 0x80
-		w.Write([]byte{IllegalFunction})
+		w.WriteException(IllegalFunction)
 	}
 }
 
-func BoolsToBytes(bools []bool) (bytes []byte) {
-	value := uint8(0)
-
-	for i, b := range bools {
-		if b {
-			value |= 1 << uint(i%8)
-		}
-		if (i%8 == 7) || i == len(bools)-1 {
-			bytes = append(bytes, value)
-			value = 0
-		}
-	}
+// BoolsToBytes packs bools into bytes.
+//
+// Deprecated: use PackBits.
+func BoolsToBytes(bools []bool) []byte {
+	return PackBits(bools)
+}
 
-	return
+// BytesToBools unpacks every bit of bytes, always returning a multiple of
+// 8 bits.
+//
+// Deprecated: use UnpackBits, which takes the exact number of bits.
+func BytesToBools(bytes []byte) []bool {
+	return UnpackBits(bytes, 8*len(bytes))
 }
 
-func BytesToBools(bytes []byte) (bools []bool) {
-	for _, b := range bytes {
-		for i := 0; i < 8; i++ {
-			if ((b >> uint(i)) & 0x01) == 1 {
-				bools = append(bools, true)
-			} else {
-				bools = append(bools, false)
-			}
-		}
+// responseBits returns the values of a response to a read of quantity
+// bits: values beyond quantity are dropped, and with FullByteCount
+// missing values are added as zero.
+func (h *RegisterHandler) responseBits(values []bool, quantity uint16) []bool {
+	if len(values) > int(quantity) {
+		return values[:quantity]
 	}
-
-	return
+	if h.FullByteCount && len(values) < int(quantity) {
+		// values may be the store's own slice: never append in place
+		values = append(values[:len(values):len(values)], make([]bool, int(quantity)-len(values))...)
+	}
+	return values
 }
 
 func (h *RegisterHandler) ReadCoils(w ResponseWriter, r *Frame) {
-	// ensure request payload is correct length
-	if len(r.data) != 4 {
-		w.Header().Fcode += 0x80
-		w.Write([]byte{IllegalDataValue})
+	// parse and validate request
+	var req ReadCoilsRequest
+	if err := req.UnmarshalBinary(r.data); err != nil {
+		WriteError(w, err)
 		return
 	}
 
-	// get offset and number of registers
-	offset := binary.BigEndian.Uint16(r.data[0:2])
-	num := binary.BigEndian.Uint16(r.data[2:4])
-
-	if num < 1 || num > 0x07D0 {
-		w.Header().Fcode += 0x80
-		w.Write([]byte{IllegalDataValue})
-		return
+	values, err := h.DataStore().ReadCoils(r.Context(), req.Addr, req.Quantity)
+	if err == nil {
+		values, err = h.onReadBits(CoilTable, req.Addr, req.Quantity, values)
 	}
-
-	// check register request range
-	if int(offset+num) > len(h.Coils) {
-		w.Header().Fcode += 0x80
-		w.Write([]byte{IllegalDataAddress})
+	if err != nil {
+		WriteError(w, err)
 		return
 	}
 
-	// take appropriate slice and convert to bytes
-	buf := &bytes.Buffer{}
-	err := binary.Write(buf, binary.BigEndian, BoolsToBytes(h.Coils[offset:offset+num]))
+	resp := ReadCoilsResponse{Values: h.responseBits(values, req.Quantity)}
+	data, err := resp.MarshalBinary()
 	if err != nil {
-		w.Header().Fcode += 0x80
-		w.Write([]byte{SlaveFailure})
+		WriteError(w, err)
 		return
 	}
 
-	data := buf.Bytes()
-
-	w.Write(append([]byte{byte(len(data))}, data...))
+	w.Write(data)
 
 	return
 }
 
 func (h *RegisterHandler) ReadDiscreteInputs(w ResponseWriter, r *Frame) {
-	// ensure request payload is correct length
-	if len(r.data) != 4 {
-		w.Header().Fcode += 0x80
-		w.Write([]byte{IllegalDataValue})
+	// parse and validate request
+	var req ReadDiscreteInputsRequest
+	if err := req.UnmarshalBinary(r.data); err != nil {
+		WriteError(w, err)
 		return
 	}
 
-	// get offset and number of registers
-	offset := binary.BigEndian.Uint16(r.data[0:2])
-	num := binary.BigEndian.Uint16(r.data[2:4])
-
-	if num < 1 || num > 0x07D0 {
-		w.Header().Fcode += 0x80
-		w.Write([]byte{IllegalDataValue})
-		return
+	values, err := h.DataStore().ReadDiscreteInputs(r.Context(), req.Addr, req.Quantity)
+	if err == nil {
+		values, err = h.onReadBits(DiscreteInputTable, req.Addr, req.Quantity, values)
 	}
-
-	// check register request range
-	if int(offset+num) > len(h.DiscreteInputs) {
-		w.Header().Fcode += 0x80
-		w.Write([]byte{IllegalDataAddress})
+	if err != nil {
+		WriteError(w, err)
 		return
 	}
 
-	// take appropriate slice and convert to bytes
-	buf := &bytes.Buffer{}
-	err := binary.Write(buf, binary.BigEndian, BoolsToBytes(h.DiscreteInputs[offset:offset+num]))
+	resp := ReadDiscreteInputsResponse{Values: h.responseBits(values, req.Quantity)}
+	data, err := resp.MarshalBinary()
 	if err != nil {
-		w.Header().Fcode += 0x80
-		w.Write([]byte{SlaveFailure})
+		WriteError(w, err)
 		return
 	}
 
-	data := buf.Bytes()
-
-	w.Write(append([]byte{byte(len(data))}, data...))
+	w.Write(data)
 
 	return
 }
 
 func (h *RegisterHandler) ReadInputRegisters(w ResponseWriter, r *Frame) {
-	// ensure request payload is correct length
-	if len(r.data) != 4 {
-		w.Header().Fcode += 0x80
-		w.Write([]byte{IllegalDataValue})
+	// parse and validate request
+	var req ReadInputRegistersRequest
+	if err := req.UnmarshalBinary(r.data); err != nil {
+		WriteError(w, err)
 		return
 	}
 
-	// get offset and number of registers
-	offset := binary.BigEndian.Uint16(r.data[0:2])
-	num := binary.BigEndian.Uint16(r.data[2:4])
-
-	if num < 1 || num > 0x007D {
-		w.Header().Fcode += 0x80
-		w.Write([]byte{IllegalDataValue})
+	store := h.DataStore()
+	if enc, ok := store.(RegisterEncoder); ok && h.OnRead == nil {
+		data := make([]byte, 1, 1+2*int(req.Quantity))
+		data, err := enc.AppendInputRegisters(r.Context(), data, req.Addr, req.Quantity)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		data[0] = byte(len(data) - 1)
+		w.Write(data)
 		return
 	}
 
-	// check register request range
-	if int(offset+num) > len(h.Inputs) {
-		w.Header().Fcode += 0x80
-		w.Write([]byte{IllegalDataAddress})
+	values, err := store.ReadInputRegisters(r.Context(), req.Addr, req.Quantity)
+	if err == nil {
+		values, err = h.onReadRegisters(InputRegisterTable, req.Addr, req.Quantity, values)
+	}
+	if err != nil {
+		WriteError(w, err)
 		return
 	}
 
-	// take appropriate slice and convert to bytes
-	buf := &bytes.Buffer{}
-	err := binary.Write(buf, binary.BigEndian, h.Inputs[offset:offset+num])
+	// convert to bytes
+	resp := ReadInputRegistersResponse{Values: values}
+	data, err := resp.MarshalBinary()
 	if err != nil {
-		w.Header().Fcode += 0x80
-		w.Write([]byte{SlaveFailure})
+		WriteError(w, err)
 		return
 	}
 
-	data := buf.Bytes()
-
-	w.Write(append([]byte{byte(len(data))}, data...))
+	w.Write(data)
 
 	return
 }
 
 func (h *RegisterHandler) ReadHoldingRegisters(w ResponseWriter, r *Frame) {
-	// ensure request payload is correct length
-	if len(r.data) != 4 {
-		w.Header().Fcode += 0x80
-		w.Write([]byte{IllegalDataValue})
+	// parse and validate request
+	var req ReadHoldingRegistersRequest
+	if err := req.UnmarshalBinary(r.data); err != nil {
+		WriteError(w, err)
 		return
 	}
 
-	// get offset and number of registers
-	offset := binary.BigEndian.Uint16(r.data[0:2])
-	num := binary.BigEndian.Uint16(r.data[2:4])
-
-	if num < 1 || num > 0x007D {
-		w.Header().Fcode += 0x80
-		w.Write([]byte{IllegalDataValue})
+	store := h.DataStore()
+	if enc, ok := store.(RegisterEncoder); ok && h.OnRead == nil {
+		data := make([]byte, 1, 1+2*int(req.Quantity))
+		data, err := enc.AppendHoldingRegisters(r.Context(), data, req.Addr, req.Quantity)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		data[0] = byte(len(data) - 1)
+		w.Write(data)
 		return
 	}
 
-	// check register request range
-	if int(offset+num) > len(h.Holdings) {
-		w.Header().Fcode += 0x80
-		w.Write([]byte{IllegalDataAddress})
+	values, err := store.ReadHoldingRegisters(r.Context(), req.Addr, req.Quantity)
+	if err == nil {
+		values, err = h.onReadRegisters(HoldingRegisterTable, req.Addr, req.Quantity, values)
+	}
+	if err != nil {
+		WriteError(w, err)
 		return
 	}
 
-	// take appropriate slice and convert to bytes
-	buf := &bytes.Buffer{}
-	err := binary.Write(buf, binary.BigEndian, h.Holdings[offset:offset+num])
+	// convert to bytes
+	resp := ReadHoldingRegistersResponse{Values: values}
+	data, err := resp.MarshalBinary()
 	if err != nil {
-		w.Header().Fcode += 0x80
-		w.Write([]byte{SlaveFailure})
+		WriteError(w, err)
 		return
 	}
 
-	data := buf.Bytes()
-
-	w.Write(append([]byte{byte(len(data))}, data...))
+	w.Write(data)
 
 	return
 }
 
 func (h *RegisterHandler) WriteSingleCoil(w ResponseWriter, r *Frame) {
-	// ensure request payload is correct length
-	if len(r.data) != 4 {
-		w.Header().Fcode += 0x80
-		w.Write([]byte{IllegalDataValue})
+	// parse and validate request
+	var req WriteSingleCoilRequest
+	if err := req.UnmarshalBinary(r.data); err != nil {
+		WriteError(w, err)
 		return
 	}
 
-	// get register address
-	address := binary.BigEndian.Uint16(r.data[0:2])
-
-	// check register request range
-	if int(address) >= len(h.Coils) {
-		w.Header().Fcode += 0x80
-		w.Write([]byte{IllegalDataAddress})
+	// check write protection
+	if h.writeProtected(CoilTable, req.Addr, 1) {
+		w.WriteException(h.protectedException())
 		return
 	}
 
-	// parse value
-	value := binary.BigEndian.Uint16(r.data[2:4])
-
-	if value == 0xFF00 {
-		h.Coils[address] = true
-	} else if value == 0x0 {
-		h.Coils[address] = false
-	} else {
-		w.Header().Fcode += 0x80
-		w.Write([]byte{IllegalDataValue})
+	if err := h.writeCoils(r.Context(), req.Addr, []bool{req.Value}); err != nil {
+		WriteError(w, err)
 		return
 	}
 
@@ -276,25 +480,23 @@ func (h *RegisterHandler) WriteSingleCoil(w ResponseWriter, r *Frame) {
 }
 
 func (h *RegisterHandler) WriteSingleRegister(w ResponseWriter, r *Frame) {
-	// ensure request payload is correct length
-	if len(r.data) != 4 {
-		w.Header().Fcode += 0x80
-		w.Write([]byte{IllegalDataValue})
+	// parse and validate request
+	var req WriteSingleRegisterRequest
+	if err := req.UnmarshalBinary(r.data); err != nil {
+		WriteError(w, err)
 		return
 	}
 
-	// get register address
-	address := binary.BigEndian.Uint16(r.data[0:2])
-
-	// check register request range
-	if int(address) >= len(h.Holdings) {
-		w.Header().Fcode += 0x80
-		w.Write([]byte{IllegalDataAddress})
+	// check write protection
+	if h.writeProtected(HoldingRegisterTable, req.Addr, 1) {
+		w.WriteException(h.protectedException())
 		return
 	}
 
-	// parse and write value
-	h.Holdings[address] = binary.BigEndian.Uint16(r.data[2:4])
+	if err := h.writeHoldings(r.Context(), req.Addr, []uint16{req.Value}); err != nil {
+		WriteError(w, err)
+		return
+	}
 
 	w.Write(r.data)
 
@@ -302,41 +504,45 @@ func (h *RegisterHandler) WriteSingleRegister(w ResponseWriter, r *Frame) {
 }
 
 func (h *RegisterHandler) WriteMultipleCoils(w ResponseWriter, r *Frame) {
-	// ensure request payload is at least correct length
-	if len(r.data) < 6 {
-		w.Header().Fcode += 0x80
-		w.Write([]byte{IllegalDataValue})
+	// parse and validate request
+	var req WriteMultipleCoilsRequest
+	if err := req.UnmarshalBinary(r.data); err != nil {
+		WriteError(w, err)
 		return
 	}
 
-	// get offset and number of registers
-	offset := binary.BigEndian.Uint16(r.data[0:2])
-	num := binary.BigEndian.Uint16(r.data[2:4])
+	// check write protection
+	if h.writeProtected(CoilTable, req.Addr, uint16(len(req.Values))) {
+		w.WriteException(h.protectedException())
+		return
+	}
 
-	if num < 1 || num > 0x07B0 {
-		w.Header().Fcode += 0x80
-		w.Write([]byte{IllegalDataValue})
+	if err := h.writeCoils(r.Context(), req.Addr, req.Values); err != nil {
+		WriteError(w, err)
 		return
 	}
 
-	// check register request range
-	if int(offset+num) > len(h.Coils) {
-		w.Header().Fcode += 0x80
-		w.Write([]byte{IllegalDataAddress})
+	w.Write(r.data[0:4])
+
+	return
+}
+
+func (h *RegisterHandler) WriteMultipleRegisters(w ResponseWriter, r *Frame) {
+	// parse and validate request
+	var req WriteMultipleRegistersRequest
+	if err := req.UnmarshalBinary(r.data); err != nil {
+		WriteError(w, err)
 		return
 	}
 
-	// parse values
-	nb := int(r.data[4])
-	if len(r.data) != 5+nb {
-		w.Header().Fcode += 0x80
-		w.Write([]byte{SlaveFailure})
+	// check write protection
+	if h.writeProtected(HoldingRegisterTable, req.Addr, uint16(len(req.Values))) {
+		w.WriteException(h.protectedException())
 		return
 	}
 
-	if copy(h.Coils[offset:offset+num], BytesToBools(r.data[5:5+nb])) != int(num) {
-		w.Header().Fcode += 0x80
-		w.Write([]byte{SlaveFailure})
+	if err := h.writeHoldings(r.Context(), req.Addr, req.Values); err != nil {
+		WriteError(w, err)
 		return
 	}
 
@@ -345,105 +551,90 @@ func (h *RegisterHandler) WriteMultipleCoils(w ResponseWriter, r *Frame) {
 	return
 }
 
-func (h *RegisterHandler) WriteMultipleRegisters(w ResponseWriter, r *Frame) {
-	// ensure request payload is at least correct length
-	if len(r.data) < 7 {
-		w.Header().Fcode += 0x80
-		w.Write([]byte{IllegalDataValue})
+// MaskWriteRegister reads, masks and writes back the register. Other
+// Mask Write Register requests served by h are held off meanwhile, but
+// plain writes reaching the Store by other paths are not.
+func (h *RegisterHandler) MaskWriteRegister(w ResponseWriter, r *Frame) {
+	// parse and validate request
+	var req MaskWriteRegisterRequest
+	if err := req.UnmarshalBinary(r.data); err != nil {
+		WriteError(w, err)
 		return
 	}
 
-	// get offset and number of registers
-	offset := binary.BigEndian.Uint16(r.data[0:2])
-	num := binary.BigEndian.Uint16(r.data[2:4])
-
-	if num < 1 || num > 0x007B {
-		w.Header().Fcode += 0x80
-		w.Write([]byte{IllegalDataValue})
+	// check write protection
+	if h.writeProtected(HoldingRegisterTable, req.Addr, 1) {
+		w.WriteException(h.protectedException())
 		return
 	}
 
-	// check register request range
-	if int(offset+num) > len(h.Holdings) {
-		w.Header().Fcode += 0x80
-		w.Write([]byte{IllegalDataAddress})
-		return
-	}
+	h.rmw.Lock()
+	defer h.rmw.Unlock()
 
-	// parse values
-	nb := int(r.data[4])
-	if len(r.data) != 5+nb {
-		w.Header().Fcode += 0x80
-		w.Write([]byte{IllegalDataValue})
-		return
+	store, ctx := h.DataStore(), r.Context()
+	values, err := store.ReadHoldingRegisters(ctx, req.Addr, 1)
+	if err == nil {
+		new := []uint16{req.Apply(values[0])}
+		if h.OnWrite != nil {
+			err = h.OnWrite(HoldingRegisterTable, req.Addr, values[:1], new)
+		}
+		if err == nil {
+			err = store.WriteHoldingRegisters(ctx, req.Addr, new)
+		}
 	}
-
-	buf := bytes.NewReader(r.data[5 : 5+nb])
-	err := binary.Read(buf, binary.BigEndian, h.Holdings[offset:offset+num])
 	if err != nil {
-		w.Header().Fcode += 0x80
-		w.Write([]byte{SlaveFailure})
+		WriteError(w, err)
 		return
 	}
 
-	w.Write(r.data[0:4])
+	w.Write(r.data)
 
 	return
 }
 
 func (h *RegisterHandler) WriteAndReadRegisters(w ResponseWriter, r *Frame) {
-	// ensure request payload is at least correct length
-	if len(r.data) < 11 {
-		w.Header().Fcode += 0x80
-		w.Write([]byte{IllegalDataValue})
+	// parse and validate request
+	var req WriteAndReadRegistersRequest
+	if err := req.UnmarshalBinary(r.data); err != nil {
+		WriteError(w, err)
 		return
 	}
 
-	// get offsets and number of registers
-	roffset := binary.BigEndian.Uint16(r.data[0:2])
-	rnum := binary.BigEndian.Uint16(r.data[2:4])
-	woffset := binary.BigEndian.Uint16(r.data[4:6])
-	wnum := binary.BigEndian.Uint16(r.data[6:8])
-	nb := int(r.data[8])
-
-	if rnum < 1 || rnum > 0x007D || wnum < 1 || wnum > 0x0079 || nb != int(wnum*2) {
-		w.Header().Fcode += 0x80
-		w.Write([]byte{IllegalDataValue})
+	// check write protection
+	if h.writeProtected(HoldingRegisterTable, req.WriteAddr, uint16(len(req.Values))) {
+		w.WriteException(h.protectedException())
 		return
 	}
 
-	// check register request ranges
-	if int(roffset+rnum) > len(h.Holdings) || int(woffset+wnum) > len(h.Holdings) {
-		w.Header().Fcode += 0x80
-		w.Write([]byte{IllegalDataAddress})
+	// check the read range before writing, so a failing request has no
+	// effect
+	store, ctx := h.DataStore(), r.Context()
+	if _, err := store.ReadHoldingRegisters(ctx, req.ReadAddr, req.ReadQuantity); err != nil {
+		WriteError(w, err)
 		return
 	}
-
-	if len(r.data) != 9+nb {
-		w.Header().Fcode += 0x80
-		w.Write([]byte{IllegalDataValue})
+	if err := h.writeHoldings(ctx, req.WriteAddr, req.Values); err != nil {
+		WriteError(w, err)
 		return
 	}
-
-	err := binary.Read(bytes.NewReader(r.data[9:9+nb]), binary.BigEndian, h.Holdings[woffset:woffset+wnum])
+	values, err := store.ReadHoldingRegisters(ctx, req.ReadAddr, req.ReadQuantity)
+	if err == nil {
+		values, err = h.onReadRegisters(HoldingRegisterTable, req.ReadAddr, req.ReadQuantity, values)
+	}
 	if err != nil {
-		w.Header().Fcode += 0x80
-		w.Write([]byte{SlaveFailure})
+		WriteError(w, err)
 		return
 	}
 
-	// take appropriate read slice and convert to bytes
-	buf := &bytes.Buffer{}
-	err = binary.Write(buf, binary.BigEndian, h.Holdings[roffset:roffset+rnum])
+	// convert to bytes
+	resp := WriteAndReadRegistersResponse{Values: values}
+	data, err := resp.MarshalBinary()
 	if err != nil {
-		w.Header().Fcode += 0x80
-		w.Write([]byte{SlaveFailure})
+		WriteError(w, err)
 		return
 	}
 
-	data := buf.Bytes()
-
-	w.Write(append([]byte{byte(len(data))}, data...))
+	w.Write(data)
 
 	return
 }
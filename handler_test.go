@@ -3,7 +3,10 @@ package modbus
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
+	"reflect"
 	"testing"
 )
 
@@ -34,6 +37,10 @@ func (w *testResponseWriter) WriteHeader() {
 	binary.Write(w.w, binary.BigEndian, w.header)
 }
 
+func (w *testResponseWriter) WriteException(code ExceptionCode) error {
+	return WriteException(w, code)
+}
+
 func TestBoolsToBytes(t *testing.T) {
 	bools := []bool{true, false, true, false, false, true, true, true,
 		false, true, true}
@@ -78,7 +85,7 @@ func TestBytesToBoolsAndBack(t *testing.T) {
 
 func TestIllegalFunction(t *testing.T) {
 	req := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x03, 0xFF, 0x73, 0x00}
-	expected := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x03, 0xFF, 0xF3, IllegalFunction}
+	expected := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x03, 0xFF, 0xF3, byte(IllegalFunction)}
 
 	h := &RegisterHandler{}
 	br := bufio.NewReader(bytes.NewReader(req))
@@ -116,7 +123,7 @@ func TestReadCoils(t *testing.T) {
 
 func TestReadCoilsIllegalAddress(t *testing.T) {
 	req := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x01, 0x00, 0xA3, 0x00, 0x25}
-	expected := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x03, 0xFF, 0x81, IllegalDataAddress}
+	expected := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x03, 0xFF, 0x81, byte(IllegalDataAddress)}
 
 	h := &RegisterHandler{}
 	h.Coils = append(make([]bool, 0x13), BytesToBools([]byte{0xCD, 0x6B, 0xB2, 0x0E, 0x1B})...)
@@ -154,7 +161,7 @@ func TestReadDiscreteInputs(t *testing.T) {
 
 func TestReadDiscreteInputsIllegalAddress(t *testing.T) {
 	req := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x02, 0x00, 0xC4, 0x00, 0x17}
-	expected := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x03, 0xFF, 0x82, IllegalDataAddress}
+	expected := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x03, 0xFF, 0x82, byte(IllegalDataAddress)}
 
 	h := &RegisterHandler{}
 	h.DiscreteInputs = append(make([]bool, 0xC4), BytesToBools([]byte{0xAC, 0xDB, 0x35})[:0x16]...)
@@ -192,7 +199,7 @@ func TestReadInputs(t *testing.T) {
 
 func TestReadInputsIllegalAddress(t *testing.T) {
 	req := []byte{0x00, 0x08, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x04, 0x00, 0x18, 0x00, 0x01}
-	expected := []byte{0x00, 0x08, 0x00, 0x00, 0x00, 0x03, 0xFF, 0x84, IllegalDataAddress}
+	expected := []byte{0x00, 0x08, 0x00, 0x00, 0x00, 0x03, 0xFF, 0x84, byte(IllegalDataAddress)}
 
 	h := &RegisterHandler{}
 	h.Inputs = []uint16{0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x000A, 0x0}
@@ -230,7 +237,7 @@ func TestHoldings(t *testing.T) {
 
 func TestHoldingsIllegalAddress(t *testing.T) {
 	req := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x03, 0x00, 0x6B, 0x00, 0x03}
-	expected := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x03, 0xFF, 0x83, IllegalDataAddress}
+	expected := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x03, 0xFF, 0x83, byte(IllegalDataAddress)}
 
 	h := &RegisterHandler{}
 	h.Holdings = make([]uint16, 0x1B)
@@ -271,7 +278,7 @@ func TestWriteSingleCoil(t *testing.T) {
 
 func TestWriteSingleCoilIllegalAddress(t *testing.T) {
 	req := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x05, 0x00, 0x0A, 0xFF, 0x00}
-	expected := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x03, 0xFF, 0x85, IllegalDataAddress}
+	expected := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x03, 0xFF, 0x85, byte(IllegalDataAddress)}
 
 	h := &RegisterHandler{}
 	h.Coils = make([]bool, 0x0A)
@@ -290,7 +297,7 @@ func TestWriteSingleCoilIllegalAddress(t *testing.T) {
 
 func TestWriteSingleCoilIllegalValue(t *testing.T) {
 	req := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x05, 0x00, 0x0A, 0xFF, 0x01}
-	expected := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x03, 0xFF, 0x85, IllegalDataValue}
+	expected := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x03, 0xFF, 0x85, byte(IllegalDataValue)}
 
 	h := &RegisterHandler{}
 	h.Coils = make([]bool, 0x0A+1)
@@ -331,7 +338,7 @@ func TestWriteSingleHolding(t *testing.T) {
 
 func TestWriteSingleHoldingIllegalAddress(t *testing.T) {
 	req := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x06, 0x00, 0x6B, 0x12, 0x34}
-	expected := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x03, 0xFF, 0x86, IllegalDataAddress}
+	expected := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x03, 0xFF, 0x86, byte(IllegalDataAddress)}
 
 	h := &RegisterHandler{}
 	h.Holdings = make([]uint16, 0x6B)
@@ -428,3 +435,339 @@ func TestWriteAndReadRegisters(t *testing.T) {
 		t.Errorf("Incorrect Response")
 	}
 }
+
+func TestWriteSingleHoldingProtected(t *testing.T) {
+	req := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x06, 0x00, 0x6B, 0x12, 0x34}
+	expected := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x03, 0xFF, 0x86, byte(IllegalDataAddress)}
+
+	h := &RegisterHandler{}
+	h.Holdings = make([]uint16, 0x6B+1)
+	h.Protect(HoldingRegisterTable, 0x60, 0x6B)
+	br := bufio.NewReader(bytes.NewReader(req))
+	bw := bytes.Buffer{}
+	r, _ := ReadFrame(br)
+	w := &testResponseWriter{req: r, w: bufio.NewWriter(&bw)}
+
+	h.ServeModbus(w, r)
+	w.w.Flush()
+
+	if !bytes.Equal(bw.Bytes(), expected) {
+		t.Errorf("Incorrect Response")
+	}
+
+	if h.Holdings[0x006B] != 0 {
+		t.Errorf("protected holding should not have been written")
+	}
+}
+
+func TestWriteMultipleCoilsReadOnly(t *testing.T) {
+	req := []byte{0x00, 0x0B, 0x00, 0x00, 0x00, 0x0C, 0xFF, 0x0F, 0x00, 0x13,
+		0x00, 0x25, 0x05, 0xCD, 0x6B, 0xB2, 0x0E, 0x1B}
+	expected := []byte{0x00, 0x0B, 0x00, 0x00, 0x00, 0x03, 0xFF, 0x8F, byte(IllegalFunction)}
+
+	h := &RegisterHandler{ReadOnly: true, ProtectedException: IllegalFunction}
+	h.Coils = make([]bool, 0x13+0x25)
+	br := bufio.NewReader(bytes.NewReader(req))
+	bw := bytes.Buffer{}
+	r, _ := ReadFrame(br)
+	w := &testResponseWriter{req: r, w: bufio.NewWriter(&bw)}
+
+	h.ServeModbus(w, r)
+	w.w.Flush()
+
+	if !bytes.Equal(bw.Bytes(), expected) {
+		t.Errorf("Incorrect Response")
+	}
+
+	for _, coil := range h.Coils {
+		if coil {
+			t.Errorf("read only coils should not have been written")
+		}
+	}
+}
+
+func TestWriteMultipleRegistersOutsideProtection(t *testing.T) {
+	req := []byte{0x00, 0x0F, 0x00, 0x00, 0x00, 0x0D, 0xFF, 0x10, 0x00, 0x6B,
+		0x00, 0x03, 0x06, 0x02, 0x2B, 0x00, 0x01, 0x00, 0x64}
+	expected := []byte{0x00, 0x0F, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x10, 0x00,
+		0x6B, 0x00, 0x03}
+
+	h := &RegisterHandler{}
+	h.Holdings = make([]uint16, 0x6B+0x03)
+	h.Protect(HoldingRegisterTable, 0x00, 0x6A)
+	h.Protect(CoilTable, 0x6B, 0x6D)
+	br := bufio.NewReader(bytes.NewReader(req))
+	bw := bytes.Buffer{}
+	r, _ := ReadFrame(br)
+	w := &testResponseWriter{req: r, w: bufio.NewWriter(&bw)}
+
+	h.ServeModbus(w, r)
+	w.w.Flush()
+
+	if !bytes.Equal(bw.Bytes(), expected) {
+		t.Errorf("Incorrect Response")
+	}
+}
+
+func TestMaskWriteRegister(t *testing.T) {
+	req := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x08, 0xFF, 0x16, 0x00, 0x04, 0x00, 0xF2, 0x00, 0x25}
+
+	h := &RegisterHandler{}
+	h.Holdings = make([]uint16, 0x05)
+	h.Holdings[0x04] = 0x0012
+	br := bufio.NewReader(bytes.NewReader(req))
+	bw := bytes.Buffer{}
+	r, _ := ReadFrame(br)
+	w := &testResponseWriter{req: r, w: bufio.NewWriter(&bw)}
+
+	h.ServeModbus(w, r)
+	w.w.Flush()
+
+	if !bytes.Equal(bw.Bytes(), req) {
+		t.Errorf("Incorrect Response")
+	}
+
+	if h.Holdings[0x04] != 0x0017 {
+		t.Errorf("0x%04X not 0x%04X", h.Holdings[0x04], 0x0017)
+	}
+}
+
+// failingStore fails every read of coils and write of holding registers,
+// as a broken back end would.
+type failingStore struct {
+	sliceStore
+}
+
+func (s failingStore) ReadCoils(ctx context.Context, addr, quantity uint16) ([]bool, error) {
+	return nil, errors.New("back end unreachable")
+}
+
+func (s failingStore) WriteHoldingRegisters(ctx context.Context, addr uint16, values []uint16) error {
+	return errors.New("back end unreachable")
+}
+
+func TestHandlerErrorMapping(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		pdu     []byte // function code and data
+		failing bool   // served from a failingStore
+		code    ExceptionCode
+	}{
+		{"read quantity", []byte{0x03, 0x00, 0x00, 0x00, 0x00}, false, IllegalDataValue},
+		{"read length", []byte{0x03, 0x00, 0x00, 0x00}, false, IllegalDataValue},
+		{"read address", []byte{0x03, 0x00, 0x0F, 0x00, 0x02}, false, IllegalDataAddress},
+		{"coils byte count", []byte{0x0F, 0x00, 0x00, 0x00, 0x03, 0x02, 0x05, 0x00}, false, IllegalDataValue},
+		{"coils short", []byte{0x0F, 0x00, 0x00, 0x00, 0x09, 0x02, 0x05}, false, IllegalDataValue},
+		{"registers byte count", []byte{0x10, 0x00, 0x00, 0x00, 0x01, 0x04, 0x00, 0x01}, false, IllegalDataValue},
+		{"write address", []byte{0x0F, 0x00, 0x0F, 0x00, 0x02, 0x01, 0x03}, false, IllegalDataAddress},
+		{"coil value", []byte{0x05, 0x00, 0x00, 0x12, 0x34}, false, IllegalDataValue},
+		{"store read", []byte{0x01, 0x00, 0x00, 0x00, 0x01}, true, SlaveFailure},
+		{"store write", []byte{0x06, 0x00, 0x00, 0x00, 0x01}, true, SlaveFailure},
+		{"mask write store", []byte{0x16, 0x00, 0x00, 0xFF, 0xFF, 0x00, 0x00}, true, SlaveFailure},
+	} {
+		data := &RegisterHandler{Coils: make([]bool, 16), Holdings: make([]uint16, 16)}
+		h := data
+		if tt.failing {
+			h = &RegisterHandler{Store: failingStore{sliceStore{data}}}
+		}
+		req := append([]byte{0x00, 0x01, 0x00, 0x00, 0x00, byte(len(tt.pdu) + 1), 0x01}, tt.pdu...)
+		br := bufio.NewReader(bytes.NewReader(req))
+		bw := bytes.Buffer{}
+		r, _ := ReadFrame(br)
+		w := &testResponseWriter{req: r, w: bufio.NewWriter(&bw)}
+
+		h.ServeModbus(w, r)
+		w.w.Flush()
+
+		expected := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x03, 0x01, tt.pdu[0] | 0x80, byte(tt.code)}
+		if !bytes.Equal(bw.Bytes(), expected) {
+			t.Errorf("%s: response % X; want % X", tt.name, bw.Bytes(), expected)
+		}
+	}
+}
+
+// shortStore returns at most three discrete inputs per read, as a store
+// backed by a smaller representation might.
+type shortStore struct {
+	sliceStore
+}
+
+func (s shortStore) ReadDiscreteInputs(ctx context.Context, addr, quantity uint16) ([]bool, error) {
+	return []bool{true, false, true}, nil
+}
+
+func TestReadBitsPadding(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		h        *RegisterHandler
+		pdu      []byte // function code and data
+		expected []byte // response data
+	}{
+		// the coils past the quantity are set, but are not sent
+		{"coils", &RegisterHandler{Coils: []bool{true, true, true, true, true, true, true, true, true, true, true, true}},
+			[]byte{0x01, 0x00, 0x00, 0x00, 0x0A}, []byte{0x02, 0xFF, 0x03}},
+		{"short store", &RegisterHandler{Store: shortStore{}},
+			[]byte{0x02, 0x00, 0x00, 0x00, 0x0C}, []byte{0x01, 0x05}},
+		{"full byte count", &RegisterHandler{Store: shortStore{}, FullByteCount: true},
+			[]byte{0x02, 0x00, 0x00, 0x00, 0x0C}, []byte{0x02, 0x05, 0x00}},
+	} {
+		req := append([]byte{0x00, 0x01, 0x00, 0x00, 0x00, byte(len(tt.pdu) + 1), 0x01}, tt.pdu...)
+		br := bufio.NewReader(bytes.NewReader(req))
+		bw := bytes.Buffer{}
+		r, _ := ReadFrame(br)
+		w := &testResponseWriter{req: r, w: bufio.NewWriter(&bw)}
+
+		tt.h.ServeModbus(w, r)
+		w.w.Flush()
+
+		expected := append([]byte{0x00, 0x01, 0x00, 0x00, 0x00, byte(len(tt.expected) + 2), 0x01, tt.pdu[0]}, tt.expected...)
+		if !bytes.Equal(bw.Bytes(), expected) {
+			t.Errorf("%s: response % X; want % X", tt.name, bw.Bytes(), expected)
+		}
+	}
+}
+
+// aliasStore returns the first three of its discrete inputs per read,
+// as a slice of its own.
+type aliasStore struct {
+	sliceStore
+	inputs []bool
+}
+
+func (s aliasStore) ReadDiscreteInputs(ctx context.Context, addr, quantity uint16) ([]bool, error) {
+	return s.inputs[:3], nil
+}
+
+func TestReadBitsPaddingKeepsStore(t *testing.T) {
+	s := aliasStore{inputs: []bool{true, false, true, true, true, true}}
+	h := &RegisterHandler{Store: s, FullByteCount: true}
+	req := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x01, 0x02, 0x00, 0x00, 0x00, 0x06}
+	r, _ := ReadFrame(bufio.NewReader(bytes.NewReader(req)))
+	bw := bytes.Buffer{}
+	w := &testResponseWriter{req: r, w: bufio.NewWriter(&bw)}
+
+	h.ServeModbus(w, r)
+	w.w.Flush()
+
+	expected := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x04, 0x01, 0x02, 0x01, 0x05}
+	if !bytes.Equal(bw.Bytes(), expected) {
+		t.Errorf("response % X; want % X", bw.Bytes(), expected)
+	}
+	if want := []bool{true, false, true, true, true, true}; !reflect.DeepEqual(s.inputs, want) {
+		t.Errorf("store inputs %v after read; want %v", s.inputs, want)
+	}
+}
+
+func TestOnWrite(t *testing.T) {
+	h := &RegisterHandler{Coils: make([]bool, 8), Holdings: []uint16{10, 20, 30}}
+	var gotOld, gotNew interface{}
+	h.OnWrite = func(tab Table, addr uint16, old, new interface{}) error {
+		gotOld, gotNew = old, new
+		if tab == HoldingRegisterTable {
+			for _, v := range new.([]uint16) {
+				if v > 100 {
+					return ErrIllegalDataValue
+				}
+			}
+		}
+		if tab == CoilTable && addr == 7 {
+			return ErrIllegalDataAddress
+		}
+		return nil
+	}
+
+	for _, tt := range []struct {
+		req, resp []byte
+		old, new  interface{}
+	}{
+		// Write Multiple Registers within range
+		{[]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x0B, 0x01, 0x10, 0x00, 0x01, 0x00, 0x02, 0x04, 0x00, 0x15, 0x00, 0x64},
+			[]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x01, 0x10, 0x00, 0x01, 0x00, 0x02},
+			[]uint16{20, 30}, []uint16{21, 100}},
+		// Write Single Register out of range
+		{[]byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x06, 0x01, 0x06, 0x00, 0x00, 0x00, 0x65},
+			[]byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x03, 0x01, 0x86, byte(IllegalDataValue)},
+			[]uint16{10}, []uint16{101}},
+		// Mask Write Register producing a value out of range
+		{[]byte{0x00, 0x03, 0x00, 0x00, 0x00, 0x08, 0x01, 0x16, 0x00, 0x00, 0x00, 0x00, 0x00, 0xFF},
+			[]byte{0x00, 0x03, 0x00, 0x00, 0x00, 0x03, 0x01, 0x96, byte(IllegalDataValue)},
+			[]uint16{10}, []uint16{0xFF}},
+		// Write Single Coil to a coil the hook refuses
+		{[]byte{0x00, 0x04, 0x00, 0x00, 0x00, 0x06, 0x01, 0x05, 0x00, 0x07, 0xFF, 0x00},
+			[]byte{0x00, 0x04, 0x00, 0x00, 0x00, 0x03, 0x01, 0x85, byte(IllegalDataAddress)},
+			[]bool{false}, []bool{true}},
+	} {
+		br := bufio.NewReader(bytes.NewReader(tt.req))
+		bw := bytes.Buffer{}
+		r, _ := ReadFrame(br)
+		w := &testResponseWriter{req: r, w: bufio.NewWriter(&bw)}
+
+		h.ServeModbus(w, r)
+		w.w.Flush()
+
+		if !bytes.Equal(bw.Bytes(), tt.resp) {
+			t.Errorf("response % X; want % X", bw.Bytes(), tt.resp)
+		}
+		if !reflect.DeepEqual(gotOld, tt.old) || !reflect.DeepEqual(gotNew, tt.new) {
+			t.Errorf("OnWrite(%v, %v); want (%v, %v)", gotOld, gotNew, tt.old, tt.new)
+		}
+	}
+	if want := []uint16{10, 21, 100}; !reflect.DeepEqual(h.Holdings, want) {
+		t.Errorf("holdings %v; want %v", h.Holdings, want)
+	}
+	if h.Coils[7] {
+		t.Errorf("refused coil was written")
+	}
+}
+
+func TestOnRead(t *testing.T) {
+	// input register 1 is a counter computed at request time, coil 0
+	// is always on, and input register 5 cannot be read
+	var reads uint16
+	h := &RegisterHandler{Coils: make([]bool, 4), Inputs: make([]uint16, 8)}
+	h.OnRead = func(tab Table, addr uint16, values interface{}) error {
+		switch v := values.(type) {
+		case []uint16:
+			if tab == InputRegisterTable && addr <= 5 && int(addr)+len(v) > 5 {
+				return ErrSlaveFailure
+			}
+			if tab == InputRegisterTable && addr <= 1 && int(addr)+len(v) > 1 {
+				reads++
+				v[1-addr] = reads
+			}
+		case []bool:
+			if tab == CoilTable && addr == 0 {
+				v[0] = true
+			}
+		}
+		return nil
+	}
+
+	for _, tt := range []struct {
+		req, resp []byte
+	}{
+		{[]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x01, 0x04, 0x00, 0x00, 0x00, 0x02},
+			[]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x07, 0x01, 0x04, 0x04, 0x00, 0x00, 0x00, 0x01}},
+		{[]byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x06, 0x01, 0x04, 0x00, 0x01, 0x00, 0x01},
+			[]byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x05, 0x01, 0x04, 0x02, 0x00, 0x02}},
+		{[]byte{0x00, 0x03, 0x00, 0x00, 0x00, 0x06, 0x01, 0x04, 0x00, 0x04, 0x00, 0x02},
+			[]byte{0x00, 0x03, 0x00, 0x00, 0x00, 0x03, 0x01, 0x84, byte(SlaveFailure)}},
+		{[]byte{0x00, 0x04, 0x00, 0x00, 0x00, 0x06, 0x01, 0x01, 0x00, 0x00, 0x00, 0x04},
+			[]byte{0x00, 0x04, 0x00, 0x00, 0x00, 0x04, 0x01, 0x01, 0x01, 0x01}},
+	} {
+		br := bufio.NewReader(bytes.NewReader(tt.req))
+		bw := bytes.Buffer{}
+		r, _ := ReadFrame(br)
+		w := &testResponseWriter{req: r, w: bufio.NewWriter(&bw)}
+
+		h.ServeModbus(w, r)
+		w.w.Flush()
+
+		if !bytes.Equal(bw.Bytes(), tt.resp) {
+			t.Errorf("response % X; want % X", bw.Bytes(), tt.resp)
+		}
+	}
+	if h.Inputs[1] != 0 || h.Coils[0] {
+		t.Errorf("OnRead changed the stored values")
+	}
+}
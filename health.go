@@ -0,0 +1,177 @@
+package modbus
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Health describes the state of a Server, for monitoring.
+type Health struct {
+	Start       time.Time     // when the Server started serving, zero before
+	Uptime      time.Duration // time since Start
+	Connections int           // number of connections served at the moment
+	Requests    uint64        // number of requests read
+	Exceptions  uint64        // number of exception responses written
+	Panics      uint64        // number of handler panics
+
+	// LastError is the last error of the Server, nil if none: a
+	// *ModbusError for an exception response, or the error of a
+	// handler panic or of accepting a connection. A panic answered
+	// by PanicHandler is reported as the exception answering it.
+	// LastErrorTime is when it occurred.
+	LastError     error
+	LastErrorTime time.Time
+}
+
+// Health returns the state of the Server. The counters cover every
+// connection served, including requests to HealthUnit.
+func (srv *Server) Health() Health {
+	s := &srv.health
+	s.mu.Lock()
+	h := Health{
+		Start:         s.start,
+		LastError:     s.lastErr,
+		LastErrorTime: s.lastErrTime,
+	}
+	s.mu.Unlock()
+	if !h.Start.IsZero() {
+		h.Uptime = time.Since(h.Start)
+	}
+	srv.mu.Lock()
+	h.Connections = srv.conns
+	srv.mu.Unlock()
+	h.Requests = s.requests.Load()
+	h.Exceptions = s.exceptions.Load()
+	h.Panics = s.panics.Load()
+	return h
+}
+
+// HealthRegisters is the number of registers of the diagnostic block
+// answered by a Server's HealthUnit. The block holds, from address 0,
+// with 32-bit values in two registers, high word first, and counters
+// wrapping to zero beyond their maximum:
+//
+//	0-1    uptime, in seconds
+//	2-3    number of requests read
+//	4-5    number of exception responses written
+//	6-7    number of handler panics
+//	8      number of connections served at the moment
+//	9      kind of the last error: 0 none, 1 exception response,
+//	       2 handler panic, 3 other
+//	10     function code and exception code of the last exception
+//	       response, in the high and low bytes
+//	11-12  seconds since the last error, 0xFFFFFFFF if none
+const HealthRegisters = 13
+
+// Kinds of the last error, as held by register 9 of the HealthUnit.
+const (
+	healthNoError = iota
+	healthException
+	healthPanic
+	healthOtherError
+)
+
+// serverHealth holds what Health reports.
+type serverHealth struct {
+	requests   atomic.Uint64
+	exceptions atomic.Uint64
+	panics     atomic.Uint64
+
+	mu            sync.Mutex // guards the following
+	start         time.Time
+	lastErr       error
+	lastErrKind   int
+	lastErrTime   time.Time
+	lastException uint16 // function and exception codes
+}
+
+// started notes that the Server started serving, unless it did before.
+func (s *serverHealth) started() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.start.IsZero() {
+		s.start = time.Now()
+	}
+}
+
+// record counts the request served by w.
+func (s *serverHealth) record(w *response) {
+	s.requests.Add(1)
+	if !w.wroteHeader || w.header.Fcode&0x80 == 0 {
+		return
+	}
+	s.exceptions.Add(1)
+	fcode := w.header.Fcode &^ 0x80
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastErr = &ModbusError{FunctionCode: FunctionCode(fcode), ExceptionCode: ExceptionCode(w.status)}
+	s.lastErrKind, s.lastErrTime = healthException, time.Now()
+	s.lastException = uint16(fcode)<<8 | uint16(w.status)
+}
+
+// notePanic records the panic v of a handler.
+func (s *serverHealth) notePanic(v interface{}) {
+	s.panics.Add(1)
+	s.setError(healthPanic, fmt.Errorf("modbus: handler panic: %v", v))
+}
+
+func (s *serverHealth) setError(kind int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastErr, s.lastErrKind, s.lastErrTime = err, kind, time.Now()
+}
+
+// healthRegisters returns the diagnostic block of the HealthUnit.
+func (srv *Server) healthRegisters() []uint16 {
+	h := srv.Health()
+	s := &srv.health
+	s.mu.Lock()
+	kind, exception := s.lastErrKind, s.lastException
+	s.mu.Unlock()
+
+	regs := make([]uint16, HealthRegisters)
+	put32 := func(i int, v uint64) {
+		regs[i], regs[i+1] = uint16(v>>16), uint16(v)
+	}
+	put32(0, uint64(h.Uptime/time.Second))
+	put32(2, h.Requests)
+	put32(4, h.Exceptions)
+	put32(6, h.Panics)
+	regs[8] = uint16(min(h.Connections, math.MaxUint16))
+	regs[9] = uint16(kind)
+	regs[10] = exception
+	since := uint64(math.MaxUint32)
+	if h.LastError != nil {
+		since = min(uint64(time.Since(h.LastErrorTime)/time.Second), math.MaxUint32-1)
+	}
+	put32(11, since)
+	return regs
+}
+
+// serveHealth answers a request to the HealthUnit: reads of input or
+// holding registers of the diagnostic block.
+func (srv *Server) serveHealth(w ResponseWriter, r *Frame) {
+	if FunctionCode(r.header.Fcode) != ReadInputRegisters && FunctionCode(r.header.Fcode) != ReadHoldingRegisters {
+		w.WriteException(IllegalFunction)
+		return
+	}
+	var req ReadInputRegistersRequest // same layout for holding registers
+	if err := req.UnmarshalBinary(r.data); err != nil {
+		WriteError(w, err)
+		return
+	}
+	if int(req.Addr)+int(req.Quantity) > HealthRegisters {
+		w.WriteException(IllegalDataAddress)
+		return
+	}
+	resp := ReadInputRegistersResponse{Values: srv.healthRegisters()[req.Addr:][:req.Quantity]}
+	data, err := resp.MarshalBinary()
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	w.Write(data)
+}
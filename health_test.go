@@ -0,0 +1,81 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestServerHealth(t *testing.T) {
+	mux := NewServeMux()
+	mux.Handle(1, &RegisterHandler{Holdings: []uint16{10}})
+	mux.Handle(2, HandlerFunc(func(w ResponseWriter, r *Frame) { panic("broken") }))
+	srv := &Server{
+		Handler:      mux,
+		HealthUnit:   248,
+		UnitIDs:      []uint8{1, 2},
+		PanicHandler: func(*Frame, interface{}) ExceptionCode { return SlaveFailure },
+		ErrorLog:     log.New(io.Discard, "", 0),
+	}
+	if h := srv.Health(); !h.Start.IsZero() || h.Requests != 0 || h.LastError != nil {
+		t.Errorf("Health before serving = %+v", h)
+	}
+	addr := startTestServer(t, srv)
+	c, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c.ReadHoldingRegisters(ctx, 1, 0, 1)
+	c.ReadHoldingRegisters(ctx, 1, 5, 1) // IllegalDataAddress
+	regs, err := c.ReadInputRegisters(ctx, 248, 0, HealthRegisters)
+	if err != nil {
+		t.Fatalf("ReadInputRegisters of the health unit: %v", err)
+	}
+	want := []uint16{0, 0, 0, 2, 0, 1, 0, 0, 1, healthException, uint16(ReadHoldingRegisters)<<8 | uint16(IllegalDataAddress), 0, 0}
+	if !reflect.DeepEqual(regs, want) {
+		t.Errorf("health registers = %v; want %v", regs, want)
+	}
+	if _, err := c.ReadHoldingRegisters(ctx, 248, 10, 4); !errors.Is(err, ErrIllegalDataAddress) {
+		t.Errorf("ReadHoldingRegisters beyond the health block = %v", err)
+	}
+	if err := c.WriteSingleRegister(ctx, 248, 0, 1); !errors.Is(err, ErrIllegalFunction) {
+		t.Errorf("WriteSingleRegister to the health unit = %v", err)
+	}
+
+	// The panic closes the connection.
+	if _, err := c.ReadHoldingRegisters(ctx, 2, 0, 1); !errors.Is(err, ErrSlaveFailure) {
+		t.Errorf("ReadHoldingRegisters of the panicking unit = %v", err)
+	}
+	h := srv.Health()
+	for deadline := time.Now().Add(time.Second); h.Requests < 6 && time.Now().Before(deadline); h = srv.Health() {
+		time.Sleep(time.Millisecond) // the last request is counted after its response is sent
+	}
+	if h.Start.IsZero() || h.Requests != 6 || h.Exceptions != 4 || h.Panics != 1 {
+		t.Errorf("Health = %+v", h)
+	}
+	if !errors.Is(h.LastError, ErrSlaveFailure) || time.Since(h.LastErrorTime) > time.Minute {
+		t.Errorf("Health.LastError = %v at %v; want the answer to the panic", h.LastError, h.LastErrorTime)
+	}
+
+	// Without a HealthUnit, unit 248 is not answered.
+	srv = &Server{Handler: mux, UnitIDs: []uint8{1}}
+	conn, err := net.Dial("tcp", startTestServer(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 248, 0x04, 0x00, 0x00, 0x00, 0x01})
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if n, _ := conn.Read(make([]byte, 16)); n != 0 {
+		t.Errorf("unit 248 answered without a HealthUnit")
+	}
+}
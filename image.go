@@ -0,0 +1,165 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// An Image is a copy of the four tables of a device's data model.
+type Image struct {
+	Coils            []bool   `json:"coils,omitempty"`
+	DiscreteInputs   []bool   `json:"discrete_inputs,omitempty"`
+	InputRegisters   []uint16 `json:"input_registers,omitempty"`
+	HoldingRegisters []uint16 `json:"holding_registers,omitempty"`
+}
+
+// A Change records a single coil or register whose value differs between
+// two images. Coils and discrete inputs have the values 0 and 1.
+type Change struct {
+	Table   Table  `json:"table"`
+	Address uint16 `json:"address"`
+	Old     uint16 `json:"old"`
+	New     uint16 `json:"new"`
+}
+
+// MarshalText encodes t as its name, for use as a JSON value.
+func (t Table) MarshalText() ([]byte, error) {
+	name, ok := tableName[t]
+	if !ok {
+		return nil, fmt.Errorf("modbus: unknown table %d", uint8(t))
+	}
+	return []byte(name), nil
+}
+
+// UnmarshalText decodes a table name produced by MarshalText.
+func (t *Table) UnmarshalText(text []byte) error {
+	for table, name := range tableName {
+		if name == string(text) {
+			*t = table
+			return nil
+		}
+	}
+	return fmt.Errorf("modbus: unknown table %q", text)
+}
+
+// DiffImages returns the changes turning image a into image b, ordered by
+// table and address. Addresses beyond the end of a table in one image are
+// taken to hold zero.
+func DiffImages(a, b *Image) []Change {
+	var changes []Change
+	changes = diffBits(changes, CoilTable, a.Coils, b.Coils)
+	changes = diffBits(changes, DiscreteInputTable, a.DiscreteInputs, b.DiscreteInputs)
+	changes = diffRegisters(changes, InputRegisterTable, a.InputRegisters, b.InputRegisters)
+	changes = diffRegisters(changes, HoldingRegisterTable, a.HoldingRegisters, b.HoldingRegisters)
+	return changes
+}
+
+func diffBits(changes []Change, t Table, a, b []bool) []Change {
+	bit := func(v []bool, i int) uint16 {
+		if i < len(v) && v[i] {
+			return 1
+		}
+		return 0
+	}
+	for i := 0; i < len(a) || i < len(b); i++ {
+		if old, new := bit(a, i), bit(b, i); old != new {
+			changes = append(changes, Change{t, uint16(i), old, new})
+		}
+	}
+	return changes
+}
+
+func diffRegisters(changes []Change, t Table, a, b []uint16) []Change {
+	reg := func(v []uint16, i int) uint16 {
+		if i < len(v) {
+			return v[i]
+		}
+		return 0
+	}
+	for i := 0; i < len(a) || i < len(b); i++ {
+		if old, new := reg(a, i), reg(b, i); old != new {
+			changes = append(changes, Change{t, uint16(i), old, new})
+		}
+	}
+	return changes
+}
+
+// An InputWriter is implemented by DataStores whose discrete inputs and
+// input registers can be written by the local application. Masters can
+// never write these tables.
+type InputWriter interface {
+	WriteDiscreteInputs(ctx context.Context, addr uint16, values []bool) error
+	WriteInputRegisters(ctx context.Context, addr uint16, values []uint16) error
+}
+
+// ErrInputsReadOnly is returned by ApplyPatch for changes to discrete
+// inputs or input registers of a store that is not an InputWriter.
+var ErrInputsReadOnly = errors.New("modbus: store cannot write inputs")
+
+// ApplyPatch writes the New value of every change to store, coalescing
+// changes to consecutive addresses into a single write. Old values are
+// not checked. Changes are applied in table and address order and the
+// first failing write ends the patch. Changes to discrete inputs and
+// input registers require store to implement InputWriter.
+func ApplyPatch(ctx context.Context, store DataStore, changes []Change) error {
+	for _, run := range patchRuns(changes) {
+		if err := applyRun(ctx, store, run); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// patchRuns returns changes sorted by table and address, split into runs
+// of consecutive addresses. Of several changes to an address, the last
+// wins.
+func patchRuns(changes []Change) [][]Change {
+	sorted := append([]Change(nil), changes...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return Location{sorted[i].Table, sorted[i].Address}.less(Location{sorted[j].Table, sorted[j].Address})
+	})
+
+	var runs [][]Change
+	for len(sorted) > 0 {
+		// find the run of consecutive addresses starting at sorted[0]
+		n := 1
+		for n < len(sorted) && sorted[n].Table == sorted[0].Table &&
+			int(sorted[n].Address) == int(sorted[0].Address)+n {
+			n++
+		}
+		runs = append(runs, sorted[:n])
+		sorted = sorted[n:]
+	}
+	return runs
+}
+
+func applyRun(ctx context.Context, store DataStore, run []Change) error {
+	t, addr := run[0].Table, run[0].Address
+	bits := make([]bool, len(run))
+	regs := make([]uint16, len(run))
+	for i, c := range run {
+		bits[i] = c.New != 0
+		regs[i] = c.New
+	}
+
+	iw, _ := store.(InputWriter)
+	switch t {
+	case CoilTable:
+		return store.WriteCoils(ctx, addr, bits)
+	case HoldingRegisterTable:
+		return store.WriteHoldingRegisters(ctx, addr, regs)
+	case DiscreteInputTable:
+		if iw == nil {
+			return ErrInputsReadOnly
+		}
+		return iw.WriteDiscreteInputs(ctx, addr, bits)
+	case InputRegisterTable:
+		if iw == nil {
+			return ErrInputsReadOnly
+		}
+		return iw.WriteInputRegisters(ctx, addr, regs)
+	}
+	return fmt.Errorf("modbus: unknown table %d", uint8(t))
+}
@@ -0,0 +1,98 @@
+package modbus
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestDiffImages(t *testing.T) {
+	a := &Image{
+		Coils:            []bool{true, false},
+		InputRegisters:   []uint16{1, 2, 3},
+		HoldingRegisters: []uint16{0x10, 0x20},
+	}
+	b := &Image{
+		Coils:            []bool{true, true, true},
+		InputRegisters:   []uint16{1, 2},
+		HoldingRegisters: []uint16{0x10, 0x21},
+	}
+	expected := []Change{
+		{CoilTable, 1, 0, 1},
+		{CoilTable, 2, 0, 1},
+		{InputRegisterTable, 2, 3, 0},
+		{HoldingRegisterTable, 1, 0x20, 0x21},
+	}
+
+	changes := DiffImages(a, b)
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("DiffImages = %v; want %v", changes, expected)
+	}
+	if len(DiffImages(a, a)) != 0 {
+		t.Errorf("identical images should have no changes")
+	}
+
+	data, err := json.Marshal(changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded []Change
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, changes) {
+		t.Errorf("JSON round trip = %v; want %v", decoded, changes)
+	}
+	if err := json.Unmarshal([]byte(`[{"table":"registers"}]`), &decoded); err == nil {
+		t.Errorf("unknown table name should fail to decode")
+	}
+}
+
+// countingStore counts the holding register writes reaching a DataStore.
+type countingStore struct {
+	DataStore
+	writes int
+}
+
+func (s *countingStore) WriteHoldingRegisters(ctx context.Context, addr uint16, values []uint16) error {
+	s.writes++
+	return s.DataStore.WriteHoldingRegisters(ctx, addr, values)
+}
+
+func TestApplyPatch(t *testing.T) {
+	h := &RegisterHandler{
+		Coils:    make([]bool, 4),
+		Inputs:   make([]uint16, 2),
+		Holdings: make([]uint16, 8),
+	}
+	changes := []Change{
+		{HoldingRegisterTable, 3, 0, 0x33},
+		{HoldingRegisterTable, 1, 0, 0x11},
+		{HoldingRegisterTable, 2, 0, 0x22},
+		{HoldingRegisterTable, 6, 0, 0x66},
+		{CoilTable, 2, 0, 1},
+	}
+
+	store := &countingStore{DataStore: h.DataStore()}
+	if err := ApplyPatch(context.Background(), store, changes); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(h.Holdings, []uint16{0, 0x11, 0x22, 0x33, 0, 0, 0x66, 0}) || !h.Coils[2] {
+		t.Errorf("changes not applied: %v %v", h.Coils, h.Holdings)
+	}
+	if store.writes != 2 {
+		t.Errorf("consecutive registers should be written together, got %d writes", store.writes)
+	}
+
+	inputs := []Change{{InputRegisterTable, 1, 0, 7}}
+	if err := ApplyPatch(context.Background(), store, inputs); err != ErrInputsReadOnly {
+		t.Errorf("ApplyPatch = %v; want %v", err, ErrInputsReadOnly)
+	}
+	if err := ApplyPatch(context.Background(), h.DataStore(), inputs); err != nil {
+		t.Fatal(err)
+	}
+	if h.Inputs[1] != 7 {
+		t.Errorf("input register change not applied")
+	}
+}
@@ -0,0 +1,94 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// UnsafeExtensions expose the raw application data units exchanged with
+// masters, for prototyping protocol extensions on top of this package.
+// The hooks see every ADU, MBAP header included, as read from the
+// connection before it is decoded, and as encoded before it is written,
+// and may replace it with arbitrary bytes. Nothing checks what they
+// return: malformed frames reach the decoder and the master as they are,
+// which is why they are set apart from the Server's other options.
+//
+// The hooks run on the goroutine serving the connection, which they hold
+// up, and see the frames of Modbus/TCP Security connections decrypted.
+type UnsafeExtensions struct {
+	// InboundADU, if non nil, is called with each request ADU read. It
+	// returns the bytes to decode in its place, which may be adu
+	// modified in place, or nil to drop the request unanswered.
+	InboundADU func(info ConnInfo, adu []byte) []byte
+
+	// OutboundADU, if non nil, is called with each response ADU
+	// written. It returns the bytes to send in its place, or nil to
+	// send nothing.
+	OutboundADU func(info ConnInfo, adu []byte) []byte
+}
+
+// An aduReader reads whole ADUs from r, serving what hook makes of
+// them.
+type aduReader struct {
+	r       io.Reader
+	hook    func(adu []byte) []byte
+	pending []byte // rest of the last ADU returned by hook
+}
+
+func (a *aduReader) Read(p []byte) (int, error) {
+	for len(a.pending) == 0 {
+		var mbap [6]byte
+		if _, err := io.ReadFull(a.r, mbap[:]); err != nil {
+			return 0, err
+		}
+		adu := make([]byte, 6+int(binary.BigEndian.Uint16(mbap[4:])))
+		copy(adu, mbap[:])
+		if _, err := io.ReadFull(a.r, adu[6:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		a.pending = a.hook(adu)
+	}
+	n := copy(p, a.pending)
+	a.pending = a.pending[n:]
+	return n, nil
+}
+
+// An aduWriter collects the bytes written until they form a whole ADU,
+// and writes what hook makes of it to w.
+type aduWriter struct {
+	w    io.Writer
+	hook func(adu []byte) []byte
+	buf  []byte // bytes of the next ADU
+}
+
+func (a *aduWriter) Write(p []byte) (int, error) {
+	a.buf = append(a.buf, p...)
+	for len(a.buf) >= 6 {
+		n := 6 + int(binary.BigEndian.Uint16(a.buf[4:]))
+		if len(a.buf) < n {
+			break
+		}
+		out := a.hook(a.buf[:n:n])
+		if len(out) > 0 {
+			if _, err := a.w.Write(out); err != nil {
+				return 0, err
+			}
+		}
+		a.buf = a.buf[n:]
+	}
+	return len(p), nil
+}
+
+// wrap returns r and w with the hooks of x applied to the ADUs of c.
+func (x *UnsafeExtensions) wrap(c *conn, r io.Reader, w io.Writer) (io.Reader, io.Writer) {
+	if h := x.InboundADU; h != nil {
+		r = &aduReader{r: r, hook: func(adu []byte) []byte { return h(c.info, adu) }}
+	}
+	if h := x.OutboundADU; h != nil {
+		w = &aduWriter{w: w, hook: func(adu []byte) []byte { return h(c.info, adu) }}
+	}
+	return r, w
+}
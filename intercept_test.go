@@ -0,0 +1,65 @@
+package modbus
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestUnsafeExtensions(t *testing.T) {
+	// a prototype function 0x65 reads holding registers under another
+	// code; requests to unit 9 are dropped
+	const prototype = 0x65
+	srv := &Server{
+		Handler: &RegisterHandler{Holdings: []uint16{0x1234, 0x5678}},
+		UnsafeExtensions: &UnsafeExtensions{
+			InboundADU: func(info ConnInfo, adu []byte) []byte {
+				if info.RemoteAddr == nil {
+					t.Errorf("InboundADU without the connection's address")
+				}
+				if adu[6] == 9 {
+					return nil
+				}
+				if adu[7] == prototype {
+					adu[7] = byte(ReadHoldingRegisters)
+				}
+				return adu
+			},
+			OutboundADU: func(info ConnInfo, adu []byte) []byte {
+				if FunctionCode(adu[7]) == ReadHoldingRegisters {
+					adu[7] = prototype
+				}
+				return adu
+			},
+		},
+	}
+	addr := startTestServer(t, srv)
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	reqs := []byte{
+		0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x09, 0x03, 0x00, 0x00, 0x00, 0x01,
+		0x00, 0x02, 0x00, 0x00, 0x00, 0x06, 0x01, prototype, 0x00, 0x00, 0x00, 0x02,
+	}
+	expected := []byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x07, 0x01, prototype, 0x04, 0x12, 0x34, 0x56, 0x78}
+	// written in pieces, so that the ADUs are reassembled
+	for _, b := range [][]byte{reqs[:4], reqs[4:15], reqs[15:]} {
+		if _, err := c.Write(b); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	resp := make([]byte, len(expected))
+	if _, err := io.ReadFull(c, resp); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(resp, expected) {
+		t.Errorf("response\n\t% X\nwant\n\t% X", resp, expected)
+	}
+}
@@ -0,0 +1,280 @@
+//go:build interop
+
+// Interoperability tests against third party Modbus implementations,
+// checking the frames exchanged byte for byte. They need docker, and
+// diagslave for TestInteropDiagslave, and are run with
+//
+//	go test -tags interop -run Interop
+//
+// The images used may be replaced by prebuilt ones through
+// MODBUS_PYMODBUS_IMAGE and MODBUS_MBPOLL_IMAGE, which must provide
+// python3 with pymodbus 3.6, and mbpoll. MODBUS_DIAGSLAVE is the path of
+// the diagslave binary; TestInteropDiagslave is skipped if it is unset.
+package modbus
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A recordingConn records the bytes read and written over a connection.
+type recordingConn struct {
+	net.Conn
+	mu      sync.Mutex
+	read    bytes.Buffer
+	written bytes.Buffer
+}
+
+func (c *recordingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.mu.Lock()
+	c.read.Write(p[:n])
+	c.mu.Unlock()
+	return n, err
+}
+
+func (c *recordingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.mu.Lock()
+	c.written.Write(p[:n])
+	c.mu.Unlock()
+	return n, err
+}
+
+// frames returns the read and written bytes split into MBAP frames.
+func (c *recordingConn) frames(t *testing.T) (read, written [][]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return splitFrames(t, c.read.Bytes()), splitFrames(t, c.written.Bytes())
+}
+
+func splitFrames(t *testing.T, b []byte) [][]byte {
+	var frames [][]byte
+	for len(b) > 0 {
+		if len(b) < 6 {
+			t.Fatalf("truncated header % X", b)
+		}
+		n := 6 + int(binary.BigEndian.Uint16(b[4:]))
+		if len(b) < n {
+			t.Fatalf("truncated frame % X", b)
+		}
+		frames = append(frames, b[:n])
+		b = b[n:]
+	}
+	return frames
+}
+
+// checkFrames compares frames to expected, ignoring the transaction
+// identifiers chosen by the other implementation.
+func checkFrames(t *testing.T, what string, frames, expected [][]byte) {
+	t.Helper()
+	if len(frames) != len(expected) {
+		t.Fatalf("%s: %d frames; want %d: % X", what, len(frames), len(expected), frames)
+	}
+	for i, f := range frames {
+		if len(f) < 2 || !bytes.Equal(f[2:], expected[i][2:]) {
+			t.Errorf("%s frame %d\n\t% X\nwant\n\t% X", what, i, f, expected[i])
+		}
+	}
+}
+
+// checkTids checks that each response carries the transaction
+// identifier of its request.
+func checkTids(t *testing.T, reqs, resps [][]byte) {
+	t.Helper()
+	for i := range reqs {
+		if i < len(resps) && !bytes.Equal(reqs[i][:2], resps[i][:2]) {
+			t.Errorf("response %d has transaction identifier % X; want % X", i, resps[i][:2], reqs[i][:2])
+		}
+	}
+}
+
+// startInteropServer serves a RegisterHandler on the loopback interface,
+// which containers on the host network share, recording its connection.
+func startInteropServer(t *testing.T) (port string, rec func() *recordingConn) {
+	var mu sync.Mutex
+	var conn *recordingConn
+	srv := &Server{
+		Handler: &RegisterHandler{Holdings: []uint16{0x1234, 0x5678}},
+		ConnWrapper: func(c net.Conn) (net.Conn, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			conn = &recordingConn{Conn: c}
+			return conn, nil
+		},
+	}
+	addr := startTestServer(t, srv)
+	_, port, _ = net.SplitHostPort(addr)
+	return port, func() *recordingConn {
+		mu.Lock()
+		defer mu.Unlock()
+		if conn == nil {
+			t.Fatal("no connection accepted")
+		}
+		return conn
+	}
+}
+
+func dockerRun(t *testing.T, image, script string) string {
+	t.Helper()
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("docker not found")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "docker", "run", "--rm", "--network", "host",
+		image, "sh", "-c", script).CombinedOutput()
+	if err != nil {
+		t.Fatalf("docker run %s: %v\n%s", image, err, out)
+	}
+	return string(out)
+}
+
+func imageEnv(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+func TestInteropPymodbus(t *testing.T) {
+	port, rec := startInteropServer(t)
+	image := imageEnv("MODBUS_PYMODBUS_IMAGE", "python:3.12-slim")
+	script := fmt.Sprintf(`
+from pymodbus.client import ModbusTcpClient
+c = ModbusTcpClient("127.0.0.1", port=%s)
+c.connect()
+print(c.read_holding_registers(0, 2, slave=1).registers)
+c.write_register(1, 7, slave=1)
+print(c.read_holding_registers(5, 1, slave=1).exception_code)
+c.close()
+`, port)
+	if os.Getenv("MODBUS_PYMODBUS_IMAGE") == "" {
+		script = "pip install -q pymodbus==3.6.9 && python3 -c '" + script + "'"
+	} else {
+		script = "python3 -c '" + script + "'"
+	}
+	out := dockerRun(t, image, script)
+
+	reqs, resps := rec().frames(t)
+	checkFrames(t, "request", reqs, [][]byte{
+		{0, 0, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x02},
+		{0, 0, 0x00, 0x00, 0x00, 0x06, 0x01, 0x06, 0x00, 0x01, 0x00, 0x07},
+		{0, 0, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x05, 0x00, 0x01},
+	})
+	checkFrames(t, "response", resps, [][]byte{
+		{0, 0, 0x00, 0x00, 0x00, 0x07, 0x01, 0x03, 0x04, 0x12, 0x34, 0x56, 0x78},
+		{0, 0, 0x00, 0x00, 0x00, 0x06, 0x01, 0x06, 0x00, 0x01, 0x00, 0x07},
+		{0, 0, 0x00, 0x00, 0x00, 0x03, 0x01, 0x83, 0x02},
+	})
+	checkTids(t, reqs, resps)
+	if !bytes.Contains([]byte(out), []byte("[4660, 22136]")) {
+		t.Errorf("pymodbus output %q lacks the values read", out)
+	}
+}
+
+func TestInteropMbpoll(t *testing.T) {
+	port, rec := startInteropServer(t)
+	image := imageEnv("MODBUS_MBPOLL_IMAGE", "debian:bookworm-slim")
+	script := fmt.Sprintf("mbpoll -m tcp -a 1 -t 4:hex -0 -r 0 -c 2 -1 -p %s 127.0.0.1", port)
+	if os.Getenv("MODBUS_MBPOLL_IMAGE") == "" {
+		script = "apt-get update -qq && apt-get install -qq -y mbpoll >/dev/null && " + script
+	}
+	out := dockerRun(t, image, script)
+
+	reqs, resps := rec().frames(t)
+	checkFrames(t, "request", reqs, [][]byte{
+		{0, 0, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x02},
+	})
+	checkFrames(t, "response", resps, [][]byte{
+		{0, 0, 0x00, 0x00, 0x00, 0x07, 0x01, 0x03, 0x04, 0x12, 0x34, 0x56, 0x78},
+	})
+	checkTids(t, reqs, resps)
+	if !bytes.Contains([]byte(out), []byte("0x1234")) {
+		t.Errorf("mbpoll output %q lacks the values read", out)
+	}
+}
+
+func TestInteropDiagslave(t *testing.T) {
+	path := os.Getenv("MODBUS_DIAGSLAVE")
+	if path == "" {
+		t.Skip("MODBUS_DIAGSLAVE not set")
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	cmd := exec.Command(path, "-m", "tcp", "-a", "1", "-p", strconv.Itoa(port))
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cmd.Process.Kill(); cmd.Wait() })
+
+	var nc net.Conn
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if nc, err = net.Dial("tcp", addr); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("dial diagslave: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	conn := &recordingConn{Conn: nc}
+	c := &Client{Transport: NewClientConn(conn)}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.WriteMultipleRegisters(ctx, 1, 10, []uint16{0x1234, 0x5678}); err != nil {
+		t.Fatal(err)
+	}
+	values, err := c.ReadHoldingRegisters(ctx, 1, 10, 2)
+	if err != nil || values[0] != 0x1234 || values[1] != 0x5678 {
+		t.Errorf("ReadHoldingRegisters = %04X, %v", values, err)
+	}
+	if err := c.WriteSingleCoil(ctx, 1, 3, true); err != nil {
+		t.Fatal(err)
+	}
+	coils, err := c.ReadCoils(ctx, 1, 0, 5)
+	if err != nil || !coils[3] {
+		t.Errorf("ReadCoils = %v, %v", coils, err)
+	}
+
+	resps, reqs := conn.frames(t)
+	expected := [][]byte{
+		{0x00, 0x01, 0x00, 0x00, 0x00, 0x0B, 0x01, 0x10, 0x00, 0x0A, 0x00, 0x02, 0x04, 0x12, 0x34, 0x56, 0x78},
+		{0x00, 0x02, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x0A, 0x00, 0x02},
+		{0x00, 0x03, 0x00, 0x00, 0x00, 0x06, 0x01, 0x05, 0x00, 0x03, 0xFF, 0x00},
+		{0x00, 0x04, 0x00, 0x00, 0x00, 0x06, 0x01, 0x01, 0x00, 0x00, 0x00, 0x05},
+	}
+	// our transaction identifiers are known, so requests are compared whole
+	if len(reqs) != len(expected) {
+		t.Fatalf("%d requests; want %d: % X", len(reqs), len(expected), reqs)
+	}
+	for i, f := range reqs {
+		if !bytes.Equal(f, expected[i]) {
+			t.Errorf("request %d\n\t% X\nwant\n\t% X", i, f, expected[i])
+		}
+	}
+	checkFrames(t, "response", resps, [][]byte{
+		{0, 0, 0x00, 0x00, 0x00, 0x06, 0x01, 0x10, 0x00, 0x0A, 0x00, 0x02},
+		{0, 0, 0x00, 0x00, 0x00, 0x07, 0x01, 0x03, 0x04, 0x12, 0x34, 0x56, 0x78},
+		{0, 0, 0x00, 0x00, 0x00, 0x06, 0x01, 0x05, 0x00, 0x03, 0xFF, 0x00},
+		{0, 0, 0x00, 0x00, 0x00, 0x04, 0x01, 0x01, 0x01, 0x08},
+	})
+	checkTids(t, reqs, resps)
+}
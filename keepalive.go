@@ -0,0 +1,55 @@
+package modbus
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// SetKeepAlivesEnabled controls whether connections are kept open for
+// further requests after a response, which they are by default. Once
+// disabled, each connection is closed after its current or next
+// response.
+func (srv *Server) SetKeepAlivesEnabled(v bool) {
+	if v {
+		atomic.StoreInt32(&srv.disableKeepAlives, 0)
+	} else {
+		atomic.StoreInt32(&srv.disableKeepAlives, 1)
+	}
+}
+
+func (srv *Server) doKeepAlives() bool {
+	return atomic.LoadInt32(&srv.disableKeepAlives) == 0
+}
+
+// idleTimeout returns how long to wait for the next request of a kept
+// alive connection, or zero to wait indefinitely.
+func (srv *Server) idleTimeout() time.Duration {
+	if srv.IdleTimeout != 0 {
+		return srv.IdleTimeout
+	}
+	return srv.ReadTimeout
+}
+
+// A tcpKeepAliveListener sets the TCP keep-alive period of the
+// connections it accepts, or disables keep-alives if it is negative.
+type tcpKeepAliveListener struct {
+	net.Listener
+	period time.Duration
+}
+
+func (l tcpKeepAliveListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if tc, ok := c.(*net.TCPConn); ok {
+		if l.period < 0 {
+			tc.SetKeepAlive(false)
+		} else {
+			tc.SetKeepAlive(true)
+			tc.SetKeepAlivePeriod(l.period)
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,65 @@
+package modbus
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+// expectClosed fails unless the server closes c within a second.
+func expectClosed(t *testing.T, c io.Reader, start time.Time) {
+	t.Helper()
+	var b [1]byte
+	if _, err := c.Read(b[:]); err != io.EOF {
+		t.Fatalf("read should fail with EOF not %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("connection closed after %v", elapsed)
+	}
+}
+
+func TestServerIdleTimeout(t *testing.T) {
+	addr, _ := testLimitServer(t, &Server{IdleTimeout: 50 * time.Millisecond})
+
+	c, ok := dialRead(t, addr)
+	if !ok {
+		t.Fatalf("request not served")
+	}
+	// the connection stays usable within the timeout
+	c.Write(limitRead)
+	resp := make([]byte, len(limitExpected))
+	if _, err := io.ReadFull(c, resp); err != nil {
+		t.Fatalf("second request: %v", err)
+	}
+	expectClosed(t, c, time.Now())
+}
+
+func TestServerSetKeepAlivesEnabled(t *testing.T) {
+	srv := &Server{}
+	srv.SetKeepAlivesEnabled(false)
+	addr, _ := testLimitServer(t, srv)
+
+	c, ok := dialRead(t, addr)
+	if !ok {
+		t.Fatalf("request not served")
+	}
+	expectClosed(t, c, time.Now())
+
+	srv.SetKeepAlivesEnabled(true)
+	c, ok = dialRead(t, addr)
+	if !ok {
+		t.Fatalf("request not served")
+	}
+	c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	var b [1]byte
+	if _, err := c.Read(b[:]); err == io.EOF {
+		t.Errorf("connection closed with keep-alives enabled")
+	}
+}
+
+func TestServerTCPKeepAlive(t *testing.T) {
+	addr, _ := testLimitServer(t, &Server{TCPKeepAlive: -1})
+	if _, ok := dialRead(t, addr); !ok {
+		t.Errorf("request not served with TCP keep-alives disabled")
+	}
+}
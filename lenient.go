@@ -0,0 +1,80 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"io"
+	"log"
+)
+
+// pduLength returns the length of the data of the response resp as its
+// function code, and byte count for the functions carrying one, imply.
+// ok is false for functions whose length is unknown, and for responses
+// too short to carry their byte count.
+func pduLength(resp *Frame) (n int, ok bool) {
+	fcode := FunctionCode(resp.header.Fcode)
+	if fcode&0x80 != 0 {
+		return 1, true
+	}
+	switch fcode {
+	case ReadCoils, ReadDiscreteInputs, ReadHoldingRegisters, ReadInputRegisters,
+		WriteAndReadRegisters, ReportSlaveId, WaitForChange:
+		i := 0
+		if fcode == WaitForChange {
+			i = 1 // the byte count follows the changed flag
+		}
+		if len(resp.data) <= i {
+			return 0, false
+		}
+		return i + 1 + int(resp.data[i]), true
+	case WriteSingleCoil, WriteSingleRegister, WriteMultipleCoils, WriteMultipleRegisters:
+		return 4, true
+	case MaskWriteRegister:
+		return 6, true
+	case ReadExceptionStatus:
+		return 1, true
+	}
+	return 0, false
+}
+
+func (cc *ClientConn) logf(format string, args ...interface{}) {
+	if cc.ErrorLog != nil {
+		cc.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// normalize repairs the response resp just read, for Lenient: bytes
+// following the data its function code implies are dropped, data
+// missing because the MBAP Length falls short is read from the bytes
+// following the frame when they have arrived with it, and bytes
+// following the frame that cannot start another are discarded. Each
+// repair is logged.
+func (cc *ClientConn) normalize(resp *Frame) {
+	n, ok := pduLength(resp)
+	if !ok {
+		return
+	}
+	h := &resp.header
+	switch missing := n - len(resp.data); {
+	case missing < 0:
+		cc.logf("modbus: response 0x%04X of unit %d: %d bytes of trailing garbage dropped", h.Tid, h.Uid, -missing)
+		resp.data = resp.data[:n]
+	case missing > 0 && cc.br.Buffered() >= missing:
+		cc.logf("modbus: response 0x%04X of unit %d: MBAP length %d short by %d", h.Tid, h.Uid, h.Length, missing)
+		rest := make([]byte, missing)
+		io.ReadFull(cc.br, rest)
+		resp.data = append(resp.data, rest...)
+	}
+	h.Length = uint16(len(resp.data) + 2)
+
+	// anything following a frame is the header of another, with a zero
+	// protocol identifier
+	if b := cc.br.Buffered(); b > 0 {
+		hb, _ := cc.br.Peek(b)
+		if b >= 4 && binary.BigEndian.Uint16(hb[2:]) != TcpPid || b < 4 && b >= 3 && hb[2] != 0 {
+			cc.logf("modbus: response 0x%04X of unit %d: %d bytes of garbage following it discarded", h.Tid, h.Uid, b)
+			cc.br.Discard(b)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package modbus
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSlave answers each request read from conn with the next of
+// responses, given without their transaction identifier, which is
+// copied from the request.
+func fakeSlave(conn net.Conn, responses ...[]byte) {
+	defer conn.Close()
+	req := make([]byte, 12)
+	for _, resp := range responses {
+		if _, err := io.ReadFull(conn, req); err != nil {
+			return
+		}
+		conn.Write(append(req[:2:2], resp...))
+	}
+	io.Copy(io.Discard, conn)
+}
+
+func TestLenient(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		resp []byte // response to a read of one holding register
+		log  string // logged repair, empty for conforming responses
+	}{
+		{"conforming", []byte{0x00, 0x00, 0x00, 0x05, 0x01, 0x03, 0x02, 0x00, 0x07}, ""},
+		{"garbage within", []byte{0x00, 0x00, 0x00, 0x07, 0x01, 0x03, 0x02, 0x00, 0x07, 0xAA, 0xBB}, "trailing garbage"},
+		{"garbage after", []byte{0x00, 0x00, 0x00, 0x05, 0x01, 0x03, 0x02, 0x00, 0x07, 0xDE, 0xAD, 0xBE, 0xEF, 0x01}, "following it"},
+		{"short length", []byte{0x00, 0x00, 0x00, 0x04, 0x01, 0x03, 0x02, 0x00, 0x07}, "short by 1"},
+		{"exception", []byte{0x00, 0x00, 0x00, 0x05, 0x01, 0x83, 0x02, 0xC4, 0x0B}, "trailing garbage"},
+	} {
+		c1, c2 := net.Pipe()
+		// the second response checks that the stream is still in step
+		go fakeSlave(c2, tt.resp, []byte{0x00, 0x00, 0x00, 0x05, 0x01, 0x03, 0x02, 0x00, 0x09})
+		var logged bytes.Buffer
+		cc := NewClientConn(c1)
+		cc.Lenient = true
+		cc.ErrorLog = log.New(&logged, "", 0)
+		c := &Client{Transport: cc}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+
+		values, err := c.ReadHoldingRegisters(ctx, 1, 0, 1)
+		if tt.name == "exception" {
+			if !errors.Is(err, ErrIllegalDataAddress) {
+				t.Errorf("%s: err should be ErrIllegalDataAddress not %v", tt.name, err)
+			}
+		} else if err != nil || !reflect.DeepEqual(values, []uint16{7}) {
+			t.Errorf("%s: ReadHoldingRegisters = %v, %v", tt.name, values, err)
+		}
+		if values, err := c.ReadHoldingRegisters(ctx, 1, 0, 1); err != nil || !reflect.DeepEqual(values, []uint16{9}) {
+			t.Errorf("%s: next ReadHoldingRegisters = %v, %v", tt.name, values, err)
+		}
+		if tt.log == "" && logged.Len() > 0 || !strings.Contains(logged.String(), tt.log) {
+			t.Errorf("%s: logged %q; want %q", tt.name, logged.String(), tt.log)
+		}
+		cancel()
+		c.Close()
+	}
+}
+
+func TestLenientOff(t *testing.T) {
+	c1, c2 := net.Pipe()
+	go fakeSlave(c2, []byte{0x00, 0x00, 0x00, 0x07, 0x01, 0x03, 0x02, 0x00, 0x07, 0xAA, 0xBB})
+	c := &Client{Transport: NewClientConn(c1)}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := c.ReadHoldingRegisters(ctx, 1, 0, 1); err == nil {
+		t.Errorf("trailing garbage accepted by a strict connection")
+	}
+}
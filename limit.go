@@ -0,0 +1,132 @@
+package modbus
+
+import (
+	"net"
+	"sync"
+)
+
+// remoteIP returns the IP address part of nc's remote address.
+func remoteIP(nc net.Conn) string {
+	addr := nc.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// waitConnSlot blocks, when QueueConnections is set, until fewer than
+// MaxConnections connections are open.
+func (s *Server) waitConnSlot() {
+	if !s.QueueConnections || s.MaxConnections <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.connFreed == nil {
+		s.connFreed = sync.NewCond(&s.mu)
+	}
+	for s.conns >= s.MaxConnections {
+		s.connFreed.Wait()
+	}
+}
+
+// The admissions of an accepted connection by acquireConn.
+const (
+	connServed   = iota // to be served now
+	connQueued          // to wait for a slot, see MaxQueuedConnections
+	connRejected        // to be closed
+)
+
+// acquireConn counts nc as open or, if MaxConnections are open, as
+// queued when MaxQueuedConnections permits. It reports connRejected if
+// the limits do not permit another connection.
+func (s *Server) acquireConn(nc net.Conn) int {
+	ip := remoteIP(nc)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.MaxConnectionsPerIP > 0 && s.ipConns[ip] >= s.MaxConnectionsPerIP {
+		return connRejected
+	}
+	admission := connServed
+	if s.MaxConnections > 0 && (s.conns >= s.MaxConnections || len(s.connQueue) > 0) {
+		if s.QueueConnections || len(s.connQueue) >= s.MaxQueuedConnections {
+			return connRejected
+		}
+		admission = connQueued
+	} else {
+		s.conns++
+	}
+	if s.ipConns == nil {
+		s.ipConns = make(map[string]int)
+	}
+	s.ipConns[ip]++
+	return admission
+}
+
+// queueConn queues nc, admitted as connQueued, and starts serving queued
+// connections should a slot have been freed meanwhile.
+func (s *Server) queueConn(nc net.Conn, handler Handler) {
+	s.mu.Lock()
+	s.connQueue = append(s.connQueue, nc)
+	s.mu.Unlock()
+	for {
+		c := s.dequeueConn(handler)
+		if c == nil {
+			return
+		}
+		account(&accounted.goroutines, 1)
+		go s.work(c, handler)
+	}
+}
+
+// dequeueConn returns the connection queued longest, counted as open, if
+// a slot is free, or nil.
+func (s *Server) dequeueConn(handler Handler) *conn {
+	for {
+		s.mu.Lock()
+		if len(s.connQueue) == 0 || s.conns >= s.MaxConnections {
+			s.mu.Unlock()
+			return nil
+		}
+		nc := s.connQueue[0]
+		s.connQueue[0] = nil
+		s.connQueue = s.connQueue[1:]
+		s.conns++
+		s.mu.Unlock()
+
+		c, err := s.newConn(nc)
+		if err != nil {
+			s.releaseConn(nc)
+			continue
+		}
+		c.handler = handler
+		c.setState(c.rwc, StateNew)
+		account(&accounted.serverConns, 1)
+		return c
+	}
+}
+
+// work serves c and then, while any wait for a slot, queued connections.
+// It is the goroutine of a connection, accounted as such.
+func (s *Server) work(c *conn, handler Handler) {
+	for c != nil {
+		c.serve()
+		if c = s.dequeueConn(handler); c != nil {
+			account(&accounted.goroutines, 1) // taken again by serve
+		}
+	}
+}
+
+// releaseConn undoes acquireConn once nc is closed or hijacked.
+func (s *Server) releaseConn(nc net.Conn) {
+	ip := remoteIP(nc)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conns--
+	if s.ipConns[ip]--; s.ipConns[ip] == 0 {
+		delete(s.ipConns, ip)
+	}
+	if s.connFreed != nil {
+		s.connFreed.Signal()
+	}
+}
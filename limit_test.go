@@ -0,0 +1,175 @@
+package modbus
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+)
+
+var limitRead = []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x03, 0x00, 0x00, 0x00, 0x01}
+var limitExpected = []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x05, 0xFF, 0x03, 0x02, 0x12, 0x34}
+
+// dialRead dials addr, sends a read request and returns the connection
+// and whether the expected response arrived.
+func dialRead(t *testing.T, addr string) (net.Conn, bool) {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	c.Write(limitRead)
+	resp := make([]byte, len(limitExpected))
+	if _, err := io.ReadFull(c, resp); err != nil {
+		return c, false
+	}
+	return c, bytes.Equal(resp, limitExpected)
+}
+
+func testLimitServer(t *testing.T, srv *Server) (string, chan ConnState) {
+	states := make(chan ConnState, 10)
+	srv.Handler = &RegisterHandler{Holdings: []uint16{0x1234}}
+	srv.ErrorLog = log.New(io.Discard, "", 0)
+	srv.ConnState = func(_ net.Conn, state ConnState) {
+		if state == StateRejected {
+			states <- state
+		}
+	}
+	return startTestServer(t, srv), states
+}
+
+func TestServerMaxConnections(t *testing.T) {
+	addr, rejected := testLimitServer(t, &Server{MaxConnections: 1})
+
+	first, ok := dialRead(t, addr)
+	if !ok {
+		t.Fatalf("first connection not served")
+	}
+	if _, ok := dialRead(t, addr); ok {
+		t.Errorf("connection over MaxConnections served")
+	}
+	select {
+	case <-rejected:
+	case <-time.After(5 * time.Second):
+		t.Errorf("StateRejected not reported")
+	}
+
+	first.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := dialRead(t, addr); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("slot not released after connection closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServerQueueConnections(t *testing.T) {
+	addr, rejected := testLimitServer(t, &Server{MaxConnections: 1, QueueConnections: true})
+
+	first, ok := dialRead(t, addr)
+	if !ok {
+		t.Fatalf("first connection not served")
+	}
+
+	served := make(chan bool, 1)
+	go func() {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			served <- false
+			return
+		}
+		defer c.Close()
+		c.SetDeadline(time.Now().Add(5 * time.Second))
+		c.Write(limitRead)
+		resp := make([]byte, len(limitExpected))
+		_, err = io.ReadFull(c, resp)
+		served <- err == nil && bytes.Equal(resp, limitExpected)
+	}()
+
+	select {
+	case <-served:
+		t.Fatalf("queued connection served while the first was open")
+	case <-time.After(100 * time.Millisecond):
+	}
+	first.Close()
+	if !<-served {
+		t.Errorf("queued connection not served after the first closed")
+	}
+	if len(rejected) != 0 {
+		t.Errorf("queued connection reported as rejected")
+	}
+}
+
+func TestServerMaxConnectionsPerIP(t *testing.T) {
+	addr, rejected := testLimitServer(t, &Server{MaxConnectionsPerIP: 2})
+
+	for i := 0; i < 2; i++ {
+		if _, ok := dialRead(t, addr); !ok {
+			t.Fatalf("connection %d not served", i)
+		}
+	}
+	if _, ok := dialRead(t, addr); ok {
+		t.Errorf("connection over MaxConnectionsPerIP served")
+	}
+	select {
+	case <-rejected:
+	case <-time.After(5 * time.Second):
+		t.Errorf("StateRejected not reported")
+	}
+}
+
+func TestServerMaxQueuedConnections(t *testing.T) {
+	addr, rejected := testLimitServer(t, &Server{MaxConnections: 1, MaxQueuedConnections: 1})
+
+	first, ok := dialRead(t, addr)
+	if !ok {
+		t.Fatalf("first connection not served")
+	}
+
+	served := make(chan bool, 1)
+	go func() {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			served <- false
+			return
+		}
+		defer c.Close()
+		c.SetDeadline(time.Now().Add(5 * time.Second))
+		c.Write(limitRead)
+		resp := make([]byte, len(limitExpected))
+		_, err = io.ReadFull(c, resp)
+		served <- err == nil && bytes.Equal(resp, limitExpected)
+	}()
+
+	// the queue holds the second connection, so the third is closed
+	select {
+	case <-rejected:
+		t.Fatalf("second connection rejected")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if _, ok := dialRead(t, addr); ok {
+		t.Errorf("connection over MaxQueuedConnections served")
+	}
+	select {
+	case <-rejected:
+	case <-time.After(5 * time.Second):
+		t.Errorf("StateRejected not reported")
+	}
+	select {
+	case <-served:
+		t.Fatalf("queued connection served while the first was open")
+	default:
+	}
+
+	first.Close()
+	if !<-served {
+		t.Errorf("queued connection not served after the first closed")
+	}
+}
@@ -0,0 +1,63 @@
+package modbus
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// ListenerFromFD returns a Listener for the listening socket of the file
+// descriptor fd, inherited from the process that started this one, such
+// as a supervisor holding the port across restarts. The name describes
+// the descriptor in errors. The descriptor itself is closed; the
+// Listener holds a duplicate of it.
+func ListenerFromFD(fd uintptr, name string) (net.Listener, error) {
+	f := os.NewFile(fd, name)
+	if f == nil {
+		return nil, fmt.Errorf("modbus: invalid file descriptor %d", fd)
+	}
+	defer f.Close()
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("modbus: listener from %s: %w", name, err)
+	}
+	return l, nil
+}
+
+// systemdFirstFD is the first file descriptor passed by systemd socket
+// activation, SD_LISTEN_FDS_START.
+const systemdFirstFD = 3
+
+// SystemdListeners returns Listeners for the sockets passed to the process
+// by systemd socket activation, in the order of the socket unit's
+// ListenStream settings, for serving with ServeAll. It returns none if
+// the process was not socket activated. The activation environment
+// variables are unset, so that child processes do not take the sockets
+// for their own.
+func SystemdListeners() ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("modbus: bad LISTEN_FDS %q", os.Getenv("LISTEN_FDS"))
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make([]net.Listener, 0, n)
+	for fd := systemdFirstFD; fd < systemdFirstFD+n; fd++ {
+		l, err := ListenerFromFD(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
@@ -0,0 +1,24 @@
+package modbus
+
+import (
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestSystemdListeners(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	if ls, err := SystemdListeners(); len(ls) != 0 || err != nil {
+		t.Errorf("SystemdListeners for another process = %v, %v", ls, err)
+	}
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "0")
+	if ls, err := SystemdListeners(); len(ls) != 0 || err != nil {
+		t.Errorf("SystemdListeners without sockets = %v, %v", ls, err)
+	}
+	if _, ok := os.LookupEnv("LISTEN_PID"); ok {
+		t.Errorf("LISTEN_PID left set")
+	}
+}
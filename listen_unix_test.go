@@ -0,0 +1,56 @@
+//go:build unix
+
+package modbus
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+func TestListenerFromFD(t *testing.T) {
+	tl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer tl.Close()
+	f, err := tl.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ListenerFromFD takes the descriptor as inherited ones are taken
+	fd, err := syscall.Dup(int(f.Fd()))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := ListenerFromFD(uintptr(fd), "inherited")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	var mu sync.Mutex
+	var states []ConnState
+	srv := &Server{
+		Handler: &RegisterHandler{Holdings: []uint16{0x1234}},
+		ConnState: func(_ net.Conn, state ConnState) {
+			mu.Lock()
+			defer mu.Unlock()
+			states = append(states, state)
+		},
+	}
+	go srv.Serve(l)
+
+	resp := exchange(t, l.Addr().String(), []byte{0, 1, 0, 0, 0, 6, 1, 0x03, 0, 0, 0, 1}, 11)
+	if expected := []byte{0, 1, 0, 0, 0, 5, 1, 0x03, 2, 0x12, 0x34}; !bytes.Equal(resp, expected) {
+		t.Errorf("response % X; want % X", resp, expected)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(states) < 2 || states[0] != StateNew || states[1] != StateActive {
+		t.Errorf("states %v; want new, active, ...", states)
+	}
+}
@@ -0,0 +1,337 @@
+package modbus
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/mubeta06/gomodbus/values"
+)
+
+// A PointType is the data type of a Point and determines the number of
+// coils or registers it occupies.
+type PointType uint8
+
+const (
+	Bool    PointType = iota // a single coil or discrete input
+	Uint16                   // one register
+	Int16                    // one register
+	Uint32                   // two registers
+	Int32                    // two registers
+	Float32                  // two registers, IEEE 754
+	Uint64                   // four registers
+	Int64                    // four registers
+	Float64                  // four registers, IEEE 754
+)
+
+var pointTypeName = map[PointType]string{
+	Bool:    "bool",
+	Uint16:  "uint16",
+	Int16:   "int16",
+	Uint32:  "uint32",
+	Int32:   "int32",
+	Float32: "float32",
+	Uint64:  "uint64",
+	Int64:   "int64",
+	Float64: "float64",
+}
+
+func (t PointType) String() string {
+	return pointTypeName[t]
+}
+
+// MarshalText encodes t as its name, for use as a JSON value.
+func (t PointType) MarshalText() ([]byte, error) {
+	name, ok := pointTypeName[t]
+	if !ok {
+		return nil, fmt.Errorf("modbus: unknown point type %d", uint8(t))
+	}
+	return []byte(name), nil
+}
+
+// UnmarshalText decodes a point type name produced by MarshalText.
+func (t *PointType) UnmarshalText(text []byte) error {
+	for typ, name := range pointTypeName {
+		if name == string(text) {
+			*t = typ
+			return nil
+		}
+	}
+	return fmt.Errorf("modbus: unknown point type %q", text)
+}
+
+// size returns the number of coils or registers occupied.
+func (t PointType) size() int {
+	switch t {
+	case Uint32, Int32, Float32:
+		return 2
+	case Uint64, Int64, Float64:
+		return 4
+	}
+	return 1
+}
+
+// A Point is a named value of a device held in one or more consecutive
+// coils or registers.
+type Point struct {
+	Name    string
+	Table   Table
+	Address uint16 // first coil or register
+	Type    PointType
+
+	// Multi register values are stored most significant word first
+	// and each register most significant byte first, as Modbus does
+	// for single registers. WordSwap reverses the order of the
+	// registers and ByteSwap the order of the bytes within each; see
+	// the values package for the resulting layouts.
+	WordSwap bool
+	ByteSwap bool
+
+	// Scale multiplies the raw value to give the value of the point,
+	// e.g. 0.1 for a register holding tenths. If zero, 1 is used.
+	Scale float64
+}
+
+func (p *Point) scale() float64 {
+	if p.Scale == 0 {
+		return 1
+	}
+	return p.Scale
+}
+
+// order returns the register layout of the point.
+func (p *Point) order() values.Order {
+	switch {
+	case p.WordSwap && p.ByteSwap:
+		return values.DCBA
+	case p.WordSwap:
+		return values.CDAB
+	case p.ByteSwap:
+		return values.BADC
+	}
+	return values.ABCD
+}
+
+// encode returns the registers, or coil values, holding v.
+func (p *Point) encode(v float64) ([]uint16, error) {
+	raw := v / p.scale()
+	regs := make([]uint16, p.Type.size())
+	o := p.order()
+	switch p.Type {
+	case Bool:
+		if raw != 0 {
+			regs[0] = 1
+		}
+		return regs, nil
+	case Float32:
+		o.PutFloat32(regs, float32(raw))
+		return regs, nil
+	case Float64:
+		o.PutFloat64(regs, raw)
+		return regs, nil
+	}
+
+	r := math.Round(raw)
+	var min, max float64
+	switch p.Type {
+	case Uint16:
+		min, max = 0, math.MaxUint16
+	case Int16:
+		min, max = math.MinInt16, math.MaxInt16
+	case Uint32:
+		min, max = 0, math.MaxUint32
+	case Int32:
+		min, max = math.MinInt32, math.MaxInt32
+	case Uint64:
+		min, max = 0, math.MaxUint64
+	case Int64:
+		min, max = math.MinInt64, math.MaxInt64
+	}
+	if math.IsNaN(r) || r < min || r > max {
+		return nil, fmt.Errorf("modbus: %v out of range for %v point %s", v, p.Type, p.Name)
+	}
+	switch p.Type {
+	case Uint16:
+		o.PutUint16(regs, uint16(r))
+	case Int16:
+		o.PutInt16(regs, int16(r))
+	case Uint32:
+		o.PutUint32(regs, uint32(r))
+	case Int32:
+		o.PutInt32(regs, int32(r))
+	case Uint64:
+		o.PutUint64(regs, uint64(r))
+	case Int64:
+		o.PutInt64(regs, int64(r))
+	}
+	return regs, nil
+}
+
+// decode returns the value held in regs, or coil values.
+func (p *Point) decode(regs []uint16) float64 {
+	var raw float64
+	o := p.order()
+	switch p.Type {
+	case Bool:
+		if regs[0] != 0 {
+			raw = 1
+		}
+		return raw
+	case Uint16:
+		raw = float64(o.Uint16(regs))
+	case Int16:
+		raw = float64(o.Int16(regs))
+	case Uint32:
+		raw = float64(o.Uint32(regs))
+	case Int32:
+		raw = float64(o.Int32(regs))
+	case Float32:
+		raw = float64(o.Float32(regs))
+	case Uint64:
+		raw = float64(o.Uint64(regs))
+	case Int64:
+		raw = float64(o.Int64(regs))
+	case Float64:
+		raw = o.Float64(regs)
+	}
+	return raw * p.scale()
+}
+
+// A Mapping is a set of named points of a device, read and written by
+// name through a Client or, on the slave side, a DataStore.
+type Mapping struct {
+	points map[string]*Point
+}
+
+// NewMapping returns a Mapping of points. It fails if two points share a
+// name, a point of type Bool is not in a bit table, or a register point
+// does not fit in its table.
+func NewMapping(points ...Point) (*Mapping, error) {
+	m := &Mapping{points: make(map[string]*Point)}
+	for i := range points {
+		p := points[i]
+		if _, ok := m.points[p.Name]; ok {
+			return nil, fmt.Errorf("modbus: duplicate point %s", p.Name)
+		}
+		isBit := p.Table == CoilTable || p.Table == DiscreteInputTable
+		if isBit != (p.Type == Bool) {
+			return nil, fmt.Errorf("modbus: %v point %s in %v", p.Type, p.Name, p.Table)
+		}
+		if int(p.Address)+p.Type.size() > 0x10000 {
+			return nil, fmt.Errorf("modbus: point %s exceeds the address space", p.Name)
+		}
+		m.points[p.Name] = &p
+	}
+	return m, nil
+}
+
+// Point returns the point called name.
+func (m *Mapping) Point(name string) (Point, bool) {
+	p, ok := m.points[name]
+	if !ok {
+		return Point{}, false
+	}
+	return *p, true
+}
+
+func (m *Mapping) point(name string) (*Point, error) {
+	p, ok := m.points[name]
+	if !ok {
+		return nil, fmt.Errorf("modbus: unknown point %s", name)
+	}
+	return p, nil
+}
+
+// ReadPoint reads the point called name of m from unit uid.
+func (c *Client) ReadPoint(ctx context.Context, uid uint8, m *Mapping, name string) (float64, error) {
+	p, err := m.point(name)
+	if err != nil {
+		return 0, err
+	}
+	regs, err := c.readTable(ctx, uid, p.Table, p.Address, uint16(p.Type.size()))
+	if err != nil {
+		return 0, err
+	}
+	return p.decode(regs), nil
+}
+
+// WritePoint writes v to the point called name of m on unit uid. Points
+// spanning several registers are written with a single request.
+func (c *Client) WritePoint(ctx context.Context, uid uint8, m *Mapping, name string, v float64) error {
+	p, err := m.point(name)
+	if err != nil {
+		return err
+	}
+	regs, err := p.encode(v)
+	if err != nil {
+		return err
+	}
+	switch {
+	case p.Table == CoilTable:
+		return c.WriteSingleCoil(ctx, uid, p.Address, regs[0] != 0)
+	case p.Table != HoldingRegisterTable:
+		return fmt.Errorf("modbus: point %s in %v is not writable", name, p.Table)
+	case len(regs) == 1:
+		return c.WriteSingleRegister(ctx, uid, p.Address, regs[0])
+	}
+	return c.WriteMultipleRegisters(ctx, uid, p.Address, regs)
+}
+
+// Get returns the value of the point called name held in store.
+func (m *Mapping) Get(ctx context.Context, store DataStore, name string) (float64, error) {
+	p, err := m.point(name)
+	if err != nil {
+		return 0, err
+	}
+	return p.get(ctx, store)
+}
+
+// get returns the value of p held in store.
+func (p *Point) get(ctx context.Context, store DataStore) (float64, error) {
+	n := uint16(p.Type.size())
+	var regs []uint16
+	var bits []bool
+	var err error
+	switch p.Table {
+	case CoilTable:
+		bits, err = store.ReadCoils(ctx, p.Address, n)
+	case DiscreteInputTable:
+		bits, err = store.ReadDiscreteInputs(ctx, p.Address, n)
+	case InputRegisterTable:
+		regs, err = store.ReadInputRegisters(ctx, p.Address, n)
+	default:
+		regs, err = store.ReadHoldingRegisters(ctx, p.Address, n)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if bits != nil {
+		regs = bitsToValues(bits)
+	}
+	return p.decode(regs), nil
+}
+
+// Set stores v as the value of the point called name in store, so that
+// a slave serving store presents it to masters. Points in the input
+// tables require store to implement InputWriter.
+func (m *Mapping) Set(ctx context.Context, store DataStore, name string, v float64) error {
+	p, err := m.point(name)
+	if err != nil {
+		return err
+	}
+	regs, err := p.encode(v)
+	if err != nil {
+		return err
+	}
+	return applyRun(ctx, store, changesAt(p.Table, p.Address, regs))
+}
+
+// changesAt returns changes setting consecutive addresses starting at
+// addr of table t to values.
+func changesAt(t Table, addr uint16, values []uint16) []Change {
+	changes := make([]Change, len(values))
+	for i, v := range values {
+		changes[i] = Change{Table: t, Address: addr + uint16(i), New: v}
+	}
+	return changes
+}
@@ -0,0 +1,88 @@
+package modbus
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestPointEncoding(t *testing.T) {
+	tests := []struct {
+		p    Point
+		v    float64
+		regs []uint16
+	}{
+		{Point{Type: Uint16}, 0xBEEF, []uint16{0xBEEF}},
+		{Point{Type: Int16}, -2, []uint16{0xFFFE}},
+		{Point{Type: Uint32}, 0x11223344, []uint16{0x1122, 0x3344}},
+		{Point{Type: Uint32, WordSwap: true}, 0x11223344, []uint16{0x3344, 0x1122}},
+		{Point{Type: Uint32, ByteSwap: true}, 0x11223344, []uint16{0x2211, 0x4433}},
+		{Point{Type: Uint32, WordSwap: true, ByteSwap: true}, 0x11223344, []uint16{0x4433, 0x2211}},
+		{Point{Type: Int32, Scale: 0.1}, -1.5, []uint16{0xFFFF, 0xFFF1}},
+		{Point{Type: Float32}, 1.5, []uint16{0x3FC0, 0x0000}},
+		{Point{Type: Float64}, 1.5, []uint16{0x3FF8, 0, 0, 0}},
+		{Point{Type: Int64}, -1, []uint16{0xFFFF, 0xFFFF, 0xFFFF, 0xFFFF}},
+	}
+	for _, tt := range tests {
+		regs, err := tt.p.encode(tt.v)
+		if err != nil || !reflect.DeepEqual(regs, tt.regs) {
+			t.Errorf("%+v encode(%v) = %04X, %v; want %04X", tt.p, tt.v, regs, err, tt.regs)
+			continue
+		}
+		if v := tt.p.decode(regs); math.Abs(v-tt.v) > 1e-9 {
+			t.Errorf("%+v decode(%04X) = %v; want %v", tt.p, regs, v, tt.v)
+		}
+	}
+
+	if _, err := (&Point{Type: Uint16}).encode(70000); err == nil {
+		t.Errorf("out of range value should fail to encode")
+	}
+}
+
+func TestMapping(t *testing.T) {
+	m, err := NewMapping(
+		Point{Name: "MotorSpeed", Table: HoldingRegisterTable, Address: 9, Type: Uint32, WordSwap: true, Scale: 0.1},
+		Point{Name: "Temperature", Table: InputRegisterTable, Address: 0, Type: Float32},
+		Point{Name: "Running", Table: CoilTable, Address: 2, Type: Bool},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewMapping(Point{Name: "x", Table: CoilTable, Type: Uint16}); err == nil {
+		t.Errorf("register point in a bit table should be rejected")
+	}
+
+	h := &RegisterHandler{Coils: make([]bool, 4), Inputs: make([]uint16, 2), Holdings: make([]uint16, 12)}
+	ctx := context.Background()
+	if err := m.Set(ctx, h.DataStore(), "Temperature", 21.5); err != nil {
+		t.Fatal(err)
+	}
+
+	c := dialTestServer(t, h)
+	if err := c.WritePoint(ctx, 1, m, "MotorSpeed", 1500.3); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(h.Holdings[9:11], []uint16{15003, 0}) {
+		t.Errorf("MotorSpeed registers = %04X", h.Holdings[9:11])
+	}
+	if err := c.WritePoint(ctx, 1, m, "Running", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WritePoint(ctx, 1, m, "Temperature", 0); err == nil {
+		t.Errorf("input register point should not be writable")
+	}
+
+	if v, err := c.ReadPoint(ctx, 1, m, "Temperature"); err != nil || v != 21.5 {
+		t.Errorf("Temperature = %v, %v", v, err)
+	}
+	if v, err := m.Get(ctx, h.DataStore(), "MotorSpeed"); err != nil || math.Abs(v-1500.3) > 1e-9 {
+		t.Errorf("MotorSpeed = %v, %v", v, err)
+	}
+	if v, err := c.ReadPoint(ctx, 1, m, "Running"); err != nil || v != 1 {
+		t.Errorf("Running = %v, %v", v, err)
+	}
+	if _, err := c.ReadPoint(ctx, 1, m, "Pressure"); err == nil {
+		t.Errorf("unknown point should fail")
+	}
+}
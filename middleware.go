@@ -0,0 +1,58 @@
+package modbus
+
+// The HandlerFunc type is an adapter to allow the use of ordinary
+// functions as Modbus handlers.
+type HandlerFunc func(ResponseWriter, *Frame)
+
+// ServeModbus calls f(w, r).
+func (f HandlerFunc) ServeModbus(w ResponseWriter, r *Frame) {
+	f(w, r)
+}
+
+// ExceptionHandler returns a Handler answering every request with an
+// exception carrying code, e.g. to disable a unit or function under
+// maintenance.
+func ExceptionHandler(code ExceptionCode) Handler {
+	return HandlerFunc(func(w ResponseWriter, r *Frame) {
+		w.WriteException(code)
+	})
+}
+
+// NotFound replies to the request with a GatewayPathUnavailable
+// exception, as a ServeMux does for units it has no handler for.
+func NotFound(w ResponseWriter, r *Frame) { w.WriteException(GatewayPathUnavailable) }
+
+// NotFoundHandler returns a simple request handler that replies to each
+// request with a GatewayPathUnavailable exception.
+func NotFoundHandler() Handler { return HandlerFunc(NotFound) }
+
+// A Middleware wraps a Handler with cross cutting behaviour such as
+// logging, metrics, authentication or rate limiting. It returns a
+// Handler that usually calls the one it wraps, and may answer the
+// request itself instead.
+type Middleware func(Handler) Handler
+
+// Chain returns h wrapped by the middleware mw. The first middleware is
+// outermost: it sees each request first and the response last.
+func Chain(h Handler, mw ...Middleware) Handler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+	return h
+}
+
+// Use adds middleware wrapping the server's Handler, outermost first,
+// after any added earlier. It must be called before Serve.
+func (srv *Server) Use(mw ...Middleware) {
+	srv.middleware = append(srv.middleware, mw...)
+}
+
+// handler returns the Handler serving requests: srv.Handler, or
+// DefaultServeMux, wrapped by the middleware.
+func (srv *Server) handler() Handler {
+	h := srv.Handler
+	if h == nil {
+		h = DefaultServeMux
+	}
+	return Chain(h, srv.middleware...)
+}
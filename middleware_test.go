@@ -0,0 +1,69 @@
+package modbus
+
+import (
+	"bytes"
+	"sync/atomic"
+	"testing"
+)
+
+// tagMiddleware records the order in which middleware sees a request.
+func tagMiddleware(tag string, order *[]string) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, r *Frame) {
+			*order = append(*order, tag)
+			next.ServeModbus(w, r)
+		})
+	}
+}
+
+func TestServerUse(t *testing.T) {
+	req := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x03, 0x00, 0x00, 0x00, 0x01}
+	expected := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x05, 0xFF, 0x03, 0x02, 0x12, 0x34}
+
+	var order []string
+	srv := &Server{Handler: &RegisterHandler{Holdings: []uint16{0x1234}}}
+	srv.Use(tagMiddleware("outer", &order), tagMiddleware("inner", &order))
+	addr := startTestServer(t, srv)
+
+	if resp := exchange(t, addr, req, len(expected)); !bytes.Equal(resp, expected) {
+		t.Errorf("response % X; want % X", resp, expected)
+	}
+	if len(order) != 2 || order[0] != "outer" || order[1] != "inner" {
+		t.Errorf("middleware order %v", order)
+	}
+}
+
+func TestChainReject(t *testing.T) {
+	req := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x03, 0x00, 0x00, 0x00, 0x01}
+	expected := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x03, 0xFF, 0x83, byte(SlaveBusy)}
+
+	reject := func(next Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, r *Frame) {
+			w.WriteException(SlaveBusy)
+		})
+	}
+	h := &countingHandler{Handler: &RegisterHandler{Holdings: []uint16{0x1234}}}
+	addr := startTestServer(t, &Server{Handler: Chain(h, reject)})
+
+	if resp := exchange(t, addr, req, len(expected)); !bytes.Equal(resp, expected) {
+		t.Errorf("response % X; want % X", resp, expected)
+	}
+	if atomic.LoadInt32(&h.n) != 0 {
+		t.Errorf("rejected request reached the handler")
+	}
+}
+
+func TestExceptionHandler(t *testing.T) {
+	req := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x01}
+	busy := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x03, 0x01, 0x83, byte(SlaveBusy)}
+	notFound := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x03, 0x01, 0x83, byte(GatewayPathUnavailable)}
+
+	addr := startTestServer(t, &Server{Handler: ExceptionHandler(SlaveBusy)})
+	if resp := exchange(t, addr, req, len(busy)); !bytes.Equal(resp, busy) {
+		t.Errorf("response % X; want % X", resp, busy)
+	}
+	addr = startTestServer(t, &Server{Handler: NotFoundHandler()})
+	if resp := exchange(t, addr, req, len(notFound)); !bytes.Equal(resp, notFound) {
+		t.Errorf("response % X; want % X", resp, notFound)
+	}
+}
@@ -0,0 +1,302 @@
+package modbus
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// A MirrorStore is a DataStore replicating the writes made through it to
+// a remote slave, so that a local store in front of a slow device, such
+// as one on a serial line, answers masters at once while the device
+// follows. With Refresh set, reads also bring the local values up to
+// date with the device's.
+//
+// Writes of coils and holding registers are made to the local store,
+// then to the device: in the background, in the order made, unless Sync
+// is set. Writes of inputs, by the application, are not replicated.
+type MirrorStore struct {
+	DataStore
+
+	// Client and Unit address the device. The Client's Addressing does
+	// not apply: the addresses of a DataStore are protocol addresses.
+	Client *Client
+	Unit   uint8
+
+	// Sync causes writes to be made to the device before the local
+	// store, failing without effect if the device's write fails.
+	Sync bool
+
+	// Refresh, if positive, is the age beyond which values are read
+	// from the device before being served, and stored locally. Values
+	// are served from the local store as they are while writes are
+	// waiting to be replicated, and when refreshing fails.
+	Refresh time.Duration
+
+	// Timeout bounds each replicated write and refresh. If zero, 5s is
+	// used.
+	Timeout time.Duration
+
+	// OnError, if non nil, is called with the errors of writes made in
+	// the background and of refreshes.
+	OnError func(error)
+
+	mu        sync.Mutex    // guards the following, and local writes
+	pending   []mirrorWrite // writes to replicate, oldest first
+	busy      bool          // a worker is replicating pending
+	idle      chan struct{} // closed once pending is empty, if non nil
+	gen       uint64        // number of local writes made
+	refreshed map[Location]time.Time
+}
+
+// A mirrorWrite is a write to replicate: bits for coils, registers for
+// holding registers.
+type mirrorWrite struct {
+	table Table
+	addr  uint16
+	bits  []bool
+	regs  []uint16
+}
+
+// NewMirrorStore returns a MirrorStore serving local and replicating its
+// writes to unit uid through c.
+func NewMirrorStore(local DataStore, c *Client, uid uint8) *MirrorStore {
+	return &MirrorStore{DataStore: local, Client: c, Unit: uid}
+}
+
+func (s *MirrorStore) timeout() time.Duration {
+	if s.Timeout <= 0 {
+		return 5 * time.Second
+	}
+	return s.Timeout
+}
+
+func (s *MirrorStore) report(err error) {
+	if s.OnError != nil {
+		s.OnError(err)
+	}
+}
+
+func (s *MirrorStore) WriteCoils(ctx context.Context, addr uint16, values []bool) error {
+	w := mirrorWrite{table: CoilTable, addr: addr, bits: append([]bool(nil), values...)}
+	if s.Sync {
+		if err := s.replicate(ctx, w); err != nil {
+			return err
+		}
+	}
+	return s.write(w, func() error { return s.DataStore.WriteCoils(ctx, addr, values) })
+}
+
+func (s *MirrorStore) WriteHoldingRegisters(ctx context.Context, addr uint16, values []uint16) error {
+	w := mirrorWrite{table: HoldingRegisterTable, addr: addr, regs: append([]uint16(nil), values...)}
+	if s.Sync {
+		if err := s.replicate(ctx, w); err != nil {
+			return err
+		}
+	}
+	return s.write(w, func() error { return s.DataStore.WriteHoldingRegisters(ctx, addr, values) })
+}
+
+// write makes the local write of w with local, and queues w for
+// replication unless it was made to the device already.
+func (s *MirrorStore) write(w mirrorWrite, local func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gen++
+	if err := local(); err != nil {
+		return err
+	}
+	if !s.Sync {
+		s.enqueue(w)
+	}
+	return nil
+}
+
+// replicate makes w to the device. Its address is a protocol address,
+// as are those of the DataStore methods, so the Client's Addressing is
+// bypassed.
+func (s *MirrorStore) replicate(ctx context.Context, w mirrorWrite) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout())
+	defer cancel()
+	var req *Frame
+	switch uid := s.Unit; {
+	case w.table == CoilTable && len(w.bits) == 1:
+		req = NewWriteSingleCoilFrame(uid, w.addr, w.bits[0])
+	case w.table == CoilTable:
+		req = NewWriteMultipleCoilsFrame(uid, w.addr, w.bits)
+	case len(w.regs) == 1:
+		req = NewWriteSingleRegisterFrame(uid, w.addr, w.regs[0])
+	default:
+		req = NewWriteMultipleRegistersFrame(uid, w.addr, w.regs)
+	}
+	_, err := s.Client.send(ctx, req)
+	return err
+}
+
+// enqueue queues w for replication, starting a worker if none runs. It
+// is called with s.mu held.
+func (s *MirrorStore) enqueue(w mirrorWrite) {
+	s.pending = append(s.pending, w)
+	if !s.busy {
+		s.busy = true
+		go s.work()
+	}
+}
+
+// work replicates the pending writes until there are none.
+func (s *MirrorStore) work() {
+	for {
+		s.mu.Lock()
+		if len(s.pending) == 0 {
+			s.busy = false
+			if s.idle != nil {
+				close(s.idle)
+				s.idle = nil
+			}
+			s.mu.Unlock()
+			return
+		}
+		w := s.pending[0]
+		s.pending = s.pending[1:]
+		s.mu.Unlock()
+		if err := s.replicate(context.Background(), w); err != nil {
+			s.report(err)
+		}
+	}
+}
+
+// Pending returns the number of writes waiting to be replicated.
+func (s *MirrorStore) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(s.pending)
+	if s.busy {
+		n++ // being replicated
+	}
+	return n
+}
+
+// Flush waits for the writes made so far to be replicated, or for ctx
+// to be done.
+func (s *MirrorStore) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.busy {
+		s.mu.Unlock()
+		return nil
+	}
+	if s.idle == nil {
+		s.idle = make(chan struct{})
+	}
+	idle := s.idle
+	s.mu.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stale reports whether any of the quantity values of table t at addr
+// is due for a refresh, and the number of local writes made so far.
+func (s *MirrorStore) stale(t Table, addr, quantity uint16) (due bool, gen uint64) {
+	if s.Refresh <= 0 {
+		return false, 0
+	}
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.busy {
+		return false, 0 // the device lags the local values
+	}
+	for i := 0; i < int(quantity); i++ {
+		if at, ok := s.refreshed[Location{t, addr + uint16(i)}]; !ok || now.Sub(at) >= s.Refresh {
+			return true, s.gen
+		}
+	}
+	return false, 0
+}
+
+// refresh reads quantity values of table t at addr from the device and
+// stores them locally, unless local writes were made meanwhile, as the
+// values read may predate them. Failures are reported, leaving the local
+// values, and the values are read again on the next read.
+func (s *MirrorStore) refresh(ctx context.Context, t Table, addr, quantity uint16) {
+	due, gen := s.stale(t, addr, quantity)
+	if !due {
+		return
+	}
+	rctx, cancel := context.WithTimeout(ctx, s.timeout())
+	defer cancel()
+	values, err := s.Client.readProtocolTable(rctx, s.Unit, t, addr, quantity)
+	if err != nil {
+		s.report(err)
+		return
+	}
+	if len(values) > int(quantity) {
+		values = values[:quantity]
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.gen != gen || s.busy {
+		return
+	}
+	if err := ApplyPatch(ctx, s.DataStore, writeChanges(t, addr, values)); err != nil {
+		s.report(err)
+		return
+	}
+	if s.refreshed == nil {
+		s.refreshed = make(map[Location]time.Time)
+	}
+	now := time.Now()
+	for i := 0; i < int(quantity); i++ {
+		s.refreshed[Location{t, addr + uint16(i)}] = now
+	}
+}
+
+func (s *MirrorStore) ReadCoils(ctx context.Context, addr, quantity uint16) ([]bool, error) {
+	s.refresh(ctx, CoilTable, addr, quantity)
+	return s.DataStore.ReadCoils(ctx, addr, quantity)
+}
+
+func (s *MirrorStore) ReadDiscreteInputs(ctx context.Context, addr, quantity uint16) ([]bool, error) {
+	s.refresh(ctx, DiscreteInputTable, addr, quantity)
+	return s.DataStore.ReadDiscreteInputs(ctx, addr, quantity)
+}
+
+func (s *MirrorStore) ReadInputRegisters(ctx context.Context, addr, quantity uint16) ([]uint16, error) {
+	s.refresh(ctx, InputRegisterTable, addr, quantity)
+	return s.DataStore.ReadInputRegisters(ctx, addr, quantity)
+}
+
+func (s *MirrorStore) ReadHoldingRegisters(ctx context.Context, addr, quantity uint16) ([]uint16, error) {
+	s.refresh(ctx, HoldingRegisterTable, addr, quantity)
+	return s.DataStore.ReadHoldingRegisters(ctx, addr, quantity)
+}
+
+// WriteDiscreteInputs writes discrete inputs of the local store, if it
+// is an InputWriter.
+func (s *MirrorStore) WriteDiscreteInputs(ctx context.Context, addr uint16, values []bool) error {
+	iw, ok := s.DataStore.(InputWriter)
+	if !ok {
+		return ErrInputsReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gen++
+	return iw.WriteDiscreteInputs(ctx, addr, values)
+}
+
+// WriteInputRegisters writes input registers of the local store, if it
+// is an InputWriter.
+func (s *MirrorStore) WriteInputRegisters(ctx context.Context, addr uint16, values []uint16) error {
+	iw, ok := s.DataStore.(InputWriter)
+	if !ok {
+		return ErrInputsReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gen++
+	return iw.WriteInputRegisters(ctx, addr, values)
+}
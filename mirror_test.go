@@ -0,0 +1,173 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newMirrorLocal(t *testing.T) *SegmentedStore {
+	local := new(SegmentedStore)
+	for _, tbl := range []Table{CoilTable, InputRegisterTable, HoldingRegisterTable} {
+		if err := local.AddRange(tbl, 0, 8); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return local
+}
+
+func TestMirrorStore(t *testing.T) {
+	ctx := context.Background()
+	device := &RegisterHandler{Coils: make([]bool, 4), Holdings: make([]uint16, 4), Inputs: make([]uint16, 4)}
+	c := dialTestServer(t, device)
+
+	var mu sync.Mutex
+	var errs []error
+	s := NewMirrorStore(newMirrorLocal(t), c, 1)
+	s.OnError = func(err error) {
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+	}
+	if err := s.WriteHoldingRegisters(ctx, 1, []uint16{10, 11}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteCoils(ctx, 2, []bool{true}); err != nil {
+		t.Fatal(err)
+	}
+	// beyond the device's registers: written locally, failing remotely
+	if err := s.WriteHoldingRegisters(ctx, 6, []uint16{60}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if s.Pending() != 0 {
+		t.Errorf("Pending after Flush = %d", s.Pending())
+	}
+	if regs, _ := device.DataStore().ReadHoldingRegisters(ctx, 0, 4); !reflect.DeepEqual(regs, []uint16{0, 10, 11, 0}) {
+		t.Errorf("device registers = %v", regs)
+	}
+	if coils, _ := device.DataStore().ReadCoils(ctx, 0, 4); !reflect.DeepEqual(coils, []bool{false, false, true, false}) {
+		t.Errorf("device coils = %v", coils)
+	}
+	mu.Lock()
+	if len(errs) != 1 || !errors.Is(errs[0], ErrIllegalDataAddress) {
+		t.Errorf("errors reported = %v", errs)
+	}
+	mu.Unlock()
+
+	s.Sync = true
+	if err := s.WriteHoldingRegisters(ctx, 7, []uint16{70}); !errors.Is(err, ErrIllegalDataAddress) {
+		t.Errorf("synchronous write failing remotely = %v", err)
+	}
+	if regs, _ := s.ReadHoldingRegisters(ctx, 7, 1); regs[0] != 0 {
+		t.Errorf("local register after failed synchronous write = %v", regs)
+	}
+	if err := s.WriteHoldingRegisters(ctx, 3, []uint16{30}); err != nil {
+		t.Errorf("synchronous write = %v", err)
+	}
+	if regs, _ := device.DataStore().ReadHoldingRegisters(ctx, 3, 1); regs[0] != 30 {
+		t.Errorf("device register after synchronous write = %v", regs)
+	}
+}
+
+func TestMirrorStoreRefresh(t *testing.T) {
+	ctx := context.Background()
+	device := &RegisterHandler{Inputs: []uint16{1, 2, 3, 4}}
+	c := dialTestServer(t, device)
+	s := NewMirrorStore(newMirrorLocal(t), c, 1)
+	s.Refresh = 50 * time.Millisecond
+
+	if regs, err := s.ReadInputRegisters(ctx, 0, 4); err != nil || !reflect.DeepEqual(regs, []uint16{1, 2, 3, 4}) {
+		t.Errorf("ReadInputRegisters = %v, %v", regs, err)
+	}
+	device.DataStore().(InputWriter).WriteInputRegisters(ctx, 0, []uint16{5})
+	if regs, _ := s.ReadInputRegisters(ctx, 0, 1); regs[0] != 1 {
+		t.Errorf("ReadInputRegisters before Refresh elapsed = %v; want [1]", regs)
+	}
+	time.Sleep(s.Refresh)
+	if regs, _ := s.ReadInputRegisters(ctx, 0, 1); regs[0] != 5 {
+		t.Errorf("ReadInputRegisters after Refresh elapsed = %v; want [5]", regs)
+	}
+}
+
+func TestMirrorStoreRefreshConcurrentWrite(t *testing.T) {
+	ctx := context.Background()
+	device := &RegisterHandler{Holdings: []uint16{1}}
+	reading, proceed := make(chan bool), make(chan bool)
+	var reads int
+	c := dialTestServer(t, HandlerFunc(func(w ResponseWriter, r *Frame) {
+		if FunctionCode(r.Header().Fcode) == ReadHoldingRegisters {
+			switch reads++; reads {
+			case 1:
+				w.WriteException(SlaveFailure)
+				return
+			case 2:
+				reading <- true
+				<-proceed
+			}
+		}
+		device.ServeModbus(w, r)
+	}))
+	s := NewMirrorStore(newMirrorLocal(t), c, 1)
+	s.Refresh = time.Hour
+
+	// A failed refresh is retried on the next read.
+	if regs, _ := s.ReadHoldingRegisters(ctx, 0, 1); regs[0] != 0 {
+		t.Errorf("ReadHoldingRegisters after a failed refresh = %v; want [0]", regs)
+	}
+
+	// A write made while the device is read is not overwritten.
+	done := make(chan []uint16)
+	go func() {
+		regs, _ := s.ReadHoldingRegisters(ctx, 0, 1)
+		done <- regs
+	}()
+	<-reading
+	if err := s.WriteHoldingRegisters(ctx, 0, []uint16{5}); err != nil {
+		t.Fatal(err)
+	}
+	close(proceed)
+	if regs := <-done; regs[0] != 5 {
+		t.Errorf("ReadHoldingRegisters racing a write = %v; want [5]", regs)
+	}
+	if err := s.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if regs, _ := s.ReadHoldingRegisters(ctx, 0, 1); regs[0] != 5 {
+		t.Errorf("ReadHoldingRegisters after Flush = %v; want [5]", regs)
+	}
+	if regs, _ := device.DataStore().ReadHoldingRegisters(ctx, 0, 1); regs[0] != 5 {
+		t.Errorf("device register = %v; want [5]", regs)
+	}
+}
+
+func TestMirrorStoreClientAddressing(t *testing.T) {
+	ctx := context.Background()
+	device := &RegisterHandler{Coils: make([]bool, 4), Holdings: make([]uint16, 4), Inputs: []uint16{0, 7, 0, 0}}
+	c := dialTestServer(t, device)
+	c.Addressing = ModiconAddressing
+	s := NewMirrorStore(newMirrorLocal(t), c, 1)
+	s.Sync = true
+	s.Refresh = time.Hour
+
+	if err := s.WriteHoldingRegisters(ctx, 2, []uint16{20}); err != nil {
+		t.Errorf("WriteHoldingRegisters = %v", err)
+	}
+	if err := s.WriteCoils(ctx, 1, []bool{true, true}); err != nil {
+		t.Errorf("WriteCoils = %v", err)
+	}
+	if regs, _ := device.DataStore().ReadHoldingRegisters(ctx, 0, 4); !reflect.DeepEqual(regs, []uint16{0, 0, 20, 0}) {
+		t.Errorf("device registers = %v", regs)
+	}
+	if coils, _ := device.DataStore().ReadCoils(ctx, 0, 4); !reflect.DeepEqual(coils, []bool{false, true, true, false}) {
+		t.Errorf("device coils = %v", coils)
+	}
+	if regs, err := s.ReadInputRegisters(ctx, 1, 1); err != nil || regs[0] != 7 {
+		t.Errorf("ReadInputRegisters = %v, %v; want [7]", regs, err)
+	}
+}
@@ -0,0 +1,108 @@
+// Package modbustest provides utilities for testing Modbus handlers.
+package modbustest
+
+import (
+	"bytes"
+	"errors"
+
+	modbus "github.com/mubeta06/gomodbus"
+)
+
+// ErrNoResponse is returned by ResponseRecorder.Result when the handler
+// left the request unanswered.
+var ErrNoResponse = errors.New("modbustest: no response written")
+
+// A ResponseRecorder is a modbus.ResponseWriter recording the response
+// a Handler writes, for inspection in tests:
+//
+//	req := modbus.NewReadHoldingRegistersFrame(1, 0, 2)
+//	rec := modbustest.NewRecorder(req)
+//	handler.ServeModbus(rec, req)
+//	resp, err := rec.Result()
+type ResponseRecorder struct {
+	// Response is the header of the response, as the handler left it
+	// when it first wrote. Its Length is that of the whole body.
+	Response modbus.Header
+
+	// Body holds the data written, excluding the header.
+	Body *bytes.Buffer
+
+	// Written reports whether the handler wrote a response.
+	Written bool
+
+	// Flushed reports whether the handler called Flush.
+	Flushed bool
+
+	header modbus.Header // offered to the handler, initially the request's
+}
+
+// NewRecorder returns a ResponseRecorder for the response to req.
+func NewRecorder(req *modbus.Frame) *ResponseRecorder {
+	return &ResponseRecorder{Body: new(bytes.Buffer), header: *req.Header()}
+}
+
+// Header returns the header of the response, which is initially that of
+// the request.
+func (rw *ResponseRecorder) Header() *modbus.Header {
+	return &rw.header
+}
+
+// Write records data as part of the response body.
+func (rw *ResponseRecorder) Write(data []byte) (int, error) {
+	if !rw.Written {
+		rw.WriteHeader()
+	}
+	n, _ := rw.Body.Write(data)
+	rw.Response.Length = uint16(rw.Body.Len() + 2)
+	return n, nil
+}
+
+// WriteHeader records the current header as that of the response.
+func (rw *ResponseRecorder) WriteHeader() {
+	if rw.Written {
+		return
+	}
+	rw.Response = rw.header
+	rw.Response.Length = 2
+	rw.Written = true
+}
+
+// WriteException records an exception response carrying code. It fails,
+// as a Server's ResponseWriter does, if a response has already been
+// written.
+func (rw *ResponseRecorder) WriteException(code modbus.ExceptionCode) error {
+	if rw.Written {
+		return errors.New("modbustest: exception after response already written")
+	}
+	return modbus.WriteException(rw, code)
+}
+
+// Flush implements modbus.Flusher, setting Flushed.
+func (rw *ResponseRecorder) Flush() error {
+	rw.Flushed = true
+	return nil
+}
+
+// Exception returns the exception code of an exception response, and
+// false if the response is not one.
+func (rw *ResponseRecorder) Exception() (modbus.ExceptionCode, bool) {
+	if !rw.Written || rw.Response.Fcode&0x80 == 0 || rw.Body.Len() < 1 {
+		return 0, false
+	}
+	return modbus.ExceptionCode(rw.Body.Bytes()[0]), true
+}
+
+// Result returns the response written. An exception response is
+// returned along with a *modbus.ModbusError carrying its codes, so that
+// tests can use errors.Is with modbus.ErrIllegalDataAddress and the
+// like. If no response was written, Result returns ErrNoResponse.
+func (rw *ResponseRecorder) Result() (*modbus.Frame, error) {
+	if !rw.Written {
+		return nil, ErrNoResponse
+	}
+	resp := modbus.NewFrame(rw.Response, append([]byte(nil), rw.Body.Bytes()...))
+	if code, ok := rw.Exception(); ok {
+		return resp, &modbus.ModbusError{FunctionCode: modbus.FunctionCode(rw.Response.Fcode &^ 0x80), ExceptionCode: code}
+	}
+	return resp, nil
+}
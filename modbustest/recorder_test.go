@@ -0,0 +1,73 @@
+package modbustest
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	modbus "github.com/mubeta06/gomodbus"
+)
+
+func TestRecorder(t *testing.T) {
+	h := &modbus.RegisterHandler{Holdings: []uint16{0x1234, 0x5678}}
+
+	req := modbus.NewReadHoldingRegistersFrame(1, 0, 2)
+	rec := NewRecorder(req)
+	h.ServeModbus(rec, req)
+	resp, err := rec.Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{0x04, 0x12, 0x34, 0x56, 0x78}
+	if !bytes.Equal(resp.Data(), expected) {
+		t.Errorf("data % X; want % X", resp.Data(), expected)
+	}
+	if hdr := resp.Header(); modbus.FunctionCode(hdr.Fcode) != modbus.ReadHoldingRegisters || hdr.Uid != 1 ||
+		hdr.Tid != req.Header().Tid || hdr.Length != 7 {
+		t.Errorf("header %+v", *hdr)
+	}
+	if _, ok := rec.Exception(); ok {
+		t.Errorf("response reported as an exception")
+	}
+
+	req = modbus.NewReadHoldingRegistersFrame(1, 1, 2)
+	rec = NewRecorder(req)
+	h.ServeModbus(rec, req)
+	resp, err = rec.Result()
+	if !errors.Is(err, modbus.ErrIllegalDataAddress) {
+		t.Fatalf("err should be ErrIllegalDataAddress not %v", err)
+	}
+	var e *modbus.ModbusError
+	if !errors.As(err, &e) || e.FunctionCode != modbus.ReadHoldingRegisters {
+		t.Errorf("err %#v", err)
+	}
+	if resp.Header().Fcode != 0x83 || resp.Header().Length != 3 {
+		t.Errorf("exception header %+v", *resp.Header())
+	}
+	if code, ok := rec.Exception(); !ok || code != modbus.IllegalDataAddress {
+		t.Errorf("Exception = %v, %v", code, ok)
+	}
+}
+
+func TestRecorderNoResponse(t *testing.T) {
+	req := modbus.NewReadHoldingRegistersFrame(1, 0, 1)
+	rec := NewRecorder(req)
+	modbus.HandlerFunc(func(w modbus.ResponseWriter, r *modbus.Frame) {}).ServeModbus(rec, req)
+	if _, err := rec.Result(); err != ErrNoResponse {
+		t.Errorf("err should be ErrNoResponse not %v", err)
+	}
+}
+
+func TestRecorderPartialWrites(t *testing.T) {
+	req := modbus.NewReadHoldingRegistersFrame(1, 0, 1)
+	rec := NewRecorder(req)
+	rec.Write([]byte{0x02})
+	rec.Write([]byte{0x00, 0x07})
+	if err := rec.WriteException(modbus.SlaveFailure); err == nil {
+		t.Errorf("exception written after the response")
+	}
+	resp, err := rec.Result()
+	if err != nil || resp.Header().Length != 5 || !bytes.Equal(resp.Data(), []byte{0x02, 0x00, 0x07}) {
+		t.Errorf("Result = %+v % X, %v", *resp.Header(), resp.Data(), err)
+	}
+}
@@ -0,0 +1,165 @@
+package modbus
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// ServeMux is a Modbus request multiplexer. It matches the unit
+// identifier of each incoming request against a list of registered
+// units and calls the handler for the unit that matches.
+//
+// A ServeMux lets a single Server (typically a gateway) emulate several
+// devices. Requests addressed to a unit without a registered handler are
+// answered with a GatewayPathUnavailable exception.
+//
+// Unless a handler is registered for it, unit identifier 0 is the
+// broadcast address: write requests sent to it are executed by every
+// registered unit and, as the Modbus specification requires of
+// broadcasts, no response is sent.
+type ServeMux struct {
+	mu    sync.RWMutex
+	units map[uint8]*muxEntry
+}
+
+type muxEntry struct {
+	h Handler
+
+	mu    sync.Mutex // guards stats
+	stats UnitStats
+}
+
+// UnitStats holds the counters a ServeMux keeps for each registered unit.
+type UnitStats struct {
+	Requests    uint64    // number of requests dispatched to the unit
+	Exceptions  uint64    // number of those answered with an exception
+	LastRequest time.Time // time the most recent request was received, zero if never
+}
+
+// NewServeMux allocates and returns a new ServeMux.
+func NewServeMux() *ServeMux { return &ServeMux{units: make(map[uint8]*muxEntry)} }
+
+// DefaultServeMux is the default ServeMux used by Serve.
+var DefaultServeMux = NewServeMux()
+
+// Handle registers the handler for the given unit identifier.
+// If a handler already exists for uid, Handle panics.
+func (mux *ServeMux) Handle(uid uint8, handler Handler) {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+
+	if handler == nil {
+		panic("modbus: nil handler")
+	}
+	if _, ok := mux.units[uid]; ok {
+		panic("modbus: multiple registrations for unit")
+	}
+	mux.units[uid] = &muxEntry{h: handler}
+}
+
+// Handler returns the handler registered for uid, or nil if there is none.
+func (mux *ServeMux) Handler(uid uint8) Handler {
+	mux.mu.RLock()
+	defer mux.mu.RUnlock()
+
+	if e, ok := mux.units[uid]; ok {
+		return e.h
+	}
+	return nil
+}
+
+// BroadcastUid is the unit identifier addressing every unit.
+const BroadcastUid uint8 = 0
+
+// ServeModbus dispatches the request to the handler registered for the
+// request's unit identifier.
+func (mux *ServeMux) ServeModbus(w ResponseWriter, r *Frame) {
+	mux.mu.RLock()
+	e, ok := mux.units[r.header.Uid]
+	mux.mu.RUnlock()
+
+	if !ok && r.header.Uid == BroadcastUid && isWriteFunction(FunctionCode(r.header.Fcode)) {
+		mux.broadcast(r)
+		return
+	}
+	if !ok {
+		NotFound(w, r)
+		return
+	}
+	e.serve(w, r)
+}
+
+// broadcast executes r on every registered unit, discarding the
+// responses.
+func (mux *ServeMux) broadcast(r *Frame) {
+	for _, uid := range mux.Units() {
+		mux.mu.RLock()
+		e, ok := mux.units[uid]
+		mux.mu.RUnlock()
+		if !ok {
+			continue
+		}
+		req := *r // handlers may modify the header through Header
+		e.serve(&discardWriter{header: r.header}, &req)
+	}
+}
+
+func (e *muxEntry) serve(w ResponseWriter, r *Frame) {
+	now := time.Now()
+	e.h.ServeModbus(w, r)
+
+	e.mu.Lock()
+	e.stats.Requests++
+	if w.Header().Fcode&0x80 != 0 {
+		e.stats.Exceptions++
+	}
+	e.stats.LastRequest = now
+	e.mu.Unlock()
+}
+
+// discardWriter is the ResponseWriter of a broadcast request. It records
+// the header, so exceptions are still counted, and discards the rest.
+type discardWriter struct {
+	header Header
+}
+
+func (w *discardWriter) Header() *Header { return &w.header }
+
+func (w *discardWriter) Write(data []byte) (int, error) { return len(data), nil }
+
+func (w *discardWriter) WriteHeader() {}
+
+func (w *discardWriter) WriteException(code ExceptionCode) error { return WriteException(w, code) }
+
+// Stats returns the counters for the unit uid. The boolean result
+// reports whether a handler is registered for uid.
+func (mux *ServeMux) Stats(uid uint8) (UnitStats, bool) {
+	mux.mu.RLock()
+	e, ok := mux.units[uid]
+	mux.mu.RUnlock()
+
+	if !ok {
+		return UnitStats{}, false
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.stats, true
+}
+
+// Units returns the registered unit identifiers in ascending order.
+func (mux *ServeMux) Units() []uint8 {
+	mux.mu.RLock()
+	defer mux.mu.RUnlock()
+
+	uids := make([]uint8, 0, len(mux.units))
+	for uid := range mux.units {
+		uids = append(uids, uid)
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	return uids
+}
+
+// Handle registers the handler for the given unit identifier in the
+// DefaultServeMux.
+func Handle(uid uint8, handler Handler) { DefaultServeMux.Handle(uid, handler) }
@@ -0,0 +1,129 @@
+package modbus
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func TestServeMux(t *testing.T) {
+	req := []byte{0x00, 0x08, 0x00, 0x00, 0x00, 0x06, 0x02, 0x04, 0x00, 0x08, 0x00, 0x01}
+	expected := []byte{0x00, 0x08, 0x00, 0x00, 0x00, 0x05, 0x02, 0x04, 0x02, 0x00, 0x0A}
+
+	h := &RegisterHandler{}
+	h.Inputs = []uint16{0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x000A, 0x0}
+	mux := NewServeMux()
+	mux.Handle(0x02, h)
+
+	br := bufio.NewReader(bytes.NewReader(req))
+	bw := bytes.Buffer{}
+	r, _ := ReadFrame(br)
+	w := &testResponseWriter{req: r, w: bufio.NewWriter(&bw)}
+
+	mux.ServeModbus(w, r)
+	w.w.Flush()
+
+	if !bytes.Equal(bw.Bytes(), expected) {
+		t.Errorf("Incorrect Response")
+	}
+
+	stats, ok := mux.Stats(0x02)
+	if !ok {
+		t.Fatalf("unit 0x02 should be registered")
+	}
+	if stats.Requests != 1 || stats.Exceptions != 0 {
+		t.Errorf("stats should be 1 request 0 exceptions not %v %v", stats.Requests, stats.Exceptions)
+	}
+	if stats.LastRequest.IsZero() {
+		t.Errorf("last request time should be set")
+	}
+}
+
+func TestServeMuxExceptionStats(t *testing.T) {
+	req := []byte{0x00, 0x08, 0x00, 0x00, 0x00, 0x06, 0x02, 0x04, 0x00, 0x18, 0x00, 0x01}
+
+	mux := NewServeMux()
+	mux.Handle(0x02, &RegisterHandler{})
+
+	br := bufio.NewReader(bytes.NewReader(req))
+	bw := bytes.Buffer{}
+	r, _ := ReadFrame(br)
+	w := &testResponseWriter{req: r, w: bufio.NewWriter(&bw)}
+
+	mux.ServeModbus(w, r)
+	w.w.Flush()
+
+	stats, _ := mux.Stats(0x02)
+	if stats.Requests != 1 || stats.Exceptions != 1 {
+		t.Errorf("stats should be 1 request 1 exception not %v %v", stats.Requests, stats.Exceptions)
+	}
+}
+
+func TestServeMuxUnknownUnit(t *testing.T) {
+	req := []byte{0x00, 0x08, 0x00, 0x00, 0x00, 0x06, 0x07, 0x04, 0x00, 0x08, 0x00, 0x01}
+	expected := []byte{0x00, 0x08, 0x00, 0x00, 0x00, 0x03, 0x07, 0x84, byte(GatewayPathUnavailable)}
+
+	mux := NewServeMux()
+	mux.Handle(0x02, &RegisterHandler{})
+
+	br := bufio.NewReader(bytes.NewReader(req))
+	bw := bytes.Buffer{}
+	r, _ := ReadFrame(br)
+	w := &testResponseWriter{req: r, w: bufio.NewWriter(&bw)}
+
+	mux.ServeModbus(w, r)
+	w.w.Flush()
+
+	if !bytes.Equal(bw.Bytes(), expected) {
+		t.Errorf("Incorrect Response")
+	}
+
+	if _, ok := mux.Stats(0x07); ok {
+		t.Errorf("unit 0x07 should not be registered")
+	}
+}
+
+func TestServeMuxBroadcast(t *testing.T) {
+	write := []byte{0x00, 0x08, 0x00, 0x00, 0x00, 0x06, 0x00, 0x06, 0x00, 0x01, 0xBE, 0xEF}
+	read := []byte{0x00, 0x09, 0x00, 0x00, 0x00, 0x06, 0x00, 0x03, 0x00, 0x01, 0x00, 0x01}
+	readExpected := []byte{0x00, 0x09, 0x00, 0x00, 0x00, 0x03, 0x00, 0x83, byte(GatewayPathUnavailable)}
+
+	h1 := &RegisterHandler{Holdings: make([]uint16, 2)}
+	h2 := &RegisterHandler{Holdings: make([]uint16, 2)}
+	h3 := &RegisterHandler{Holdings: make([]uint16, 1)}
+	mux := NewServeMux()
+	mux.Handle(0x01, h1)
+	mux.Handle(0x02, h2)
+	mux.Handle(0x03, h3)
+
+	br := bufio.NewReader(bytes.NewReader(write))
+	bw := bytes.Buffer{}
+	r, _ := ReadFrame(br)
+	w := &testResponseWriter{req: r, w: bufio.NewWriter(&bw)}
+
+	mux.ServeModbus(w, r)
+	w.w.Flush()
+
+	if bw.Len() != 0 {
+		t.Errorf("broadcast should not be answered")
+	}
+	if h1.Holdings[1] != 0xBEEF || h2.Holdings[1] != 0xBEEF {
+		t.Errorf("broadcast write should reach every unit")
+	}
+	if stats, _ := mux.Stats(0x03); stats.Requests != 1 || stats.Exceptions != 1 {
+		t.Errorf("stats should be 1 request 1 exception not %v %v", stats.Requests, stats.Exceptions)
+	}
+
+	// reads cannot be broadcast
+	br = bufio.NewReader(bytes.NewReader(read))
+	bw.Reset()
+	r, _ = ReadFrame(br)
+	w = &testResponseWriter{req: r, w: bufio.NewWriter(&bw)}
+
+	mux.ServeModbus(w, r)
+	w.w.Flush()
+
+	if !bytes.Equal(bw.Bytes(), readExpected) {
+		t.Errorf("Incorrect Response")
+	}
+}
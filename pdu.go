@@ -0,0 +1,604 @@
+package modbus
+
+import (
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// A PDU is the function specific part of a Modbus request or response:
+// the bytes following the function code, as held in Frame.Data.
+//
+// UnmarshalBinary validates requests the way the Modbus application
+// protocol specification requires of a slave, returning an error
+// wrapping ErrIllegalDataValue for malformed requests, so that handlers
+// can reply with WriteError.
+type PDU interface {
+	FunctionCode() FunctionCode
+	encoding.BinaryMarshaler
+	encoding.BinaryUnmarshaler
+}
+
+// Limits on the number of values carried by a single request.
+const (
+	MaxReadBits       = 0x07D0
+	MaxReadRegisters  = 0x007D
+	MaxWriteBits      = 0x07B0
+	MaxWriteRegisters = 0x007B
+	MaxRWRegisters    = 0x0079 // registers written by Write And Read Registers
+)
+
+// NewPDUFrame returns a request frame for unit uid carrying p.
+func NewPDUFrame(uid uint8, p PDU) (*Frame, error) {
+	data, err := p.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return newRequestFrame(uid, p.FunctionCode(), data), nil
+}
+
+// newRequestPDU returns a zero request PDU for fcode, or nil if the
+// function code has no typed request.
+func newRequestPDU(fcode FunctionCode) PDU {
+	switch fcode {
+	case ReadCoils:
+		return new(ReadCoilsRequest)
+	case ReadDiscreteInputs:
+		return new(ReadDiscreteInputsRequest)
+	case ReadHoldingRegisters:
+		return new(ReadHoldingRegistersRequest)
+	case ReadInputRegisters:
+		return new(ReadInputRegistersRequest)
+	case WriteSingleCoil:
+		return new(WriteSingleCoilRequest)
+	case WriteSingleRegister:
+		return new(WriteSingleRegisterRequest)
+	case WriteMultipleCoils:
+		return new(WriteMultipleCoilsRequest)
+	case WriteMultipleRegisters:
+		return new(WriteMultipleRegistersRequest)
+	case MaskWriteRegister:
+		return new(MaskWriteRegisterRequest)
+	case WriteAndReadRegisters:
+		return new(WriteAndReadRegistersRequest)
+	}
+	return nil
+}
+
+// newResponsePDU returns a zero response PDU for fcode, or nil if the
+// function code has no typed response.
+func newResponsePDU(fcode FunctionCode) PDU {
+	switch fcode {
+	case ReadCoils:
+		return new(ReadCoilsResponse)
+	case ReadDiscreteInputs:
+		return new(ReadDiscreteInputsResponse)
+	case ReadHoldingRegisters:
+		return new(ReadHoldingRegistersResponse)
+	case ReadInputRegisters:
+		return new(ReadInputRegistersResponse)
+	case WriteSingleCoil:
+		return new(WriteSingleCoilResponse)
+	case WriteSingleRegister:
+		return new(WriteSingleRegisterResponse)
+	case WriteMultipleCoils:
+		return new(WriteMultipleCoilsResponse)
+	case WriteMultipleRegisters:
+		return new(WriteMultipleRegistersResponse)
+	case MaskWriteRegister:
+		return new(MaskWriteRegisterResponse)
+	case WriteAndReadRegisters:
+		return new(WriteAndReadRegistersResponse)
+	}
+	return nil
+}
+
+// decodePDU returns the typed PDU of f: its request if it is a well
+// formed request, else its response if it is a well formed response,
+// else nil. Some short responses are also well formed requests, so the
+// decoding of a frame whose direction is unknown is a best guess.
+func decodePDU(f *Frame) PDU {
+	for _, p := range []PDU{newRequestPDU(FunctionCode(f.header.Fcode)), newResponsePDU(FunctionCode(f.header.Fcode))} {
+		if p != nil && p.UnmarshalBinary(f.data) == nil {
+			return p
+		}
+	}
+	return nil
+}
+
+// writeTarget returns the table and address range modified by the write
+// request f. ok is false if f is not a well formed write request.
+func writeTarget(f *Frame) (t Table, addr, num uint16, ok bool) {
+	p := newRequestPDU(FunctionCode(f.header.Fcode))
+	if p == nil || p.UnmarshalBinary(f.data) != nil {
+		return 0, 0, 0, false
+	}
+	switch p := p.(type) {
+	case *WriteSingleCoilRequest:
+		return CoilTable, p.Addr, 1, true
+	case *WriteMultipleCoilsRequest:
+		return CoilTable, p.Addr, uint16(len(p.Values)), true
+	case *WriteSingleRegisterRequest:
+		return HoldingRegisterTable, p.Addr, 1, true
+	case *WriteMultipleRegistersRequest:
+		return HoldingRegisterTable, p.Addr, uint16(len(p.Values)), true
+	case *MaskWriteRegisterRequest:
+		return HoldingRegisterTable, p.Addr, 1, true
+	case *WriteAndReadRegistersRequest:
+		return HoldingRegisterTable, p.WriteAddr, uint16(len(p.Values)), true
+	}
+	return 0, 0, 0, false
+}
+
+func illegalValue(format string, args ...interface{}) error {
+	return fmt.Errorf("%s: %w", fmt.Sprintf(format, args...), ErrIllegalDataValue)
+}
+
+var errMalformedResponse = errors.New("modbus: malformed response")
+
+func encodeRegisters(b []byte, values []uint16) {
+	for i, v := range values {
+		binary.BigEndian.PutUint16(b[2*i:], v)
+	}
+}
+
+func decodeRegisters(b []byte) []uint16 {
+	values := make([]uint16, len(b)/2)
+	for i := range values {
+		values[i] = binary.BigEndian.Uint16(b[2*i:])
+	}
+	return values
+}
+
+// readRequest is the address / quantity pair of the read functions.
+type readRequest struct {
+	Addr     uint16
+	Quantity uint16
+}
+
+func (r *readRequest) marshal() ([]byte, error) {
+	return addrQuantity(r.Addr, r.Quantity), nil
+}
+
+func (r *readRequest) unmarshal(data []byte, max uint16) error {
+	if len(data) != 4 {
+		return illegalValue("modbus: read request length %d", len(data))
+	}
+	r.Addr = binary.BigEndian.Uint16(data[0:2])
+	r.Quantity = binary.BigEndian.Uint16(data[2:4])
+	if r.Quantity < 1 || r.Quantity > max {
+		return illegalValue("modbus: read quantity %d", r.Quantity)
+	}
+	return nil
+}
+
+// ReadCoilsRequest is the Read Coils (0x01) request.
+type ReadCoilsRequest struct {
+	Addr     uint16
+	Quantity uint16
+}
+
+func (r *ReadCoilsRequest) FunctionCode() FunctionCode { return ReadCoils }
+func (r *ReadCoilsRequest) MarshalBinary() ([]byte, error) {
+	return (*readRequest)(r).marshal()
+}
+func (r *ReadCoilsRequest) UnmarshalBinary(data []byte) error {
+	return (*readRequest)(r).unmarshal(data, MaxReadBits)
+}
+
+// ReadDiscreteInputsRequest is the Read Discrete Inputs (0x02) request.
+type ReadDiscreteInputsRequest struct {
+	Addr     uint16
+	Quantity uint16
+}
+
+func (r *ReadDiscreteInputsRequest) FunctionCode() FunctionCode { return ReadDiscreteInputs }
+func (r *ReadDiscreteInputsRequest) MarshalBinary() ([]byte, error) {
+	return (*readRequest)(r).marshal()
+}
+func (r *ReadDiscreteInputsRequest) UnmarshalBinary(data []byte) error {
+	return (*readRequest)(r).unmarshal(data, MaxReadBits)
+}
+
+// ReadHoldingRegistersRequest is the Read Holding Registers (0x03)
+// request.
+type ReadHoldingRegistersRequest struct {
+	Addr     uint16
+	Quantity uint16
+}
+
+func (r *ReadHoldingRegistersRequest) FunctionCode() FunctionCode { return ReadHoldingRegisters }
+func (r *ReadHoldingRegistersRequest) MarshalBinary() ([]byte, error) {
+	return (*readRequest)(r).marshal()
+}
+func (r *ReadHoldingRegistersRequest) UnmarshalBinary(data []byte) error {
+	return (*readRequest)(r).unmarshal(data, MaxReadRegisters)
+}
+
+// ReadInputRegistersRequest is the Read Input Registers (0x04) request.
+type ReadInputRegistersRequest struct {
+	Addr     uint16
+	Quantity uint16
+}
+
+func (r *ReadInputRegistersRequest) FunctionCode() FunctionCode { return ReadInputRegisters }
+func (r *ReadInputRegistersRequest) MarshalBinary() ([]byte, error) {
+	return (*readRequest)(r).marshal()
+}
+func (r *ReadInputRegistersRequest) UnmarshalBinary(data []byte) error {
+	return (*readRequest)(r).unmarshal(data, MaxReadRegisters)
+}
+
+// bitsResponse is the byte count prefixed bit field of the bit reads.
+// Values are packed exactly, eight to a byte, least significant bit
+// first, the unused high bits of the last byte being zero, as the
+// specification requires.
+type bitsResponse struct {
+	Values []bool
+}
+
+func (r *bitsResponse) marshal() ([]byte, error) {
+	b := PackBits(r.Values)
+	if len(b) > 0xFF {
+		return nil, errors.New("modbus: too many values")
+	}
+	return append([]byte{byte(len(b))}, b...), nil
+}
+
+func (r *bitsResponse) unmarshal(data []byte) error {
+	if len(data) < 1 || int(data[0]) != len(data)-1 {
+		return errMalformedResponse
+	}
+	r.Values = UnpackBits(data[1:], 8*(len(data)-1))
+	return nil
+}
+
+// ReadCoilsResponse is the Read Coils (0x01) response. Values are
+// marshalled exactly, and unmarshalled as a multiple of 8 coils; the
+// caller truncates them to the quantity requested.
+type ReadCoilsResponse struct {
+	Values []bool
+}
+
+func (r *ReadCoilsResponse) FunctionCode() FunctionCode { return ReadCoils }
+func (r *ReadCoilsResponse) MarshalBinary() ([]byte, error) {
+	return (*bitsResponse)(r).marshal()
+}
+func (r *ReadCoilsResponse) UnmarshalBinary(data []byte) error {
+	return (*bitsResponse)(r).unmarshal(data)
+}
+
+// ReadDiscreteInputsResponse is the Read Discrete Inputs (0x02) response.
+// Values are marshalled exactly, and unmarshalled as a multiple of 8
+// inputs; the caller truncates them to the quantity requested.
+type ReadDiscreteInputsResponse struct {
+	Values []bool
+}
+
+func (r *ReadDiscreteInputsResponse) FunctionCode() FunctionCode { return ReadDiscreteInputs }
+func (r *ReadDiscreteInputsResponse) MarshalBinary() ([]byte, error) {
+	return (*bitsResponse)(r).marshal()
+}
+func (r *ReadDiscreteInputsResponse) UnmarshalBinary(data []byte) error {
+	return (*bitsResponse)(r).unmarshal(data)
+}
+
+// registersResponse is the byte count prefixed register list of the
+// register reads.
+type registersResponse struct {
+	Values []uint16
+}
+
+func (r *registersResponse) marshal() ([]byte, error) {
+	if 2*len(r.Values) > 0xFF {
+		return nil, errors.New("modbus: too many values")
+	}
+	data := make([]byte, 1+2*len(r.Values))
+	data[0] = byte(2 * len(r.Values))
+	encodeRegisters(data[1:], r.Values)
+	return data, nil
+}
+
+func (r *registersResponse) unmarshal(data []byte) error {
+	if len(data) < 1 || int(data[0]) != len(data)-1 || data[0]%2 != 0 {
+		return errMalformedResponse
+	}
+	r.Values = decodeRegisters(data[1:])
+	return nil
+}
+
+// ReadHoldingRegistersResponse is the Read Holding Registers (0x03)
+// response.
+type ReadHoldingRegistersResponse struct {
+	Values []uint16
+}
+
+func (r *ReadHoldingRegistersResponse) FunctionCode() FunctionCode { return ReadHoldingRegisters }
+func (r *ReadHoldingRegistersResponse) MarshalBinary() ([]byte, error) {
+	return (*registersResponse)(r).marshal()
+}
+func (r *ReadHoldingRegistersResponse) UnmarshalBinary(data []byte) error {
+	return (*registersResponse)(r).unmarshal(data)
+}
+
+// ReadInputRegistersResponse is the Read Input Registers (0x04) response.
+type ReadInputRegistersResponse struct {
+	Values []uint16
+}
+
+func (r *ReadInputRegistersResponse) FunctionCode() FunctionCode { return ReadInputRegisters }
+func (r *ReadInputRegistersResponse) MarshalBinary() ([]byte, error) {
+	return (*registersResponse)(r).marshal()
+}
+func (r *ReadInputRegistersResponse) UnmarshalBinary(data []byte) error {
+	return (*registersResponse)(r).unmarshal(data)
+}
+
+// WriteSingleCoilRequest is the Write Single Coil (0x05) request. The
+// response echoes the request.
+type WriteSingleCoilRequest struct {
+	Addr  uint16
+	Value bool
+}
+
+// WriteSingleCoilResponse is the Write Single Coil (0x05) response.
+type WriteSingleCoilResponse = WriteSingleCoilRequest
+
+func (r *WriteSingleCoilRequest) FunctionCode() FunctionCode { return WriteSingleCoil }
+
+func (r *WriteSingleCoilRequest) MarshalBinary() ([]byte, error) {
+	var v uint16
+	if r.Value {
+		v = 0xFF00
+	}
+	return addrQuantity(r.Addr, v), nil
+}
+
+func (r *WriteSingleCoilRequest) UnmarshalBinary(data []byte) error {
+	if len(data) != 4 {
+		return illegalValue("modbus: write single coil length %d", len(data))
+	}
+	r.Addr = binary.BigEndian.Uint16(data[0:2])
+	switch v := binary.BigEndian.Uint16(data[2:4]); v {
+	case 0xFF00:
+		r.Value = true
+	case 0x0000:
+		r.Value = false
+	default:
+		return illegalValue("modbus: coil value 0x%04X", v)
+	}
+	return nil
+}
+
+// WriteSingleRegisterRequest is the Write Single Register (0x06) request.
+// The response echoes the request.
+type WriteSingleRegisterRequest struct {
+	Addr  uint16
+	Value uint16
+}
+
+// WriteSingleRegisterResponse is the Write Single Register (0x06)
+// response.
+type WriteSingleRegisterResponse = WriteSingleRegisterRequest
+
+func (r *WriteSingleRegisterRequest) FunctionCode() FunctionCode { return WriteSingleRegister }
+
+func (r *WriteSingleRegisterRequest) MarshalBinary() ([]byte, error) {
+	return addrQuantity(r.Addr, r.Value), nil
+}
+
+func (r *WriteSingleRegisterRequest) UnmarshalBinary(data []byte) error {
+	if len(data) != 4 {
+		return illegalValue("modbus: write single register length %d", len(data))
+	}
+	r.Addr = binary.BigEndian.Uint16(data[0:2])
+	r.Value = binary.BigEndian.Uint16(data[2:4])
+	return nil
+}
+
+// WriteMultipleCoilsRequest is the Write Multiple Coils (0x0F) request.
+type WriteMultipleCoilsRequest struct {
+	Addr   uint16
+	Values []bool
+}
+
+func (r *WriteMultipleCoilsRequest) FunctionCode() FunctionCode { return WriteMultipleCoils }
+
+func (r *WriteMultipleCoilsRequest) MarshalBinary() ([]byte, error) {
+	b := PackBits(r.Values)
+	if len(b) > 0xFF {
+		return nil, errors.New("modbus: too many values")
+	}
+	data := append(addrQuantity(r.Addr, uint16(len(r.Values))), byte(len(b)))
+	return append(data, b...), nil
+}
+
+func (r *WriteMultipleCoilsRequest) UnmarshalBinary(data []byte) error {
+	if len(data) < 6 {
+		return illegalValue("modbus: write multiple coils length %d", len(data))
+	}
+	r.Addr = binary.BigEndian.Uint16(data[0:2])
+	num := binary.BigEndian.Uint16(data[2:4])
+	if num < 1 || num > MaxWriteBits {
+		return illegalValue("modbus: write quantity %d", num)
+	}
+	nb := int(data[4])
+	if nb != (int(num)+7)/8 || len(data) != 5+nb {
+		return illegalValue("modbus: write multiple coils byte count %d", nb)
+	}
+	r.Values = UnpackBits(data[5:], int(num))
+	return nil
+}
+
+// WriteMultipleRegistersRequest is the Write Multiple Registers (0x10)
+// request.
+type WriteMultipleRegistersRequest struct {
+	Addr   uint16
+	Values []uint16
+}
+
+func (r *WriteMultipleRegistersRequest) FunctionCode() FunctionCode { return WriteMultipleRegisters }
+
+func (r *WriteMultipleRegistersRequest) MarshalBinary() ([]byte, error) {
+	if 2*len(r.Values) > 0xFF {
+		return nil, errors.New("modbus: too many values")
+	}
+	data := make([]byte, 5+2*len(r.Values))
+	copy(data, addrQuantity(r.Addr, uint16(len(r.Values))))
+	data[4] = byte(2 * len(r.Values))
+	encodeRegisters(data[5:], r.Values)
+	return data, nil
+}
+
+func (r *WriteMultipleRegistersRequest) UnmarshalBinary(data []byte) error {
+	if len(data) < 7 {
+		return illegalValue("modbus: write multiple registers length %d", len(data))
+	}
+	r.Addr = binary.BigEndian.Uint16(data[0:2])
+	num := binary.BigEndian.Uint16(data[2:4])
+	if num < 1 || num > MaxWriteRegisters {
+		return illegalValue("modbus: write quantity %d", num)
+	}
+	nb := int(data[4])
+	if nb != 2*int(num) || len(data) != 5+nb {
+		return illegalValue("modbus: write multiple registers byte count %d", nb)
+	}
+	r.Values = decodeRegisters(data[5:])
+	return nil
+}
+
+// writeMultipleResponse is the address / quantity acknowledgement of the
+// multiple write functions.
+type writeMultipleResponse struct {
+	Addr     uint16
+	Quantity uint16
+}
+
+func (r *writeMultipleResponse) unmarshal(data []byte) error {
+	if len(data) != 4 {
+		return errMalformedResponse
+	}
+	r.Addr = binary.BigEndian.Uint16(data[0:2])
+	r.Quantity = binary.BigEndian.Uint16(data[2:4])
+	return nil
+}
+
+// WriteMultipleCoilsResponse is the Write Multiple Coils (0x0F) response.
+type WriteMultipleCoilsResponse struct {
+	Addr     uint16
+	Quantity uint16
+}
+
+func (r *WriteMultipleCoilsResponse) FunctionCode() FunctionCode { return WriteMultipleCoils }
+func (r *WriteMultipleCoilsResponse) MarshalBinary() ([]byte, error) {
+	return addrQuantity(r.Addr, r.Quantity), nil
+}
+func (r *WriteMultipleCoilsResponse) UnmarshalBinary(data []byte) error {
+	return (*writeMultipleResponse)(r).unmarshal(data)
+}
+
+// WriteMultipleRegistersResponse is the Write Multiple Registers (0x10)
+// response.
+type WriteMultipleRegistersResponse struct {
+	Addr     uint16
+	Quantity uint16
+}
+
+func (r *WriteMultipleRegistersResponse) FunctionCode() FunctionCode { return WriteMultipleRegisters }
+func (r *WriteMultipleRegistersResponse) MarshalBinary() ([]byte, error) {
+	return addrQuantity(r.Addr, r.Quantity), nil
+}
+func (r *WriteMultipleRegistersResponse) UnmarshalBinary(data []byte) error {
+	return (*writeMultipleResponse)(r).unmarshal(data)
+}
+
+// MaskWriteRegisterRequest is the Mask Write Register (0x16) request. The
+// response echoes the request.
+type MaskWriteRegisterRequest struct {
+	Addr    uint16
+	AndMask uint16
+	OrMask  uint16
+}
+
+// MaskWriteRegisterResponse is the Mask Write Register (0x16) response.
+type MaskWriteRegisterResponse = MaskWriteRegisterRequest
+
+func (r *MaskWriteRegisterRequest) FunctionCode() FunctionCode { return MaskWriteRegister }
+
+func (r *MaskWriteRegisterRequest) MarshalBinary() ([]byte, error) {
+	return append(addrQuantity(r.Addr, r.AndMask), byte(r.OrMask>>8), byte(r.OrMask)), nil
+}
+
+func (r *MaskWriteRegisterRequest) UnmarshalBinary(data []byte) error {
+	if len(data) != 6 {
+		return illegalValue("modbus: mask write register length %d", len(data))
+	}
+	r.Addr = binary.BigEndian.Uint16(data[0:2])
+	r.AndMask = binary.BigEndian.Uint16(data[2:4])
+	r.OrMask = binary.BigEndian.Uint16(data[4:6])
+	return nil
+}
+
+// Apply returns the result of applying the masks to value.
+func (r *MaskWriteRegisterRequest) Apply(value uint16) uint16 {
+	return (value & r.AndMask) | (r.OrMask &^ r.AndMask)
+}
+
+// WriteAndReadRegistersRequest is the Read/Write Multiple Registers
+// (0x17) request. Values are written at WriteAddr before ReadQuantity
+// registers are read at ReadAddr.
+type WriteAndReadRegistersRequest struct {
+	ReadAddr     uint16
+	ReadQuantity uint16
+	WriteAddr    uint16
+	Values       []uint16
+}
+
+// WriteAndReadRegistersResponse is the Read/Write Multiple Registers
+// (0x17) response.
+type WriteAndReadRegistersResponse struct {
+	Values []uint16
+}
+
+func (r *WriteAndReadRegistersRequest) FunctionCode() FunctionCode { return WriteAndReadRegisters }
+
+func (r *WriteAndReadRegistersRequest) MarshalBinary() ([]byte, error) {
+	if 2*len(r.Values) > 0xFF {
+		return nil, errors.New("modbus: too many values")
+	}
+	data := make([]byte, 9+2*len(r.Values))
+	copy(data, addrQuantity(r.ReadAddr, r.ReadQuantity))
+	copy(data[4:], addrQuantity(r.WriteAddr, uint16(len(r.Values))))
+	data[8] = byte(2 * len(r.Values))
+	encodeRegisters(data[9:], r.Values)
+	return data, nil
+}
+
+func (r *WriteAndReadRegistersRequest) UnmarshalBinary(data []byte) error {
+	if len(data) < 11 {
+		return illegalValue("modbus: write and read registers length %d", len(data))
+	}
+	r.ReadAddr = binary.BigEndian.Uint16(data[0:2])
+	r.ReadQuantity = binary.BigEndian.Uint16(data[2:4])
+	r.WriteAddr = binary.BigEndian.Uint16(data[4:6])
+	wnum := binary.BigEndian.Uint16(data[6:8])
+	nb := int(data[8])
+	if r.ReadQuantity < 1 || r.ReadQuantity > MaxReadRegisters ||
+		wnum < 1 || wnum > MaxRWRegisters || nb != 2*int(wnum) {
+		return illegalValue("modbus: write and read quantities %d/%d", r.ReadQuantity, wnum)
+	}
+	if len(data) != 9+nb {
+		return illegalValue("modbus: write and read registers byte count %d", nb)
+	}
+	r.Values = decodeRegisters(data[9:])
+	return nil
+}
+
+func (r *WriteAndReadRegistersResponse) FunctionCode() FunctionCode { return WriteAndReadRegisters }
+func (r *WriteAndReadRegistersResponse) MarshalBinary() ([]byte, error) {
+	return (*registersResponse)(r).marshal()
+}
+func (r *WriteAndReadRegistersResponse) UnmarshalBinary(data []byte) error {
+	return (*registersResponse)(r).unmarshal(data)
+}
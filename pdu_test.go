@@ -0,0 +1,106 @@
+package modbus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestPDURoundTrip(t *testing.T) {
+	for _, tt := range []struct {
+		p        PDU
+		decoded  PDU
+		expected []byte
+	}{
+		{&ReadHoldingRegistersRequest{Addr: 0x6B, Quantity: 3}, new(ReadHoldingRegistersRequest),
+			[]byte{0x00, 0x6B, 0x00, 0x03}},
+		{&ReadHoldingRegistersResponse{Values: []uint16{0x022B, 0x0001, 0x0064}}, new(ReadHoldingRegistersResponse),
+			[]byte{0x06, 0x02, 0x2B, 0x00, 0x01, 0x00, 0x64}},
+		{&ReadCoilsResponse{Values: []bool{true, false, true, true, false, false, true, true, true, false}}, new(ReadCoilsResponse),
+			[]byte{0x02, 0xCD, 0x01}},
+		{&ReadDiscreteInputsResponse{Values: []bool{false, true}}, new(ReadDiscreteInputsResponse),
+			[]byte{0x01, 0x02}},
+		{&WriteSingleCoilRequest{Addr: 0x0A, Value: true}, new(WriteSingleCoilRequest),
+			[]byte{0x00, 0x0A, 0xFF, 0x00}},
+		{&WriteMultipleRegistersRequest{Addr: 0x6B, Values: []uint16{0x022B, 0x0001, 0x0064}}, new(WriteMultipleRegistersRequest),
+			[]byte{0x00, 0x6B, 0x00, 0x03, 0x06, 0x02, 0x2B, 0x00, 0x01, 0x00, 0x64}},
+		{&MaskWriteRegisterRequest{Addr: 0x04, AndMask: 0x00F2, OrMask: 0x0025}, new(MaskWriteRegisterRequest),
+			[]byte{0x00, 0x04, 0x00, 0xF2, 0x00, 0x25}},
+		{&WriteAndReadRegistersRequest{ReadAddr: 0x6B, ReadQuantity: 3, WriteAddr: 0x6C, Values: []uint16{0x0102, 0x0304}},
+			new(WriteAndReadRegistersRequest),
+			[]byte{0x00, 0x6B, 0x00, 0x03, 0x00, 0x6C, 0x00, 0x02, 0x04, 0x01, 0x02, 0x03, 0x04}},
+	} {
+		data, err := tt.p.MarshalBinary()
+		if err != nil {
+			t.Fatalf("err should be nil not %v", err)
+		}
+		if !bytes.Equal(data, tt.expected) {
+			t.Errorf("Incorrect encoding % X", data)
+		}
+		if err := tt.decoded.UnmarshalBinary(data); err != nil {
+			t.Fatalf("err should be nil not %v", err)
+		}
+		again, _ := tt.decoded.MarshalBinary()
+		if !bytes.Equal(again, tt.expected) {
+			t.Errorf("Incorrect round trip % X", again)
+		}
+	}
+}
+
+func TestPDUValidation(t *testing.T) {
+	for _, tt := range []struct {
+		p    PDU
+		data []byte
+	}{
+		{new(ReadCoilsRequest), []byte{0x00, 0x13, 0x07, 0xD1}},
+		{new(ReadInputRegistersRequest), []byte{0x00, 0x08, 0x00, 0x00}},
+		{new(ReadHoldingRegistersRequest), []byte{0x00, 0x08, 0x00}},
+		{new(WriteSingleCoilRequest), []byte{0x00, 0x0A, 0xFF, 0x01}},
+		{new(WriteMultipleCoilsRequest), []byte{0x00, 0x13, 0x00, 0x25, 0x04, 0xCD, 0x6B, 0xB2, 0x0E}},
+		{new(WriteMultipleRegistersRequest), []byte{0x00, 0x6B, 0x00, 0x02, 0x06, 0x02, 0x2B, 0x00, 0x01, 0x00, 0x64}},
+	} {
+		if err := tt.p.UnmarshalBinary(tt.data); !errors.Is(err, ErrIllegalDataValue) {
+			t.Errorf("%T should fail with ErrIllegalDataValue not %v", tt.p, err)
+		}
+	}
+}
+
+// The Reflect benchmarks encode with binary.Read and binary.Write, as
+// the codecs once did, for comparison.
+
+func BenchmarkEncodeRegisters(b *testing.B) {
+	values := make([]uint16, MaxReadRegisters)
+	buf := make([]byte, 2*len(values))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		encodeRegisters(buf, values)
+	}
+}
+
+func BenchmarkEncodeRegistersReflect(b *testing.B) {
+	values := make([]uint16, MaxReadRegisters)
+	var buf bytes.Buffer
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		binary.Write(&buf, binary.BigEndian, values)
+	}
+}
+
+func BenchmarkDecodeRegisters(b *testing.B) {
+	data := make([]byte, 2*MaxReadRegisters)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		decodeRegisters(data)
+	}
+}
+
+func BenchmarkDecodeRegistersReflect(b *testing.B) {
+	data := make([]byte, 2*MaxReadRegisters)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		values := make([]uint16, MaxReadRegisters)
+		binary.Read(bytes.NewReader(data), binary.BigEndian, values)
+	}
+}
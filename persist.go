@@ -0,0 +1,400 @@
+package modbus
+
+import (
+	"bufio"
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// walMagic is the additional data authenticating sealed write-ahead log
+// records, so that they cannot be passed off as snapshots.
+var walMagic = []byte("MBWAL1\n")
+
+// PersistOptions configure a PersistentStore.
+type PersistOptions struct {
+	// Coils and HoldingRegisters are the number of coils and holding
+	// registers persisted, from address 0. They must exist in the
+	// wrapped store.
+	Coils            int
+	HoldingRegisters int
+
+	// Interval is the time between snapshots of a store written to
+	// since the last one. If zero, snapshots are taken only by Snapshot
+	// and Close.
+	Interval time.Duration
+
+	// WAL makes every write to coils and holding registers append to a
+	// write-ahead log, synced to disk before the write is applied, so
+	// that no acknowledged write is lost to a crash. Without it, writes
+	// since the last snapshot are lost.
+	WAL bool
+
+	// Key, if non nil, encrypts the snapshot and log as SaveImage does.
+	Key []byte
+}
+
+// A PersistentStore is a DataStore keeping the coils and holding
+// registers of the DataStore it wraps in a file, so that a software
+// slave keeps them across restarts. The file holds a snapshot, as
+// written by SaveImage, and the write-ahead log, if any, is kept next to
+// it with a ".wal" suffix.
+//
+// Discrete inputs and input registers, being the application's to set,
+// are not persisted.
+type PersistentStore struct {
+	DataStore
+
+	path string
+	opts PersistOptions
+	aead cipher.AEAD // seals log records, nil without a key
+
+	mu     sync.Mutex
+	wal    *os.File
+	dirty  bool // written to since the last snapshot
+	closed bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// A walRecord is a write made to a PersistentStore. Coils are 0 or 1.
+type walRecord struct {
+	Table  Table    `json:"table"`
+	Addr   uint16   `json:"addr"`
+	Values []uint16 `json:"values"`
+}
+
+// OpenPersistentStore returns a PersistentStore wrapping s, persisted to
+// the file at path. It first restores s from the file and its log, if
+// they exist, then records a fresh snapshot. A log whose last record
+// was cut short by a crash is accepted; the write it held was never
+// acknowledged.
+//
+// Close must be called when the store is no longer served, typically as
+// the server shuts down, to take a final snapshot.
+func OpenPersistentStore(ctx context.Context, s DataStore, path string, opts PersistOptions) (*PersistentStore, error) {
+	if opts.Coils < 0 || opts.Coils > 0xFFFF || opts.HoldingRegisters < 0 || opts.HoldingRegisters > 0xFFFF {
+		return nil, errors.New("modbus: persisted table size out of range")
+	}
+	ps := &PersistentStore{DataStore: s, path: path, opts: opts}
+	if opts.Key != nil {
+		aead, err := snapshotAEAD(opts.Key)
+		if err != nil {
+			return nil, err
+		}
+		ps.aead = aead
+	}
+	if err := ps.restore(ctx); err != nil {
+		return nil, err
+	}
+	if err := ps.replay(ctx); err != nil {
+		return nil, err
+	}
+	if opts.WAL {
+		f, err := os.OpenFile(ps.walPath(), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, err
+		}
+		ps.wal = f
+	}
+	if err := ps.Snapshot(ctx); err != nil {
+		ps.closeWAL()
+		return nil, err
+	}
+	if opts.Interval > 0 {
+		ps.stop, ps.done = make(chan struct{}), make(chan struct{})
+		go ps.snapshotLoop()
+	}
+	return ps, nil
+}
+
+func (ps *PersistentStore) walPath() string { return ps.path + ".wal" }
+
+// restore writes the snapshot at ps.path, if any, to the wrapped store.
+func (ps *PersistentStore) restore(ctx context.Context) error {
+	f, err := os.Open(ps.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	img, err := LoadImage(f, ps.opts.Key)
+	if err != nil {
+		return err
+	}
+	if len(img.Coils) > 0 {
+		if err := ps.DataStore.WriteCoils(ctx, 0, img.Coils); err != nil {
+			return err
+		}
+	}
+	if len(img.HoldingRegisters) > 0 {
+		if err := ps.DataStore.WriteHoldingRegisters(ctx, 0, img.HoldingRegisters); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replay applies the records of the log, if any, to the wrapped store.
+func (ps *PersistentStore) replay(ctx context.Context) error {
+	f, err := os.Open(ps.walPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	for {
+		var n [4]byte
+		if _, err := io.ReadFull(r, n[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			return err
+		}
+		data := make([]byte, binary.BigEndian.Uint32(n[:]))
+		if _, err := io.ReadFull(r, data); err != nil {
+			if err == io.ErrUnexpectedEOF || err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		rec, err := ps.openRecord(data)
+		if err != nil {
+			return err
+		}
+		if err := ps.apply(ctx, rec); err != nil {
+			return err
+		}
+	}
+}
+
+func (ps *PersistentStore) apply(ctx context.Context, rec *walRecord) error {
+	switch rec.Table {
+	case CoilTable:
+		values := make([]bool, len(rec.Values))
+		for i, v := range rec.Values {
+			values[i] = v != 0
+		}
+		return ps.DataStore.WriteCoils(ctx, rec.Addr, values)
+	case HoldingRegisterTable:
+		return ps.DataStore.WriteHoldingRegisters(ctx, rec.Addr, rec.Values)
+	}
+	return errors.New("modbus: write-ahead log record for a read-only table")
+}
+
+func (ps *PersistentStore) sealRecord(rec *walRecord) ([]byte, error) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	if ps.aead != nil {
+		nonce := make([]byte, ps.aead.NonceSize(), ps.aead.NonceSize()+len(data)+ps.aead.Overhead())
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+		data = ps.aead.Seal(nonce, nonce, data, walMagic)
+	}
+	out := make([]byte, 4, 4+len(data))
+	binary.BigEndian.PutUint32(out, uint32(len(data)))
+	return append(out, data...), nil
+}
+
+func (ps *PersistentStore) openRecord(data []byte) (*walRecord, error) {
+	if ps.aead != nil {
+		if len(data) < ps.aead.NonceSize() {
+			return nil, ErrSnapshotAuth
+		}
+		nonce, sealed := data[:ps.aead.NonceSize()], data[ps.aead.NonceSize():]
+		var err error
+		if data, err = ps.aead.Open(nil, nonce, sealed, walMagic); err != nil {
+			return nil, ErrSnapshotAuth
+		}
+	}
+	rec := new(walRecord)
+	if err := json.Unmarshal(data, rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// log appends rec to the log and syncs it. ps.mu must be held.
+func (ps *PersistentStore) log(rec *walRecord) error {
+	if ps.wal == nil {
+		return nil
+	}
+	data, err := ps.sealRecord(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := ps.wal.Write(data); err != nil {
+		return err
+	}
+	return ps.wal.Sync()
+}
+
+func (ps *PersistentStore) WriteCoils(ctx context.Context, addr uint16, values []bool) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.wal != nil {
+		rec := &walRecord{Table: CoilTable, Addr: addr, Values: make([]uint16, len(values))}
+		for i, v := range values {
+			if v {
+				rec.Values[i] = 1
+			}
+		}
+		if err := ps.log(rec); err != nil {
+			return err
+		}
+	}
+	if err := ps.DataStore.WriteCoils(ctx, addr, values); err != nil {
+		return err
+	}
+	ps.dirty = true
+	return nil
+}
+
+func (ps *PersistentStore) WriteHoldingRegisters(ctx context.Context, addr uint16, values []uint16) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if err := ps.log(&walRecord{Table: HoldingRegisterTable, Addr: addr, Values: values}); err != nil {
+		return err
+	}
+	if err := ps.DataStore.WriteHoldingRegisters(ctx, addr, values); err != nil {
+		return err
+	}
+	ps.dirty = true
+	return nil
+}
+
+func (ps *PersistentStore) WriteDiscreteInputs(ctx context.Context, addr uint16, values []bool) error {
+	iw, ok := ps.DataStore.(InputWriter)
+	if !ok {
+		return ErrInputsReadOnly
+	}
+	return iw.WriteDiscreteInputs(ctx, addr, values)
+}
+
+func (ps *PersistentStore) WriteInputRegisters(ctx context.Context, addr uint16, values []uint16) error {
+	iw, ok := ps.DataStore.(InputWriter)
+	if !ok {
+		return ErrInputsReadOnly
+	}
+	return iw.WriteInputRegisters(ctx, addr, values)
+}
+
+// Snapshot writes the persisted tables to the file, replacing it
+// atomically, and empties the log.
+func (ps *PersistentStore) Snapshot(ctx context.Context) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.snapshot(ctx)
+}
+
+// snapshot is Snapshot with ps.mu held.
+func (ps *PersistentStore) snapshot(ctx context.Context) error {
+	img := new(Image)
+	var err error
+	if n := ps.opts.Coils; n > 0 {
+		if img.Coils, err = ps.DataStore.ReadCoils(ctx, 0, uint16(n)); err != nil {
+			return err
+		}
+	}
+	if n := ps.opts.HoldingRegisters; n > 0 {
+		if img.HoldingRegisters, err = ps.DataStore.ReadHoldingRegisters(ctx, 0, uint16(n)); err != nil {
+			return err
+		}
+	}
+
+	tmp := ps.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	err = SaveImage(f, img, ps.opts.Key)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, ps.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	// the log is replayed over the new snapshot should a crash come
+	// before it is emptied, which rewrites the same values
+	if ps.wal != nil {
+		if err := ps.wal.Truncate(0); err != nil {
+			return err
+		}
+	} else if err := os.Remove(ps.walPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	ps.dirty = false
+	return nil
+}
+
+func (ps *PersistentStore) snapshotLoop() {
+	defer close(ps.done)
+	t := time.NewTicker(ps.opts.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			ps.mu.Lock()
+			if ps.dirty {
+				ps.snapshot(context.Background())
+			}
+			ps.mu.Unlock()
+		case <-ps.stop:
+			return
+		}
+	}
+}
+
+func (ps *PersistentStore) closeWAL() error {
+	if ps.wal == nil {
+		return nil
+	}
+	err := ps.wal.Close()
+	ps.wal = nil
+	return err
+}
+
+// Close stops the periodic snapshots, takes a final snapshot and closes
+// the log. Writes made afterwards are not persisted.
+func (ps *PersistentStore) Close() error {
+	if ps.stop != nil {
+		close(ps.stop)
+		<-ps.done
+		ps.stop = nil
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.closed {
+		return nil
+	}
+	ps.closed = true
+	err := ps.snapshot(context.Background())
+	if cerr := ps.closeWAL(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,127 @@
+package modbus
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func openTestStore(t *testing.T, path string, opts PersistOptions) (*PersistentStore, *RegisterHandler) {
+	t.Helper()
+	h := &RegisterHandler{Coils: make([]bool, 8), Holdings: make([]uint16, 4)}
+	ps, err := OpenPersistentStore(context.Background(), sliceStore{h}, path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ps, h
+}
+
+func TestPersistentStoreRestore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "state")
+	key := bytes.Repeat([]byte{0x42}, 16)
+	opts := PersistOptions{Coils: 8, HoldingRegisters: 4, Key: key}
+
+	ps, _ := openTestStore(t, path, opts)
+	if err := ps.WriteHoldingRegisters(ctx, 1, []uint16{0x1234, 0x5678}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.WriteCoils(ctx, 2, []bool{true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("holding_registers")) {
+		t.Errorf("encrypted snapshot readable: %q", data)
+	}
+
+	ps, h := openTestStore(t, path, opts)
+	defer ps.Close()
+	if want := []uint16{0, 0x1234, 0x5678, 0}; !reflect.DeepEqual(h.Holdings, want) {
+		t.Errorf("restored holdings %04X; want %04X", h.Holdings, want)
+	}
+	if !h.Coils[2] || h.Coils[1] {
+		t.Errorf("restored coils %v", h.Coils)
+	}
+}
+
+func TestPersistentStoreWAL(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	for _, key := range [][]byte{nil, bytes.Repeat([]byte{0x42}, 32)} {
+		path := filepath.Join(dir, "state")
+		os.Remove(path)
+		os.Remove(path + ".wal")
+		opts := PersistOptions{Coils: 8, HoldingRegisters: 4, WAL: true, Key: key}
+
+		// the store is abandoned without Close, as by a crash
+		crashed, _ := openTestStore(t, path, opts)
+		if err := crashed.WriteHoldingRegisters(ctx, 0, []uint16{1, 2}); err != nil {
+			t.Fatal(err)
+		}
+		if err := crashed.WriteHoldingRegisters(ctx, 1, []uint16{7}); err != nil {
+			t.Fatal(err)
+		}
+		if err := crashed.WriteCoils(ctx, 7, []bool{true}); err != nil {
+			t.Fatal(err)
+		}
+		crashed.closeWAL()
+
+		// a record cut short by the crash is ignored
+		f, err := os.OpenFile(path+".wal", os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte{0, 0, 0, 40, '{'})
+		f.Close()
+
+		ps, h := openTestStore(t, path, opts)
+		if want := []uint16{1, 7, 0, 0}; !reflect.DeepEqual(h.Holdings, want) {
+			t.Errorf("key %v: recovered holdings %v; want %v", key != nil, h.Holdings, want)
+		}
+		if !h.Coils[7] {
+			t.Errorf("key %v: recovered coils %v", key != nil, h.Coils)
+		}
+		if fi, err := os.Stat(path + ".wal"); err != nil || fi.Size() != 0 {
+			t.Errorf("key %v: log not emptied after recovery: %v, %v", key != nil, fi, err)
+		}
+		ps.Close()
+	}
+}
+
+func TestPersistentStoreInterval(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "state")
+	ps, _ := openTestStore(t, path, PersistOptions{HoldingRegisters: 4, Interval: 10 * time.Millisecond})
+	defer ps.Close()
+	if err := ps.WriteHoldingRegisters(ctx, 3, []uint16{99}); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := LoadImage(f, nil)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(img.HoldingRegisters) == 4 && img.HoldingRegisters[3] == 99 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("snapshot holds %v", img.HoldingRegisters)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,196 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A PollGroup is a set of addresses of one table of a unit read together
+// at a fixed interval. Addresses are documented addresses under the
+// Addressing of the Poller's Client.
+type PollGroup struct {
+	Unit      uint8
+	Table     Table
+	Addresses []uint16
+	Interval  time.Duration
+
+	// Handler, if non nil, receives the group's results. Otherwise they
+	// are sent on the Poller's C.
+	Handler func(PollResult)
+}
+
+// A PollResult holds the values read for a PollGroup in one interval.
+type PollResult struct {
+	Group *PollGroup
+	Time  time.Time // time the poll started
+
+	// Values maps each address of the group to its value; coils and
+	// discrete inputs have the values 0 and 1. If Err is non nil,
+	// Values holds the addresses read before the failure.
+	Values map[uint16]uint16
+
+	// Err is the first error met. Exception responses are reported as
+	// a *ModbusError.
+	Err error
+}
+
+// A Poller reads groups of addresses from slaves at regular intervals.
+// The addresses of a group are coalesced into as few read requests as
+// possible.
+type Poller struct {
+	Client *Client
+
+	// MaxGap is the number of unwanted addresses a single request may
+	// read to avoid splitting a group into two requests.
+	MaxGap uint16
+
+	// Retries is the number of times a read failing for reasons other
+	// than an exception response is retried within an interval.
+	Retries int
+
+	// C delivers the results of groups without a Handler.
+	C <-chan PollResult
+
+	c chan PollResult
+
+	mu      sync.Mutex
+	groups  []*PollGroup
+	running bool
+}
+
+// NewPoller returns a Poller using c.
+func NewPoller(c *Client) *Poller {
+	ch := make(chan PollResult, 16)
+	return &Poller{Client: c, C: ch, c: ch}
+}
+
+// Add adds the group g. It panics if called after Run.
+func (p *Poller) Add(g *PollGroup) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.running {
+		panic("modbus: PollGroup added to running Poller")
+	}
+	if g.Interval <= 0 {
+		panic("modbus: non-positive PollGroup interval")
+	}
+	p.groups = append(p.groups, g)
+}
+
+// Run polls every group until ctx is done, then returns ctx.Err(). Reads
+// are made with PriorityLow unless ctx carries a Priority.
+func (p *Poller) Run(ctx context.Context) error {
+	if _, ok := ctx.Value(priorityKey{}).(Priority); !ok {
+		ctx = WithPriority(ctx, PriorityLow)
+	}
+	p.mu.Lock()
+	p.running = true
+	groups := p.groups
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, g := range groups {
+		wg.Add(1)
+		go func(g *PollGroup) {
+			defer wg.Done()
+			p.runGroup(ctx, g)
+		}(g)
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+func (p *Poller) runGroup(ctx context.Context, g *PollGroup) {
+	reads := coalesce(g.Addresses, p.MaxGap, maxPollQuantity(g.Table))
+	ticker := time.NewTicker(g.Interval)
+	defer ticker.Stop()
+	for {
+		res := p.poll(ctx, g, reads)
+		if ctx.Err() != nil {
+			return
+		}
+		if g.Handler != nil {
+			g.Handler(res)
+		} else {
+			select {
+			case p.c <- res:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// poll performs the reads of one interval.
+func (p *Poller) poll(ctx context.Context, g *PollGroup, reads []pollRead) PollResult {
+	res := PollResult{Group: g, Time: time.Now(), Values: make(map[uint16]uint16)}
+	for _, r := range reads {
+		values, err := p.read(ctx, g, r)
+		if err != nil {
+			res.Err = err
+			return res
+		}
+		for _, addr := range r.wanted {
+			res.Values[addr] = values[addr-r.addr]
+		}
+	}
+	return res
+}
+
+func (p *Poller) read(ctx context.Context, g *PollGroup, r pollRead) (values []uint16, err error) {
+	for attempt := 0; ; attempt++ {
+		values, err = p.Client.readTable(ctx, g.Unit, g.Table, r.addr, r.quantity)
+		var e *ModbusError
+		if err == nil || errors.As(err, &e) || attempt >= p.Retries || ctx.Err() != nil {
+			return values, err
+		}
+	}
+}
+
+// A pollRead is a single read request covering some of a group's
+// addresses.
+type pollRead struct {
+	addr, quantity uint16
+	wanted         []uint16
+}
+
+func maxPollQuantity(t Table) int {
+	if t == CoilTable || t == DiscreteInputTable {
+		return MaxReadBits
+	}
+	return MaxReadRegisters
+}
+
+// coalesce returns the fewest reads of at most max addresses covering
+// addrs, reading across gaps of at most maxGap unwanted addresses.
+func coalesce(addrs []uint16, maxGap uint16, max int) []pollRead {
+	sorted := append([]uint16(nil), addrs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var reads []pollRead
+	for i, addr := range sorted {
+		if i > 0 && addr == sorted[i-1] {
+			continue
+		}
+		if n := len(reads); n > 0 {
+			r := &reads[n-1]
+			last := int(r.addr) + int(r.quantity) - 1
+			if int(addr)-last-1 <= int(maxGap) && int(addr)-int(r.addr)+1 <= max {
+				r.quantity = addr - r.addr + 1
+				r.wanted = append(r.wanted, addr)
+				continue
+			}
+		}
+		reads = append(reads, pollRead{addr: addr, quantity: 1, wanted: []uint16{addr}})
+	}
+	return reads
+}
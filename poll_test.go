@@ -0,0 +1,86 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCoalesce(t *testing.T) {
+	reads := coalesce([]uint16{9, 1, 2, 2, 5, 200}, 2, MaxReadRegisters)
+	expected := []pollRead{
+		{addr: 1, quantity: 5, wanted: []uint16{1, 2, 5}},
+		{addr: 9, quantity: 1, wanted: []uint16{9}},
+		{addr: 200, quantity: 1, wanted: []uint16{200}},
+	}
+	if !reflect.DeepEqual(reads, expected) {
+		t.Errorf("coalesce = %+v; want %+v", reads, expected)
+	}
+
+	if reads := coalesce([]uint16{0, 124, 125}, 200, MaxReadRegisters); len(reads) != 2 || reads[0].quantity != 125 {
+		t.Errorf("reads should be split at the maximum quantity: %+v", reads)
+	}
+}
+
+// countingHandler counts the requests reaching a Handler.
+type countingHandler struct {
+	Handler
+	n int32
+}
+
+func (h *countingHandler) ServeModbus(w ResponseWriter, r *Frame) {
+	atomic.AddInt32(&h.n, 1)
+	h.Handler.ServeModbus(w, r)
+}
+
+func TestPoller(t *testing.T) {
+	h := &countingHandler{Handler: &RegisterHandler{
+		Coils:    []bool{false, true},
+		Holdings: []uint16{10, 11, 12, 13, 14, 15},
+	}}
+	p := NewPoller(dialTestServer(t, h))
+	p.MaxGap = 2
+
+	registers := &PollGroup{Unit: 1, Table: HoldingRegisterTable, Addresses: []uint16{0, 2, 5}, Interval: time.Hour}
+	coils := &PollGroup{Unit: 1, Table: CoilTable, Addresses: []uint16{1}, Interval: time.Hour}
+	missing := &PollGroup{Unit: 1, Table: HoldingRegisterTable, Addresses: []uint16{4, 40}, Interval: time.Hour}
+	p.Add(registers)
+	p.Add(coils)
+	p.Add(missing)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan error)
+	go func() { done <- p.Run(ctx) }()
+
+	for i := 0; i < 3; i++ {
+		res := <-p.C
+		switch res.Group {
+		case registers:
+			if res.Err != nil || !reflect.DeepEqual(res.Values, map[uint16]uint16{0: 10, 2: 12, 5: 15}) {
+				t.Errorf("registers = %v, %v", res.Values, res.Err)
+			}
+		case coils:
+			if res.Err != nil || !reflect.DeepEqual(res.Values, map[uint16]uint16{1: 1}) {
+				t.Errorf("coils = %v, %v", res.Values, res.Err)
+			}
+		case missing:
+			if !errors.Is(res.Err, ErrIllegalDataAddress) {
+				t.Errorf("missing error = %v; want %v", res.Err, ErrIllegalDataAddress)
+			}
+			if !reflect.DeepEqual(res.Values, map[uint16]uint16{4: 14}) {
+				t.Errorf("missing = %v", res.Values)
+			}
+		}
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Run = %v; want %v", err, context.Canceled)
+	}
+	if n := atomic.LoadInt32(&h.n); n != 4 {
+		t.Errorf("%d requests; want 4", n)
+	}
+}
@@ -0,0 +1,275 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// A ClientPool is a RoundTripper keeping a number of connections to a
+// single Modbus TCP slave. Failed connections are redialled with
+// exponential backoff, and reads that fail because their connection broke
+// or timed out are retried on another connection, so long running
+// pollers need not handle dial loops themselves. Writes are never
+// retried, as the slave may have executed them.
+//
+// The exported fields must not be changed after the first call to
+// RoundTrip.
+type ClientPool struct {
+	// Addr is the TCP address of the slave.
+	Addr string
+
+	// Size is the number of connections kept. If zero, 1 is used.
+	Size int
+
+	// Dial, if non nil, is used to open connections instead of a
+	// net.Dialer, e.g. the DialConn method of a Dialer.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// ConnWrapper, if non nil, wraps every connection dialled.
+	ConnWrapper ConnWrapper
+
+	// MinBackoff and MaxBackoff bound the delay before a connection is
+	// redialled after failed attempts. The delay doubles with every
+	// consecutive failure. If zero, 100ms and 30s are used.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// ReadRetries is the number of times a read is retried. If zero, 2
+	// is used; if negative, reads are not retried.
+	ReadRetries int
+
+	// MaxInFlight, MaxOvertakes, Timeout, NextTid, Lenient and
+	// ErrorLog configure each connection, see ClientConn. NextTid is
+	// shared by the connections.
+	MaxInFlight  int
+	MaxOvertakes int
+	Timeout      time.Duration
+	NextTid      func() uint16
+	Lenient      bool
+	ErrorLog     *log.Logger
+
+	once  sync.Once
+	slots []*poolSlot
+
+	mu     sync.Mutex // guards the following
+	next   int        // slot to try first
+	closed bool
+}
+
+type poolSlot struct {
+	mu       sync.Mutex // guards the following, held while dialling
+	cc       *ClientConn
+	failures int       // consecutive failed dials
+	retryAt  time.Time // earliest time of the next dial
+}
+
+func (p *ClientPool) init() {
+	p.once.Do(func() {
+		n := p.Size
+		if n <= 0 {
+			n = 1
+		}
+		p.slots = make([]*poolSlot, n)
+		for i := range p.slots {
+			p.slots[i] = new(poolSlot)
+		}
+	})
+}
+
+func (p *ClientPool) backoff(failures int) time.Duration {
+	min, max := p.MinBackoff, p.MaxBackoff
+	if min <= 0 {
+		min = 100 * time.Millisecond
+	}
+	if max <= 0 {
+		max = 30 * time.Second
+	}
+	d := min
+	for i := 1; i < failures && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+func (p *ClientPool) dial(ctx context.Context) (conn net.Conn, err error) {
+	if trace := ContextClientTrace(ctx); trace != nil {
+		if trace.ConnectStart != nil {
+			trace.ConnectStart("tcp", p.Addr)
+		}
+		if trace.ConnectDone != nil {
+			defer func() { trace.ConnectDone("tcp", p.Addr, err) }()
+		}
+	}
+	if p.Dial != nil {
+		return p.Dial(ctx, "tcp", p.Addr)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", p.Addr)
+}
+
+// isRetryable reports whether a request with function code fcode may be
+// sent again after an unknown outcome.
+func isRetryable(fcode FunctionCode) bool {
+	switch fcode {
+	case ReadCoils, ReadDiscreteInputs, ReadHoldingRegisters, ReadInputRegisters,
+		ReadExceptionStatus, ReportSlaveId:
+		return true
+	}
+	return false
+}
+
+// RoundTrip sends req over one of the pool's connections, dialling it if
+// needed, and retries it on failure if it is a read.
+func (p *ClientPool) RoundTrip(ctx context.Context, req *Frame) (*Frame, error) {
+	p.init()
+	retries := p.ReadRetries
+	if retries == 0 {
+		retries = 2
+	}
+	if !isRetryable(FunctionCode(req.header.Fcode)) {
+		retries = 0
+	}
+
+	for attempt := 0; ; attempt++ {
+		slot, cc, err := p.conn(ctx)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := cc.RoundTrip(ctx, req)
+		if err == nil {
+			return resp, nil
+		}
+		if ctx.Err() == nil && !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, context.Canceled) {
+			// the connection is broken, or in an unknown state; a
+			// caller giving up leaves it usable by the others
+			p.discard(slot, cc)
+		}
+		if attempt >= retries || ctx.Err() != nil {
+			return nil, err
+		}
+	}
+}
+
+// conn returns an open connection, dialling a slot whose backoff has
+// expired if none is open. It waits for the earliest backoff to expire
+// when every slot is backing off.
+func (p *ClientPool) conn(ctx context.Context) (*poolSlot, *ClientConn, error) {
+	var lastErr error
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, nil, ErrClientConnClosed
+		}
+		start := p.next
+		p.next = (p.next + 1) % len(p.slots)
+		p.mu.Unlock()
+
+		var wake time.Time
+		for i := range p.slots {
+			slot := p.slots[(start+i)%len(p.slots)]
+			cc, retryAt, err := p.slotConn(ctx, slot)
+			if cc != nil {
+				return slot, cc, nil
+			}
+			if err != nil {
+				lastErr = err
+			}
+			if wake.IsZero() || retryAt.Before(wake) {
+				wake = retryAt
+			}
+		}
+
+		timer := time.NewTimer(time.Until(wake))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			if lastErr != nil {
+				return nil, nil, lastErr
+			}
+			return nil, nil, ctx.Err()
+		}
+	}
+}
+
+// slotConn returns the slot's connection, dialling it if its backoff
+// has expired. Otherwise it returns the time the next dial is due and
+// the error of a dial it just made.
+func (p *ClientPool) slotConn(ctx context.Context, slot *poolSlot) (*ClientConn, time.Time, error) {
+	slot.mu.Lock()
+	defer slot.mu.Unlock()
+	if slot.cc != nil {
+		return slot.cc, time.Time{}, nil
+	}
+	if now := time.Now(); now.Before(slot.retryAt) {
+		return nil, slot.retryAt, nil
+	}
+
+	conn, err := p.dial(ctx)
+	if err == nil && p.ConnWrapper != nil {
+		var wrapped net.Conn
+		if wrapped, err = p.ConnWrapper(conn); err != nil {
+			conn.Close()
+		}
+		conn = wrapped
+	}
+	if err != nil {
+		slot.failures++
+		slot.retryAt = time.Now().Add(p.backoff(slot.failures))
+		return nil, slot.retryAt, err
+	}
+	cc := NewClientConn(conn)
+	cc.MaxInFlight = p.MaxInFlight
+	cc.MaxOvertakes = p.MaxOvertakes
+	cc.Timeout = p.Timeout
+	cc.NextTid = p.NextTid
+	cc.Lenient = p.Lenient
+	cc.ErrorLog = p.ErrorLog
+
+	p.mu.Lock()
+	closed := p.closed
+	p.mu.Unlock()
+	if closed {
+		cc.Close()
+		return nil, time.Time{}, ErrClientConnClosed
+	}
+	slot.cc = cc
+	slot.failures = 0
+	return cc, time.Time{}, nil
+}
+
+// discard closes cc and clears it from slot so that it is redialled.
+func (p *ClientPool) discard(slot *poolSlot, cc *ClientConn) {
+	slot.mu.Lock()
+	defer slot.mu.Unlock()
+	if slot.cc == cc {
+		slot.cc = nil
+	}
+	cc.Close()
+}
+
+// Close closes the pool's connections. Later calls to RoundTrip fail
+// with ErrClientConnClosed.
+func (p *ClientPool) Close() error {
+	p.init()
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	for _, slot := range p.slots {
+		slot.mu.Lock()
+		if slot.cc != nil {
+			slot.cc.Close()
+			slot.cc = nil
+		}
+		slot.mu.Unlock()
+	}
+	return nil
+}
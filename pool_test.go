@@ -0,0 +1,149 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// trackingListener records the connections it accepts.
+type trackingListener struct {
+	net.Listener
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func (l *trackingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err == nil {
+		l.mu.Lock()
+		l.conns = append(l.conns, c)
+		l.mu.Unlock()
+	}
+	return c, err
+}
+
+// closeAll drops every accepted connection, imitating a network failure.
+func (l *trackingListener) closeAll() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, c := range l.conns {
+		c.Close()
+	}
+	l.conns = nil
+}
+
+func TestClientPoolRetriesReads(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	l := &trackingListener{Listener: ln}
+	t.Cleanup(func() { l.Close() })
+	go (&Server{Handler: &RegisterHandler{Holdings: []uint16{0x1234}}}).Serve(l)
+
+	pool := &ClientPool{Addr: ln.Addr().String(), Size: 2, MinBackoff: time.Millisecond}
+	c := &Client{Transport: pool}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 0; i < 3; i++ {
+		values, err := c.ReadHoldingRegisters(ctx, 1, 0, 1)
+		if err != nil {
+			t.Fatalf("read %d: %v", i, err)
+		}
+		if values[0] != 0x1234 {
+			t.Errorf("read %d = 0x%04X", i, values[0])
+		}
+		l.closeAll()
+	}
+
+	c.Close()
+	if _, err := c.ReadHoldingRegisters(ctx, 1, 0, 1); !errors.Is(err, ErrClientConnClosed) {
+		t.Errorf("read after Close = %v; want %v", err, ErrClientConnClosed)
+	}
+}
+
+func TestClientPoolBackoff(t *testing.T) {
+	var dials int32
+	refused := errors.New("connection refused")
+	pool := &ClientPool{
+		Addr: "slave:502",
+		Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			atomic.AddInt32(&dials, 1)
+			return nil, refused
+		},
+		MinBackoff: 20 * time.Millisecond,
+		MaxBackoff: 40 * time.Millisecond,
+	}
+	defer pool.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+	if _, err := pool.RoundTrip(ctx, NewReadHoldingRegistersFrame(1, 0, 1)); err != refused {
+		t.Errorf("RoundTrip = %v; want %v", err, refused)
+	}
+	// dials at 0, 20, 60, 100 and 140ms
+	if n := atomic.LoadInt32(&dials); n < 3 || n > 6 {
+		t.Errorf("%d dials within 150ms; want about 5", n)
+	}
+	if d := pool.backoff(10); d != 40*time.Millisecond {
+		t.Errorf("backoff = %v; want MaxBackoff", d)
+	}
+}
+
+func TestClientPoolCancelKeepsConn(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	l := &trackingListener{Listener: ln}
+	t.Cleanup(func() { l.Close() })
+	entered, release := make(chan bool, 1), make(chan bool)
+	h := &RegisterHandler{Holdings: []uint16{0x1234}}
+	go (&Server{Handler: HandlerFunc(func(w ResponseWriter, r *Frame) {
+		if r.Header().Uid == 2 {
+			entered <- true
+			<-release
+		}
+		h.ServeModbus(w, r)
+	})}).Serve(l)
+
+	pool := &ClientPool{Addr: ln.Addr().String(), MaxInFlight: 2}
+	c := &Client{Transport: pool}
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := make(chan error)
+	go func() {
+		_, err := c.ReadHoldingRegisters(ctx, 2, 0, 1)
+		cancelled <- err
+	}()
+	<-entered
+	done := make(chan error)
+	go func() {
+		values, err := c.ReadHoldingRegisters(context.Background(), 1, 0, 1)
+		if err == nil && values[0] != 0x1234 {
+			err = errors.New("wrong value")
+		}
+		done <- err
+	}()
+	cancel()
+	if err := <-cancelled; !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled read = %v", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("concurrent read = %v", err)
+	}
+	l.mu.Lock()
+	if n := len(l.conns); n != 1 {
+		t.Errorf("%d connections dialled; want 1", n)
+	}
+	l.mu.Unlock()
+}
@@ -0,0 +1,153 @@
+package modbus
+
+import (
+	"context"
+	"sync"
+)
+
+// A Priority is the class of a transaction, deciding the order in which
+// transactions waiting for a ClientConn are sent. Operator initiated
+// writes, for example, can be given PriorityHigh so that they are not
+// held up behind background polling.
+type Priority uint8
+
+const (
+	PriorityLow    Priority = iota // background work, such as a Poller's reads
+	PriorityNormal                 // the priority of contexts without one
+	PriorityHigh                   // interactive work, such as operator writes
+
+	numPriorities = int(PriorityHigh) + 1
+)
+
+type priorityKey struct{}
+
+// WithPriority returns a copy of ctx carrying the priority p. Transactions
+// made with the context are queued according to p by ClientConn, and
+// hence by ClientPool and SharedClient.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// priorityFrom returns the priority carried by ctx, or PriorityNormal.
+func priorityFrom(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok && int(p) < numPriorities {
+		return p
+	}
+	return PriorityNormal
+}
+
+// A sendQueue admits up to max transactions at once. Waiting transactions
+// are admitted highest priority first and, within a priority, in the
+// order they arrived. A transaction overtaken maxOvertakes times by ones
+// of higher priority is admitted next regardless, so background work is
+// delayed but never starved.
+type sendQueue struct {
+	max          int
+	maxOvertakes int
+
+	mu       sync.Mutex // guards the following
+	inFlight int
+	waiting  [numPriorities][]*sendWaiter
+}
+
+type sendWaiter struct {
+	ready     chan struct{} // closed when admitted
+	admitted  bool
+	overtaken int // times a later transaction of higher priority was admitted first
+}
+
+// acquire waits until a transaction of priority p may be sent, or ctx is
+// done.
+func (q *sendQueue) acquire(ctx context.Context, p Priority) error {
+	q.mu.Lock()
+	if q.inFlight < q.max && q.empty() {
+		q.inFlight++
+		q.mu.Unlock()
+		return nil
+	}
+	w := &sendWaiter{ready: make(chan struct{})}
+	q.waiting[p] = append(q.waiting[p], w)
+	q.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		q.mu.Lock()
+		admitted := w.admitted
+		if !admitted {
+			q.remove(p, w)
+		}
+		q.mu.Unlock()
+		if admitted {
+			// admitted while giving up; pass the slot on
+			q.release()
+		}
+		return ctx.Err()
+	}
+}
+
+// release ends a transaction, admitting the next waiting one if any.
+func (q *sendQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	w := q.next()
+	if w == nil {
+		q.inFlight--
+		return
+	}
+	w.admitted = true
+	close(w.ready)
+}
+
+func (q *sendQueue) empty() bool {
+	for _, ws := range q.waiting {
+		if len(ws) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (q *sendQueue) remove(p Priority, w *sendWaiter) {
+	ws := q.waiting[p]
+	for i, x := range ws {
+		if x == w {
+			q.waiting[p] = append(ws[:i:i], ws[i+1:]...)
+			return
+		}
+	}
+}
+
+// next dequeues the waiter to admit next, or returns nil if there is
+// none. The oldest waiter of the lowest priority that has been
+// overtaken too often goes first; otherwise the oldest of the highest
+// priority does, and every waiter of lower priority counts as overtaken.
+func (q *sendQueue) next() *sendWaiter {
+	pick := -1
+	for p := 0; p < numPriorities; p++ {
+		if ws := q.waiting[p]; len(ws) > 0 && ws[0].overtaken >= q.maxOvertakes {
+			pick = p
+			break
+		}
+	}
+	if pick < 0 {
+		for p := numPriorities - 1; p >= 0; p-- {
+			if len(q.waiting[p]) > 0 {
+				pick = p
+				break
+			}
+		}
+	}
+	if pick < 0 {
+		return nil
+	}
+	for p := 0; p < pick; p++ {
+		for _, w := range q.waiting[p] {
+			w.overtaken++
+		}
+	}
+	w := q.waiting[pick][0]
+	q.waiting[pick] = q.waiting[pick][1:]
+	return w
+}
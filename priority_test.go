@@ -0,0 +1,89 @@
+package modbus
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// enqueue starts a transaction of priority p waiting on q, and waits for
+// it to be queued. The name is sent on order once it is admitted.
+func enqueue(t *testing.T, q *sendQueue, p Priority, name string, order chan<- string) {
+	q.mu.Lock()
+	n := len(q.waiting[p])
+	q.mu.Unlock()
+	go func() {
+		if err := q.acquire(context.Background(), p); err != nil {
+			t.Errorf("acquire %s: %v", name, err)
+			return
+		}
+		order <- name
+	}()
+	for {
+		q.mu.Lock()
+		queued := len(q.waiting[p]) > n
+		q.mu.Unlock()
+		if queued {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSendQueuePriority(t *testing.T) {
+	q := &sendQueue{max: 1, maxOvertakes: 2}
+	if err := q.acquire(context.Background(), PriorityNormal); err != nil {
+		t.Fatal(err)
+	}
+
+	order := make(chan string, 8)
+	enqueue(t, q, PriorityLow, "poll", order)
+	enqueue(t, q, PriorityNormal, "read", order)
+	enqueue(t, q, PriorityHigh, "write1", order)
+	enqueue(t, q, PriorityHigh, "write2", order)
+	enqueue(t, q, PriorityHigh, "write3", order)
+
+	var got []string
+	for i := 0; i < 5; i++ {
+		q.release()
+		got = append(got, <-order)
+	}
+	q.release()
+
+	// the poll and the read are overtaken twice by the writes, after
+	// which they go first in the order they arrived
+	expected := []string{"write1", "write2", "poll", "read", "write3"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("admitted %v; want %v", got, expected)
+	}
+	if q.inFlight != 0 {
+		t.Errorf("%d transactions in flight after release", q.inFlight)
+	}
+}
+
+func TestSendQueueCancel(t *testing.T) {
+	q := &sendQueue{max: 1, maxOvertakes: 8}
+	if err := q.acquire(context.Background(), PriorityNormal); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := q.acquire(ctx, PriorityHigh); err != context.DeadlineExceeded {
+		t.Errorf("acquire = %v; want %v", err, context.DeadlineExceeded)
+	}
+	q.release()
+	if !q.empty() || q.inFlight != 0 {
+		t.Errorf("abandoned transaction left in queue")
+	}
+}
+
+func TestPriorityFrom(t *testing.T) {
+	ctx := context.Background()
+	if p := priorityFrom(ctx); p != PriorityNormal {
+		t.Errorf("default priority %d", p)
+	}
+	if p := priorityFrom(WithPriority(ctx, PriorityHigh)); p != PriorityHigh {
+		t.Errorf("priority %d; want %d", p, PriorityHigh)
+	}
+}
@@ -0,0 +1,95 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// A Proxy is a Handler relaying every request, whatever its unit, to an
+// upstream slave through Transport, and answering with the slave's
+// responses, exceptions included, as they are. Served by a Server, it
+// makes a transparent Modbus TCP proxy:
+//
+//	modbus.ListenAndServe(":502", modbus.NewProxy("10.0.0.7:502"))
+//
+// The Request and Response hooks observe the frames in flight, e.g. to
+// log or audit them, and may modify or block them. The connection of
+// the master is described by ContextConnInfo(req.Context()).
+type Proxy struct {
+	Transport RoundTripper
+
+	// Timeout bounds the time the slave is given to respond. If zero,
+	// 1s is used. Requests the slave fails to answer are answered with
+	// a GatewayTargetFailed exception.
+	Timeout time.Duration
+
+	// Request, if non nil, is called with each request before it is
+	// relayed. It may modify req, e.g. with SetData. If it returns an
+	// error, the request is blocked: it is left unanswered if the error
+	// is ErrFrameDropped, answered with the exception code of a
+	// *ModbusError it wraps, or else with an IllegalFunction exception.
+	Request func(req *Frame) error
+
+	// Response, if non nil, is called with each response of the slave
+	// and the request it answers, as relayed. It may modify resp, and
+	// block it as Request blocks requests.
+	Response func(req, resp *Frame) error
+}
+
+// ErrFrameDropped is returned by the hooks of a Proxy to leave a request
+// unanswered, as if it had been lost.
+var ErrFrameDropped = errors.New("modbus: frame dropped")
+
+// NewProxy returns a Proxy relaying requests to the Modbus TCP slave at
+// addr over a ClientPool.
+func NewProxy(addr string) *Proxy {
+	return &Proxy{Transport: &ClientPool{Addr: addr}}
+}
+
+func (p *Proxy) ServeModbus(w ResponseWriter, r *Frame) {
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	req := NewFrame(r.header, append([]byte(nil), r.data...)).WithContext(r.Context())
+	if p.Request != nil {
+		if err := p.Request(req); err != nil {
+			blockFrame(w, err)
+			return
+		}
+	}
+	resp, err := p.Transport.RoundTrip(ctx, req)
+	if err != nil {
+		w.WriteException(GatewayTargetFailed)
+		return
+	}
+	if p.Response != nil {
+		if err := p.Response(req, resp); err != nil {
+			blockFrame(w, err)
+			return
+		}
+	}
+	if resp.header.Fcode&0x80 != 0 && len(resp.data) > 0 {
+		w.WriteException(ExceptionCode(resp.data[0]))
+		return
+	}
+	w.Header().Fcode = resp.header.Fcode
+	w.Write(resp.data)
+}
+
+// blockFrame answers a request blocked by a Proxy hook with err.
+func blockFrame(w ResponseWriter, err error) {
+	if errors.Is(err, ErrFrameDropped) {
+		return
+	}
+	var e *ModbusError
+	if errors.As(err, &e) {
+		w.WriteException(e.ExceptionCode)
+		return
+	}
+	w.WriteException(IllegalFunction)
+}
@@ -0,0 +1,70 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProxy(t *testing.T) {
+	mux := NewServeMux()
+	mux.Handle(5, &RegisterHandler{Holdings: []uint16{50, 51}})
+	p := NewProxy(startTestServer(t, &Server{Handler: mux}))
+	defer p.Transport.(*ClientPool).Close()
+
+	var mu sync.Mutex
+	var seen []FunctionCode
+	p.Request = func(req *Frame) error {
+		mu.Lock()
+		seen = append(seen, FunctionCode(req.Header().Fcode))
+		mu.Unlock()
+		if _, ok := ContextConnInfo(req.Context()); !ok {
+			t.Errorf("request without ConnInfo")
+		}
+		switch FunctionCode(req.Header().Fcode) {
+		case WriteSingleRegister:
+			return &ModbusError{ExceptionCode: IllegalDataValue}
+		case WriteMultipleRegisters:
+			return ErrFrameDropped
+		}
+		return nil
+	}
+	p.Response = func(req, resp *Frame) error {
+		if req.Header().Uid == 5 && FunctionCode(resp.Header().Fcode) == ReadHoldingRegisters {
+			resp.Data()[2] = 99 // low byte of the first register
+		}
+		return nil
+	}
+	c := dialTestServer(t, p)
+	ctx := context.Background()
+
+	if v, err := c.ReadHoldingRegisters(ctx, 5, 0, 2); err != nil || !reflect.DeepEqual(v, []uint16{99, 51}) {
+		t.Errorf("ReadHoldingRegisters = %v, %v; want [99 51]", v, err)
+	}
+	if _, err := c.ReadHoldingRegisters(ctx, 6, 0, 1); !errors.Is(err, ErrGatewayPathUnavailable) {
+		t.Errorf("ReadHoldingRegisters of a unit unknown upstream = %v", err)
+	}
+	if err := c.WriteSingleRegister(ctx, 5, 0, 1); !errors.Is(err, ErrIllegalDataValue) {
+		t.Errorf("blocked WriteSingleRegister = %v", err)
+	}
+	dctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if err := c.WriteMultipleRegisters(dctx, 5, 0, []uint16{1}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("dropped WriteMultipleRegisters = %v", err)
+	}
+	mu.Lock()
+	if want := []FunctionCode{ReadHoldingRegisters, ReadHoldingRegisters, WriteSingleRegister, WriteMultipleRegisters}; !reflect.DeepEqual(seen, want) {
+		t.Errorf("requests seen = %v; want %v", seen, want)
+	}
+	mu.Unlock()
+
+	down := &ClientPool{Addr: "127.0.0.1:1", MinBackoff: time.Hour}
+	defer down.Close()
+	c = dialTestServer(t, &Proxy{Transport: down, Timeout: 100 * time.Millisecond})
+	if _, err := c.ReadHoldingRegisters(ctx, 5, 0, 1); !errors.Is(err, ErrGatewayTargetFailed) {
+		t.Errorf("ReadHoldingRegisters without upstream = %v", err)
+	}
+}
@@ -0,0 +1,234 @@
+package modbus
+
+import "sync"
+
+// A RequestQueue bounds the number of requests reaching a Handler at
+// once, as a slow DataStore serialising its work requires, and decides
+// the order in which waiting requests are served across connections,
+// rather than leaving it to the scheduling of the connections'
+// goroutines. Waiting requests are served highest Priority first, and
+// within a priority the connections take turns, so that a busy master
+// cannot crowd out the others. A request overtaken MaxOvertakes times by
+// ones of higher priority is served next regardless.
+//
+// The exported fields must not be changed once the Middleware serves
+// requests.
+type RequestQueue struct {
+	// Concurrency is the number of requests handled at once. If zero,
+	// 1 is used.
+	Concurrency int
+
+	// Priority, if non nil, returns the priority of a request. If nil,
+	// writes are PriorityHigh and other requests PriorityNormal.
+	Priority func(r *Frame) Priority
+
+	// MaxOvertakes is the number of times a waiting request may be
+	// overtaken by requests of higher priority before it is served
+	// next regardless. If zero, 8 is used.
+	MaxOvertakes int
+
+	// MaxWaiting, if positive, bounds the number of waiting requests.
+	// Further requests are answered with SlaveBusy.
+	MaxWaiting int
+
+	mu       sync.Mutex // guards the following
+	active   int
+	waiting  int
+	turns    [numPriorities][]*connWaiters // connections with waiting requests, next turn first
+	byConn   [numPriorities]map[string]*connWaiters
+	rejected uint64
+}
+
+// connWaiters are the requests of one connection waiting at one
+// priority, oldest first.
+type connWaiters struct {
+	conn string
+	ws   []*queueWaiter
+}
+
+type queueWaiter struct {
+	ready     chan struct{} // closed when admitted
+	overtaken int
+}
+
+// Rejected returns the number of requests answered with SlaveBusy
+// without being handled.
+func (q *RequestQueue) Rejected() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.rejected
+}
+
+// Middleware is a Middleware queueing requests as q describes.
+// Connections are told apart by their remote address. A request whose
+// context ends while it waits, as when its master goes away, is answered
+// with SlaveBusy.
+func (q *RequestQueue) Middleware(h Handler) Handler {
+	return HandlerFunc(func(w ResponseWriter, r *Frame) {
+		var conn string
+		if info, ok := ContextConnInfo(r.Context()); ok && info.RemoteAddr != nil {
+			conn = info.RemoteAddr.String()
+		}
+		p := PriorityNormal
+		if q.Priority != nil {
+			p = q.Priority(r)
+		} else if isWriteFunction(FunctionCode(r.header.Fcode)) {
+			p = PriorityHigh
+		}
+		if int(p) >= numPriorities {
+			p = PriorityHigh
+		}
+		ok, admitted := q.acquire(conn, p)
+		if !ok {
+			w.WriteException(SlaveBusy)
+			return
+		}
+		select {
+		case <-admitted:
+		case <-r.Context().Done():
+			if !q.abandon(conn, p, admitted) {
+				w.WriteException(SlaveBusy)
+				return
+			}
+		}
+		defer q.release()
+		h.ServeModbus(w, r)
+	})
+}
+
+// acquire admits a request of priority p from conn, or queues it. It
+// reports false if the queue is full; otherwise the returned channel is
+// closed once the request is admitted.
+func (q *RequestQueue) acquire(conn string, p Priority) (bool, chan struct{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	w := &queueWaiter{ready: make(chan struct{})}
+	if q.active < q.concurrency() && q.waiting == 0 {
+		q.active++
+		close(w.ready)
+		return true, w.ready
+	}
+	if q.MaxWaiting > 0 && q.waiting >= q.MaxWaiting {
+		q.rejected++
+		return false, nil
+	}
+	cw := q.byConn[p][conn]
+	if cw == nil {
+		if q.byConn[p] == nil {
+			q.byConn[p] = make(map[string]*connWaiters)
+		}
+		cw = &connWaiters{conn: conn}
+		q.byConn[p][conn] = cw
+		q.turns[p] = append(q.turns[p], cw)
+	}
+	cw.ws = append(cw.ws, w)
+	q.waiting++
+	return true, w.ready
+}
+
+// abandon removes the waiter of ready from the queue, reporting whether
+// it had been admitted meanwhile, in which case it must be released.
+func (q *RequestQueue) abandon(conn string, p Priority, ready chan struct{}) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	cw := q.byConn[p][conn]
+	if cw == nil {
+		return true // admitted, its connection's turn taken
+	}
+	for i, w := range cw.ws {
+		if w.ready == ready {
+			cw.ws = append(cw.ws[:i:i], cw.ws[i+1:]...)
+			q.waiting--
+			q.rejected++
+			if len(cw.ws) == 0 {
+				q.dropTurn(p, cw)
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// release ends a request, admitting the next waiting one if any.
+func (q *RequestQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	w := q.next()
+	if w == nil {
+		q.active--
+		return
+	}
+	close(w.ready)
+}
+
+func (q *RequestQueue) concurrency() int {
+	if q.Concurrency <= 0 {
+		return 1
+	}
+	return q.Concurrency
+}
+
+// dropTurn removes cw, which has no waiting requests, from the turns of
+// priority p.
+func (q *RequestQueue) dropTurn(p Priority, cw *connWaiters) {
+	delete(q.byConn[p], cw.conn)
+	for i, x := range q.turns[p] {
+		if x == cw {
+			q.turns[p] = append(q.turns[p][:i:i], q.turns[p][i+1:]...)
+			return
+		}
+	}
+}
+
+// next dequeues the request to admit next, or returns nil if there is
+// none. A request of the lowest priority that has been overtaken too
+// often goes first; otherwise the highest priority does, and every
+// request of lower priority counts as overtaken. Within a priority the
+// connection whose turn it is goes, and then waits for its next turn.
+func (q *RequestQueue) next() *queueWaiter {
+	maxOvertakes := q.MaxOvertakes
+	if maxOvertakes <= 0 {
+		maxOvertakes = 8
+	}
+	pick, turn := -1, 0
+starved:
+	for p := 0; p < numPriorities; p++ {
+		for i, cw := range q.turns[p] {
+			if cw.ws[0].overtaken >= maxOvertakes {
+				pick, turn = p, i
+				break starved
+			}
+		}
+	}
+	if pick < 0 {
+		for p := numPriorities - 1; p >= 0; p-- {
+			if len(q.turns[p]) > 0 {
+				pick = p
+				break
+			}
+		}
+	}
+	if pick < 0 {
+		return nil
+	}
+	for p := 0; p < pick; p++ {
+		for _, cw := range q.turns[p] {
+			for _, w := range cw.ws {
+				w.overtaken++
+			}
+		}
+	}
+	turns := q.turns[pick]
+	cw := turns[turn]
+	w := cw.ws[0]
+	cw.ws = cw.ws[1:]
+	q.waiting--
+	if len(cw.ws) == 0 {
+		q.dropTurn(Priority(pick), cw)
+	} else {
+		// to the back of the line
+		copy(turns[turn:], turns[turn+1:])
+		turns[len(turns)-1] = cw
+	}
+	return w
+}
@@ -0,0 +1,132 @@
+package modbus
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// admitted returns the indexes of the channels of readies that are
+// closed.
+func admitted(readies []chan struct{}) []int {
+	var is []int
+	for i, ready := range readies {
+		select {
+		case <-ready:
+			is = append(is, i)
+		default:
+		}
+	}
+	return is
+}
+
+func TestRequestQueueOrder(t *testing.T) {
+	q := &RequestQueue{MaxOvertakes: 2}
+	if _, ready := q.acquire("a", PriorityNormal); len(admitted([]chan struct{}{ready})) != 1 {
+		t.Fatalf("request to an idle queue not admitted")
+	}
+	var readies []chan struct{}
+	for _, r := range []struct {
+		conn string
+		p    Priority
+	}{
+		{"a", PriorityNormal}, // 0
+		{"a", PriorityNormal}, // 1
+		{"a", PriorityNormal}, // 2
+		{"b", PriorityNormal}, // 3
+		{"c", PriorityLow},    // 4
+		{"c", PriorityHigh},   // 5
+		{"b", PriorityHigh},   // 6
+	} {
+		_, ready := q.acquire(r.conn, r.p)
+		readies = append(readies, ready)
+	}
+	// the high priority requests first, then the connections in turn,
+	// once the others have been overtaken twice
+	var order []int
+	for range readies {
+		q.release()
+		for _, i := range admitted(readies) {
+			if !contains(order, i) {
+				order = append(order, i)
+			}
+		}
+	}
+	if expected := []int{5, 6, 4, 0, 3, 1, 2}; !reflect.DeepEqual(order, expected) {
+		t.Errorf("admitted in order %v; want %v", order, expected)
+	}
+	q.release()
+	if q.active != 0 || q.waiting != 0 {
+		t.Errorf("%d active, %d waiting after releasing all", q.active, q.waiting)
+	}
+	q.MaxWaiting = 1
+	q.acquire("a", PriorityNormal)
+	q.acquire("a", PriorityNormal)
+	if ok, _ := q.acquire("b", PriorityHigh); ok {
+		t.Errorf("request admitted to a full queue")
+	}
+}
+
+func contains(is []int, i int) bool {
+	for _, x := range is {
+		if x == i {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRequestQueueMiddleware(t *testing.T) {
+	block := make(chan struct{})
+	h := &RegisterHandler{Holdings: []uint16{7}}
+	srv := &Server{Handler: testHandlerFunc(func(w ResponseWriter, r *Frame) {
+		if r.Header().Tid == 1 {
+			<-block
+		}
+		h.ServeModbus(w, r)
+	})}
+	q := &RequestQueue{MaxWaiting: 1}
+	srv.Use(q.Middleware)
+	addr := startTestServer(t, srv)
+
+	read := func(tid byte) []byte {
+		return []byte{0, tid, 0, 0, 0, 6, 1, 0x03, 0, 0, 0, 1}
+	}
+	first := make(chan []byte)
+	go func() { first <- exchange(t, addr, read(1), 11) }()
+	for q.activeCount() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	second := make(chan []byte)
+	go func() { second <- exchange(t, addr, read(2), 11) }()
+	for q.waitingCount() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the queue is full
+	if resp := exchange(t, addr, read(3), 9); !bytes.Equal(resp, []byte{0, 3, 0, 0, 0, 3, 1, 0x83, byte(SlaveBusy)}) {
+		t.Errorf("response % X to a full queue; want SlaveBusy", resp)
+	}
+	close(block)
+	for tid, ch := range map[byte]chan []byte{1: first, 2: second} {
+		if resp := <-ch; !bytes.Equal(resp, []byte{0, tid, 0, 0, 0, 5, 1, 0x03, 2, 0, 7}) {
+			t.Errorf("response % X to request %d", resp, tid)
+		}
+	}
+	if n := q.Rejected(); n != 1 {
+		t.Errorf("Rejected = %d; want 1", n)
+	}
+}
+
+func (q *RequestQueue) activeCount() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.active
+}
+
+func (q *RequestQueue) waitingCount() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.waiting
+}
@@ -0,0 +1,79 @@
+package modbus
+
+import "sync"
+
+// A DeviceProfile names a model of slave, such as "acme/pm3000", so that
+// the quirks of its firmware can be registered once and applied by every
+// Client talking to it, see Client.Profile.
+type DeviceProfile string
+
+// A Quirk works around a known deviation of a device from the protocol.
+// Its Fix repairs a response in place, given the request it answers,
+// before the Client decodes it. Fixes must leave conforming responses
+// unchanged.
+type Quirk struct {
+	Name string
+	Fix  func(req, resp *Frame)
+}
+
+var (
+	quirksMu sync.RWMutex
+	quirks   = make(map[DeviceProfile][]Quirk)
+)
+
+// RegisterQuirks adds qs to the quirks of devices of profile p, to be
+// applied in order. It is typically called from an init function.
+func RegisterQuirks(p DeviceProfile, qs ...Quirk) {
+	quirksMu.Lock()
+	defer quirksMu.Unlock()
+	quirks[p] = append(quirks[p], qs...)
+}
+
+// Quirks returns the quirks registered for profile p.
+func Quirks(p DeviceProfile) []Quirk {
+	quirksMu.RLock()
+	defer quirksMu.RUnlock()
+	return append([]Quirk(nil), quirks[p]...)
+}
+
+// applyQuirks repairs resp with the quirks of p.
+func applyQuirks(p DeviceProfile, req, resp *Frame) {
+	if p == "" {
+		return
+	}
+	quirksMu.RLock()
+	qs := quirks[p]
+	quirksMu.RUnlock()
+	for _, q := range qs {
+		q.Fix(req, resp)
+	}
+}
+
+// QuirkReadInputRegistersByteCount repairs Read Input Registers
+// responses whose byte count is off by one from the register data that
+// follows it.
+var QuirkReadInputRegistersByteCount = Quirk{
+	Name: "fc4-byte-count-off-by-one",
+	Fix: func(req, resp *Frame) {
+		if FunctionCode(resp.header.Fcode) != ReadInputRegisters || len(resp.data) < 1 {
+			return
+		}
+		n := len(resp.data) - 1
+		if d := int(resp.data[0]) - n; (d == 1 || d == -1) && n%2 == 0 {
+			resp.data[0] = byte(n)
+		}
+	},
+}
+
+// QuirkExceptionWithoutBit repairs exception responses carrying the
+// request's function code without its exception bit set. They are told
+// apart by their single byte payload, shorter than that of any normal
+// response.
+var QuirkExceptionWithoutBit = Quirk{
+	Name: "exception-without-0x80",
+	Fix: func(req, resp *Frame) {
+		if resp.header.Fcode == req.header.Fcode && len(resp.data) == 1 {
+			resp.header.Fcode |= 0x80
+		}
+	},
+}
@@ -0,0 +1,55 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// quirkyHandler answers Read Input Registers with a byte count one too
+// large, and exceptions without the exception bit.
+var quirkyHandler = testHandlerFunc(func(w ResponseWriter, r *Frame) {
+	if FunctionCode(r.Header().Fcode) == ReadInputRegisters {
+		w.Write([]byte{0x03, 0x12, 0x34})
+		return
+	}
+	w.Write([]byte{byte(IllegalDataAddress)})
+})
+
+func TestQuirks(t *testing.T) {
+	const profile DeviceProfile = "test/quirky"
+	RegisterQuirks(profile, QuirkReadInputRegistersByteCount, QuirkExceptionWithoutBit)
+	if qs := Quirks(profile); len(qs) != 2 || qs[1].Name != "exception-without-0x80" {
+		t.Errorf("Quirks = %v", qs)
+	}
+
+	c := dialTestServer(t, quirkyHandler)
+	ctx := context.Background()
+	if _, err := c.ReadInputRegisters(ctx, 1, 0, 1); err == nil {
+		t.Errorf("quirky response decoded without the profile")
+	}
+
+	c.Profile = profile
+	values, err := c.ReadInputRegisters(ctx, 1, 0, 1)
+	if err != nil || len(values) != 1 || values[0] != 0x1234 {
+		t.Errorf("ReadInputRegisters = %v, %v", values, err)
+	}
+	if _, err := c.ReadHoldingRegisters(ctx, 1, 0, 1); !errors.Is(err, ErrIllegalDataAddress) {
+		t.Errorf("err should be ErrIllegalDataAddress not %v", err)
+	}
+}
+
+func TestQuirksLeaveConformingResponses(t *testing.T) {
+	req := NewReadInputRegistersFrame(1, 0, 1)
+	for _, resp := range []*Frame{
+		NewFrame(Header{Fcode: byte(ReadInputRegisters)}, []byte{0x02, 0x12, 0x34}),
+		NewFrame(Header{Fcode: byte(ReadInputRegisters | 0x80)}, []byte{byte(IllegalDataAddress)}),
+	} {
+		h, data := resp.header, string(resp.data)
+		QuirkReadInputRegistersByteCount.Fix(req, resp)
+		QuirkExceptionWithoutBit.Fix(req, resp)
+		if resp.header != h || string(resp.data) != data {
+			t.Errorf("conforming response % X changed to % X", data, resp.data)
+		}
+	}
+}
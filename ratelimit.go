@@ -0,0 +1,140 @@
+package modbus
+
+import (
+	"sync"
+	"time"
+)
+
+// A tokenBucket permits events at a sustained rate a second, burst of
+// them at once. The zero value is a full bucket.
+type tokenBucket struct {
+	tokens float64   // events permitted now
+	last   time.Time // time tokens was last topped up
+}
+
+// fill tops b up to now and reports whether it holds a token.
+func (b *tokenBucket) fill(now time.Time, rate float64, burst int) bool {
+	max := float64(burst)
+	if max < 1 {
+		max = 1
+	}
+	if b.last.IsZero() {
+		b.tokens = max
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * rate
+		if b.tokens > max {
+			b.tokens = max
+		}
+	}
+	b.last = now
+	return b.tokens >= 1
+}
+
+// take reports whether an event at now is permitted, counting it if so.
+func (b *tokenBucket) take(now time.Time, rate float64, burst int) bool {
+	if !b.fill(now, rate, burst) {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// A RateLimiter bounds the rate of requests reaching a Handler, so that a
+// resource constrained backend is protected from aggressive masters.
+// Requests over the rate are answered with SlaveBusy by its Middleware,
+// which masters are expected to retry later.
+//
+// The exported fields must not be changed once the Middleware serves
+// requests.
+type RateLimiter struct {
+	// Rate, if positive, bounds the sustained rate of requests a second
+	// of all connections together; Burst requests, at least 1, may
+	// arrive at once.
+	Rate  float64
+	Burst int
+
+	// PerConnRate and PerConnBurst bound the requests of each
+	// connection alike.
+	PerConnRate  float64
+	PerConnBurst int
+
+	mu       sync.Mutex // guards the following
+	global   tokenBucket
+	conns    map[string]*tokenBucket // by remote address
+	swept    time.Time               // time conns was last swept
+	rejected uint64
+}
+
+// Rejected returns the number of requests answered with SlaveBusy.
+func (l *RateLimiter) Rejected() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rejected
+}
+
+// Middleware is a Middleware answering requests over the rates of l with
+// SlaveBusy. Connections are told apart by their remote address.
+func (l *RateLimiter) Middleware(h Handler) Handler {
+	return HandlerFunc(func(w ResponseWriter, r *Frame) {
+		var conn string
+		if info, ok := ContextConnInfo(r.Context()); ok && info.RemoteAddr != nil {
+			conn = info.RemoteAddr.String()
+		}
+		if !l.allow(conn, time.Now()) {
+			w.WriteException(SlaveBusy)
+			return
+		}
+		h.ServeModbus(w, r)
+	})
+}
+
+// allow reports whether a request of the connection conn at now is
+// within the rates, counting it if so.
+func (l *RateLimiter) allow(conn string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+	ok := l.Rate <= 0 || l.global.fill(now, l.Rate, l.Burst)
+	var b *tokenBucket
+	if l.PerConnRate > 0 {
+		b = l.conns[conn]
+		if b == nil {
+			if l.conns == nil {
+				l.conns = make(map[string]*tokenBucket)
+			}
+			b = new(tokenBucket)
+			l.conns[conn] = b
+		}
+		ok = b.fill(now, l.PerConnRate, l.PerConnBurst) && ok
+	}
+	if !ok {
+		l.rejected++
+		return false
+	}
+	if l.Rate > 0 {
+		l.global.tokens--
+	}
+	if b != nil {
+		b.tokens--
+	}
+	return true
+}
+
+// sweep drops, at most once a second, the buckets of connections that
+// have refilled, as a new bucket is full, so that the buckets of closed
+// connections do not accumulate. l.mu must be held.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < time.Second {
+		return
+	}
+	l.swept = now
+	max := float64(l.PerConnBurst)
+	if max < 1 {
+		max = 1
+	}
+	for conn, b := range l.conns {
+		if b.fill(now, l.PerConnRate, l.PerConnBurst); b.tokens >= max {
+			delete(l.conns, conn)
+		}
+	}
+}
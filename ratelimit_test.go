@@ -0,0 +1,57 @@
+package modbus
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	l := &RateLimiter{Rate: 10, Burst: 3, PerConnRate: 2, PerConnBurst: 2}
+	now := time.Unix(1000, 0)
+	for i, tt := range []struct {
+		conn  string
+		after time.Duration
+		ok    bool
+	}{
+		{"a", 0, true},
+		{"a", 0, true},
+		{"a", 0, false}, // over a's burst
+		{"b", 0, true},
+		{"c", 0, false}, // over the global burst
+		{"c", 100 * time.Millisecond, true},
+		{"a", 200 * time.Millisecond, false}, // a's rate refills more slowly
+		{"a", 200 * time.Millisecond, true},
+	} {
+		now = now.Add(tt.after)
+		if ok := l.allow(tt.conn, now); ok != tt.ok {
+			t.Errorf("request %d of %s allowed = %v; want %v", i, tt.conn, ok, tt.ok)
+		}
+	}
+	if n := l.Rejected(); n != 3 {
+		t.Errorf("Rejected = %d; want 3", n)
+	}
+
+	// refilled buckets of idle connections are dropped
+	l.allow("d", now.Add(10*time.Second))
+	if len(l.conns) != 1 {
+		t.Errorf("%d connection buckets kept; want 1", len(l.conns))
+	}
+}
+
+func TestRateLimiterMiddleware(t *testing.T) {
+	srv := &Server{Handler: &RegisterHandler{Holdings: []uint16{7}}}
+	l := &RateLimiter{Rate: 0.1, Burst: 2}
+	srv.Use(l.Middleware)
+	addr := startTestServer(t, srv)
+
+	req := []byte{0, 1, 0, 0, 0, 6, 1, 0x03, 0, 0, 0, 1}
+	for i := 0; i < 2; i++ {
+		if resp := exchange(t, addr, req, 11); !bytes.Equal(resp, []byte{0, 1, 0, 0, 0, 5, 1, 0x03, 2, 0, 7}) {
+			t.Errorf("response % X within the burst", resp)
+		}
+	}
+	if resp := exchange(t, addr, req, 9); !bytes.Equal(resp, []byte{0, 1, 0, 0, 0, 3, 1, 0x83, byte(SlaveBusy)}) {
+		t.Errorf("response % X over the rate; want SlaveBusy", resp)
+	}
+}
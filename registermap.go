@@ -0,0 +1,228 @@
+package modbus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// A RegisterMap describes the tables of a simulated device, as read by
+// LoadRegisterMap and written by SaveRegisterMap. Its JSON form is
+//
+//	{
+//		"coils": [{"start": 0, "values": [1, 0, 1]}],
+//		"holding_registers": [
+//			{"start": 0, "count": 100},
+//			{"start": 200, "values": [4660, 22136]}
+//		],
+//		"points": [
+//			{"name": "setpoint", "table": "holding registers", "address": 10,
+//			 "type": "float32", "value": 21.5}
+//		]
+//	}
+//
+// with "discrete_inputs" and "input_registers" like "coils" and
+// "holding_registers". An "addressing" member, such as "modicon", gives
+// the addresses as documented by a device manual; see Addressing.
+//
+// Built with the modbus_yaml tag, LoadRegisterMapYAML and
+// SaveRegisterMapYAML read and write the same document as YAML.
+type RegisterMap struct {
+	// Addressing, if non nil, relates the addresses of the ranges and
+	// points to protocol addresses. The handler returned by Handler
+	// takes it too.
+	Addressing *Addressing `json:"addressing,omitempty"`
+
+	Coils            []RegisterRange `json:"coils,omitempty"`
+	DiscreteInputs   []RegisterRange `json:"discrete_inputs,omitempty"`
+	InputRegisters   []RegisterRange `json:"input_registers,omitempty"`
+	HoldingRegisters []RegisterRange `json:"holding_registers,omitempty"`
+
+	// Points set typed values over the ranges, after them.
+	Points []MapPoint `json:"points,omitempty"`
+}
+
+// A RegisterRange is a range of addresses of a table and their initial
+// values. Count, if larger than the number of values, extends the range
+// with zeros. Coils and discrete inputs are set by non zero values.
+type RegisterRange struct {
+	Start  uint16   `json:"start"`
+	Count  int      `json:"count,omitempty"`
+	Values []uint16 `json:"values,omitempty"`
+}
+
+// end returns the address following the range.
+func (r *RegisterRange) end() int {
+	n := r.Count
+	if n < len(r.Values) {
+		n = len(r.Values)
+	}
+	return int(r.Start) + n
+}
+
+// A MapPoint is a Point of a RegisterMap and its initial value.
+type MapPoint struct {
+	Name     string    `json:"name"`
+	Table    Table     `json:"table"`
+	Address  uint16    `json:"address"`
+	Type     PointType `json:"type"`
+	WordSwap bool      `json:"word_swap,omitempty"`
+	ByteSwap bool      `json:"byte_swap,omitempty"`
+	Scale    float64   `json:"scale,omitempty"`
+	Value    float64   `json:"value"`
+}
+
+// Point returns the Point p describes.
+func (p *MapPoint) Point() Point {
+	return Point{
+		Name:     p.Name,
+		Table:    p.Table,
+		Address:  p.Address,
+		Type:     p.Type,
+		WordSwap: p.WordSwap,
+		ByteSwap: p.ByteSwap,
+		Scale:    p.Scale,
+	}
+}
+
+// LoadRegisterMap reads a RegisterMap from r and returns a RegisterHandler
+// serving it. Each table is as long as its last range or point requires;
+// addresses between ranges are served too, holding zero.
+func LoadRegisterMap(r io.Reader) (*RegisterHandler, error) {
+	var m RegisterMap
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, fmt.Errorf("modbus: register map: %w", err)
+	}
+	return m.Handler()
+}
+
+// Handler returns a RegisterHandler serving a new copy of the tables m
+// describes.
+func (m *RegisterMap) Handler() (*RegisterHandler, error) {
+	var a Addressing
+	if m.Addressing != nil {
+		a = *m.Addressing
+	}
+	ranges := [4][]RegisterRange{m.Coils, m.DiscreteInputs, m.InputRegisters, m.HoldingRegisters}
+	for t, rs := range ranges {
+		ranges[t] = make([]RegisterRange, len(rs))
+		for i, r := range rs {
+			start, err := a.Protocol(Table(t), r.Start)
+			if err != nil {
+				return nil, fmt.Errorf("modbus: register map: %w", err)
+			}
+			r.Start = start
+			ranges[t][i] = r
+		}
+	}
+	var size [4]int
+	for t, rs := range ranges {
+		for i := range rs {
+			if end := rs[i].end(); end > size[t] {
+				size[t] = end
+			}
+		}
+	}
+	points := make([]Point, len(m.Points))
+	for i := range m.Points {
+		points[i] = m.Points[i].Point()
+		if int(points[i].Table) >= len(size) {
+			return nil, fmt.Errorf("modbus: register map: point %s in unknown table", points[i].Name)
+		}
+		addr, err := a.Protocol(points[i].Table, points[i].Address)
+		if err != nil {
+			return nil, fmt.Errorf("modbus: register map: point %s: %w", points[i].Name, err)
+		}
+		points[i].Address = addr
+		if end := int(points[i].Address) + points[i].Type.size(); end > size[points[i].Table] {
+			size[points[i].Table] = end
+		}
+	}
+	for t, n := range size {
+		if n > 0x10000 {
+			return nil, fmt.Errorf("modbus: register map: %v exceed the address space", Table(t))
+		}
+	}
+
+	h := &RegisterHandler{
+		Coils:          make([]bool, size[CoilTable]),
+		DiscreteInputs: make([]bool, size[DiscreteInputTable]),
+		Inputs:         make([]uint16, size[InputRegisterTable]),
+		Holdings:       make([]uint16, size[HoldingRegisterTable]),
+		Addressing:     a,
+	}
+	for _, r := range ranges[CoilTable] {
+		for i, v := range r.Values {
+			h.Coils[int(r.Start)+i] = v != 0
+		}
+	}
+	for _, r := range ranges[DiscreteInputTable] {
+		for i, v := range r.Values {
+			h.DiscreteInputs[int(r.Start)+i] = v != 0
+		}
+	}
+	for _, r := range ranges[InputRegisterTable] {
+		copy(h.Inputs[r.Start:], r.Values)
+	}
+	for _, r := range ranges[HoldingRegisterTable] {
+		copy(h.Holdings[r.Start:], r.Values)
+	}
+
+	mapping, err := NewMapping(points...)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range m.Points {
+		if err := mapping.Set(context.Background(), h.DataStore(), p.Name, p.Value); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// SaveRegisterMap writes the current values of h's slices to w as a
+// RegisterMap, one range per table, which LoadRegisterMap reads back.
+// The map keeps h.Addressing, the ranges starting at the documented
+// address of protocol address 0.
+func SaveRegisterMap(w io.Writer, h *RegisterHandler) error {
+	h.mu.RLock()
+	m := RegisterMap{
+		Coils:            bitsRange(h.Coils),
+		DiscreteInputs:   bitsRange(h.DiscreteInputs),
+		InputRegisters:   registersRange(h.Inputs),
+		HoldingRegisters: registersRange(h.Holdings),
+	}
+	h.mu.RUnlock()
+	if a := h.Addressing; a != (Addressing{}) {
+		m.Addressing = &a
+		for t, rs := range [][]RegisterRange{m.Coils, m.DiscreteInputs, m.InputRegisters, m.HoldingRegisters} {
+			for i := range rs {
+				rs[i].Start = a.Base(Table(t))
+			}
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(&m)
+}
+
+func bitsRange(bits []bool) []RegisterRange {
+	if len(bits) == 0 {
+		return nil
+	}
+	values := make([]uint16, len(bits))
+	for i, b := range bits {
+		if b {
+			values[i] = 1
+		}
+	}
+	return []RegisterRange{{Values: values}}
+}
+
+func registersRange(regs []uint16) []RegisterRange {
+	if len(regs) == 0 {
+		return nil
+	}
+	return []RegisterRange{{Values: append([]uint16(nil), regs...)}}
+}
@@ -0,0 +1,87 @@
+package modbus
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadRegisterMap(t *testing.T) {
+	h, err := LoadRegisterMap(strings.NewReader(`{
+		"coils": [{"start": 0, "values": [1, 0, 1]}, {"start": 5, "count": 1}],
+		"input_registers": [{"start": 0, "values": [7]}],
+		"holding_registers": [{"start": 0, "count": 2}, {"start": 4, "values": [4660]}],
+		"points": [
+			{"name": "setpoint", "table": "holding registers", "address": 6, "type": "float32", "value": 1.5},
+			{"name": "temp", "table": "input registers", "address": 1, "type": "int16", "scale": 0.1, "value": -2.5},
+			{"name": "alarm", "table": "discrete inputs", "address": 2, "type": "bool", "value": 1}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []bool{true, false, true, false, false, false}; !reflect.DeepEqual(h.Coils, expected) {
+		t.Errorf("coils %v; want %v", h.Coils, expected)
+	}
+	if expected := []bool{false, false, true}; !reflect.DeepEqual(h.DiscreteInputs, expected) {
+		t.Errorf("discrete inputs %v; want %v", h.DiscreteInputs, expected)
+	}
+	if expected := []uint16{7, 0xFFE7}; !reflect.DeepEqual(h.Inputs, expected) {
+		t.Errorf("input registers %04X; want %04X", h.Inputs, expected)
+	}
+	if expected := []uint16{0, 0, 0, 0, 0x1234, 0, 0x3FC0, 0}; !reflect.DeepEqual(h.Holdings, expected) {
+		t.Errorf("holding registers %04X; want %04X", h.Holdings, expected)
+	}
+
+	var buf bytes.Buffer
+	if err := SaveRegisterMap(&buf, h); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := LoadRegisterMap(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(saved.Coils, h.Coils) || !reflect.DeepEqual(saved.DiscreteInputs, h.DiscreteInputs) ||
+		!reflect.DeepEqual(saved.Inputs, h.Inputs) || !reflect.DeepEqual(saved.Holdings, h.Holdings) {
+		t.Errorf("saved map loads as %v %v %v %v", saved.Coils, saved.DiscreteInputs, saved.Inputs, saved.Holdings)
+	}
+}
+
+func TestLoadRegisterMapErrors(t *testing.T) {
+	for _, m := range []string{
+		`{"coils": [{"start": 65535, "count": 2}]}`,
+		`{"points": [{"name": "x", "table": "holding registers", "type": "int8"}]}`,
+		`{"points": [{"name": "x", "table": "coils", "type": "uint16", "value": 3}]}`,
+		`{"points": [{"name": "x", "table": "holding registers", "type": "uint16", "value": -1}]}`,
+		`{"coils": [`,
+	} {
+		if _, err := LoadRegisterMap(strings.NewReader(m)); err == nil {
+			t.Errorf("map %s loaded", m)
+		}
+	}
+}
+
+func TestRegisterMapHandlersIndependent(t *testing.T) {
+	m := &RegisterMap{
+		Coils:            []RegisterRange{{Start: 0, Values: []uint16{1}}},
+		DiscreteInputs:   []RegisterRange{{Start: 0, Values: []uint16{1}}},
+		InputRegisters:   []RegisterRange{{Start: 0, Values: []uint16{7}}},
+		HoldingRegisters: []RegisterRange{{Start: 0, Values: []uint16{8}}},
+	}
+	h, err := m.Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := m.Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.Coils[0], other.DiscreteInputs[0], other.Inputs[0], other.Holdings[0] = false, false, 0, 0
+	if !h.Coils[0] || !h.DiscreteInputs[0] || h.Inputs[0] != 7 || h.Holdings[0] != 8 {
+		t.Errorf("handlers of one map share their tables: %v %v %v %v", h.Coils, h.DiscreteInputs, h.Inputs, h.Holdings)
+	}
+	if m.HoldingRegisters[0].Values[0] != 8 || m.InputRegisters[0].Values[0] != 7 {
+		t.Errorf("map changed through its handlers: %+v", m)
+	}
+}
@@ -0,0 +1,77 @@
+//go:build modbus_yaml
+
+package modbus
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"gopkg.in/yaml.v3"
+)
+
+func init() {
+	capabilities = append(capabilities, CapabilityYAML)
+}
+
+// LoadRegisterMapYAML is like LoadRegisterMap but reads the RegisterMap
+// as YAML, with the members of its JSON form:
+//
+//	holding_registers:
+//	  - start: 0
+//	    count: 100
+//	  - start: 200
+//	    values: [4660, 22136]
+//
+// As JSON is YAML, it reads JSON register maps too.
+func LoadRegisterMapYAML(r io.Reader) (*RegisterHandler, error) {
+	var v interface{}
+	if err := yaml.NewDecoder(r).Decode(&v); err != nil {
+		return nil, fmt.Errorf("modbus: register map: %w", err)
+	}
+	// The document is decoded through its JSON form, which the
+	// RegisterMap and the types of its fields define.
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("modbus: register map: %w", err)
+	}
+	return LoadRegisterMap(bytes.NewReader(b))
+}
+
+// SaveRegisterMapYAML is like SaveRegisterMap but writes the RegisterMap
+// as YAML, which LoadRegisterMapYAML reads back.
+func SaveRegisterMapYAML(w io.Writer, h *RegisterHandler) error {
+	var b bytes.Buffer
+	if err := SaveRegisterMap(&b, h); err != nil {
+		return err
+	}
+	// JSON is YAML: decoding it into a node keeps the order of the
+	// members, and clearing the styles of the node writes it as block
+	// YAML.
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b.Bytes(), &doc); err != nil {
+		return err
+	}
+	plainStyle(&doc)
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// plainStyle clears the styles of n and its children, leaving lists of
+// scalars in flow style to keep long tables short.
+func plainStyle(n *yaml.Node) {
+	n.Style = 0
+	scalars := n.Kind == yaml.SequenceNode
+	for _, c := range n.Content {
+		plainStyle(c)
+		scalars = scalars && c.Kind == yaml.ScalarNode
+	}
+	if scalars && len(n.Content) > 0 {
+		n.Style = yaml.FlowStyle
+	}
+}
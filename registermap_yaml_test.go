@@ -0,0 +1,61 @@
+//go:build modbus_yaml
+
+package modbus
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadRegisterMapYAML(t *testing.T) {
+	h, err := LoadRegisterMapYAML(strings.NewReader(`
+addressing: modicon
+coils:
+  - start: 1
+    values: [1, 0, 1]
+holding_registers:
+  - start: 40001
+    count: 2
+  - start: 40005
+    values: [4660]
+points:
+  - name: setpoint
+    table: holding registers
+    address: 40007
+    type: float32
+    value: 1.5
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []bool{true, false, true}; !reflect.DeepEqual(h.Coils, expected) {
+		t.Errorf("coils %v; want %v", h.Coils, expected)
+	}
+	if expected := []uint16{0, 0, 0, 0, 0x1234, 0, 0x3FC0, 0}; !reflect.DeepEqual(h.Holdings, expected) {
+		t.Errorf("holding registers %04X; want %04X", h.Holdings, expected)
+	}
+
+	var buf bytes.Buffer
+	if err := SaveRegisterMapYAML(&buf, h); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "{") {
+		t.Errorf("saved map is not block YAML:\n%s", buf.String())
+	}
+	saved, err := LoadRegisterMapYAML(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(saved.Coils, h.Coils) || !reflect.DeepEqual(saved.Holdings, h.Holdings) {
+		t.Errorf("saved map loads as %v %v", saved.Coils, saved.Holdings)
+	}
+
+	if _, err := LoadRegisterMapYAML(strings.NewReader(`{"input_registers": [{"start": 0, "values": [7]}]}`)); err != nil {
+		t.Errorf("JSON map: %v", err)
+	}
+	if _, err := LoadRegisterMapYAML(strings.NewReader("coils: [")); err == nil {
+		t.Errorf("malformed map loaded")
+	}
+}
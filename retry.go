@@ -0,0 +1,103 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// A RetryPolicy governs the retrying of failed requests by a Client.
+// Only requests that are safe to repeat, the reads, are retried: a
+// write that failed may have been executed by the slave all the same.
+type RetryPolicy struct {
+	// MaxAttempts bounds the number of times a request is sent,
+	// counting the first. If less than 2, requests are not retried.
+	MaxAttempts int
+
+	// MinBackoff is the delay before the first retry, doubling for
+	// every further retry up to MaxBackoff. If zero, 100ms and 5s are
+	// used.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// Exceptions lists the exception codes after which a request is
+	// retried. If nil, requests are retried after SlaveBusy and
+	// Acknowledge exceptions. Errors of the Transport are always
+	// retried, unless the request's context is done.
+	Exceptions []ExceptionCode
+}
+
+// A RetryError is returned by a Client with a RetryPolicy for a request
+// that may be retried, reporting the attempts made.
+type RetryError struct {
+	Attempts int   // number of times the request was sent
+	Err      error // error of the last attempt
+}
+
+func (e *RetryError) Error() string {
+	if e.Attempts == 1 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%v (after %d attempts)", e.Err, e.Attempts)
+}
+
+func (e *RetryError) Unwrap() error { return e.Err }
+
+// retryable reports whether a request failing with err may be retried.
+func (p *RetryPolicy) retryable(err error) bool {
+	var me *ModbusError
+	if !errors.As(err, &me) {
+		return true
+	}
+	codes := p.Exceptions
+	if codes == nil {
+		codes = []ExceptionCode{SlaveBusy, Acknowledge}
+	}
+	for _, code := range codes {
+		if me.ExceptionCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the delay before retry n, counting from 1.
+func (p *RetryPolicy) backoff(n int) time.Duration {
+	min, max := p.MinBackoff, p.MaxBackoff
+	if min <= 0 {
+		min = 100 * time.Millisecond
+	}
+	if max <= 0 {
+		max = 5 * time.Second
+	}
+	d := min
+	for i := 1; i < n && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// do calls send until it succeeds, fails with an error that is not
+// retryable, or p.MaxAttempts attempts have been made.
+func (p *RetryPolicy) do(ctx context.Context, send func() ([]byte, error)) ([]byte, error) {
+	for attempt := 1; ; attempt++ {
+		data, err := send()
+		if err == nil {
+			return data, nil
+		}
+		if attempt >= p.MaxAttempts || ctx.Err() != nil || !p.retryable(err) {
+			return nil, &RetryError{Attempts: attempt, Err: err}
+		}
+		timer := time.NewTimer(p.backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, &RetryError{Attempts: attempt, Err: err}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClientRetry(t *testing.T) {
+	var busy, requests int32
+	h := &RegisterHandler{Holdings: []uint16{7}}
+	c := dialTestServer(t, testHandlerFunc(func(w ResponseWriter, r *Frame) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.AddInt32(&busy, -1) >= 0 {
+			WriteError(w, ErrSlaveBusy)
+			return
+		}
+		h.ServeModbus(w, r)
+	}))
+	c.Retry = &RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond}
+	ctx := context.Background()
+
+	atomic.StoreInt32(&busy, 2)
+	values, err := c.ReadHoldingRegisters(ctx, 1, 0, 1)
+	if err != nil || len(values) != 1 || values[0] != 7 {
+		t.Errorf("read after 2 busy answers = %v, %v", values, err)
+	}
+
+	atomic.StoreInt32(&busy, 3)
+	_, err = c.ReadHoldingRegisters(ctx, 1, 0, 1)
+	var re *RetryError
+	if !errors.As(err, &re) || re.Attempts != 3 || !errors.Is(err, ErrSlaveBusy) {
+		t.Errorf("read after 3 busy answers = %v; want %v after 3 attempts", err, ErrSlaveBusy)
+	}
+
+	// other exceptions are not retried
+	atomic.StoreInt32(&busy, 0)
+	atomic.StoreInt32(&requests, 0)
+	_, err = c.ReadHoldingRegisters(ctx, 1, 1, 1)
+	if !errors.As(err, &re) || re.Attempts != 1 || !errors.Is(err, ErrIllegalDataAddress) {
+		t.Errorf("read of a missing register = %v; want %v after 1 attempt", err, ErrIllegalDataAddress)
+	}
+
+	// nor are writes
+	atomic.StoreInt32(&busy, 1)
+	err = c.WriteSingleRegister(ctx, 1, 0, 8)
+	if !errors.Is(err, ErrSlaveBusy) || errors.As(err, &re) {
+		t.Errorf("busy write = %v; want %v", err, ErrSlaveBusy)
+	}
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("%d requests; want 2", n)
+	}
+}
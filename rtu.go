@@ -0,0 +1,133 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mubeta06/gomodbus/serial"
+)
+
+// ErrRTUChecksum is returned for RTU responses whose CRC does not match
+// their content, as when the line's settings differ from the slave's.
+var ErrRTUChecksum = errors.New("modbus: rtu: CRC mismatch")
+
+// An RTUTransport is a RoundTripper carrying requests to the slaves of a
+// Modbus RTU serial line, one at a time. The MBAP header of a request
+// only contributes its unit identifier, the slave address; responses
+// carry the request's transaction identifier.
+//
+// Broadcast requests, to unit 0, are not supported, as slaves do not
+// answer them.
+type RTUTransport struct {
+	// Port is the open port of the line.
+	Port serial.Port
+
+	// Config is the configuration Port was opened with, giving the
+	// silent interval kept between frames.
+	Config serial.Config
+
+	// Timeout bounds the wait for each response. If zero, 1s is used.
+	Timeout time.Duration
+
+	mu   sync.Mutex
+	last time.Time // end of the last frame on the line
+}
+
+// RoundTrip sends req and reads the slave's response.
+func (t *RTUTransport) RoundTrip(ctx context.Context, req *Frame) (*Frame, error) {
+	uid, fcode := req.header.Uid, req.header.Fcode
+	if uid == 0 {
+		return nil, errors.New("modbus: rtu: broadcast requests are not supported")
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if wait := time.Until(t.last.Add(t.Config.FrameGap())); wait > 0 {
+		time.Sleep(wait)
+	}
+	t.Port.Flush()
+	adu := append([]byte{uid, fcode}, req.data...)
+	_, err := t.Port.Write(AppendCRC16(adu))
+	t.last = time.Now()
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := t.Timeout
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	rctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	resp, err := t.readResponse(rctx, uid)
+	t.last = time.Now()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	resp.header.Tid = req.header.Tid
+	return resp, nil
+}
+
+// readResponse reads the response of unit uid.
+func (t *RTUTransport) readResponse(ctx context.Context, uid uint8) (*Frame, error) {
+	adu := make([]byte, 3, 256)
+	if err := t.read(ctx, adu); err != nil {
+		return nil, fmt.Errorf("modbus: rtu: no response from unit %d: %w", uid, err)
+	}
+	n := rtuResponseLength(adu)
+	if n == 0 {
+		return nil, fmt.Errorf("modbus: rtu: cannot delimit responses of function %v", FunctionCode(adu[1]))
+	}
+	adu = adu[:n]
+	if err := t.read(ctx, adu[3:]); err != nil {
+		return nil, fmt.Errorf("modbus: rtu: truncated response from unit %d: %w", uid, err)
+	}
+	if !VerifyCRC16(adu) {
+		return nil, ErrRTUChecksum
+	}
+	if adu[0] != uid {
+		return nil, fmt.Errorf("modbus: rtu: response from unit %d to a request to unit %d", adu[0], uid)
+	}
+	data := append([]byte(nil), adu[2:n-2]...)
+	return NewFrame(Header{Pid: TcpPid, Uid: uid, Fcode: adu[1]}, data), nil
+}
+
+// read fills b from the port.
+func (t *RTUTransport) read(ctx context.Context, b []byte) error {
+	for n := 0; n < len(b); {
+		m, err := serial.ReadContext(ctx, t.Port, b[n:])
+		n += m
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rtuResponseLength returns the length, CRC included, of the RTU response
+// starting with the address, function code and next byte in b, or 0 if
+// responses of the function have no known length.
+func rtuResponseLength(b []byte) int {
+	fcode := FunctionCode(b[1])
+	if fcode&0x80 != 0 {
+		return 5
+	}
+	switch fcode {
+	case ReadCoils, ReadDiscreteInputs, ReadHoldingRegisters, ReadInputRegisters,
+		ReportSlaveId, WriteAndReadRegisters:
+		return 3 + int(b[2]) + 2
+	case WriteSingleCoil, WriteSingleRegister, WriteMultipleCoils, WriteMultipleRegisters:
+		return 8
+	case MaskWriteRegister:
+		return 10
+	case ReadExceptionStatus:
+		return 5
+	}
+	return 0
+}
@@ -0,0 +1,190 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mubeta06/gomodbus/serial"
+)
+
+// A fakeRTUPort is a serial.Port whose writes are answered by serve.
+type fakeRTUPort struct {
+	serve func(adu []byte) []byte
+
+	mu       sync.Mutex
+	rx       []byte
+	deadline time.Time
+	wake     chan struct{}
+}
+
+func newFakeRTUPort(serve func(adu []byte) []byte) *fakeRTUPort {
+	return &fakeRTUPort{serve: serve, wake: make(chan struct{}, 1)}
+}
+
+func (p *fakeRTUPort) signal() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (p *fakeRTUPort) Read(b []byte) (int, error) {
+	for {
+		p.mu.Lock()
+		if len(p.rx) > 0 {
+			n := copy(b, p.rx)
+			p.rx = p.rx[n:]
+			p.mu.Unlock()
+			return n, nil
+		}
+		d := p.deadline
+		p.mu.Unlock()
+		if !d.IsZero() && !time.Now().Before(d) {
+			return 0, os.ErrDeadlineExceeded
+		}
+		var expired <-chan time.Time
+		if !d.IsZero() {
+			t := time.NewTimer(time.Until(d))
+			defer t.Stop()
+			expired = t.C
+		}
+		select {
+		case <-p.wake:
+		case <-expired:
+		}
+	}
+}
+
+func (p *fakeRTUPort) Write(b []byte) (int, error) {
+	resp := p.serve(append([]byte(nil), b...))
+	p.mu.Lock()
+	p.rx = append(p.rx, resp...)
+	p.mu.Unlock()
+	p.signal()
+	return len(b), nil
+}
+
+func (p *fakeRTUPort) SetReadDeadline(t time.Time) error {
+	p.mu.Lock()
+	p.deadline = t
+	p.mu.Unlock()
+	p.signal()
+	return nil
+}
+
+func (p *fakeRTUPort) Flush() error {
+	p.mu.Lock()
+	p.rx = nil
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *fakeRTUPort) Close() error { return nil }
+
+// rtuSlaves answers as a line with unit 3, holding register 0 set to
+// 0x1234 and reporting the slave ID "meter", and unit 7, with no
+// registers nor Report Slave ID.
+func rtuSlaves(adu []byte) []byte {
+	if !VerifyCRC16(adu) {
+		return nil
+	}
+	switch uid, fcode := adu[0], adu[1]; {
+	case uid == 3 && FunctionCode(fcode) == ReadHoldingRegisters:
+		return AppendCRC16([]byte{3, fcode, 2, 0x12, 0x34})
+	case uid == 3 && FunctionCode(fcode) == ReportSlaveId:
+		return AppendCRC16(append([]byte{3, fcode, 5}, "meter"...))
+	case uid == 7 && FunctionCode(fcode) == ReadHoldingRegisters:
+		return AppendCRC16([]byte{7, fcode | 0x80, byte(IllegalDataAddress)})
+	case uid == 7:
+		return AppendCRC16([]byte{7, fcode | 0x80, byte(IllegalFunction)})
+	}
+	return nil
+}
+
+func TestRTUTransport(t *testing.T) {
+	p := newFakeRTUPort(rtuSlaves)
+	c := &Client{Transport: &RTUTransport{Port: p, Timeout: 20 * time.Millisecond}}
+	ctx := context.Background()
+
+	regs, err := c.ReadHoldingRegisters(ctx, 3, 0, 1)
+	if err != nil || !reflect.DeepEqual(regs, []uint16{0x1234}) {
+		t.Errorf("ReadHoldingRegisters = %v, %v", regs, err)
+	}
+	if _, err := c.ReadHoldingRegisters(ctx, 7, 0, 1); !errors.Is(err, ErrIllegalDataAddress) {
+		t.Errorf("ReadHoldingRegisters of unit 7: %v", err)
+	}
+	if _, err := c.ReadHoldingRegisters(ctx, 9, 0, 1); !errors.Is(err, os.ErrDeadlineExceeded) &&
+		!errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("ReadHoldingRegisters of absent unit: %v", err)
+	}
+
+	p.serve = func(adu []byte) []byte {
+		resp := rtuSlaves(adu)
+		resp[len(resp)-1] ^= 0xFF
+		return resp
+	}
+	if _, err := c.ReadHoldingRegisters(ctx, 3, 0, 1); !errors.Is(err, ErrRTUChecksum) {
+		t.Errorf("ReadHoldingRegisters with corrupt CRC: %v", err)
+	}
+}
+
+func TestRTUScanner(t *testing.T) {
+	// The slaves use 19200 baud with even parity; at 9600 baud their
+	// responses arrive corrupted.
+	open := func(c serial.Config) (serial.Port, error) {
+		switch {
+		case c.BaudRate == 19200 && c.Parity == serial.ParityEven:
+			return newFakeRTUPort(rtuSlaves), nil
+		case c.BaudRate == 9600 && c.Parity == serial.ParityEven:
+			return newFakeRTUPort(func(adu []byte) []byte {
+				resp := rtuSlaves(adu)
+				if resp != nil {
+					resp[len(resp)-1] ^= 0x55
+				}
+				return resp
+			}), nil
+		}
+		return newFakeRTUPort(func([]byte) []byte { return nil }), nil
+	}
+	var progress []RTUScanProgress
+	s := &RTUScanner{
+		BaudRates: []int{9600, 19200},
+		Parities:  []serial.Parity{serial.ParityEven, serial.ParityNone},
+		Units:     []uint8{1, 3, 7},
+		Timeout:   20 * time.Millisecond,
+		Open:      open,
+		Progress:  func(p RTUScanProgress) { progress = append(progress, p) },
+	}
+	found, err := s.Scan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []RTUDevice{
+		{Config: serial.Config{BaudRate: 19200, Parity: serial.ParityEven}, Unit: 3, SlaveID: []byte("\x05meter")},
+		{Config: serial.Config{BaudRate: 19200, Parity: serial.ParityEven}, Unit: 7,
+			Exception: &ModbusError{ReadHoldingRegisters, IllegalDataAddress}},
+	}
+	if !reflect.DeepEqual(found, want) {
+		t.Errorf("Scan = %+v, want %+v", found, want)
+	}
+	if len(progress) != 12 || progress[11].Done != 12 || progress[11].Total != 12 {
+		t.Fatalf("progress = %+v", progress)
+	}
+	if p := progress[1]; p.Unit != 3 || !errors.Is(p.Err, ErrRTUChecksum) {
+		t.Errorf("progress of unit 3 at 9600 baud = %+v", p)
+	}
+	if p := progress[7]; p.Unit != 3 || p.Device == nil || p.Device.Unit != 3 {
+		t.Errorf("progress of unit 3 at 19200 baud = %+v", p)
+	}
+
+	progress = nil
+	s.StopAtFirst = true
+	if found, err := s.Scan(context.Background()); err != nil || len(found) != 2 || len(progress) != 9 {
+		t.Errorf("Scan with StopAtFirst = %v, %v after %d probes", found, err, len(progress))
+	}
+}
@@ -0,0 +1,172 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mubeta06/gomodbus/serial"
+)
+
+// An RTUDevice is a unit that answered the probes of an RTUScanner.
+type RTUDevice struct {
+	// Config is the configuration of the port the unit answered on,
+	// giving its likely line settings.
+	Config serial.Config
+	Unit   uint8
+
+	// SlaveID is the data of the unit's Report Slave ID response, or
+	// nil if it did not report it.
+	SlaveID []byte
+
+	// Exception is the exception the unit answered the read of
+	// holding register 0 with, if any. A unit answering with an
+	// exception is there, though it may have no register 0.
+	Exception *ModbusError
+}
+
+// An RTUScanProgress reports a probe of an RTUScanner.
+type RTUScanProgress struct {
+	Config serial.Config // configuration of the port
+	Unit   uint8         // unit probed
+	Done   int           // number of probes made, this one included
+	Total  int           // number of probes of the scan
+
+	// Device is the unit if it answered. Otherwise Err is the error
+	// of the probe: a timeout if nothing answered, or another, such as
+	// ErrRTUChecksum, hinting at a device with other line settings.
+	Device *RTUDevice
+	Err    error
+}
+
+// An RTUScanner sweeps the unit addresses of a Modbus RTU serial line at
+// a range of line settings, to find the devices on it and the settings
+// they use. The zero value with Config.Address set is usable.
+//
+// A scan takes up to Timeout for each unit at each setting, and for
+// large sweeps may take many minutes.
+type RTUScanner struct {
+	// Config configures the port, e.g. its Address and RS485
+	// switching. Its BaudRate and Parity are replaced by each setting
+	// scanned, and its StopBits by the default for the parity.
+	Config serial.Config
+
+	// BaudRates are the baud rates scanned. If empty, 9600, 19200,
+	// 38400, 57600 and 115200 are.
+	BaudRates []int
+
+	// Parities are the parities scanned at each baud rate. If empty,
+	// even, none and odd are.
+	Parities []serial.Parity
+
+	// Units are the addresses probed at each setting. If empty, 1 to
+	// 247 are.
+	Units []uint8
+
+	// Timeout bounds each probe. If zero, 200ms is used.
+	Timeout time.Duration
+
+	// StopAtFirst stops the scan after the first setting at which any
+	// unit answered, as the devices of a line share its settings.
+	StopAtFirst bool
+
+	// Open opens the port for each setting. If nil, serial.Open is
+	// used.
+	Open func(serial.Config) (serial.Port, error)
+
+	// Progress, if non nil, is called after each probe.
+	Progress func(RTUScanProgress)
+}
+
+// Scan probes each unit at each setting, asking it to read holding
+// register 0 and, if it answers, if only with an exception, to Report
+// Slave ID. It returns the units that answered, in the order found.
+//
+// Scan returns once every setting is scanned, or with the units found so
+// far and an error once ctx is done or a port fails to open.
+func (s *RTUScanner) Scan(ctx context.Context) ([]RTUDevice, error) {
+	bauds := s.BaudRates
+	if len(bauds) == 0 {
+		bauds = []int{9600, 19200, 38400, 57600, 115200}
+	}
+	parities := s.Parities
+	if len(parities) == 0 {
+		parities = []serial.Parity{serial.ParityEven, serial.ParityNone, serial.ParityOdd}
+	}
+	units := s.Units
+	if len(units) == 0 {
+		for uid := 1; uid <= 247; uid++ {
+			units = append(units, uint8(uid))
+		}
+	}
+
+	var found []RTUDevice
+	pr := RTUScanProgress{Total: len(bauds) * len(parities) * len(units)}
+	for _, baud := range bauds {
+		for _, parity := range parities {
+			c := s.Config
+			c.BaudRate, c.Parity, c.StopBits = baud, parity, 0
+			n, err := s.scan(ctx, c, units, &pr)
+			found = append(found, n...)
+			if err != nil {
+				return found, err
+			}
+			if s.StopAtFirst && len(n) > 0 {
+				return found, nil
+			}
+		}
+	}
+	return found, nil
+}
+
+// scan probes units on a port configured by c.
+func (s *RTUScanner) scan(ctx context.Context, c serial.Config, units []uint8, pr *RTUScanProgress) ([]RTUDevice, error) {
+	open := s.Open
+	if open == nil {
+		open = serial.Open
+	}
+	p, err := open(c)
+	if err != nil {
+		return nil, err
+	}
+	defer p.Close()
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = 200 * time.Millisecond
+	}
+	cl := &Client{Transport: &RTUTransport{Port: p, Config: c, Timeout: timeout}}
+
+	var found []RTUDevice
+	for _, uid := range units {
+		if err := ctx.Err(); err != nil {
+			return found, err
+		}
+		d, err := s.probe(ctx, cl, uid)
+		d.Config = c
+		pr.Config, pr.Unit, pr.Device, pr.Err = c, uid, nil, err
+		pr.Done++
+		if err == nil {
+			found = append(found, d)
+			pr.Device = &d
+		}
+		if s.Progress != nil {
+			s.Progress(*pr)
+		}
+	}
+	return found, nil
+}
+
+// probe returns the unit uid if it answers through c.
+func (s *RTUScanner) probe(ctx context.Context, c *Client, uid uint8) (RTUDevice, error) {
+	d := RTUDevice{Unit: uid}
+	_, err := c.send(ctx, NewReadHoldingRegistersFrame(uid, 0, 1))
+	var e *ModbusError
+	if err != nil && !errors.As(err, &e) {
+		return d, err
+	}
+	d.Exception = e
+	if id, err := c.send(ctx, NewReportSlaveIdFrame(uid)); err == nil {
+		d.SlaveID = id
+	}
+	return d, nil
+}
@@ -0,0 +1,199 @@
+package modbus
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// A SegmentedStore is a DataStore holding only the address ranges added
+// to it, so that a device whose registers live at high addresses, such as
+// 0x9C40 onwards, needs no memory for the addresses below. Requests
+// touching an address outside every range fail with
+// ErrIllegalDataAddress. Added ranges are zero; adjacent ranges of a
+// table are served as one, so requests may span them.
+//
+// A SegmentedStore implements InputWriter. The zero value is an empty
+// store, ready to use.
+type SegmentedStore struct {
+	mu       sync.RWMutex
+	segments [4][]segment // by table, in address order
+}
+
+// A segment is a range of addresses of one table. Coils and discrete
+// inputs are held as 0 or 1.
+type segment struct {
+	start  uint16
+	values []uint16
+}
+
+func (g *segment) end() int { return int(g.start) + len(g.values) }
+
+// AddRange adds count addresses of table t starting at start to s. It
+// fails if the range overflows the address space or overlaps a range
+// already added.
+func (s *SegmentedStore) AddRange(t Table, start, count uint16) error {
+	if int(t) >= len(s.segments) {
+		return fmt.Errorf("modbus: unknown table %v", t)
+	}
+	end := int(start) + int(count)
+	if count == 0 || end > 0x10000 {
+		return fmt.Errorf("modbus: %v range of %d at %d does not fit the address space", t, count, start)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	segs := s.segments[t]
+	i := sort.Search(len(segs), func(i int) bool { return segs[i].end() > int(start) })
+	if i < len(segs) && int(segs[i].start) < end {
+		return fmt.Errorf("modbus: %v range %d-%d overlaps range %d-%d", t, start, end-1, segs[i].start, segs[i].end()-1)
+	}
+	g := segment{start, make([]uint16, count)}
+	if i < len(segs) && int(segs[i].start) == end {
+		g.values = append(g.values, segs[i].values...)
+		segs = append(segs[:i], segs[i+1:]...)
+	}
+	if i > 0 && segs[i-1].end() == int(start) {
+		i--
+		segs[i].values = append(segs[i].values, g.values...)
+	} else {
+		segs = append(segs, segment{})
+		copy(segs[i+1:], segs[i:])
+		segs[i] = g
+	}
+	s.segments[t] = segs
+	return nil
+}
+
+// Ranges returns the ranges of table t held by s, adjacent ranges
+// merged, in address order.
+func (s *SegmentedStore) Ranges(t Table) []AddressRange {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var ranges []AddressRange
+	if int(t) < len(s.segments) {
+		for _, g := range s.segments[t] {
+			ranges = append(ranges, AddressRange{t, g.start, uint16(g.end() - 1)})
+		}
+	}
+	return ranges
+}
+
+// find returns the values of table t from addr to addr+quantity, which
+// alias the store, or nil if some are not held. s must be locked.
+func (s *SegmentedStore) find(t Table, addr uint16, quantity int) []uint16 {
+	segs := s.segments[t]
+	i := sort.Search(len(segs), func(i int) bool { return segs[i].end() > int(addr) })
+	if i == len(segs) || addr < segs[i].start || int(addr)+quantity > segs[i].end() {
+		return nil
+	}
+	off := int(addr - segs[i].start)
+	return segs[i].values[off : off+quantity]
+}
+
+func (s *SegmentedStore) readBits(t Table, addr, quantity uint16) ([]bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	values := s.find(t, addr, int(quantity))
+	if values == nil {
+		return nil, ErrIllegalDataAddress
+	}
+	bits := make([]bool, len(values))
+	for i, v := range values {
+		bits[i] = v != 0
+	}
+	return bits, nil
+}
+
+func (s *SegmentedStore) readRegisters(t Table, addr, quantity uint16) ([]uint16, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	values := s.find(t, addr, int(quantity))
+	if values == nil {
+		return nil, ErrIllegalDataAddress
+	}
+	return append([]uint16(nil), values...), nil
+}
+
+func (s *SegmentedStore) writeBits(t Table, addr uint16, bits []bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	values := s.find(t, addr, len(bits))
+	if values == nil {
+		return ErrIllegalDataAddress
+	}
+	for i, b := range bits {
+		values[i] = 0
+		if b {
+			values[i] = 1
+		}
+	}
+	return nil
+}
+
+func (s *SegmentedStore) writeRegisters(t Table, addr uint16, registers []uint16) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	values := s.find(t, addr, len(registers))
+	if values == nil {
+		return ErrIllegalDataAddress
+	}
+	copy(values, registers)
+	return nil
+}
+
+func (s *SegmentedStore) ReadCoils(ctx context.Context, addr, quantity uint16) ([]bool, error) {
+	return s.readBits(CoilTable, addr, quantity)
+}
+
+func (s *SegmentedStore) ReadDiscreteInputs(ctx context.Context, addr, quantity uint16) ([]bool, error) {
+	return s.readBits(DiscreteInputTable, addr, quantity)
+}
+
+func (s *SegmentedStore) ReadInputRegisters(ctx context.Context, addr, quantity uint16) ([]uint16, error) {
+	return s.readRegisters(InputRegisterTable, addr, quantity)
+}
+
+func (s *SegmentedStore) ReadHoldingRegisters(ctx context.Context, addr, quantity uint16) ([]uint16, error) {
+	return s.readRegisters(HoldingRegisterTable, addr, quantity)
+}
+
+func (s *SegmentedStore) WriteCoils(ctx context.Context, addr uint16, values []bool) error {
+	return s.writeBits(CoilTable, addr, values)
+}
+
+func (s *SegmentedStore) WriteHoldingRegisters(ctx context.Context, addr uint16, values []uint16) error {
+	return s.writeRegisters(HoldingRegisterTable, addr, values)
+}
+
+func (s *SegmentedStore) WriteDiscreteInputs(ctx context.Context, addr uint16, values []bool) error {
+	return s.writeBits(DiscreteInputTable, addr, values)
+}
+
+func (s *SegmentedStore) WriteInputRegisters(ctx context.Context, addr uint16, values []uint16) error {
+	return s.writeRegisters(InputRegisterTable, addr, values)
+}
+
+// Transact applies changes under the store's write lock, failing with
+// ErrIllegalDataAddress without setting any value if one is outside the
+// ranges added.
+func (s *SegmentedStore) Transact(ctx context.Context, changes []Change) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range changes {
+		if int(c.Table) >= len(s.segments) {
+			return fmt.Errorf("modbus: unknown table %v", c.Table)
+		}
+		if s.find(c.Table, c.Address, 1) == nil {
+			return ErrIllegalDataAddress
+		}
+	}
+	for _, c := range changes {
+		v := c.New
+		if (c.Table == CoilTable || c.Table == DiscreteInputTable) && v != 0 {
+			v = 1
+		}
+		s.find(c.Table, c.Address, 1)[0] = v
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package modbus
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestSegmentedStore(t *testing.T) {
+	ctx := context.Background()
+	var s SegmentedStore
+	for _, r := range []struct{ start, count uint16 }{{0x9C40, 4}, {0x9C48, 2}, {0x9C44, 4}, {0x10, 2}} {
+		if err := s.AddRange(HoldingRegisterTable, r.start, r.count); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.AddRange(CoilTable, 0xFFF0, 16); err != nil {
+		t.Fatal(err)
+	}
+	expected := []AddressRange{{HoldingRegisterTable, 0x10, 0x11}, {HoldingRegisterTable, 0x9C40, 0x9C49}}
+	if ranges := s.Ranges(HoldingRegisterTable); !reflect.DeepEqual(ranges, expected) {
+		t.Errorf("Ranges = %v; want %v", ranges, expected)
+	}
+	if err := s.AddRange(HoldingRegisterTable, 0x9C49, 2); err == nil {
+		t.Errorf("overlapping range added")
+	}
+	if err := s.AddRange(CoilTable, 0xFFFF, 2); err == nil {
+		t.Errorf("range past the address space added")
+	}
+
+	// writes and reads span ranges added separately
+	if err := s.WriteHoldingRegisters(ctx, 0x9C43, []uint16{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	values, err := s.ReadHoldingRegisters(ctx, 0x9C42, 4)
+	if err != nil || !reflect.DeepEqual(values, []uint16{0, 1, 2, 0}) {
+		t.Errorf("ReadHoldingRegisters = %v, %v", values, err)
+	}
+	if err := s.WriteCoils(ctx, 0xFFFE, []bool{true, true}); err != nil {
+		t.Fatal(err)
+	}
+	if bits, err := s.ReadCoils(ctx, 0xFFFD, 3); err != nil || !reflect.DeepEqual(bits, []bool{false, true, true}) {
+		t.Errorf("ReadCoils = %v, %v", bits, err)
+	}
+	for _, r := range []struct{ addr, quantity uint16 }{{0x9C3F, 2}, {0x9C49, 2}, {0x12, 1}, {0, 1}} {
+		if _, err := s.ReadHoldingRegisters(ctx, r.addr, r.quantity); err != ErrIllegalDataAddress {
+			t.Errorf("read of %d at 0x%04X = %v; want %v", r.quantity, r.addr, err, ErrIllegalDataAddress)
+		}
+	}
+	if err := s.WriteInputRegisters(ctx, 0, []uint16{1}); err != ErrIllegalDataAddress {
+		t.Errorf("write of an input register outside every range = %v", err)
+	}
+
+	// served by a handler, gaps answer IllegalDataAddress
+	addr := startTestServer(t, &Server{Handler: &RegisterHandler{Store: &s}})
+	resp := exchange(t, addr, []byte{0, 1, 0, 0, 0, 6, 1, 0x03, 0x9C, 0x43, 0, 2}, 13)
+	if expected := []byte{0, 1, 0, 0, 0, 7, 1, 0x03, 4, 0, 1, 0, 2}; !bytes.Equal(resp, expected) {
+		t.Errorf("response % X; want % X", resp, expected)
+	}
+	resp = exchange(t, addr, []byte{0, 2, 0, 0, 0, 6, 1, 0x03, 0x9C, 0x3F, 0, 2}, 9)
+	if expected := []byte{0, 2, 0, 0, 0, 3, 1, 0x83, 0x02}; !bytes.Equal(resp, expected) {
+		t.Errorf("response % X; want % X", resp, expected)
+	}
+}
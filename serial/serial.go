@@ -0,0 +1,211 @@
+// Package serial provides serial ports configured for Modbus RTU and
+// ASCII links: baud rate, data bits, parity and stop bits, read
+// timeouts, and the RTS line switching of half duplex RS-485
+// transceivers.
+//
+// Ports are implemented for Linux on architectures using the generic
+// terminal ioctls, unless built with the modbus_noserial tag; elsewhere
+// Open fails with errors.ErrUnsupported, and users may supply their own
+// implementation of Port.
+package serial
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// A Port is an open serial port.
+type Port interface {
+	// Read reads received bytes. With a Timeout configured, it fails
+	// with an error satisfying errors.Is(err, os.ErrDeadlineExceeded)
+	// if no byte arrives in time.
+	Read(p []byte) (int, error)
+
+	// Write transmits p, returning once it has been handed to the
+	// driver, or, with RS485 switched by software, once it has been
+	// sent.
+	Write(p []byte) (int, error)
+
+	// Flush discards bytes received but not yet read, such as the rest
+	// of a corrupted frame.
+	Flush() error
+
+	// Close closes the port. A Read in progress fails rather than
+	// wait on for data.
+	io.Closer
+}
+
+// aLongTimeAgo is a deadline in the past, failing reads at once.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// ReadContext reads from p as p.Read does, but stops waiting for data
+// when ctx is done, returning ctx.Err(). Cancellation requires p to
+// implement
+//
+//	SetReadDeadline(t time.Time) error
+//
+// as the ports returned by Open do, setting a deadline, in addition to
+// any Timeout, on current and future reads. Reads of other ports end
+// only with data, their timeout, or Close.
+func ReadContext(ctx context.Context, p Port, b []byte) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	d, ok := p.(interface{ SetReadDeadline(time.Time) error })
+	if !ok || ctx.Done() == nil {
+		return p.Read(b)
+	}
+	expired := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		d.SetReadDeadline(aLongTimeAgo)
+		close(expired)
+	})
+	n, err := p.Read(b)
+	if !stop() {
+		// the deadline was, or is being, set; wait for it and clear it
+		<-expired
+		d.SetReadDeadline(time.Time{})
+		if err != nil {
+			err = ctx.Err()
+		}
+	}
+	return n, err
+}
+
+// Parity is the parity bit of each character.
+type Parity byte
+
+const (
+	ParityEven Parity = 'E' // the default of the Modbus serial line specification
+	ParityOdd  Parity = 'O'
+	ParityNone Parity = 'N'
+)
+
+// Config configures a Port.
+type Config struct {
+	// Address is the device of the port, e.g. "/dev/ttyUSB0".
+	Address string
+
+	// BaudRate is the line speed in bits per second. If zero, 19200
+	// is used, the default of the Modbus serial line specification.
+	BaudRate int
+
+	// DataBits is the number of data bits per character, 5 to 8. If
+	// zero, 8 is used, as Modbus RTU requires; Modbus ASCII uses 7.
+	DataBits int
+
+	// Parity is the parity of each character. If zero, ParityEven is
+	// used.
+	Parity Parity
+
+	// StopBits is the number of stop bits, 1 or 2. If zero, 1 is used
+	// with parity and 2 with ParityNone, keeping characters 11 bits
+	// long as the specification requires.
+	StopBits int
+
+	// Timeout, if positive, bounds the time each Read waits for data.
+	Timeout time.Duration
+
+	// RS485, if non nil, has the RTS line switch the transmitter of a
+	// half duplex RS-485 transceiver.
+	RS485 *RS485
+}
+
+// RS485 configures the switching of an RS-485 transmitter by RTS. Drivers
+// supporting RS-485 mode switch it themselves, with precise timing;
+// otherwise, or if Software is set, RTS is switched around every Write,
+// which returns once the data has been sent.
+type RS485 struct {
+	// ActiveLow keeps RTS low while transmitting, and high otherwise,
+	// for transceivers whose driver enable is inverted.
+	ActiveLow bool
+
+	// DelayBeforeSend and DelayAfterSend are waited between switching
+	// the transmitter on and sending, and between the end of sending
+	// and switching it off. Drivers use whole milliseconds.
+	DelayBeforeSend time.Duration
+	DelayAfterSend  time.Duration
+
+	// Software switches RTS from Write even if the driver supports
+	// RS-485 mode.
+	Software bool
+}
+
+// normalize returns c with defaults applied, or an error if it is
+// invalid.
+func (c Config) normalize() (Config, error) {
+	if c.BaudRate == 0 {
+		c.BaudRate = 19200
+	}
+	if c.BaudRate < 0 {
+		return c, fmt.Errorf("serial: invalid baud rate %d", c.BaudRate)
+	}
+	if c.DataBits == 0 {
+		c.DataBits = 8
+	}
+	if c.DataBits < 5 || c.DataBits > 8 {
+		return c, fmt.Errorf("serial: invalid data bits %d", c.DataBits)
+	}
+	if c.Parity == 0 {
+		c.Parity = ParityEven
+	}
+	if c.Parity != ParityEven && c.Parity != ParityOdd && c.Parity != ParityNone {
+		return c, fmt.Errorf("serial: invalid parity %q", c.Parity)
+	}
+	if c.StopBits == 0 {
+		c.StopBits = 1
+		if c.Parity == ParityNone {
+			c.StopBits = 2
+		}
+	}
+	if c.StopBits != 1 && c.StopBits != 2 {
+		return c, fmt.Errorf("serial: invalid stop bits %d", c.StopBits)
+	}
+	return c, nil
+}
+
+// CharTime returns the time taken to transmit a character with the
+// configuration's defaults applied.
+func (c Config) CharTime() time.Duration {
+	bits, baud := c.charBits()
+	return time.Duration(bits) * time.Second / time.Duration(baud)
+}
+
+// FrameGap returns the silent interval separating Modbus RTU frames: 3.5
+// character times, or 1.75ms above 19200 baud as the specification
+// fixes it there.
+func (c Config) FrameGap() time.Duration {
+	bits, baud := c.charBits()
+	if baud > 19200 {
+		return 1750 * time.Microsecond
+	}
+	return time.Duration(7*bits) * time.Second / time.Duration(2*baud)
+}
+
+// charBits returns the bits per character and the baud rate of c.
+func (c Config) charBits() (bits, baud int) {
+	c, _ = c.normalize()
+	if c.BaudRate <= 0 {
+		c.BaudRate = 19200 // of an invalid configuration
+	}
+	bits = 1 + c.DataBits + c.StopBits // with the start bit
+	if c.Parity != ParityNone {
+		bits++
+	}
+	return bits, c.BaudRate
+}
+
+// Open opens the port configured by c.
+func Open(c Config) (Port, error) {
+	if c.Address == "" {
+		return nil, errors.New("serial: no address")
+	}
+	c, err := c.normalize()
+	if err != nil {
+		return nil, err
+	}
+	return open(c)
+}
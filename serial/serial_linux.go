@@ -0,0 +1,247 @@
+//go:build linux && (386 || amd64 || arm || arm64 || loong64 || riscv64 || s390x) && !modbus_noserial
+
+package serial
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Terminal ioctls and flags of the generic Linux ABI.
+const (
+	tcgets     = 0x5401
+	tcsets     = 0x5402
+	tcsbrk     = 0x5409
+	tcflsh     = 0x540B
+	tiocexcl   = 0x540C
+	tiocmbis   = 0x5416
+	tiocmbic   = 0x5417
+	tiocsrs485 = 0x542F
+
+	tciflush = 0
+	tiocmRTS = 0x004
+
+	inpck  = 0x010
+	csize  = 0x030
+	cstopb = 0x040
+	cread  = 0x080
+	parenb = 0x100
+	parodd = 0x200
+	clocal = 0x800
+
+	vtime = 5
+	vmin  = 6
+
+	serRS485Enabled      = 1 << 0
+	serRS485RTSOnSend    = 1 << 1
+	serRS485RTSAfterSend = 1 << 2
+)
+
+var baudRates = map[int]uint32{
+	300:    0x0007,
+	600:    0x0008,
+	1200:   0x0009,
+	2400:   0x000B,
+	4800:   0x000C,
+	9600:   0x000D,
+	19200:  0x000E,
+	38400:  0x000F,
+	57600:  0x1001,
+	115200: 0x1002,
+	230400: 0x1003,
+	460800: 0x1004,
+	921600: 0x1007,
+}
+
+// serialRS485 is the kernel's struct serial_rs485.
+type serialRS485 struct {
+	flags              uint32
+	delayRTSBeforeSend uint32 // milliseconds
+	delayRTSAfterSend  uint32
+	padding            [5]uint32
+}
+
+type port struct {
+	f       *os.File
+	rc      syscall.RawConn
+	timeout time.Duration
+	rs485   *RS485 // set if RTS is switched by Write
+
+	mu        sync.Mutex
+	deadline  time.Time // set by SetReadDeadline
+	timeoutAt time.Time // of the last Read, with a timeout
+}
+
+func open(c Config) (Port, error) {
+	speed, ok := baudRates[c.BaudRate]
+	if !ok {
+		return nil, fmt.Errorf("serial: unsupported baud rate %d", c.BaudRate)
+	}
+	// non blocking, so that the runtime poller serves reads and their
+	// deadlines
+	fd, err := syscall.Open(c.Address, syscall.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: c.Address, Err: err}
+	}
+	f := os.NewFile(uintptr(fd), c.Address)
+	p := &port{f: f, timeout: c.Timeout}
+	if p.rc, err = f.SyscallConn(); err == nil {
+		err = p.configure(c, speed)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// configure puts the terminal in raw mode with the framing of c.
+func (p *port) configure(c Config, speed uint32) error {
+	var t syscall.Termios
+	if err := p.ioctlPtr(tcgets, unsafe.Pointer(&t)); err != nil {
+		return fmt.Errorf("serial: %s: %w", p.f.Name(), err)
+	}
+	t.Iflag, t.Oflag, t.Lflag = 0, 0, 0
+	t.Cflag = cread | clocal | speed | uint32(c.DataBits-5)<<4&csize
+	if c.StopBits == 2 {
+		t.Cflag |= cstopb
+	}
+	switch c.Parity {
+	case ParityEven:
+		t.Cflag |= parenb
+		t.Iflag |= inpck
+	case ParityOdd:
+		t.Cflag |= parenb | parodd
+		t.Iflag |= inpck
+	}
+	t.Cc[vmin], t.Cc[vtime] = 1, 0
+	if err := p.ioctlPtr(tcsets, unsafe.Pointer(&t)); err != nil {
+		return fmt.Errorf("serial: configuring %s: %w", p.f.Name(), err)
+	}
+	if err := p.ioctl(tiocexcl, 0); err != nil {
+		return fmt.Errorf("serial: locking %s: %w", p.f.Name(), err)
+	}
+
+	r := c.RS485
+	if r == nil {
+		return nil
+	}
+	if !r.Software {
+		k := serialRS485{
+			flags:              serRS485Enabled | serRS485RTSOnSend,
+			delayRTSBeforeSend: uint32(r.DelayBeforeSend / time.Millisecond),
+			delayRTSAfterSend:  uint32(r.DelayAfterSend / time.Millisecond),
+		}
+		if r.ActiveLow {
+			k.flags = serRS485Enabled | serRS485RTSAfterSend
+		}
+		if p.ioctlPtr(tiocsrs485, unsafe.Pointer(&k)) == nil {
+			return nil
+		}
+		// the driver lacks RS-485 mode
+	}
+	p.rs485 = r
+	if err := p.setRTS(false); err != nil {
+		return fmt.Errorf("serial: switching RTS of %s: %w", p.f.Name(), err)
+	}
+	return nil
+}
+
+func (p *port) ioctl(req, arg uintptr) error {
+	var errno syscall.Errno
+	err := p.rc.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, req, arg)
+	})
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func (p *port) ioctlPtr(req uintptr, arg unsafe.Pointer) error {
+	var errno syscall.Errno
+	err := p.rc.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg))
+	})
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// setRTS switches the transmitter on or off.
+func (p *port) setRTS(transmit bool) error {
+	req := uintptr(tiocmbic)
+	if transmit != p.rs485.ActiveLow {
+		req = tiocmbis
+	}
+	bits := int32(tiocmRTS)
+	return p.ioctlPtr(req, unsafe.Pointer(&bits))
+}
+
+// earliest returns the earlier of two deadlines, zero meaning none.
+func earliest(a, b time.Time) time.Time {
+	if a.IsZero() || !b.IsZero() && b.Before(a) {
+		return b
+	}
+	return a
+}
+
+func (p *port) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	if p.timeout > 0 {
+		p.timeoutAt = time.Now().Add(p.timeout)
+	}
+	err := p.f.SetReadDeadline(earliest(p.deadline, p.timeoutAt))
+	p.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	return p.f.Read(b)
+}
+
+// SetReadDeadline sets the deadline of current and future reads, in
+// addition to the Timeout of each. A zero t means no deadline.
+func (p *port) SetReadDeadline(t time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.deadline = t
+	return p.f.SetReadDeadline(earliest(t, p.timeoutAt))
+}
+
+func (p *port) Write(b []byte) (int, error) {
+	if p.rs485 == nil {
+		return p.f.Write(b)
+	}
+	if err := p.setRTS(true); err != nil {
+		return 0, err
+	}
+	time.Sleep(p.rs485.DelayBeforeSend)
+	n, err := p.f.Write(b)
+	if err == nil {
+		err = p.ioctl(tcsbrk, 1) // tcdrain: wait for the data to be sent
+	}
+	time.Sleep(p.rs485.DelayAfterSend)
+	if err2 := p.setRTS(false); err == nil {
+		err = err2
+	}
+	return n, err
+}
+
+func (p *port) Flush() error {
+	return p.ioctl(tcflsh, tciflush)
+}
+
+func (p *port) Close() error {
+	return p.f.Close()
+}
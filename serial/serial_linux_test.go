@@ -0,0 +1,132 @@
+//go:build linux && (386 || amd64 || arm || arm64 || loong64 || riscv64 || s390x) && !modbus_noserial
+
+package serial
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// openPTY returns the master of a new pseudo terminal and the path of
+// its slave, which stands in for a serial port.
+func openPTY(t *testing.T) (*os.File, string) {
+	m, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("no pseudo terminals: %v", err)
+	}
+	t.Cleanup(func() { m.Close() })
+	var unlock int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, m.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+		t.Fatalf("unlocking pty: %v", errno)
+	}
+	var n uint32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, m.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); errno != 0 {
+		t.Fatalf("pty number: %v", errno)
+	}
+	return m, fmt.Sprintf("/dev/pts/%d", n)
+}
+
+func TestPort(t *testing.T) {
+	m, name := openPTY(t)
+	p, err := Open(Config{Address: name, BaudRate: 9600, Parity: ParityNone, Timeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	// raw mode: bytes pass unchanged, without echo
+	frame := []byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x01, 0x84, 0x0A, '\n', 0x03}
+	if _, err := m.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, len(frame))
+	if _, err := io.ReadFull(p, got); err != nil || string(got) != string(frame) {
+		t.Errorf("read % X, %v", got, err)
+	}
+	if _, err := p.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(m, got); err != nil || string(got) != string(frame) {
+		t.Errorf("master read % X, %v", got, err)
+	}
+
+	start := time.Now()
+	if _, err := p.Read(got); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("idle read should time out, not %v", err)
+	} else if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > time.Second {
+		t.Errorf("timed out after %v", elapsed)
+	}
+
+	m.Write([]byte{0xFF, 0xFF})
+	time.Sleep(20 * time.Millisecond)
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	m.Write([]byte{0x01})
+	if n, err := p.Read(got); err != nil || n != 1 || got[0] != 0x01 {
+		t.Errorf("read after Flush % X, %v", got[:n], err)
+	}
+}
+
+func TestOpenUnsupportedBaudRate(t *testing.T) {
+	_, name := openPTY(t)
+	if _, err := Open(Config{Address: name, BaudRate: 12345}); err == nil {
+		t.Errorf("Open with an unsupported baud rate should fail")
+	}
+}
+
+func TestReadContext(t *testing.T) {
+	m, name := openPTY(t)
+	p, err := Open(Config{Address: name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	// no Timeout: only the context ends the read of an idle line
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	b := make([]byte, 4)
+	start := time.Now()
+	if _, err := ReadContext(ctx, p, b); err != context.DeadlineExceeded {
+		t.Errorf("ReadContext of an idle line = %v; want %v", err, context.DeadlineExceeded)
+	} else if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("canceled after %v", elapsed)
+	}
+
+	// the deadline set by cancellation does not outlive it
+	m.Write([]byte{0x01})
+	if n, err := ReadContext(context.Background(), p, b); err != nil || n != 1 {
+		t.Errorf("ReadContext after cancellation = %d, %v", n, err)
+	}
+}
+
+func TestCloseUnblocksRead(t *testing.T) {
+	_, name := openPTY(t)
+	p, err := Open(Config{Address: name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		_, err := p.Read(make([]byte, 1))
+		errc <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	p.Close()
+	select {
+	case err := <-errc:
+		if !errors.Is(err, os.ErrClosed) {
+			t.Errorf("Read after Close = %v; want %v", err, os.ErrClosed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not end the Read")
+	}
+}
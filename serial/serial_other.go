@@ -0,0 +1,13 @@
+//go:build !linux || !(386 || amd64 || arm || arm64 || loong64 || riscv64 || s390x) || modbus_noserial
+
+package serial
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+)
+
+func open(c Config) (Port, error) {
+	return nil, fmt.Errorf("serial: %w on %s/%s", errors.ErrUnsupported, runtime.GOOS, runtime.GOARCH)
+}
@@ -0,0 +1,52 @@
+package serial
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfigDefaults(t *testing.T) {
+	c, err := Config{}.normalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.BaudRate != 19200 || c.DataBits != 8 || c.Parity != ParityEven || c.StopBits != 1 {
+		t.Errorf("defaults %+v", c)
+	}
+	if c, _ = (Config{Parity: ParityNone}).normalize(); c.StopBits != 2 {
+		t.Errorf("%d stop bits without parity; want 2", c.StopBits)
+	}
+	for _, c := range []Config{
+		{BaudRate: -1}, {DataBits: 9}, {Parity: 'X'}, {StopBits: 3},
+	} {
+		if _, err := c.normalize(); err == nil {
+			t.Errorf("%+v should be invalid", c)
+		}
+	}
+	if _, err := Open(Config{}); err == nil {
+		t.Errorf("Open without an address should fail")
+	}
+}
+
+func TestFrameGap(t *testing.T) {
+	tests := []struct {
+		c    Config
+		char time.Duration
+		gap  time.Duration
+	}{
+		// 11 bit characters
+		{Config{BaudRate: 9600}, 1145833 * time.Nanosecond, 4010416 * time.Nanosecond},
+		{Config{}, 572916 * time.Nanosecond, 2005208 * time.Nanosecond},
+		{Config{BaudRate: 115200}, 95486 * time.Nanosecond, 1750 * time.Microsecond},
+		// 7E1 Modbus ASCII: 10 bit characters
+		{Config{BaudRate: 9600, DataBits: 7}, 1041666 * time.Nanosecond, 3645833 * time.Nanosecond},
+	}
+	for _, test := range tests {
+		if char := test.c.CharTime(); char != test.char {
+			t.Errorf("%+v: CharTime %v; want %v", test.c, char, test.char)
+		}
+		if gap := test.c.FrameGap(); gap != test.gap {
+			t.Errorf("%+v: FrameGap %v; want %v", test.c, gap, test.gap)
+		}
+	}
+}
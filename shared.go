@@ -0,0 +1,43 @@
+package modbus
+
+import "net"
+
+// A SharedClient is a Client safe for concurrent use by any number of
+// goroutines over a single TCP connection, so applications need not
+// serialise their calls with locking of their own.
+//
+// Each call is given its own transaction identifier and its response is
+// matched back to it, so up to MaxInFlight calls may be outstanding at
+// once; further calls wait their turn in the order they were made, unless
+// given a Priority with WithPriority: operator initiated writes made with
+// PriorityHigh, for instance, are sent ahead of background polling.
+type SharedClient struct {
+	*Client
+	conn *ClientConn
+}
+
+// NewSharedClient returns a SharedClient using conn with up to
+// maxInFlight outstanding transactions. Most slaves serve one
+// transaction at a time; maxInFlight values above 1 should only be used
+// with slaves known to accept pipelined requests.
+func NewSharedClient(conn net.Conn, maxInFlight int) *SharedClient {
+	cc := NewClientConn(conn)
+	cc.MaxInFlight = maxInFlight
+	return &SharedClient{Client: &Client{Transport: cc}, conn: cc}
+}
+
+// DialShared connects to the Modbus TCP slave at addr and returns a
+// SharedClient using the connection.
+func DialShared(addr string, maxInFlight int) (*SharedClient, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewSharedClient(conn, maxInFlight), nil
+}
+
+// Conn returns the underlying ClientConn, e.g. to set its Timeout before
+// the first call.
+func (c *SharedClient) Conn() *ClientConn {
+	return c.conn
+}
@@ -0,0 +1,43 @@
+package modbus
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSharedClientConcurrent(t *testing.T) {
+	h := &RegisterHandler{Holdings: make([]uint16, 16)}
+	for i := range h.Holdings {
+		h.Holdings[i] = uint16(i) * 0x0101
+	}
+	addr := startTestServer(t, &Server{Handler: h})
+
+	c, err := DialShared(addr, 1)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+	c.Conn().Timeout = 5 * time.Second
+
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				values, err := c.ReadHoldingRegisters(context.Background(), 1, uint16(g), 1)
+				if err != nil {
+					t.Errorf("read: %v", err)
+					return
+				}
+				if values[0] != uint16(g)*0x0101 {
+					t.Errorf("goroutine %d read 0x%04X", g, values[0])
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,359 @@
+//go:build unix
+
+package modbus
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+// A SharedStore is a DataStore kept in a memory mapped file, letting
+// another process - typically a C or real-time control task - read and
+// write the data image directly while the slave serves it. A POSIX shared
+// memory object is used by passing its path under /dev/shm.
+//
+// The file starts with a 64 byte header, followed by the four tables in
+// order, each starting at a multiple of 8 bytes from the start of the
+// file:
+//
+//	offset  size  field
+//	0       4     magic "MBSS"
+//	4       2     layout version, 1
+//	6       2     flags; bit 0 set when the seqlock is in use
+//	8       4     sequence counter of the seqlock
+//	12      4     reserved, zero
+//	16      4     number of coils
+//	20      4     number of discrete inputs
+//	24      4     number of input registers
+//	28      4     number of holding registers
+//	32      32    reserved, zero
+//	64            coils, one byte each, 0 or 1
+//	              discrete inputs, one byte each, 0 or 1
+//	              input registers, uint16 each
+//	              holding registers, uint16 each
+//
+// All header fields and registers are in host byte order.
+//
+// When the seqlock is in use, every access to the tables follows the
+// protocol below; otherwise each coil and register is simply read and
+// written in place and only single values are consistent.
+//
+// A writer atomically changes an even sequence counter to the next, odd,
+// value with a compare-and-swap, retrying while the counter is odd or
+// the swap fails. It then writes the tables and atomically adds one more,
+// leaving the counter even. Writers therefore exclude one another.
+//
+// A reader loads the counter, retrying while it is odd, copies the values
+// it needs, and loads the counter again. If the counter changed, the copy
+// may be torn and the read is repeated.
+type SharedStore struct {
+	mu  sync.RWMutex // orders accesses within this process
+	mem []byte
+
+	seq      *uint32
+	seqlock  bool
+	coils    []byte
+	discrete []byte
+	inputs   []byte
+	holdings []byte
+}
+
+// A SharedLayout gives the table sizes of a new SharedStore.
+type SharedLayout struct {
+	Coils            int
+	DiscreteInputs   int
+	InputRegisters   int
+	HoldingRegisters int
+
+	// Seqlock enables the sequence lock protocol for multi value
+	// consistency.
+	Seqlock bool
+}
+
+const (
+	sharedMagic      = "MBSS"
+	sharedVersion    = 1
+	sharedHeaderSize = 64
+	sharedSeqlock    = 1 << 0
+)
+
+// ErrSharedLayout is returned when opening a file that does not hold a
+// SharedStore of a supported layout version.
+var ErrSharedLayout = errors.New("modbus: invalid shared store layout")
+
+// ErrSharedStoreClosed is returned by the methods of a SharedStore once
+// it is closed.
+var ErrSharedStoreClosed = errors.New("modbus: shared store closed")
+
+// sharedSections returns the offsets of the four tables and the file size
+// for the given table sizes.
+func sharedSections(n [4]int) (off [4]int, size int) {
+	elem := [4]int{1, 1, 2, 2}
+	size = sharedHeaderSize
+	for i := range n {
+		off[i] = size
+		size += (n[i]*elem[i] + 7) &^ 7
+	}
+	return off, size
+}
+
+// CreateSharedStore creates, or truncates, the file at path and maps it
+// as a SharedStore of the given layout with all values zero.
+func CreateSharedStore(path string, layout SharedLayout) (*SharedStore, error) {
+	n := [4]int{layout.Coils, layout.DiscreteInputs, layout.InputRegisters, layout.HoldingRegisters}
+	for _, v := range n {
+		if v < 0 || v > 0x10000 {
+			return nil, errors.New("modbus: shared store table size out of range")
+		}
+	}
+	_, size := sharedSections(n)
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var hdr [sharedHeaderSize]byte
+	copy(hdr[0:4], sharedMagic)
+	binary.NativeEndian.PutUint16(hdr[4:6], sharedVersion)
+	if layout.Seqlock {
+		binary.NativeEndian.PutUint16(hdr[6:8], sharedSeqlock)
+	}
+	for i, v := range n {
+		binary.NativeEndian.PutUint32(hdr[16+4*i:], uint32(v))
+	}
+	if err = f.Truncate(int64(size)); err != nil {
+		return nil, err
+	}
+	if _, err = f.WriteAt(hdr[:], 0); err != nil {
+		return nil, err
+	}
+	return mapSharedStore(f)
+}
+
+// OpenSharedStore maps the existing SharedStore file at path.
+func OpenSharedStore(path string) (*SharedStore, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return mapSharedStore(f)
+}
+
+func mapSharedStore(f *os.File) (*SharedStore, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() < sharedHeaderSize {
+		return nil, ErrSharedLayout
+	}
+	mem, err := syscall.Mmap(int(f.Fd()), 0, int(fi.Size()), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+
+	var n [4]int
+	for i := range n {
+		n[i] = int(binary.NativeEndian.Uint32(mem[16+4*i:]))
+	}
+	off, size := sharedSections(n)
+	if string(mem[0:4]) != sharedMagic ||
+		binary.NativeEndian.Uint16(mem[4:6]) != sharedVersion ||
+		size > len(mem) {
+		syscall.Munmap(mem)
+		return nil, ErrSharedLayout
+	}
+
+	return &SharedStore{
+		mem:      mem,
+		seq:      (*uint32)(unsafe.Pointer(&mem[8])),
+		seqlock:  binary.NativeEndian.Uint16(mem[6:8])&sharedSeqlock != 0,
+		coils:    mem[off[0] : off[0]+n[0]],
+		discrete: mem[off[1] : off[1]+n[1]],
+		inputs:   mem[off[2] : off[2]+2*n[2]],
+		holdings: mem[off[3] : off[3]+2*n[3]],
+	}, nil
+}
+
+// Close unmaps the store. Its methods return ErrSharedStoreClosed
+// afterwards.
+func (s *SharedStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.mem == nil {
+		return nil
+	}
+	err := syscall.Munmap(s.mem)
+	s.mem, s.seq = nil, nil
+	s.coils, s.discrete, s.inputs, s.holdings = nil, nil, nil, nil
+	return err
+}
+
+// read calls copyOut until it has run without a concurrent writer. The
+// tables must only be accessed within copyOut.
+func (s *SharedStore) read(ctx context.Context, copyOut func() error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.mem == nil {
+		return ErrSharedStoreClosed
+	}
+	if !s.seqlock {
+		return copyOut()
+	}
+	for {
+		seq := atomic.LoadUint32(s.seq)
+		if seq&1 == 0 {
+			if err := copyOut(); err != nil {
+				return err
+			}
+			if atomic.LoadUint32(s.seq) == seq {
+				return nil
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		runtime.Gosched()
+	}
+}
+
+// write calls copyIn while holding the sequence lock. The tables must
+// only be accessed within copyIn.
+func (s *SharedStore) write(ctx context.Context, copyIn func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.mem == nil {
+		return ErrSharedStoreClosed
+	}
+	if !s.seqlock {
+		return copyIn()
+	}
+	for {
+		seq := atomic.LoadUint32(s.seq)
+		if seq&1 == 0 && atomic.CompareAndSwapUint32(s.seq, seq, seq+1) {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		runtime.Gosched()
+	}
+	err := copyIn()
+	atomic.AddUint32(s.seq, 1)
+	return err
+}
+
+// The following functions access the table held by *table, which Close
+// clears.
+
+func (s *SharedStore) readBits(ctx context.Context, table *[]byte, addr, quantity uint16) ([]bool, error) {
+	values := make([]bool, quantity)
+	err := s.read(ctx, func() error {
+		t := *table
+		if int(addr)+int(quantity) > len(t) {
+			return ErrIllegalDataAddress
+		}
+		for i := range values {
+			values[i] = t[int(addr)+i] != 0
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func (s *SharedStore) readRegisters(ctx context.Context, table *[]byte, addr, quantity uint16) ([]uint16, error) {
+	values := make([]uint16, quantity)
+	err := s.read(ctx, func() error {
+		t := *table
+		if 2*(int(addr)+int(quantity)) > len(t) {
+			return ErrIllegalDataAddress
+		}
+		for i := range values {
+			values[i] = binary.NativeEndian.Uint16(t[2*(int(addr)+i):])
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func (s *SharedStore) writeBits(ctx context.Context, table *[]byte, addr uint16, values []bool) error {
+	return s.write(ctx, func() error {
+		t := *table
+		if int(addr)+len(values) > len(t) {
+			return ErrIllegalDataAddress
+		}
+		for i, v := range values {
+			var b byte
+			if v {
+				b = 1
+			}
+			t[int(addr)+i] = b
+		}
+		return nil
+	})
+}
+
+func (s *SharedStore) writeRegisters(ctx context.Context, table *[]byte, addr uint16, values []uint16) error {
+	return s.write(ctx, func() error {
+		t := *table
+		if 2*(int(addr)+len(values)) > len(t) {
+			return ErrIllegalDataAddress
+		}
+		for i, v := range values {
+			binary.NativeEndian.PutUint16(t[2*(int(addr)+i):], v)
+		}
+		return nil
+	})
+}
+
+func (s *SharedStore) ReadCoils(ctx context.Context, addr, quantity uint16) ([]bool, error) {
+	return s.readBits(ctx, &s.coils, addr, quantity)
+}
+
+func (s *SharedStore) ReadDiscreteInputs(ctx context.Context, addr, quantity uint16) ([]bool, error) {
+	return s.readBits(ctx, &s.discrete, addr, quantity)
+}
+
+func (s *SharedStore) ReadInputRegisters(ctx context.Context, addr, quantity uint16) ([]uint16, error) {
+	return s.readRegisters(ctx, &s.inputs, addr, quantity)
+}
+
+func (s *SharedStore) ReadHoldingRegisters(ctx context.Context, addr, quantity uint16) ([]uint16, error) {
+	return s.readRegisters(ctx, &s.holdings, addr, quantity)
+}
+
+func (s *SharedStore) WriteCoils(ctx context.Context, addr uint16, values []bool) error {
+	return s.writeBits(ctx, &s.coils, addr, values)
+}
+
+func (s *SharedStore) WriteHoldingRegisters(ctx context.Context, addr uint16, values []uint16) error {
+	return s.writeRegisters(ctx, &s.holdings, addr, values)
+}
+
+// WriteDiscreteInputs sets discrete inputs starting at addr. Masters
+// cannot write discrete inputs; this is for the local process.
+func (s *SharedStore) WriteDiscreteInputs(ctx context.Context, addr uint16, values []bool) error {
+	return s.writeBits(ctx, &s.discrete, addr, values)
+}
+
+// WriteInputRegisters sets input registers starting at addr. Masters
+// cannot write input registers; this is for the local process.
+func (s *SharedStore) WriteInputRegisters(ctx context.Context, addr uint16, values []uint16) error {
+	return s.writeRegisters(ctx, &s.inputs, addr, values)
+}
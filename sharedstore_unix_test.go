@@ -0,0 +1,134 @@
+//go:build unix
+
+package modbus
+
+import (
+	"context"
+	"encoding/binary"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSharedStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "image")
+	layout := SharedLayout{Coils: 10, DiscreteInputs: 3, InputRegisters: 5, HoldingRegisters: 7, Seqlock: true}
+	s, err := CreateSharedStore(path, layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// a second mapping stands in for the other process
+	peer, err := OpenSharedStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	ctx := context.Background()
+	if err := s.WriteHoldingRegisters(ctx, 5, []uint16{0x1234, 0xABCD}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := peer.ReadHoldingRegisters(ctx, 5, 2); err != nil || !reflect.DeepEqual(got, []uint16{0x1234, 0xABCD}) {
+		t.Errorf("ReadHoldingRegisters = %v, %v", got, err)
+	}
+	if err := peer.WriteCoils(ctx, 8, []bool{true, true}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.ReadCoils(ctx, 7, 3); err != nil || !reflect.DeepEqual(got, []bool{false, true, true}) {
+		t.Errorf("ReadCoils = %v, %v", got, err)
+	}
+	if _, err := s.ReadInputRegisters(ctx, 4, 2); err != ErrIllegalDataAddress {
+		t.Errorf("ReadInputRegisters out of range = %v; want %v", err, ErrIllegalDataAddress)
+	}
+
+	// host byte order registers in the documented place
+	off, _ := sharedSections([4]int{10, 3, 5, 7})
+	if v := binary.NativeEndian.Uint16(peer.mem[off[3]+10:]); v != 0x1234 {
+		t.Errorf("holding register 5 at offset %d = 0x%04X", off[3]+10, v)
+	}
+}
+
+func TestSharedStoreSeqlock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "image")
+	s, err := CreateSharedStore(path, SharedLayout{HoldingRegisters: 1, Seqlock: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// another process holds the sequence lock
+	*s.seq = 1
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := s.ReadHoldingRegisters(ctx, 0, 1); err != context.DeadlineExceeded {
+		t.Errorf("read during write = %v; want %v", err, context.DeadlineExceeded)
+	}
+	if err := s.WriteHoldingRegisters(ctx, 0, []uint16{1}); err != context.DeadlineExceeded {
+		t.Errorf("write during write = %v; want %v", err, context.DeadlineExceeded)
+	}
+
+	*s.seq = 2
+	if err := s.WriteHoldingRegisters(context.Background(), 0, []uint16{1}); err != nil {
+		t.Fatal(err)
+	}
+	if *s.seq != 4 {
+		t.Errorf("sequence = %d after write; want 4", *s.seq)
+	}
+	if err := s.WriteHoldingRegisters(context.Background(), 1, []uint16{1}); err != ErrIllegalDataAddress {
+		t.Errorf("write out of range = %v; want %v", err, ErrIllegalDataAddress)
+	}
+	if *s.seq&1 != 0 {
+		t.Errorf("sequence = %d after failed write; want even", *s.seq)
+	}
+}
+
+func TestSharedStoreClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "image")
+	s, err := CreateSharedStore(path, SharedLayout{Coils: 1, DiscreteInputs: 1, InputRegisters: 1, HoldingRegisters: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("second Close = %v", err)
+	}
+
+	ctx := context.Background()
+	if _, err := s.ReadCoils(ctx, 0, 1); err != ErrSharedStoreClosed {
+		t.Errorf("ReadCoils after Close = %v; want %v", err, ErrSharedStoreClosed)
+	}
+	if _, err := s.ReadDiscreteInputs(ctx, 0, 1); err != ErrSharedStoreClosed {
+		t.Errorf("ReadDiscreteInputs after Close = %v; want %v", err, ErrSharedStoreClosed)
+	}
+	if _, err := s.ReadInputRegisters(ctx, 0, 1); err != ErrSharedStoreClosed {
+		t.Errorf("ReadInputRegisters after Close = %v; want %v", err, ErrSharedStoreClosed)
+	}
+	if _, err := s.ReadHoldingRegisters(ctx, 0, 1); err != ErrSharedStoreClosed {
+		t.Errorf("ReadHoldingRegisters after Close = %v; want %v", err, ErrSharedStoreClosed)
+	}
+	if err := s.WriteCoils(ctx, 0, []bool{true}); err != ErrSharedStoreClosed {
+		t.Errorf("WriteCoils after Close = %v; want %v", err, ErrSharedStoreClosed)
+	}
+	if err := s.WriteHoldingRegisters(ctx, 0, []uint16{1}); err != ErrSharedStoreClosed {
+		t.Errorf("WriteHoldingRegisters after Close = %v; want %v", err, ErrSharedStoreClosed)
+	}
+}
+
+func TestOpenSharedStoreInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "image")
+	s, err := CreateSharedStore(path, SharedLayout{Coils: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(s.mem, "XXXX")
+	s.Close()
+	if _, err := OpenSharedStore(path); err != ErrSharedLayout {
+		t.Errorf("OpenSharedStore = %v; want %v", err, ErrSharedLayout)
+	}
+}
@@ -0,0 +1,153 @@
+package simulator
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	modbus "github.com/mubeta06/gomodbus"
+)
+
+// A Latency draws the delay of a response from r.
+type Latency func(r *rand.Rand) time.Duration
+
+// FixedLatency returns a Latency of d.
+func FixedLatency(d time.Duration) Latency {
+	return func(*rand.Rand) time.Duration { return d }
+}
+
+// UniformLatency returns a Latency distributed uniformly between min and
+// max inclusive.
+func UniformLatency(min, max time.Duration) Latency {
+	return func(r *rand.Rand) time.Duration {
+		if max <= min {
+			return min
+		}
+		return min + time.Duration(r.Int63n(int64(max-min)+1))
+	}
+}
+
+// NormalLatency returns a normally distributed Latency, negative draws
+// being taken as zero.
+func NormalLatency(mean, stddev time.Duration) Latency {
+	return func(r *rand.Rand) time.Duration {
+		d := mean + time.Duration(r.NormFloat64()*float64(stddev))
+		if d < 0 {
+			return 0
+		}
+		return d
+	}
+}
+
+// ExponentialLatency returns an exponentially distributed Latency, whose
+// long tail resembles the response times of a loaded device.
+func ExponentialLatency(mean time.Duration) Latency {
+	return func(r *rand.Rand) time.Duration {
+		return time.Duration(r.ExpFloat64() * float64(mean))
+	}
+}
+
+// Faults injects the failures of real devices into the responses of a
+// handler, for testing the timeout and retry logic of masters. Its
+// Middleware may be installed with modbus.Server.Use.
+//
+// The exported fields must not be changed after the first request.
+type Faults struct {
+	// Latency, if non nil, gives the delay before each response.
+	Latency Latency
+
+	// ExceptionRate is the fraction of requests answered with
+	// Exception, SlaveBusy if zero, without being served.
+	ExceptionRate float64
+	Exception     modbus.ExceptionCode
+
+	// DropRate is the fraction of requests left unanswered. They are
+	// served nonetheless, as by a device whose response is lost on the
+	// way back, so that masters retrying writes can be caught out.
+	DropRate float64
+
+	// Seed seeds the draws, so that runs can be repeated. If zero, the
+	// time is used.
+	Seed int64
+
+	mu   sync.Mutex // guards rand
+	rand *rand.Rand
+}
+
+// A fault is the treatment drawn for a request.
+type fault int
+
+const (
+	faultNone fault = iota
+	faultException
+	faultDrop
+)
+
+// draw returns the delay of a response and its fault.
+func (f *Faults) draw() (time.Duration, fault) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.rand == nil {
+		seed := f.Seed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		f.rand = rand.New(rand.NewSource(seed))
+	}
+	var d time.Duration
+	if f.Latency != nil {
+		d = f.Latency(f.rand)
+	}
+	switch p := f.rand.Float64(); {
+	case p < f.ExceptionRate:
+		return d, faultException
+	case p < f.ExceptionRate+f.DropRate:
+		return d, faultDrop
+	}
+	return d, faultNone
+}
+
+// Middleware returns h with the faults injected.
+func (f *Faults) Middleware(h modbus.Handler) modbus.Handler {
+	return modbus.HandlerFunc(func(w modbus.ResponseWriter, r *modbus.Frame) {
+		d, fault := f.draw()
+		if d > 0 && !sleep(r.Context(), d) {
+			return
+		}
+		switch fault {
+		case faultException:
+			code := f.Exception
+			if code == 0 {
+				code = modbus.SlaveBusy
+			}
+			w.WriteException(code)
+		case faultDrop:
+			h.ServeModbus(&discardWriter{header: *r.Header()}, r)
+		default:
+			h.ServeModbus(w, r)
+		}
+	})
+}
+
+// A discardWriter is a ResponseWriter discarding the response.
+type discardWriter struct {
+	header modbus.Header
+}
+
+func (w *discardWriter) Header() *modbus.Header                         { return &w.header }
+func (w *discardWriter) Write(p []byte) (int, error)                    { return len(p), nil }
+func (w *discardWriter) WriteHeader()                                   {}
+func (w *discardWriter) WriteException(code modbus.ExceptionCode) error { return nil }
+
+// sleep waits for d, returning false if ctx is done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,80 @@
+package simulator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	modbus "github.com/mubeta06/gomodbus"
+	"github.com/mubeta06/gomodbus/modbustest"
+)
+
+func TestFaults(t *testing.T) {
+	served := 0
+	h := modbus.HandlerFunc(func(w modbus.ResponseWriter, r *modbus.Frame) {
+		served++
+		w.Write([]byte{0})
+	})
+	f := &Faults{
+		Latency:       FixedLatency(10 * time.Millisecond),
+		ExceptionRate: 0.3,
+		DropRate:      0.3,
+		Seed:          1,
+	}
+	handler := f.Middleware(h)
+
+	exceptions, dropped := 0, 0
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		req := modbus.NewReadHoldingRegistersFrame(1, 0, 1)
+		w := modbustest.NewRecorder(req)
+		handler.ServeModbus(w, req)
+		if code, ok := w.Exception(); ok {
+			if code != modbus.SlaveBusy {
+				t.Errorf("exception %v; want SlaveBusy", code)
+			}
+			exceptions++
+		} else if !w.Written {
+			dropped++
+		}
+	}
+	if d := time.Since(start); d < 200*time.Millisecond {
+		t.Errorf("20 requests took %v; want at least 200ms", d)
+	}
+	if exceptions == 0 || dropped == 0 || exceptions+served != 20 {
+		t.Errorf("%d exceptions, %d dropped, %d served", exceptions, dropped, served)
+	}
+
+	// a master going away ends the delay
+	f = &Faults{Latency: FixedLatency(time.Hour)}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := modbus.NewReadHoldingRegistersFrame(1, 0, 1).WithContext(ctx)
+	w := modbustest.NewRecorder(req)
+	f.Middleware(h).ServeModbus(w, req)
+	if w.Written {
+		t.Errorf("response written after the master went away")
+	}
+}
+
+func TestLatency(t *testing.T) {
+	f := &Faults{Seed: 1}
+	f.draw()
+	for name, l := range map[string]Latency{
+		"uniform":     UniformLatency(10*time.Millisecond, 20*time.Millisecond),
+		"normal":      NormalLatency(15*time.Millisecond, 2*time.Millisecond),
+		"exponential": ExponentialLatency(15 * time.Millisecond),
+	} {
+		var sum time.Duration
+		for i := 0; i < 1000; i++ {
+			d := l(f.rand)
+			if d < 0 || name == "uniform" && (d < 10*time.Millisecond || d > 20*time.Millisecond) {
+				t.Fatalf("%s latency %v out of range", name, d)
+			}
+			sum += d
+		}
+		if mean := sum / 1000; mean < 13*time.Millisecond || mean > 17*time.Millisecond {
+			t.Errorf("%s latency mean %v; want about 15ms", name, mean)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package simulator
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A Generator returns the value of a signal at elapsed since the start
+// of the simulation. Generators of a Simulator are called from the
+// goroutines of their signals, so a Generator shared by several signals
+// must be safe for concurrent use, as those of this package are.
+type Generator func(elapsed time.Duration) float64
+
+// Constant returns a Generator of the value v.
+func Constant(v float64) Generator {
+	return func(time.Duration) float64 { return v }
+}
+
+// Sine returns a Generator of a sine wave of the given period oscillating
+// by amplitude about offset.
+func Sine(offset, amplitude float64, period time.Duration) Generator {
+	return func(elapsed time.Duration) float64 {
+		return offset + amplitude*math.Sin(2*math.Pi*float64(elapsed)/float64(period))
+	}
+}
+
+// Ramp returns a Generator rising linearly from from to to over period,
+// then starting again from from; a sawtooth. To may be less than from
+// for a falling ramp.
+func Ramp(from, to float64, period time.Duration) Generator {
+	return func(elapsed time.Duration) float64 {
+		frac := float64(elapsed%period) / float64(period)
+		return from + (to-from)*frac
+	}
+}
+
+// Noise returns a Generator of values distributed uniformly within
+// amplitude of center, drawn from a source seeded with seed so that runs
+// can be repeated.
+func Noise(center, amplitude float64, seed int64) Generator {
+	var mu sync.Mutex
+	r := rand.New(rand.NewSource(seed))
+	return func(time.Duration) float64 {
+		mu.Lock()
+		defer mu.Unlock()
+		return center + amplitude*(2*r.Float64()-1)
+	}
+}
+
+// Sum returns a Generator of the sum of the values of gs, e.g. of a
+// Sine and some Noise.
+func Sum(gs ...Generator) Generator {
+	return func(elapsed time.Duration) float64 {
+		var v float64
+		for _, g := range gs {
+			v += g(elapsed)
+		}
+		return v
+	}
+}
+
+// CSV returns a Generator playing back a recording read from r. Each
+// record holds the time of a sample, in seconds from the start of the
+// recording, followed by values; column selects the value played back,
+// counting from 1 for the first after the time. A first record whose
+// time does not parse is skipped as a header. The value of the latest
+// sample at or before the elapsed time is returned, and the recording
+// is repeated once its last sample is reached.
+func CSV(r io.Reader, column int) (Generator, error) {
+	if column < 1 {
+		return nil, fmt.Errorf("simulator: invalid CSV column %d", column)
+	}
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	var times []time.Duration
+	var values []float64
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		secs, err := strconv.ParseFloat(rec[0], 64)
+		if err != nil && line == 1 {
+			continue // a header
+		}
+		if err != nil {
+			return nil, fmt.Errorf("simulator: CSV line %d: %v", line, err)
+		}
+		if column >= len(rec) {
+			return nil, fmt.Errorf("simulator: CSV line %d has no column %d", line, column)
+		}
+		v, err := strconv.ParseFloat(rec[column], 64)
+		if err != nil {
+			return nil, fmt.Errorf("simulator: CSV line %d: %v", line, err)
+		}
+		t := time.Duration(secs * float64(time.Second))
+		if n := len(times); n > 0 && t <= times[n-1] {
+			return nil, fmt.Errorf("simulator: CSV line %d: time does not increase", line)
+		}
+		times = append(times, t)
+		values = append(values, v)
+	}
+	if len(times) == 0 {
+		return nil, errors.New("simulator: empty CSV recording")
+	}
+
+	length := times[len(times)-1]
+	return func(elapsed time.Duration) float64 {
+		if length > 0 {
+			elapsed %= length
+		}
+		i := sort.Search(len(times), func(i int) bool { return times[i] > elapsed })
+		if i == 0 {
+			return values[0] // before the first sample
+		}
+		return values[i-1]
+	}, nil
+}
@@ -0,0 +1,125 @@
+// Package simulator provides simulated Modbus devices whose points are
+// driven by signal generators, such as sine waves, ramps, noise or the
+// playback of recorded CSV data, for the integration testing of SCADA
+// systems without real hardware. Faults make them as unreliable as real
+// devices, to exercise the masters' timeout and retry logic.
+package simulator
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	modbus "github.com/mubeta06/gomodbus"
+)
+
+// A Signal drives a point of a simulated device.
+type Signal struct {
+	// Point is the point set, decoded as by a modbus.Mapping.
+	Point modbus.Point
+
+	// Generator gives the value of the point over time.
+	Generator Generator
+
+	// Interval is the period of updates of the point. If zero, 1s is
+	// used.
+	Interval time.Duration
+}
+
+func (s *Signal) interval() time.Duration {
+	if s.Interval <= 0 {
+		return time.Second
+	}
+	return s.Interval
+}
+
+// A Simulator is a modbus.Handler serving a RegisterHandler whose points
+// are set by Signals. Masters may write driven holding registers and
+// coils, but their values are replaced at the next update.
+type Simulator struct {
+	*modbus.RegisterHandler
+
+	// ErrorLog specifies an optional logger for values that could not
+	// be set, such as generator values out of range of their point. If
+	// nil, logging goes to os.Stderr via the log package's standard
+	// logger.
+	ErrorLog *log.Logger
+
+	mapping *modbus.Mapping
+	signals []Signal
+}
+
+// New returns a Simulator driving the points of h with signals. The
+// points must have distinct names, and those in the input tables
+// require h to serve its slices, or a Store implementing
+// modbus.InputWriter.
+func New(h *modbus.RegisterHandler, signals ...Signal) (*Simulator, error) {
+	points := make([]modbus.Point, len(signals))
+	for i, s := range signals {
+		if s.Generator == nil {
+			return nil, fmt.Errorf("simulator: point %s has no generator", s.Point.Name)
+		}
+		points[i] = s.Point
+	}
+	m, err := modbus.NewMapping(points...)
+	if err != nil {
+		return nil, err
+	}
+	return &Simulator{RegisterHandler: h, mapping: m, signals: signals}, nil
+}
+
+func (s *Simulator) logf(format string, args ...interface{}) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// Step sets every point to the value of its generator at elapsed since
+// the start of the simulation, returning the first error. It allows
+// tests to drive a Simulator deterministically instead of running it.
+func (s *Simulator) Step(ctx context.Context, elapsed time.Duration) error {
+	var first error
+	for i := range s.signals {
+		if err := s.set(ctx, &s.signals[i], elapsed); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (s *Simulator) set(ctx context.Context, sig *Signal, elapsed time.Duration) error {
+	v := sig.Generator(elapsed)
+	return s.mapping.Set(ctx, s.DataStore(), sig.Point.Name, v)
+}
+
+// Run updates every point on the interval of its signal until ctx is
+// done, then returns ctx.Err(). Errors setting points are logged.
+func (s *Simulator) Run(ctx context.Context) error {
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := range s.signals {
+		sig := &s.signals[i]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(sig.interval())
+			defer ticker.Stop()
+			for {
+				if err := s.set(ctx, sig, time.Since(start)); err != nil && ctx.Err() == nil {
+					s.logf("simulator: setting %s: %v", sig.Point.Name, err)
+				}
+				select {
+				case <-ticker.C:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return ctx.Err()
+}
@@ -0,0 +1,117 @@
+package simulator
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	modbus "github.com/mubeta06/gomodbus"
+)
+
+func TestGenerators(t *testing.T) {
+	tests := []struct {
+		name    string
+		g       Generator
+		elapsed time.Duration
+		want    float64
+	}{
+		{"sine start", Sine(10, 5, 4*time.Second), 0, 10},
+		{"sine peak", Sine(10, 5, 4*time.Second), time.Second, 15},
+		{"sine trough", Sine(10, 5, 4*time.Second), 3 * time.Second, 5},
+		{"ramp", Ramp(0, 100, 10*time.Second), 2500 * time.Millisecond, 25},
+		{"ramp repeats", Ramp(0, 100, 10*time.Second), 12 * time.Second, 20},
+		{"falling ramp", Ramp(100, 0, 10*time.Second), time.Second, 90},
+		{"sum", Sum(Constant(1), Constant(2)), 0, 3},
+	}
+	for _, test := range tests {
+		if v := test.g(test.elapsed); math.Abs(v-test.want) > 1e-9 {
+			t.Errorf("%s: %v; want %v", test.name, v, test.want)
+		}
+	}
+
+	a, b := Noise(50, 2, 1), Noise(50, 2, 1)
+	for i := 0; i < 100; i++ {
+		v := a(0)
+		if v < 48 || v > 52 {
+			t.Fatalf("noise %v out of range", v)
+		}
+		if w := b(0); w != v {
+			t.Fatalf("noise of the same seed differs: %v, %v", v, w)
+		}
+	}
+}
+
+func TestCSV(t *testing.T) {
+	const recording = `time,voltage,current
+0,230.1,5
+1.5,229.8,6
+3,231.0,7
+`
+	g, err := CSV(strings.NewReader(recording), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		elapsed time.Duration
+		want    float64
+	}{
+		{0, 5}, {time.Second, 5}, {1500 * time.Millisecond, 6}, {2999 * time.Millisecond, 6},
+		{3 * time.Second, 5}, {4500 * time.Millisecond, 6}, // repeated
+	} {
+		if v := g(test.elapsed); v != test.want {
+			t.Errorf("at %v: %v; want %v", test.elapsed, v, test.want)
+		}
+	}
+
+	for _, bad := range []string{"", "0,1\n0,2\n", "0,1\nx,2\n", "0\n"} {
+		if _, err := CSV(strings.NewReader(bad), 1); err == nil {
+			t.Errorf("CSV(%q) should fail", bad)
+		}
+	}
+}
+
+func TestSimulator(t *testing.T) {
+	h := &modbus.RegisterHandler{Inputs: make([]uint16, 4), Coils: make([]bool, 1)}
+	s, err := New(h,
+		Signal{
+			Point:     modbus.Point{Name: "voltage", Table: modbus.InputRegisterTable, Type: modbus.Uint16, Scale: 0.1},
+			Generator: Sine(230, 10, 4*time.Second),
+		},
+		Signal{
+			Point:     modbus.Point{Name: "energy", Table: modbus.InputRegisterTable, Address: 2, Type: modbus.Float32},
+			Generator: Ramp(0, 1000, time.Hour),
+		},
+		Signal{
+			Point:     modbus.Point{Name: "running", Table: modbus.CoilTable, Type: modbus.Bool},
+			Generator: Constant(1),
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := s.Step(ctx, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	expected := []uint16{2400, 0, 0x3E8E, 0x38E4} // 1000/3600 as a float32
+	if !reflect.DeepEqual(h.Inputs, expected) || !h.Coils[0] {
+		t.Errorf("inputs %04X coils %v; want %04X [true]", h.Inputs, h.Coils, expected)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	h.Inputs[0] = 0
+	if err := s.Run(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Run = %v", err)
+	}
+	if v := h.Inputs[0]; v < 2300 || v > 2310 {
+		t.Errorf("voltage register %d after Run", v)
+	}
+
+	if _, err := New(h, Signal{Point: modbus.Point{Name: "x"}}); err == nil {
+		t.Errorf("New should fail for a signal without a generator")
+	}
+}
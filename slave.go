@@ -2,7 +2,9 @@ package modbus
 
 import (
 	"bufio"
-	"encoding/binary"
+	"bytes"
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
@@ -13,6 +15,11 @@ import (
 	"time"
 )
 
+// A Handler responds to a Modbus request.
+//
+// The request's data is reused for the next request on the connection,
+// so handlers must not retain the Frame, or slices of its Data, after
+// ServeModbus returns.
 type Handler interface {
 	ServeModbus(ResponseWriter, *Frame)
 }
@@ -22,9 +29,51 @@ type Handler interface {
 type ResponseWriter interface {
 	Header() *Header
 
+	// Write writes data as (part of) the response payload, writing the
+	// header first if needed. Output is buffered; once writing it to
+	// the connection has failed, Write returns the connection's error
+	// and the request's context is cancelled.
 	Write([]byte) (int, error)
 
 	WriteHeader()
+
+	// WriteException replies to the request with an exception response
+	// carrying code. It sets the exception bit of the function code and
+	// writes the header and exception code together. It fails if a
+	// response has already been written.
+	WriteException(code ExceptionCode) error
+}
+
+// The Flusher interface is implemented by ResponseWriters that allow a
+// Handler to send buffered data to the master before it returns, and so
+// learn whether the master is still there.
+type Flusher interface {
+	// Flush writes any buffered response data to the connection and
+	// returns the error of the connection if it failed, now or in an
+	// earlier write.
+	Flush() error
+}
+
+// The CloseNotifier interface is implemented by ResponseWriters that
+// allow detecting when the master has gone away, so that handlers doing
+// slow work, such as gateways waiting on a serial bus, can give up. The
+// request's context is cancelled at the same time.
+type CloseNotifier interface {
+	// CloseNotify returns a channel that receives a single value when
+	// the master's connection goes away, detected by it closing the
+	// connection or by a failed write. Detection is best effort: while
+	// a further request of the master is already buffered, a close is
+	// not noticed until the handler returns.
+	CloseNotify() <-chan bool
+}
+
+// WriteException sets the exception bit of w's function code and writes
+// code as the response payload. It is a helper for ResponseWriter
+// implementations of the WriteException method.
+func WriteException(w ResponseWriter, code ExceptionCode) error {
+	w.Header().Fcode |= 0x80
+	_, err := w.Write([]byte{byte(code)})
+	return err
 }
 
 // loggingConn is used for debugging.
@@ -80,6 +129,7 @@ func (w checkConnErrorWriter) Write(p []byte) (n int, err error) {
 	n, err = w.c.w.Write(p) // c.w == c.rwc, except after a hijack, when rwc is nil.
 	if err != nil && w.c.werr == nil {
 		w.c.werr = err
+		w.c.noteClientGone()
 	}
 	return
 }
@@ -87,18 +137,24 @@ func (w checkConnErrorWriter) Write(p []byte) (n int, err error) {
 // A conn represents the server side of an HTTP connection.
 type conn struct {
 	remoteAddr string            // network address of remote side
+	info       ConnInfo          // description of the connection passed to Server.Authorize
+	roleHeld   bool              // connection holds a Server.Roles connection slot
 	server     *Server           // the Server on which the connection arrived
+	handler    Handler           // the server's Handler wrapped by its middleware
 	rwc        net.Conn          // i/o connection
 	w          io.Writer         // checkConnErrorWriter's copy of wrc, not zeroed on Hijack
 	werr       error             // any errors writing to w
 	sr         liveSwitchReader  // where the LimitReader reads from; usually the rwc
-	lr         *io.LimitedReader // io.LimitReader(sr)
+	lr         *io.LimitedReader // io.LimitReader(sr), through any UnsafeExtensions
 	buf        *bufio.ReadWriter // buffered(lr,rwc), reading from bufio->limitReader->sr->rwc
-
-	//    mu           sync.Mutex // guards the following
-	//    clientGone   bool       // if client has disconnected mid-request
-	//    closeNotifyc chan bool  // made lazily
-	//    hijackedv    bool       // connection has been hijacked by handler
+	reqData    []byte            // data buffer reused by successive requests
+	sc         *ServerConn       // counters passed to Server.ConnEvent
+	ib         interByteReader   // enforces Server.InterByteTimeout
+
+	mu           sync.Mutex // guards the following
+	clientGone   bool       // if client has disconnected mid-request
+	closeNotifyc chan bool  // made lazily
+	hijackedv    bool       // connection has been hijacked by handler
 }
 
 // A liveSwitchReader can have its Reader changed at runtime. It's
@@ -126,9 +182,9 @@ type response struct {
 	header       Header
 	calledHeader bool // handler accessed handlerHeader via Header
 
-	written       int64 // number of bytes written in body
-	contentLength int64 // explicitly-declared Content-Length; or -1
-	status        uint8 // exception status
+	written       int64         // number of bytes written in body
+	contentLength int64         // explicitly-declared Content-Length; or -1
+	status        ExceptionCode // exception status
 
 	// close connection after this reply.  set on request and
 	// updated after response from handler if there's a
@@ -136,6 +192,9 @@ type response struct {
 	// Content-Length.
 	closeAfterReply bool
 
+	cancelCtx context.CancelFunc // cancels the request context
+	stopWatch func()             // stops watching for the master going away
+
 	handlerDone bool // set true when the handler exits
 }
 
@@ -150,6 +209,8 @@ const debugServerConnections = false
 func (srv *Server) newConn(rwc net.Conn) (c *conn, err error) {
 	c = new(conn)
 	c.remoteAddr = rwc.RemoteAddr().String()
+	c.info = ConnInfo{RemoteAddr: rwc.RemoteAddr(), LocalAddr: rwc.LocalAddr()}
+	c.sc = &ServerConn{info: c.info}
 	c.server = srv
 	c.rwc = rwc
 	c.w = rwc
@@ -157,9 +218,15 @@ func (srv *Server) newConn(rwc net.Conn) (c *conn, err error) {
 		c.rwc = newLoggingConn("server", c.rwc)
 	}
 	c.sr.r = c.rwc
-	c.lr = io.LimitReader(&c.sr, noLimit).(*io.LimitedReader)
+	c.ib = interByteReader{r: &c.sr, conn: c.rwc}
+	var r io.Reader = &c.ib
+	var w io.Writer = checkConnErrorWriter{c}
+	if x := srv.UnsafeExtensions; x != nil {
+		r, w = x.wrap(c, r, w)
+	}
+	c.lr = io.LimitReader(r, noLimit).(*io.LimitedReader)
 	br := newBufioReader(c.lr)
-	bw := newBufioWriterSize(checkConnErrorWriter{c}, 4<<10)
+	bw := newBufioWriterSize(w, 4<<10)
 	c.buf = bufio.NewReadWriter(br, bw)
 	return c, nil
 }
@@ -181,6 +248,7 @@ func bufioWriterPool(size int) *sync.Pool {
 }
 
 func newBufioReader(r io.Reader) *bufio.Reader {
+	account(&accounted.buffers, 1)
 	if v := bufioReaderPool.Get(); v != nil {
 		br := v.(*bufio.Reader)
 		br.Reset(r)
@@ -192,11 +260,13 @@ func newBufioReader(r io.Reader) *bufio.Reader {
 }
 
 func putBufioReader(br *bufio.Reader) {
+	account(&accounted.buffers, -1)
 	br.Reset(nil)
 	bufioReaderPool.Put(br)
 }
 
 func newBufioWriterSize(w io.Writer, size int) *bufio.Writer {
+	account(&accounted.buffers, 1)
 	pool := bufioWriterPool(size)
 	if pool != nil {
 		if v := pool.Get(); v != nil {
@@ -209,6 +279,7 @@ func newBufioWriterSize(w io.Writer, size int) *bufio.Writer {
 }
 
 func putBufioWriter(bw *bufio.Writer) {
+	account(&accounted.buffers, -1)
 	bw.Reset(nil)
 	if pool := bufioWriterPool(bw.Available()); pool != nil {
 		pool.Put(bw)
@@ -217,10 +288,19 @@ func putBufioWriter(bw *bufio.Writer) {
 
 var errTooLarge = errors.New("modbus: request too large")
 
+func (s *Server) maxFrameBytes() int {
+	if s.MaxFrameBytes > 0 {
+		return s.MaxFrameBytes
+	}
+	return DefaultMaxFrameBytes
+}
+
 // Read next request from connection.
-func (c *conn) readRequest() (w *response, err error) {
+func (c *conn) readRequest(ctx context.Context) (w *response, err error) {
+	var deadline time.Time
 	if d := c.server.ReadTimeout; d != 0 {
-		c.rwc.SetReadDeadline(time.Now().Add(d))
+		deadline = time.Now().Add(d)
+		c.rwc.SetReadDeadline(deadline)
 	}
 	if d := c.server.WriteTimeout; d != 0 {
 		defer func() {
@@ -228,51 +308,101 @@ func (c *conn) readRequest() (w *response, err error) {
 		}()
 	}
 
-	var req *Frame
-	if req, err = ReadFrame(c.buf.Reader); err != nil {
+	if d := c.server.InterByteTimeout; d > 0 {
+		if _, err := c.buf.Peek(1); err != nil {
+			return nil, err
+		}
+		c.ib.arm(d, deadline)
+	}
+	req := &Frame{data: c.reqData}
+	err = readFrameInto(c.buf.Reader, req, c.server.maxFrameBytes())
+	if c.ib.armed {
+		c.ib.disarm(deadline)
+	}
+	if err != nil && err != ErrFrameTooLarge {
 		if c.lr.N == 0 {
 			return nil, errTooLarge
 		}
 		return nil, err
 	}
+	c.reqData = req.data[:0]
 	c.lr.N = noLimit
 
+	var cancel context.CancelFunc
+	if d := c.server.WriteTimeout; d != 0 {
+		ctx, cancel = context.WithTimeout(ctx, d)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	req.ctx = ctx
+
 	w = &response{
-		conn: c,
-		req:  req,
+		conn:      c,
+		req:       req,
+		cancelCtx: cancel,
 	}
 
 	w.w = newBufioWriterSize(w.conn.buf, 2048)
 
-	return w, nil
+	// on ErrFrameTooLarge, w answers the oversized request
+	return w, err
 }
 
 func (c *conn) setState(nc net.Conn, state ConnState) {
 	if hook := c.server.ConnState; hook != nil {
 		hook(nc, state)
 	}
+	c.server.connEvent(c.sc, state)
 }
 
 // Serve a new connection.
 func (c *conn) serve() {
 	origConn := c.rwc // copy it before it's set nil on Close or Hijack
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
 	defer func() {
+		account(&accounted.goroutines, -1)
+		account(&accounted.serverConns, -1)
 		if err := recover(); err != nil {
-			const size = 64 << 10
-			buf := make([]byte, size)
-			buf = buf[:runtime.Stack(buf, false)]
-			c.server.logf("http: panic serving %v: %v\n%s", c.remoteAddr, err, buf)
+			c.logPanic(err)
+		}
+		if c.roleHeld {
+			c.server.releaseRole(c.info.Role)
+		}
+		c.server.releaseConn(origConn)
+		if !c.hijacked() {
+			c.close()
+			c.setState(origConn, StateClosed)
 		}
-		c.close()
-		c.setState(origConn, StateClosed)
 	}()
 
+	if t := c.server.Trace; t != nil && t.ConnectStart != nil {
+		t.ConnectStart(c.info)
+	}
+
+	if !c.handshake() {
+		return
+	}
+	c.sc.setInfo(c.info)
+	ctx = context.WithValue(ctx, connInfoKey{}, c.info)
+
 	for {
-		w, err := c.readRequest()
+		w, err := c.readRequest(ctx)
 		if c.lr.N != 0 { //c.server.initialLimitedReaderSize() {
 			// If we read any bytes off the wire, we're active.
 			c.setState(c.rwc, StateActive)
 		}
+		if err == ErrFrameTooLarge {
+			// the unread data leaves the stream unusable
+			c.server.logf("modbus: frame of %d bytes from %s exceeds MaxFrameBytes", w.req.Size(), c.remoteAddr)
+			c.server.traceFrameRead(w)
+			w.WriteException(IllegalDataValue)
+			w.cancelCtx()
+			w.finishRequest()
+			c.server.traceResponse(w)
+			break
+		}
 		if err != nil {
 			if err == errTooLarge {
 				break // Don't reply
@@ -285,13 +415,229 @@ func (c *conn) serve() {
 			break
 		}
 
-		c.server.Handler.ServeModbus(w, w.req)
+		c.server.traceFrameRead(w)
+		handler := c.handler
+		panicked, answered := false, false
+		start := time.Now()
+		w.stopWatch = c.watchPeer(w.cancelCtx)
+		if err := c.server.checkStrict(w.req); err != nil {
+			if err != errWrongProtocol {
+				WriteError(w, err)
+			}
+		} else if err := c.server.checkUnit(w.req); err != nil {
+			if err != errOtherUnit {
+				WriteError(w, err)
+			}
+		} else if err := c.server.authorize(c.info, w.req); err != nil {
+			c.server.writeUnauthorized(w, err)
+		} else {
+			c.server.checkWarnings(c.info, w.req)
+			if t := c.server.Trace; t != nil && t.HandlerStart != nil {
+				t.HandlerStart(w.req.Context(), w.req)
+			}
+			if uid := w.req.header.Uid; uid != 0 && uid == c.server.HealthUnit {
+				handler = HandlerFunc(c.server.serveHealth)
+			}
+			panicked, answered = c.callHandler(handler, w)
+		}
+		w.stopWatch()
+		w.cancelCtx()
+		if panicked && !answered {
+			break // the response may be incomplete
+		}
+		if d := c.server.SlowRequestThreshold; d > 0 {
+			if elapsed := time.Since(start); elapsed > d {
+				c.server.logSlowRequest(c.remoteAddr, w.req, elapsed)
+			}
+		}
+		if c.hijacked() {
+			return
+		}
 		w.finishRequest() // write the payload
-		if !w.shouldReuseConnection() {
+		c.server.traceResponse(w)
+		c.sc.record(w)
+		c.server.health.record(w)
+		if w.wroteHeader && w.header.Fcode&0x80 != 0 {
+			c.server.connEvent(c.sc, StateException)
+		}
+		if panicked || !w.shouldReuseConnection() {
 			break
 		}
 		c.setState(c.rwc, StateIdle)
+
+		if d := c.server.idleTimeout(); d != 0 {
+			c.rwc.SetReadDeadline(time.Now().Add(d))
+			if _, err := c.buf.Peek(1); err != nil {
+				break
+			}
+			c.rwc.SetReadDeadline(time.Time{})
+		}
+	}
+}
+
+// callHandler calls handler for the request of w. If the Server has a
+// PanicHandler, a panic of the handler is recovered and logged, and the
+// request answered with the exception code it returns, if any and if no
+// response was written yet. It reports whether the handler panicked and
+// whether the request was answered after it did.
+func (c *conn) callHandler(handler Handler, w *response) (panicked, answered bool) {
+	ph := c.server.PanicHandler
+	if ph == nil {
+		handler.ServeModbus(w, w.req)
+		return false, false
+	}
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+		c.logPanic(v)
+		panicked = true
+		if code := ph(w.req, v); code != 0 && !w.wroteHeader && !c.hijacked() {
+			answered = w.WriteException(code) == nil
+		}
+	}()
+	handler.ServeModbus(w, w.req)
+	return false, false
+}
+
+// logPanic logs the panic v of a handler with the stack of the panicking
+// goroutine.
+func (c *conn) logPanic(v interface{}) {
+	const size = 64 << 10
+	buf := make([]byte, size)
+	buf = buf[:runtime.Stack(buf, false)]
+	c.server.health.notePanic(v)
+	c.server.logf("http: panic serving %v: %v\n%s", c.remoteAddr, v, buf)
+}
+
+// The Hijacker interface is implemented by ResponseWriters that allow a
+// Handler to take over the connection, e.g. to speak a vendor specific
+// framing or to tunnel another protocol.
+type Hijacker interface {
+	// Hijack lets the caller take over the connection. Any response
+	// written so far is flushed first. After a call to Hijack the
+	// server will not do anything else with the connection; it becomes
+	// the caller's responsibility to manage and close it.
+	//
+	// The returned bufio.ReadWriter may hold buffered data read from
+	// the master but not yet consumed by the server.
+	Hijack() (net.Conn, *bufio.ReadWriter, error)
+}
+
+// ErrHijacked is returned by ResponseWriter.Write calls when the
+// underlying connection has been hijacked using the Hijacker interface.
+var ErrHijacked = errors.New("modbus: connection has been hijacked")
+
+// aLongTimeAgo is a non-zero time, far in the past, used for immediate
+// cancellation of network operations.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// watchPeer reads from the connection while a request is being handled
+// and calls cancel and notes the client gone if the master closes it. A byte of a pipelined request
+// read meanwhile is kept for the next readRequest. The returned function
+// stops the watch and must be called before the connection is read
+// again; calling it more than once is a no-op.
+func (c *conn) watchPeer(cancel context.CancelFunc) (stop func()) {
+	if c.buf.Reader.Buffered() > 0 {
+		// the master has already sent its next request
+		return func() {}
+	}
+
+	rwc := c.rwc
+	done := make(chan struct{})
+	var b [1]byte
+	var n int
+	account(&accounted.goroutines, 1)
+	go func() {
+		defer account(&accounted.goroutines, -1)
+		defer close(done)
+		var err error
+		n, err = rwc.Read(b[:])
+		if n == 0 {
+			if neterr, ok := err.(net.Error); !ok || !neterr.Timeout() {
+				c.noteClientGone()
+				cancel()
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			rwc.SetReadDeadline(aLongTimeAgo)
+			<-done
+			rwc.SetReadDeadline(time.Time{})
+			if n > 0 {
+				c.sr.Lock()
+				c.sr.r = io.MultiReader(bytes.NewReader(b[:n]), c.sr.r)
+				c.sr.Unlock()
+			}
+		})
+	}
+}
+
+func (c *conn) closeNotify() <-chan bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closeNotifyc == nil {
+		c.closeNotifyc = make(chan bool, 1)
+		if c.clientGone {
+			c.closeNotifyc <- true
+		}
+	}
+	return c.closeNotifyc
+}
+
+func (c *conn) noteClientGone() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closeNotifyc != nil && !c.clientGone {
+		c.closeNotifyc <- true
+	}
+	c.clientGone = true
+}
+
+func (c *conn) hijacked() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hijackedv
+}
+
+func (c *conn) hijack() (rwc net.Conn, buf *bufio.ReadWriter, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.hijackedv {
+		return nil, nil, ErrHijacked
+	}
+	c.hijackedv = true
+	rwc = c.rwc
+	buf = c.buf
+	account(&accounted.buffers, -2) // the caller's now
+	c.rwc = nil
+	c.buf = nil
+	c.setState(rwc, StateHijacked)
+	return
+}
+
+// Hijack implements the Hijacker interface.
+func (w *response) Hijack() (rwc net.Conn, buf *bufio.ReadWriter, err error) {
+	if w.handlerDone {
+		return nil, nil, errors.New("modbus: Hijack called after handler returned")
+	}
+	w.stopWatch()
+	if err = w.w.Flush(); err != nil {
+		return nil, nil, err
+	}
+	if err = w.conn.buf.Flush(); err != nil {
+		return nil, nil, err
 	}
+	rwc, buf, err = w.conn.hijack()
+	if err == nil {
+		putBufioWriter(w.w)
+		w.w = nil
+	}
+	return
 }
 
 func (w *response) Header() *Header {
@@ -300,10 +646,19 @@ func (w *response) Header() *Header {
 }
 
 func (w *response) Write(data []byte) (n int, err error) {
+	if w.conn.hijacked() {
+		return 0, ErrHijacked
+	}
+	if err := w.conn.werr; err != nil {
+		return 0, err
+	}
 	if !w.wroteHeader {
 		// need to calculate new length
 		w.header = *w.Header()
 		w.header.Length = uint16(len(data) + 2)
+		if w.header.Fcode&0x80 != 0 && len(data) > 0 {
+			w.status = ExceptionCode(data[0])
+		}
 		w.WriteHeader()
 	}
 	if len(data) == 0 {
@@ -311,11 +666,54 @@ func (w *response) Write(data []byte) (n int, err error) {
 	}
 
 	w.written += int64(len(data)) // ignoring errors, for errorKludge
-	return w.w.Write(data)
+	n, err = w.w.Write(data)
+	if err == nil {
+		err = w.conn.werr
+	}
+	if err != nil {
+		w.cancelCtx()
+	}
+	return n, err
+}
+
+// CloseNotify implements the CloseNotifier interface.
+func (w *response) CloseNotify() <-chan bool {
+	return w.conn.closeNotify()
 }
 
+// Flush implements the Flusher interface.
+func (w *response) Flush() error {
+	if w.conn.hijacked() {
+		return ErrHijacked
+	}
+	w.w.Flush()
+	w.conn.buf.Flush()
+	if err := w.conn.werr; err != nil {
+		w.cancelCtx()
+		return err
+	}
+	return nil
+}
+
+func (w *response) WriteException(code ExceptionCode) error {
+	if w.conn.hijacked() {
+		return ErrHijacked
+	}
+	if w.wroteHeader {
+		return errResponseWritten
+	}
+	return WriteException(w, code)
+}
+
+var errResponseWritten = errors.New("modbus: exception after response already written")
+
 func (w *response) WriteHeader() {
-	binary.Write(w.w, binary.BigEndian, w.header)
+	if w.conn.hijacked() {
+		return
+	}
+	var hb [headerSize]byte
+	w.header.encode(hb[:])
+	w.w.Write(hb[:])
 	w.wroteHeader = true
 }
 
@@ -347,6 +745,10 @@ func (w *response) shouldReuseConnection() bool {
 		return false
 	}
 
+	if !w.conn.server.doKeepAlives() {
+		return false
+	}
+
 	return true
 }
 
@@ -379,24 +781,218 @@ func (c *conn) close() {
 // The zero value for Server is a valid configuration.
 type Server struct { // this to become Slave
 	Addr           string        // TCP address to listen on, ":http" if empty
-	Handler        Handler       // handler to invoke, http.DefaultServeMux if nil
+	Handler        Handler       // handler to invoke, DefaultServeMux if nil
 	ReadTimeout    time.Duration // maximum duration before timing out read of the request
 	WriteTimeout   time.Duration // maximum duration before timing out write of the response
 	MaxHeaderBytes int           // maximum size of request headers, DefaultMaxHeaderBytes if 0
 
+	// IdleTimeout is the maximum time to wait for the next request on
+	// a connection kept open after a response. Idle connections are
+	// closed once it expires. If zero, ReadTimeout is used; if both are
+	// zero, connections wait indefinitely.
+	IdleTimeout time.Duration
+
+	// InterByteTimeout, if positive, is the maximum time to wait for
+	// more of a request once its first byte has arrived, renewed
+	// whenever more bytes arrive. A master, or a serial gateway in
+	// front of it, that stalls mid-request has its connection closed
+	// instead of holding it until ReadTimeout, which still bounds the
+	// request as a whole.
+	InterByteTimeout time.Duration
+
+	// TCPKeepAlive is the TCP keep-alive period of accepted TCP
+	// connections, letting the server notice masters that vanished
+	// without closing their connection. If zero, the listener's
+	// setting is kept, which for listeners of net.Listen is a period
+	// of 15 seconds. If negative, keep-alives are disabled.
+	TCPKeepAlive time.Duration
+
+	// MaxFrameBytes is the size of the largest request frame accepted,
+	// MBAP header included. Larger requests are answered with an
+	// IllegalDataValue exception without reading their data, and the
+	// connection is closed. If zero, DefaultMaxFrameBytes is used.
+	MaxFrameBytes int
+
+	// SlowRequestThreshold, if positive, causes every request whose
+	// handler runs for longer than the threshold to be logged to
+	// ErrorLog together with its function code and address range.
+	SlowRequestThreshold time.Duration
+
+	// Warnings configures warnings about requests that are valid but
+	// suggest a misbehaving master.
+	Warnings WarnThresholds
+
+	// Strict causes requests to be validated before they are passed
+	// to Authorize and Handler: frames with a protocol identifier
+	// other than 0 are dropped unanswered, and requests of the
+	// functions this package implements with malformed lengths,
+	// quantities or byte counts are answered with an IllegalDataValue
+	// exception. Handlers may then assume well formed requests. Other
+	// function codes are passed on unchecked.
+	Strict bool
+
+	// UnitIDs, if non empty, are the unit identifiers the server
+	// answers as, like a device behind a gateway. Requests addressed
+	// to other units are dropped unanswered, or answered with a
+	// GatewayTargetFailed exception if RejectOtherUnits is set.
+	// Requests to NoUnitUid, BroadcastUid and HealthUnit are always
+	// served.
+	UnitIDs          []uint8
+	RejectOtherUnits bool
+
+	// Authorize, if non nil, is called for every request before it is
+	// passed to Handler. A non nil error rejects the request: it is
+	// answered with the exception code of the *ModbusError in the
+	// error's chain, or with AuthorizeException, and the handler is not
+	// invoked.
+	Authorize func(ConnInfo, *Frame) error
+
+	// TLSConfig optionally provides a TLS configuration for use by
+	// ServeTLS and ListenAndServeTLS.
+	TLSConfig *tls.Config
+
+	// Roles, if non nil, restricts Modbus/TCP Security connections by
+	// the role carried in the master's certificate. Requests from
+	// roles without an entry are rejected as if by Authorize, as are
+	// writes outside the role's Writable ranges. Plain TCP connections
+	// are not affected.
+	Roles map[string]RoleLimit
+
+	// AuthorizeException is the exception code returned for requests
+	// rejected by Authorize with an error that is not a *ModbusError.
+	// If zero, IllegalFunction is used.
+	AuthorizeException ExceptionCode
+
+	// MaxConnections, if positive, bounds the number of connections
+	// served at once. Connections beyond the limit are closed as soon
+	// as they are accepted, or left waiting in the listener's backlog
+	// until a connection closes if QueueConnections is set.
+	MaxConnections   int
+	QueueConnections bool
+
+	// MaxQueuedConnections, if positive, lets up to that many
+	// connections accepted beyond MaxConnections wait, in the order
+	// they were accepted, for a connection to close instead of being
+	// closed, as QueueConnections does without bounding the listener's
+	// backlog. A waiting connection is served by the goroutine of the
+	// connection that closed, so at most MaxConnections goroutines
+	// serve connections. It has no effect if QueueConnections is set.
+	MaxQueuedConnections int
+
+	// MaxConnectionsPerIP, if positive, bounds the number of
+	// connections served at once from a single remote IP address.
+	// Excess connections are always closed as soon as they are
+	// accepted, so that one master cannot hold the queue.
+	MaxConnectionsPerIP int
+
+	// ConnWrapper, if non nil, wraps every accepted connection, below
+	// TLS when serving Modbus/TCP Security. It is called from the
+	// accepting goroutine, so wrappers exchanging a handshake with the
+	// peer should do so on first Read or Write.
+	ConnWrapper ConnWrapper
+
+	// UnsafeExtensions, if non nil, lets code see and rewrite the raw
+	// frames exchanged with masters. See UnsafeExtensions.
+	UnsafeExtensions *UnsafeExtensions
+
 	// ConnState specifies an optional callback function that is
 	// called when a client connection changes state. See the
 	// ConnState type and associated constants for details.
 	ConnState func(net.Conn, ConnState)
 
+	// ConnEvent, if non nil, is called with the connection's
+	// ServerConn whenever ConnState would be, and with StateException
+	// after each exception response written.
+	ConnEvent func(*ServerConn, ConnState)
+
+	// Trace, if non nil, provides hooks run at stages of the
+	// connections and requests served.
+	Trace *ServerTrace
+
+	// PanicHandler, if non nil, is called with the request and the
+	// recovered value when a handler panics, after the panic is
+	// logged. Unless it returns 0, or the handler wrote a response
+	// already, the request is answered with the exception code it
+	// returns, such as SlaveFailure, so that the master gets an error
+	// rather than a reset connection. The connection is closed either
+	// way. If nil, panics close the connection unanswered.
+	PanicHandler func(r *Frame, v interface{}) ExceptionCode
+
+	// HealthUnit, if non zero, is the unit identifier of a built-in
+	// unit answering reads of input or holding registers with a
+	// diagnostic block of HealthRegisters registers reporting Health,
+	// so that monitoring can check the server end to end over Modbus.
+	// Its requests are authorized as others but are not passed to
+	// Handler. 248, the first reserved unit identifier, is a common
+	// choice.
+	HealthUnit uint8
+
 	// ErrorLog specifies an optional logger for errors accepting
 	// connections and unexpected behavior from handlers.
 	// If nil, logging goes to os.Stderr via the log package's
 	// standard logger.
 	ErrorLog *log.Logger
 
-	// keep Alive functionality not implemented for the moment - matb.
-	disableKeepAlives int32 // accessed atomically.
+	disableKeepAlives int32 // accessed atomically, see SetKeepAlivesEnabled
+
+	mu        sync.Mutex
+	roleConns map[string]int // open TLS connections per role
+	conns     int            // open connections
+	ipConns   map[string]int // open and queued connections per remote IP
+	connFreed *sync.Cond     // signalled when conns decreases, made lazily
+	connQueue []net.Conn     // connections waiting for a slot, see MaxQueuedConnections
+
+	writeRates writeRates // write counts for Warnings.WriteRate
+
+	middleware []Middleware // added by Use
+
+	health serverHealth // reported by Health
+}
+
+// ConnInfo describes the connection a request arrived on.
+type ConnInfo struct {
+	RemoteAddr net.Addr // address of the master
+	LocalAddr  net.Addr // address the request was accepted on
+
+	// TLS and Role describe Modbus/TCP Security connections: the
+	// completed handshake and the role carried by the master's
+	// certificate. TLS is nil for plain connections.
+	TLS  *tls.ConnectionState
+	Role string
+}
+
+type connInfoKey struct{}
+
+// ContextConnInfo returns the description of the connection a request
+// arrived on, carried by the request's context.
+func ContextConnInfo(ctx context.Context) (ConnInfo, bool) {
+	info, ok := ctx.Value(connInfoKey{}).(ConnInfo)
+	return info, ok
+}
+
+// authorize applies the Roles policy and then Authorize to a request.
+func (s *Server) authorize(info ConnInfo, f *Frame) error {
+	if err := s.authorizeRole(info, f); err != nil {
+		return err
+	}
+	if s.Authorize == nil {
+		return nil
+	}
+	return s.Authorize(info, f)
+}
+
+// writeUnauthorized answers a request rejected by Authorize.
+func (s *Server) writeUnauthorized(w ResponseWriter, err error) {
+	var e *ModbusError
+	if errors.As(err, &e) {
+		w.WriteException(e.ExceptionCode)
+		return
+	}
+	if s.AuthorizeException != 0 {
+		w.WriteException(s.AuthorizeException)
+		return
+	}
+	w.WriteException(IllegalFunction)
 }
 
 // A ConnState represents the state of a client connection to a server.
@@ -432,14 +1028,26 @@ const (
 	// This is a terminal state. Hijacked connections do not
 	// transition to StateClosed.
 	StateClosed
+
+	// StateRejected represents a connection closed on accept
+	// because of MaxConnections or MaxConnectionsPerIP. It is the
+	// only state reported for such a connection.
+	StateRejected
+
+	// StateException is not a state but an event, reported to the
+	// Server.ConnEvent hook only, after an exception response is
+	// written. The connection stays in its state.
+	StateException
 )
 
 var stateName = map[ConnState]string{
-	StateNew:      "new",
-	StateActive:   "active",
-	StateIdle:     "idle",
-	StateHijacked: "hijacked",
-	StateClosed:   "closed",
+	StateNew:       "new",
+	StateActive:    "active",
+	StateIdle:      "idle",
+	StateHijacked:  "hijacked",
+	StateClosed:    "closed",
+	StateRejected:  "rejected",
+	StateException: "exception",
 }
 
 func (c ConnState) String() string {
@@ -465,9 +1073,40 @@ func (srv *Server) ListenAndServe() error {
 // new service goroutine for each.  The service goroutines read requests and
 // then call srv.Handler to reply to them.
 func (srv *Server) Serve(l net.Listener) error {
+	return srv.serve(srv.wrapListener(l))
+}
+
+// ServeAll serves each of listeners as Serve does, with the Server's
+// handler, limits and counters shared among them, e.g. plain Modbus TCP
+// on :502 alongside Modbus/TCP Security on :802, see TLSListener. Once
+// serving one of them fails, the others are closed; ServeAll returns
+// the first error when all have stopped. Closing every listener shuts
+// the Server's listening down as a whole.
+func (srv *Server) ServeAll(listeners ...net.Listener) error {
+	errc := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func() { errc <- srv.Serve(l) }()
+	}
+	var first error
+	for range listeners {
+		err := <-errc
+		if first == nil {
+			first = err
+			for _, l := range listeners {
+				l.Close()
+			}
+		}
+	}
+	return first
+}
+
+func (srv *Server) serve(l net.Listener) error {
 	defer l.Close()
+	srv.health.started()
+	handler := srv.handler()
 	var tempDelay time.Duration // how long to sleep on accept failure
 	for {
+		srv.waitConnSlot()
 		rw, e := l.Accept()
 		if e != nil {
 			if ne, ok := e.(net.Error); ok && ne.Temporary() {
@@ -480,18 +1119,35 @@ func (srv *Server) Serve(l net.Listener) error {
 					tempDelay = max
 				}
 				srv.logf("http: Accept error: %v; retrying in %v", e, tempDelay)
+				srv.health.setError(healthOtherError, e)
 				time.Sleep(tempDelay)
 				continue
 			}
 			return e
 		}
 		tempDelay = 0
-		c, err := srv.newConn(rw)
-		if err != nil {
-			continue
+		switch srv.acquireConn(rw) {
+		case connRejected:
+			srv.logf("modbus: connection limit reached, closing %s", rw.RemoteAddr())
+			if hook := srv.ConnState; hook != nil {
+				hook(rw, StateRejected)
+			}
+			srv.connEvent(&ServerConn{info: ConnInfo{RemoteAddr: rw.RemoteAddr(), LocalAddr: rw.LocalAddr()}}, StateRejected)
+			rw.Close()
+		case connQueued:
+			srv.queueConn(rw, handler)
+		default:
+			c, err := srv.newConn(rw)
+			if err != nil {
+				srv.releaseConn(rw)
+				continue
+			}
+			c.handler = handler
+			c.setState(c.rwc, StateNew) // before Serve can return
+			account(&accounted.serverConns, 1)
+			account(&accounted.goroutines, 1)
+			go srv.work(c, handler)
 		}
-		c.setState(c.rwc, StateNew) // before Serve can return
-		go c.serve()
 	}
 }
 
@@ -503,6 +1159,23 @@ func (s *Server) logf(format string, args ...interface{}) {
 	}
 }
 
+// logSlowRequest reports a request whose handler exceeded
+// SlowRequestThreshold. The handler may already have set the exception
+// bit on the shared header, so it is masked off before decoding.
+func (s *Server) logSlowRequest(remoteAddr string, f *Frame, elapsed time.Duration) {
+	req := Frame{header: f.header, data: f.data}
+	req.header.Fcode &^= 0x80
+	h := req.header
+	exception := f.header.Fcode&0x80 != 0
+	if r, err := NewRequest(&req); err == nil {
+		s.logf("modbus: slow request from %s: tid=%d uid=%d fc=%v addr=%d qty=%d exception=%t elapsed=%v",
+			remoteAddr, h.Tid, h.Uid, FunctionCode(h.Fcode), r.Offset(), r.Number(), exception, elapsed)
+		return
+	}
+	s.logf("modbus: slow request from %s: tid=%d uid=%d fc=%v exception=%t elapsed=%v",
+		remoteAddr, h.Tid, h.Uid, FunctionCode(h.Fcode), exception, elapsed)
+}
+
 func ListenAndServe(addr string, handler Handler) error {
 	srv := &Server{Addr: addr, Handler: handler}
 	return srv.ListenAndServe()
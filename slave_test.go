@@ -0,0 +1,383 @@
+package modbus
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer safe for use as a log destination from
+// connection goroutines.
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
+}
+
+// startTestServer serves srv on a loopback listener and returns the
+// listener address. The listener is closed when the test finishes.
+func startTestServer(t testing.TB, srv *Server) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go srv.Serve(l)
+	return l.Addr().String()
+}
+
+// exchange sends req to the server at addr and reads a response of
+// len(expected) bytes.
+func exchange(t *testing.T, addr string, req []byte, n int) []byte {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := c.Write(req); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	resp := make([]byte, n)
+	if _, err := io.ReadFull(c, resp); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return resp
+}
+
+type testHandlerFunc func(ResponseWriter, *Frame)
+
+func (f testHandlerFunc) ServeModbus(w ResponseWriter, r *Frame) { f(w, r) }
+
+type slowHandler struct {
+	Handler
+	delay time.Duration
+}
+
+func (h slowHandler) ServeModbus(w ResponseWriter, r *Frame) {
+	time.Sleep(h.delay)
+	h.Handler.ServeModbus(w, r)
+}
+
+func TestServerSlowRequestLog(t *testing.T) {
+	req := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x03, 0x00, 0x6B, 0x00, 0x03}
+	expected := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x09, 0xFF, 0x03, 0x06, 0x02, 0x2B, 0x00, 0x01, 0x00, 0x64}
+
+	h := &RegisterHandler{}
+	h.Holdings = append(make([]uint16, 0x6B), []uint16{0x022B, 0x0001, 0x0064}...)
+	logbuf := &syncBuffer{}
+	srv := &Server{
+		Handler:              slowHandler{h, 20 * time.Millisecond},
+		SlowRequestThreshold: time.Millisecond,
+		ErrorLog:             log.New(logbuf, "", 0),
+	}
+	addr := startTestServer(t, srv)
+
+	if resp := exchange(t, addr, req, len(expected)); !bytes.Equal(resp, expected) {
+		t.Errorf("Incorrect Response")
+	}
+
+	out := logbuf.String()
+	if !strings.Contains(out, "slow request") || !strings.Contains(out, "fc=ReadHoldingRegisters addr=107 qty=3") {
+		t.Errorf("slow request not logged: %q", out)
+	}
+}
+
+func TestResponseWriteExceptionAfterWrite(t *testing.T) {
+	req := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x06, 0x00, 0x01, 0x12, 0x34}
+
+	werr := make(chan error, 1)
+	srv := &Server{Handler: testHandlerFunc(func(w ResponseWriter, r *Frame) {
+		w.Write(r.data)
+		werr <- w.WriteException(SlaveFailure)
+	})}
+	addr := startTestServer(t, srv)
+
+	if resp := exchange(t, addr, req, len(req)); !bytes.Equal(resp, req) {
+		t.Errorf("Incorrect Response")
+	}
+	if err := <-werr; err == nil {
+		t.Errorf("WriteException after Write should fail")
+	}
+}
+
+func TestServerAuthorize(t *testing.T) {
+	read := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x03, 0x00, 0x00, 0x00, 0x01}
+	readExpected := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x05, 0xFF, 0x03, 0x02, 0x12, 0x34}
+	write := []byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x06, 0x00, 0x00, 0xBE, 0xEF}
+	writeExpected := []byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x03, 0xFF, 0x86, byte(IllegalDataAddress)}
+
+	h := &RegisterHandler{Holdings: []uint16{0x1234}}
+	var seen net.Addr
+	srv := &Server{
+		Handler: h,
+		Authorize: func(info ConnInfo, f *Frame) error {
+			seen = info.RemoteAddr
+			if FunctionCode(f.Header().Fcode) == WriteSingleRegister {
+				return errors.New("writes forbidden")
+			}
+			return nil
+		},
+		AuthorizeException: IllegalDataAddress,
+	}
+	addr := startTestServer(t, srv)
+
+	if resp := exchange(t, addr, read, len(readExpected)); !bytes.Equal(resp, readExpected) {
+		t.Errorf("Incorrect Response")
+	}
+	if resp := exchange(t, addr, write, len(writeExpected)); !bytes.Equal(resp, writeExpected) {
+		t.Errorf("Incorrect Response")
+	}
+	if h.Holdings[0] != 0x1234 {
+		t.Errorf("unauthorized write should not be applied")
+	}
+	if seen == nil {
+		t.Errorf("Authorize should receive the remote address")
+	}
+}
+
+func TestServerHijack(t *testing.T) {
+	req := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x06, 0x00, 0x01, 0x12, 0x34}
+
+	states := make(chan ConnState, 8)
+	werr := make(chan error, 1)
+	srv := &Server{
+		Handler: testHandlerFunc(func(w ResponseWriter, r *Frame) {
+			w.Write(r.data)
+			conn, buf, err := w.(Hijacker).Hijack()
+			if err != nil {
+				t.Errorf("Hijack should succeed: %v", err)
+				return
+			}
+			_, err = w.Write(r.data)
+			werr <- err
+			buf.WriteString("tunnel")
+			buf.Flush()
+			conn.Close()
+		}),
+		ConnState: func(c net.Conn, state ConnState) { states <- state },
+	}
+	addr := startTestServer(t, srv)
+
+	resp := exchange(t, addr, req, len(req)+len("tunnel"))
+	if !bytes.Equal(resp[:len(req)], req) || string(resp[len(req):]) != "tunnel" {
+		t.Errorf("Incorrect Response % X", resp)
+	}
+	if err := <-werr; err != ErrHijacked {
+		t.Errorf("Write after Hijack should fail with ErrHijacked not %v", err)
+	}
+
+	for _, expected := range []ConnState{StateNew, StateActive, StateHijacked} {
+		if state := <-states; state != expected {
+			t.Errorf("state should be %v not %v", expected, state)
+		}
+	}
+}
+
+func TestServerMaxFrameBytes(t *testing.T) {
+	// a header claiming 0x1000 bytes of data
+	req := []byte{0x00, 0x01, 0x00, 0x00, 0x10, 0x00, 0xFF, 0x10, 0x00, 0x00}
+	expected := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x03, 0xFF, 0x90, byte(IllegalDataValue)}
+
+	h := &RegisterHandler{Holdings: make([]uint16, 10)}
+	addr := startTestServer(t, &Server{Handler: h, ErrorLog: log.New(io.Discard, "", 0)})
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := c.Write(req); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	resp, err := io.ReadAll(c)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !bytes.Equal(resp, expected) {
+		t.Errorf("response % X; want % X and close", resp, expected)
+	}
+
+	// a larger limit admits the frame
+	req = []byte{0x00, 0x01, 0x00, 0x00, 0x01, 0x01, 0xFF, 0x10, 0x00, 0x00}
+	req = append(req, make([]byte, 0xFF-2)...)
+	addr = startTestServer(t, &Server{Handler: h, MaxFrameBytes: 300})
+	resp = exchange(t, addr, req, 9)
+	if resp[7] != 0x90 || ExceptionCode(resp[8]) != IllegalDataValue {
+		t.Errorf("response % X; want an IllegalDataValue exception", resp)
+	}
+}
+
+var errNotCancelled = errors.New("request context not cancelled")
+
+func TestResponseFlushError(t *testing.T) {
+	req := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x03, 0x00, 0x00, 0x00, 0x01}
+
+	closed := make(chan struct{})
+	result := make(chan error, 1)
+	h := testHandlerFunc(func(w ResponseWriter, r *Frame) {
+		<-closed
+		var err error
+		deadline := time.Now().Add(5 * time.Second)
+		for err == nil && time.Now().Before(deadline) {
+			if _, err = w.Write(make([]byte, 1024)); err == nil {
+				err = w.(Flusher).Flush()
+			}
+		}
+		if err != nil && r.Context().Err() == nil {
+			err = errNotCancelled
+		}
+		result <- err
+	})
+	addr := startTestServer(t, &Server{Handler: h})
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	if _, err := c.Write(req); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	c.(*net.TCPConn).SetLinger(0)
+	c.Close()
+	close(closed)
+
+	err = <-result
+	if err == nil {
+		t.Errorf("writes to a closed connection should fail")
+	} else if err == errNotCancelled {
+		t.Error(err)
+	}
+}
+
+func TestCloseNotify(t *testing.T) {
+	req := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x03, 0x00, 0x00, 0x00, 0x01}
+
+	notified := make(chan bool, 1)
+	h := testHandlerFunc(func(w ResponseWriter, r *Frame) {
+		select {
+		case v := <-w.(CloseNotifier).CloseNotify():
+			notified <- v
+		case <-time.After(5 * time.Second):
+			notified <- false
+		}
+	})
+	addr := startTestServer(t, &Server{Handler: h})
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	if _, err := c.Write(req); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	c.Close()
+
+	if !<-notified {
+		t.Errorf("handler not notified of the closed connection")
+	}
+}
+
+func TestServerInterByteTimeout(t *testing.T) {
+	h := &RegisterHandler{Holdings: make([]uint16, 10)}
+	addr := startTestServer(t, &Server{Handler: h, InterByteTimeout: 50 * time.Millisecond})
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// an idle connection is not affected
+	time.Sleep(100 * time.Millisecond)
+	req := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x03, 0x00, 0x00, 0x00, 0x01}
+	if _, err := c.Write(req); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	resp := make([]byte, 11)
+	if _, err := io.ReadFull(c, resp); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	// a request stalling after its header closes the connection
+	if _, err := c.Write(req[:8]); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if b, err := io.ReadAll(c); err != nil || len(b) != 0 {
+		t.Errorf("after a stalled request read % X, %v; want the connection closed", b, err)
+	}
+}
+
+func TestServerPanicHandler(t *testing.T) {
+	var recovered interface{}
+	srv := &Server{
+		Handler: testHandlerFunc(func(w ResponseWriter, r *Frame) {
+			if r.Header().Uid == 2 {
+				w.Write([]byte{0x02, 0x00})
+			}
+			panic("broken")
+		}),
+		PanicHandler: func(r *Frame, v interface{}) ExceptionCode {
+			recovered = v
+			return SlaveFailure
+		},
+		ErrorLog: log.New(io.Discard, "", 0),
+	}
+	addr := startTestServer(t, srv)
+
+	req := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x01}
+	expected := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x03, 0x01, 0x83, byte(SlaveFailure)}
+	if resp := exchangeAll(t, addr, req); !bytes.Equal(resp, expected) {
+		t.Errorf("response to a panicking handler = % x; want % x and the connection closed", resp, expected)
+	}
+	if recovered != "broken" {
+		t.Errorf("PanicHandler got %v", recovered)
+	}
+
+	// A response partly written is not sent.
+	req[6] = 2
+	if resp := exchangeAll(t, addr, req); len(resp) != 0 {
+		t.Errorf("response to a handler panicking after writing = % x; want none", resp)
+	}
+}
+
+// exchangeAll sends req to the server at addr and reads until it closes
+// the connection.
+func exchangeAll(t *testing.T, addr string, req []byte) []byte {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := c.Write(req); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	resp, err := io.ReadAll(c)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return resp
+}
@@ -0,0 +1,100 @@
+package modbus
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// snapshotMagic starts encrypted snapshots. Plain snapshots are JSON, so
+// start with '{'.
+var snapshotMagic = []byte("MBSNAP1\n")
+
+var (
+	// ErrSnapshotEncrypted is returned by LoadImage for an encrypted
+	// snapshot when no key is given.
+	ErrSnapshotEncrypted = errors.New("modbus: snapshot is encrypted")
+
+	// ErrSnapshotAuth is returned by LoadImage when a key is given and
+	// the snapshot was not sealed with it, was altered, or is not
+	// encrypted at all.
+	ErrSnapshotAuth = errors.New("modbus: snapshot authentication failed")
+)
+
+func snapshotAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// SaveImage writes img to w as a snapshot to be read back by LoadImage.
+// If key is nil the snapshot is plain JSON. Otherwise it is encrypted and
+// authenticated with AES-GCM under key, which must be 16, 24 or 32 bytes
+// long, for images holding sensitive setpoints or credentials. Every
+// snapshot gets a random nonce, so a key may seal many snapshots.
+func SaveImage(w io.Writer, img *Image, key []byte) error {
+	data, err := json.Marshal(img)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		_, err = w.Write(data)
+		return err
+	}
+	aead, err := snapshotAEAD(key)
+	if err != nil {
+		return err
+	}
+	out := make([]byte, len(snapshotMagic)+aead.NonceSize(), len(snapshotMagic)+aead.NonceSize()+len(data)+aead.Overhead())
+	copy(out, snapshotMagic)
+	nonce := out[len(snapshotMagic):]
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	out = aead.Seal(out, nonce, data, snapshotMagic)
+	_, err = w.Write(out)
+	return err
+}
+
+// LoadImage reads a snapshot written by SaveImage. With a nil key it
+// reads plain snapshots only, failing with ErrSnapshotEncrypted for
+// encrypted ones. With a key it reads snapshots sealed with that key
+// only, failing with ErrSnapshotAuth for any other, so that a plain
+// snapshot cannot be substituted for an encrypted one.
+func LoadImage(r io.Reader, key []byte) (*Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	encrypted := bytes.HasPrefix(data, snapshotMagic)
+	switch {
+	case key == nil && encrypted:
+		return nil, ErrSnapshotEncrypted
+	case key != nil && !encrypted:
+		return nil, ErrSnapshotAuth
+	case key != nil:
+		aead, err := snapshotAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		data = data[len(snapshotMagic):]
+		if len(data) < aead.NonceSize() {
+			return nil, ErrSnapshotAuth
+		}
+		nonce, sealed := data[:aead.NonceSize()], data[aead.NonceSize():]
+		if data, err = aead.Open(nil, nonce, sealed, snapshotMagic); err != nil {
+			return nil, ErrSnapshotAuth
+		}
+	}
+	img := new(Image)
+	if err := json.Unmarshal(data, img); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
@@ -0,0 +1,59 @@
+package modbus
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	img := &Image{
+		Coils:            []bool{true, false, true},
+		HoldingRegisters: []uint16{0x1234, 0xBEEF},
+	}
+	key := bytes.Repeat([]byte{0x42}, 32)
+
+	var plain, sealed bytes.Buffer
+	if err := SaveImage(&plain, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveImage(&sealed, img, key); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed.Bytes(), []byte("holding_registers")) {
+		t.Errorf("encrypted snapshot readable: %q", sealed.Bytes())
+	}
+
+	for _, tt := range []struct {
+		name string
+		data []byte
+		key  []byte
+		err  error
+	}{
+		{"plain", plain.Bytes(), nil, nil},
+		{"encrypted", sealed.Bytes(), key, nil},
+		{"no key", sealed.Bytes(), nil, ErrSnapshotEncrypted},
+		{"wrong key", sealed.Bytes(), bytes.Repeat([]byte{0x43}, 32), ErrSnapshotAuth},
+		{"plain substituted", plain.Bytes(), key, ErrSnapshotAuth},
+		{"truncated", sealed.Bytes()[:len(snapshotMagic)+4], key, ErrSnapshotAuth},
+	} {
+		got, err := LoadImage(bytes.NewReader(tt.data), tt.key)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: err should be %v not %v", tt.name, tt.err, err)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(got, img) {
+			t.Errorf("%s: loaded %+v; want %+v", tt.name, got, img)
+		}
+	}
+
+	// every byte of an encrypted snapshot is authenticated
+	for i := range sealed.Bytes() {
+		data := append([]byte(nil), sealed.Bytes()...)
+		data[i] ^= 0x01
+		if _, err := LoadImage(bytes.NewReader(data), key); err == nil {
+			t.Errorf("snapshot altered at byte %d loaded", i)
+		}
+	}
+}
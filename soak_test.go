@@ -0,0 +1,168 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var soakTransactions = flag.Int("soak", 20000, "number of transactions made by TestSoak; use millions before releases")
+
+func init() {
+	accountingEnabled = true
+}
+
+// waitResources waits for the accounted resources to drop to at most
+// those of base, returning the last counts.
+func waitResources(base resources) resources {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		r := accountedResources()
+		if r.goroutines <= base.goroutines && r.buffers <= base.buffers &&
+			r.serverConns <= base.serverConns && r.clientConns <= base.clientConns ||
+			time.Now().After(deadline) {
+			return r
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func heapInUse() uint64 {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapInuse
+}
+
+// TestSoak runs transactions over shared, pooled and short lived
+// connections, checking that the resources held stay within the bounds
+// the connections imply, and that all are released at the end.
+func TestSoak(t *testing.T) {
+	if testing.Short() {
+		t.Skip("soak test skipped in short mode")
+	}
+	const workers = 8
+	const churnEvery = 500 // transactions between short lived connections
+	// a shared and 2 pooled connections, and a short lived one per worker
+	const maxConns = 3 + workers
+
+	base := accountedResources()
+	h := &RegisterHandler{Holdings: make([]uint16, 100)}
+	addr := startTestServer(t, &Server{Handler: h})
+
+	shared, err := DialShared(addr, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pooled := &Client{Transport: &ClientPool{Addr: addr, Size: 2}}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	var (
+		next     int64 // transactions started
+		mu       sync.Mutex
+		firstErr error
+		heap     uint64 // heap in use after the first checkpoint
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		cancel()
+	}
+	checkpoint := func(n int64) {
+		r := accountedResources()
+		if d := r.serverConns - base.serverConns; d > maxConns {
+			fail(fmt.Errorf("%d transactions: %d server connections", n, d))
+		}
+		if d := r.clientConns - base.clientConns; d > maxConns {
+			fail(fmt.Errorf("%d transactions: %d client connections", n, d))
+		}
+		// serve and watchPeer per server connection, readLoop per client
+		if d := r.goroutines - base.goroutines; d > 3*maxConns {
+			fail(fmt.Errorf("%d transactions: %d goroutines", n, d))
+		}
+		// reader and writer per connection, writer per response
+		if d := r.buffers - base.buffers; d > 3*maxConns {
+			fail(fmt.Errorf("%d transactions: %d pooled buffers", n, d))
+		}
+		h := heapInUse()
+		if !atomic.CompareAndSwapUint64(&heap, 0, h) && h > 2*atomic.LoadUint64(&heap)+4<<20 {
+			fail(fmt.Errorf("%d transactions: heap grew from %d to %d bytes", n, atomic.LoadUint64(&heap), h))
+		}
+	}
+
+	total := int64(*soakTransactions)
+	step := total / 10
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for {
+				n := atomic.AddInt64(&next, 1)
+				if n > total || ctx.Err() != nil {
+					return
+				}
+				if step > 0 && n%step == 0 {
+					checkpoint(n)
+				}
+				if err := soakTransaction(ctx, addr, shared, pooled, n, churnEvery); err != nil {
+					fail(err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		t.Fatal(firstErr)
+	}
+
+	shared.Close()
+	pooled.Close()
+	if r := waitResources(base); r.goroutines > base.goroutines || r.buffers > base.buffers ||
+		r.serverConns > base.serverConns || r.clientConns > base.clientConns {
+		t.Errorf("resources not released: %+v; before %+v", r, base)
+	}
+}
+
+// soakTransaction makes the n'th transaction of TestSoak.
+func soakTransaction(ctx context.Context, addr string, shared *SharedClient, pooled *Client, n, churnEvery int64) error {
+	c := shared.Client
+	if n%2 == 0 {
+		c = pooled
+	}
+	if n%churnEvery == 0 {
+		short, err := Dial(addr)
+		if err != nil {
+			return err
+		}
+		defer short.Close()
+		c = short
+	}
+	reg := uint16(n % 100)
+	switch n % 4 {
+	case 0:
+		return c.WriteSingleRegister(ctx, 1, reg, uint16(n))
+	case 1:
+		_, err := c.ReadHoldingRegisters(ctx, 1, reg, 1)
+		return err
+	case 2:
+		_, err := c.ReadHoldingRegisters(ctx, 1, 0, 100)
+		return err
+	}
+	// an exception response
+	if _, err := c.ReadHoldingRegisters(ctx, 1, 100, 1); !errors.Is(err, ErrIllegalDataAddress) {
+		return fmt.Errorf("read out of range: %v", err)
+	}
+	return nil
+}
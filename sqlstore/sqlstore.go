@@ -0,0 +1,263 @@
+// Package sqlstore provides a modbus.DataStore backed by a SQL database,
+// so that a slave can serve values owned by another system.
+//
+// Values live in a single table of three integer columns:
+//
+//	CREATE TABLE modbus (
+//		tbl   INTEGER NOT NULL, -- modbus.Table: 0 coils, 1 discrete inputs,
+//		                        -- 2 input registers, 3 holding registers
+//		addr  INTEGER NOT NULL,
+//		value INTEGER NOT NULL,  -- 0 or 1 for coils and discrete inputs
+//		PRIMARY KEY (tbl, addr)
+//	)
+//
+// The rows define the address space: reading or writing an address
+// without a row fails with modbus.ErrIllegalDataAddress. Writes of
+// several values are made in one transaction, so they are applied
+// entirely or not at all.
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	modbus "github.com/mubeta06/gomodbus"
+)
+
+// Config configures a Store.
+type Config struct {
+	// Table is the name of the table holding the values. If empty,
+	// "modbus" is used.
+	Table string
+
+	// Placeholder returns the query placeholder of the n'th argument,
+	// counting from 1, e.g. "$1" for PostgreSQL. If nil, "?" is used.
+	Placeholder func(n int) string
+
+	// CacheTTL is how long values read are reused before being read
+	// again; values written through the Store are cached for as long.
+	// If zero, every read queries the database.
+	CacheTTL time.Duration
+}
+
+// A Store is a modbus.DataStore, and modbus.InputWriter, reading and
+// writing values through prepared statements.
+type Store struct {
+	db     *sql.DB
+	ttl    time.Duration
+	read   *sql.Stmt
+	update *sql.Stmt
+
+	mu    sync.Mutex // guards cache
+	cache map[modbus.Location]cached
+}
+
+type cached struct {
+	value   uint16
+	expires time.Time
+}
+
+// New returns a Store using db, preparing its statements.
+func New(ctx context.Context, db *sql.DB, config Config) (*Store, error) {
+	table := config.Table
+	if table == "" {
+		table = "modbus"
+	}
+	ph := config.Placeholder
+	if ph == nil {
+		ph = func(int) string { return "?" }
+	}
+
+	read, err := db.PrepareContext(ctx, fmt.Sprintf(
+		"SELECT addr, value FROM %s WHERE tbl = %s AND addr >= %s AND addr < %s ORDER BY addr",
+		table, ph(1), ph(2), ph(3)))
+	if err != nil {
+		return nil, err
+	}
+	update, err := db.PrepareContext(ctx, fmt.Sprintf(
+		"UPDATE %s SET value = %s WHERE tbl = %s AND addr = %s",
+		table, ph(1), ph(2), ph(3)))
+	if err != nil {
+		read.Close()
+		return nil, err
+	}
+	return &Store{
+		db:     db,
+		ttl:    config.CacheTTL,
+		read:   read,
+		update: update,
+		cache:  make(map[modbus.Location]cached),
+	}, nil
+}
+
+// Close closes the prepared statements. It does not close the database.
+func (s *Store) Close() error {
+	err := s.read.Close()
+	if err2 := s.update.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// fromCache returns the quantity values at addr of table t if all are
+// cached.
+func (s *Store) fromCache(t modbus.Table, addr, quantity uint16) ([]uint16, bool) {
+	if s.ttl <= 0 {
+		return nil, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	values := make([]uint16, quantity)
+	for i := range values {
+		c, ok := s.cache[modbus.Location{Table: t, Address: addr + uint16(i)}]
+		if !ok || now.After(c.expires) {
+			return nil, false
+		}
+		values[i] = c.value
+	}
+	return values, true
+}
+
+func (s *Store) toCache(t modbus.Table, addr uint16, values []uint16) {
+	if s.ttl <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expires := time.Now().Add(s.ttl)
+	for i, v := range values {
+		s.cache[modbus.Location{Table: t, Address: addr + uint16(i)}] = cached{v, expires}
+	}
+}
+
+// readTable returns quantity values at addr of table t.
+func (s *Store) readTable(ctx context.Context, t modbus.Table, addr, quantity uint16) ([]uint16, error) {
+	if int(addr)+int(quantity) > 0x10000 {
+		return nil, modbus.ErrIllegalDataAddress
+	}
+	if values, ok := s.fromCache(t, addr, quantity); ok {
+		return values, nil
+	}
+
+	rows, err := s.read.QueryContext(ctx, int(t), int(addr), int(addr)+int(quantity))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	values := make([]uint16, 0, quantity)
+	for rows.Next() {
+		var a, v int64
+		if err := rows.Scan(&a, &v); err != nil {
+			return nil, err
+		}
+		if a != int64(addr)+int64(len(values)) {
+			// a missing row
+			return nil, modbus.ErrIllegalDataAddress
+		}
+		values = append(values, uint16(v))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(values) != int(quantity) {
+		return nil, modbus.ErrIllegalDataAddress
+	}
+	s.toCache(t, addr, values)
+	return values, nil
+}
+
+// writeTable writes values at addr of table t in a single transaction.
+func (s *Store) writeTable(ctx context.Context, t modbus.Table, addr uint16, values []uint16) (err error) {
+	if int(addr)+len(values) > 0x10000 {
+		return modbus.ErrIllegalDataAddress
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	update := tx.StmtContext(ctx, s.update)
+	for i, v := range values {
+		res, err := update.ExecContext(ctx, int(v), int(t), int(addr)+i)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n != 1 {
+			return modbus.ErrIllegalDataAddress
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.toCache(t, addr, values)
+	return nil
+}
+
+func toBits(values []uint16) []bool {
+	bits := make([]bool, len(values))
+	for i, v := range values {
+		bits[i] = v != 0
+	}
+	return bits
+}
+
+func fromBits(bits []bool) []uint16 {
+	values := make([]uint16, len(bits))
+	for i, b := range bits {
+		if b {
+			values[i] = 1
+		}
+	}
+	return values
+}
+
+func (s *Store) ReadCoils(ctx context.Context, addr, quantity uint16) ([]bool, error) {
+	values, err := s.readTable(ctx, modbus.CoilTable, addr, quantity)
+	if err != nil {
+		return nil, err
+	}
+	return toBits(values), nil
+}
+
+func (s *Store) ReadDiscreteInputs(ctx context.Context, addr, quantity uint16) ([]bool, error) {
+	values, err := s.readTable(ctx, modbus.DiscreteInputTable, addr, quantity)
+	if err != nil {
+		return nil, err
+	}
+	return toBits(values), nil
+}
+
+func (s *Store) ReadInputRegisters(ctx context.Context, addr, quantity uint16) ([]uint16, error) {
+	return s.readTable(ctx, modbus.InputRegisterTable, addr, quantity)
+}
+
+func (s *Store) ReadHoldingRegisters(ctx context.Context, addr, quantity uint16) ([]uint16, error) {
+	return s.readTable(ctx, modbus.HoldingRegisterTable, addr, quantity)
+}
+
+func (s *Store) WriteCoils(ctx context.Context, addr uint16, values []bool) error {
+	return s.writeTable(ctx, modbus.CoilTable, addr, fromBits(values))
+}
+
+func (s *Store) WriteHoldingRegisters(ctx context.Context, addr uint16, values []uint16) error {
+	return s.writeTable(ctx, modbus.HoldingRegisterTable, addr, values)
+}
+
+func (s *Store) WriteDiscreteInputs(ctx context.Context, addr uint16, values []bool) error {
+	return s.writeTable(ctx, modbus.DiscreteInputTable, addr, fromBits(values))
+}
+
+func (s *Store) WriteInputRegisters(ctx context.Context, addr uint16, values []uint16) error {
+	return s.writeTable(ctx, modbus.InputRegisterTable, addr, values)
+}
@@ -0,0 +1,214 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	modbus "github.com/mubeta06/gomodbus"
+)
+
+// fakeDriver understands just the statements prepared by Store, over a
+// map of rows keyed by table and address.
+type fakeDriver struct {
+	mu      sync.Mutex
+	rows    map[[2]int64]int64
+	queries int
+}
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error) { return &fakeConn{d: d}, nil }
+
+type fakeConn struct {
+	d  *fakeDriver
+	tx map[[2]int64]int64 // rows as changed by the open transaction
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	if !strings.HasPrefix(query, "SELECT") && !strings.HasPrefix(query, "UPDATE") {
+		return nil, errors.New("unexpected query " + query)
+	}
+	return &fakeStmt{c: c, query: query}, nil
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.d.mu.Lock()
+	defer c.d.mu.Unlock()
+	c.tx = make(map[[2]int64]int64)
+	for k, v := range c.d.rows {
+		c.tx[k] = v
+	}
+	return c, nil
+}
+
+func (c *fakeConn) Commit() error {
+	c.d.mu.Lock()
+	defer c.d.mu.Unlock()
+	c.d.rows, c.tx = c.tx, nil
+	return nil
+}
+
+func (c *fakeConn) Rollback() error {
+	c.tx = nil
+	return nil
+}
+
+type fakeStmt struct {
+	c     *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return 3 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	d := s.c.d
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	rows := d.rows
+	if s.c.tx != nil {
+		rows = s.c.tx
+	}
+	key := [2]int64{args[1].(int64), args[2].(int64)}
+	if _, ok := rows[key]; !ok {
+		return driver.RowsAffected(0), nil
+	}
+	rows[key] = args[0].(int64)
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	d := s.c.d
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.queries++
+	tbl, lo, hi := args[0].(int64), args[1].(int64), args[2].(int64)
+	r := &fakeRows{}
+	for k, v := range d.rows {
+		if k[0] == tbl && k[1] >= lo && k[1] < hi {
+			r.rows = append(r.rows, [2]int64{k[1], v})
+		}
+	}
+	sort.Slice(r.rows, func(i, j int) bool { return r.rows[i][0] < r.rows[j][0] })
+	return r, nil
+}
+
+type fakeRows struct {
+	rows [][2]int64
+}
+
+func (r *fakeRows) Columns() []string { return []string{"addr", "value"} }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	dest[0], dest[1] = r.rows[0][0], r.rows[0][1]
+	r.rows = r.rows[1:]
+	return nil
+}
+
+var registerOnce sync.Once
+var testDriver = &fakeDriver{}
+
+func openTestStore(t *testing.T, config Config) (*Store, *fakeDriver) {
+	registerOnce.Do(func() { sql.Register("sqlstore-fake", testDriver) })
+	testDriver.mu.Lock()
+	testDriver.rows = map[[2]int64]int64{
+		{int64(modbus.CoilTable), 0}:            1,
+		{int64(modbus.CoilTable), 1}:            0,
+		{int64(modbus.HoldingRegisterTable), 0}: 10,
+		{int64(modbus.HoldingRegisterTable), 1}: 11,
+		{int64(modbus.HoldingRegisterTable), 2}: 12,
+		{int64(modbus.HoldingRegisterTable), 4}: 14,
+	}
+	testDriver.queries = 0
+	testDriver.mu.Unlock()
+
+	db, err := sql.Open("sqlstore-fake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	s, err := New(context.Background(), db, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s, testDriver
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	s, _ := openTestStore(t, Config{})
+
+	values, err := s.ReadHoldingRegisters(ctx, 0, 3)
+	if err != nil || !reflect.DeepEqual(values, []uint16{10, 11, 12}) {
+		t.Errorf("ReadHoldingRegisters = %v, %v", values, err)
+	}
+	if _, err := s.ReadHoldingRegisters(ctx, 1, 4); !errors.Is(err, modbus.ErrIllegalDataAddress) {
+		t.Errorf("reading across a missing row should fail with ErrIllegalDataAddress not %v", err)
+	}
+	coils, err := s.ReadCoils(ctx, 0, 2)
+	if err != nil || !reflect.DeepEqual(coils, []bool{true, false}) {
+		t.Errorf("ReadCoils = %v, %v", coils, err)
+	}
+
+	if err := s.WriteHoldingRegisters(ctx, 1, []uint16{21, 22}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteCoils(ctx, 1, []bool{true}); err != nil {
+		t.Fatal(err)
+	}
+	values, _ = s.ReadHoldingRegisters(ctx, 0, 3)
+	coils, _ = s.ReadCoils(ctx, 0, 2)
+	if !reflect.DeepEqual(values, []uint16{10, 21, 22}) || !reflect.DeepEqual(coils, []bool{true, true}) {
+		t.Errorf("after writes: %v %v", values, coils)
+	}
+}
+
+func TestStoreTransactionalWrite(t *testing.T) {
+	ctx := context.Background()
+	s, _ := openTestStore(t, Config{})
+
+	// address 3 has no row, so nothing is written
+	err := s.WriteHoldingRegisters(ctx, 1, []uint16{31, 32, 33, 34})
+	if !errors.Is(err, modbus.ErrIllegalDataAddress) {
+		t.Errorf("err should be ErrIllegalDataAddress not %v", err)
+	}
+	values, _ := s.ReadHoldingRegisters(ctx, 0, 3)
+	if !reflect.DeepEqual(values, []uint16{10, 11, 12}) {
+		t.Errorf("partial write applied: %v", values)
+	}
+}
+
+func TestStoreCache(t *testing.T) {
+	ctx := context.Background()
+	s, d := openTestStore(t, Config{CacheTTL: time.Hour})
+
+	for i := 0; i < 3; i++ {
+		if _, err := s.ReadHoldingRegisters(ctx, 0, 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.WriteHoldingRegisters(ctx, 4, []uint16{44}); err != nil {
+		t.Fatal(err)
+	}
+	values, err := s.ReadHoldingRegisters(ctx, 4, 1)
+	if err != nil || values[0] != 44 {
+		t.Errorf("ReadHoldingRegisters = %v, %v", values, err)
+	}
+	if d.queries != 1 {
+		t.Errorf("%d queries; want 1", d.queries)
+	}
+}
@@ -0,0 +1,306 @@
+package modbus
+
+import (
+	"context"
+	"fmt"
+)
+
+// A DataStore holds the coils, discrete inputs and registers served by a
+// RegisterHandler.
+//
+// The ctx passed to each method is the request's context; it is cancelled
+// when the request times out or the master's connection drops. Stores
+// that block on I/O should give up and return ctx.Err() once it is done.
+//
+// Methods report addresses outside the store with ErrIllegalDataAddress.
+// Any *ModbusError in a returned error's chain is sent to the master as
+// is; other errors are answered with SlaveFailure.
+type DataStore interface {
+	ReadCoils(ctx context.Context, addr, quantity uint16) ([]bool, error)
+	ReadDiscreteInputs(ctx context.Context, addr, quantity uint16) ([]bool, error)
+	ReadInputRegisters(ctx context.Context, addr, quantity uint16) ([]uint16, error)
+	ReadHoldingRegisters(ctx context.Context, addr, quantity uint16) ([]uint16, error)
+	WriteCoils(ctx context.Context, addr uint16, values []bool) error
+	WriteHoldingRegisters(ctx context.Context, addr uint16, values []uint16) error
+}
+
+// A RegisterEncoder is a DataStore able to append the big endian wire
+// encoding of registers straight to a response, sparing the handler the
+// intermediate []uint16 of large reads. RegisterHandler uses these
+// methods in place of ReadInputRegisters and ReadHoldingRegisters when
+// its store implements them.
+type RegisterEncoder interface {
+	// AppendInputRegisters appends quantity input registers starting
+	// at addr to dst, two bytes each, most significant byte first, and
+	// returns the extended slice.
+	AppendInputRegisters(ctx context.Context, dst []byte, addr, quantity uint16) ([]byte, error)
+
+	// AppendHoldingRegisters is AppendInputRegisters for holding
+	// registers.
+	AppendHoldingRegisters(ctx context.Context, dst []byte, addr, quantity uint16) ([]byte, error)
+}
+
+// sliceStore is the DataStore of a RegisterHandler without a Store,
+// serving the handler's own slices, or once they have been migrated, see
+// RegisterHandler.Migrate, the store they were migrated to.
+type sliceStore struct {
+	h *RegisterHandler
+}
+
+func (s sliceStore) ReadCoils(ctx context.Context, addr, quantity uint16) ([]bool, error) {
+	if m := s.h.rlockSlices(); m != nil {
+		return m.ReadCoils(ctx, addr, quantity)
+	}
+	defer s.h.mu.RUnlock()
+	return readBits(s.h.Coils, addr, quantity)
+}
+
+func (s sliceStore) ReadDiscreteInputs(ctx context.Context, addr, quantity uint16) ([]bool, error) {
+	if m := s.h.rlockSlices(); m != nil {
+		return m.ReadDiscreteInputs(ctx, addr, quantity)
+	}
+	defer s.h.mu.RUnlock()
+	return readBits(s.h.DiscreteInputs, addr, quantity)
+}
+
+func (s sliceStore) ReadInputRegisters(ctx context.Context, addr, quantity uint16) ([]uint16, error) {
+	if m := s.h.rlockSlices(); m != nil {
+		return m.ReadInputRegisters(ctx, addr, quantity)
+	}
+	defer s.h.mu.RUnlock()
+	return readRegisters(s.h.Inputs, addr, quantity)
+}
+
+func (s sliceStore) ReadHoldingRegisters(ctx context.Context, addr, quantity uint16) ([]uint16, error) {
+	if m := s.h.rlockSlices(); m != nil {
+		return m.ReadHoldingRegisters(ctx, addr, quantity)
+	}
+	defer s.h.mu.RUnlock()
+	return readRegisters(s.h.Holdings, addr, quantity)
+}
+
+func (s sliceStore) AppendInputRegisters(ctx context.Context, dst []byte, addr, quantity uint16) ([]byte, error) {
+	if m := s.h.rlockSlices(); m != nil {
+		if enc, ok := m.(RegisterEncoder); ok {
+			return enc.AppendInputRegisters(ctx, dst, addr, quantity)
+		}
+		values, err := m.ReadInputRegisters(ctx, addr, quantity)
+		if err != nil {
+			return nil, err
+		}
+		return appendRegisters(dst, values, 0, quantity)
+	}
+	defer s.h.mu.RUnlock()
+	return appendRegisters(dst, s.h.Inputs, addr, quantity)
+}
+
+func (s sliceStore) AppendHoldingRegisters(ctx context.Context, dst []byte, addr, quantity uint16) ([]byte, error) {
+	if m := s.h.rlockSlices(); m != nil {
+		if enc, ok := m.(RegisterEncoder); ok {
+			return enc.AppendHoldingRegisters(ctx, dst, addr, quantity)
+		}
+		values, err := m.ReadHoldingRegisters(ctx, addr, quantity)
+		if err != nil {
+			return nil, err
+		}
+		return appendRegisters(dst, values, 0, quantity)
+	}
+	defer s.h.mu.RUnlock()
+	return appendRegisters(dst, s.h.Holdings, addr, quantity)
+}
+
+func (s sliceStore) WriteCoils(ctx context.Context, addr uint16, values []bool) error {
+	if m := s.h.lockSlices(); m != nil {
+		return m.WriteCoils(ctx, addr, values)
+	}
+	defer s.h.mu.Unlock()
+	if int(addr)+len(values) > len(s.h.Coils) {
+		return ErrIllegalDataAddress
+	}
+	copy(s.h.Coils[addr:], values)
+	return nil
+}
+
+func (s sliceStore) WriteHoldingRegisters(ctx context.Context, addr uint16, values []uint16) error {
+	if m := s.h.lockSlices(); m != nil {
+		return m.WriteHoldingRegisters(ctx, addr, values)
+	}
+	defer s.h.mu.Unlock()
+	if int(addr)+len(values) > len(s.h.Holdings) {
+		return ErrIllegalDataAddress
+	}
+	copy(s.h.Holdings[addr:], values)
+	return nil
+}
+
+func (s sliceStore) WriteDiscreteInputs(ctx context.Context, addr uint16, values []bool) error {
+	if m := s.h.lockSlices(); m != nil {
+		iw, ok := m.(InputWriter)
+		if !ok {
+			return ErrInputsReadOnly
+		}
+		return iw.WriteDiscreteInputs(ctx, addr, values)
+	}
+	defer s.h.mu.Unlock()
+	if int(addr)+len(values) > len(s.h.DiscreteInputs) {
+		return ErrIllegalDataAddress
+	}
+	copy(s.h.DiscreteInputs[addr:], values)
+	return nil
+}
+
+func (s sliceStore) WriteInputRegisters(ctx context.Context, addr uint16, values []uint16) error {
+	if m := s.h.lockSlices(); m != nil {
+		iw, ok := m.(InputWriter)
+		if !ok {
+			return ErrInputsReadOnly
+		}
+		return iw.WriteInputRegisters(ctx, addr, values)
+	}
+	defer s.h.mu.Unlock()
+	if int(addr)+len(values) > len(s.h.Inputs) {
+		return ErrIllegalDataAddress
+	}
+	copy(s.h.Inputs[addr:], values)
+	return nil
+}
+
+// Transact applies changes under the slices' write lock, checking every
+// address before setting any value.
+func (s sliceStore) Transact(ctx context.Context, changes []Change) error {
+	if m := s.h.lockSlices(); m != nil {
+		return ApplyAtomic(ctx, m, changes)
+	}
+	defer s.h.mu.Unlock()
+	for _, c := range changes {
+		if err := s.set(c, true); err != nil {
+			return err
+		}
+	}
+	for _, c := range changes {
+		s.set(c, false)
+	}
+	return nil
+}
+
+// set sets the New value of c in the slices, or if check is set only
+// checks its address. The slices must be locked.
+func (s sliceStore) set(c Change, check bool) error {
+	var bits []bool
+	var regs []uint16
+	switch c.Table {
+	case CoilTable:
+		bits = s.h.Coils
+	case DiscreteInputTable:
+		bits = s.h.DiscreteInputs
+	case InputRegisterTable:
+		regs = s.h.Inputs
+	case HoldingRegisterTable:
+		regs = s.h.Holdings
+	default:
+		return fmt.Errorf("modbus: unknown table %d", uint8(c.Table))
+	}
+	if int(c.Address) >= len(bits)+len(regs) {
+		return ErrIllegalDataAddress
+	}
+	switch {
+	case check:
+	case bits != nil:
+		bits[c.Address] = c.New != 0
+	default:
+		regs[c.Address] = c.New
+	}
+	return nil
+}
+
+// rlockSlices read locks the slices of h and returns nil, or if they have
+// been migrated returns the store they were migrated to, unlocked.
+func (h *RegisterHandler) rlockSlices() DataStore {
+	h.mu.RLock()
+	if m := h.migrated; m != nil {
+		h.mu.RUnlock()
+		return m
+	}
+	return nil
+}
+
+// lockSlices is rlockSlices taking the write lock.
+func (h *RegisterHandler) lockSlices() DataStore {
+	h.mu.Lock()
+	if m := h.migrated; m != nil {
+		h.mu.Unlock()
+		return m
+	}
+	return nil
+}
+
+// Migrate moves the values of h's slices to dst, which then serves them
+// in place of the slices, without interrupting service: requests are held
+// off while the values are copied, and requests already under way when
+// Migrate returns reach dst. Stores wrapping h.DataStore(), such as a
+// WatchStore set as h.Store, keep wrapping it, so their write hooks see
+// the writes made after the migration. The slices are set to nil.
+//
+// Migrate fails with ErrInputsReadOnly if h has discrete inputs or input
+// registers and dst is not an InputWriter. If copying fails the slices
+// are left in service, and dst may hold some of their values. Migrating
+// again has no effect.
+func (h *RegisterHandler) Migrate(ctx context.Context, dst DataStore) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.migrated != nil {
+		return nil
+	}
+	iw, ok := dst.(InputWriter)
+	if !ok && (len(h.DiscreteInputs) > 0 || len(h.Inputs) > 0) {
+		return ErrInputsReadOnly
+	}
+	var err error
+	if len(h.Coils) > 0 {
+		err = dst.WriteCoils(ctx, 0, h.Coils)
+	}
+	if err == nil && len(h.Holdings) > 0 {
+		err = dst.WriteHoldingRegisters(ctx, 0, h.Holdings)
+	}
+	if err == nil && len(h.DiscreteInputs) > 0 {
+		err = iw.WriteDiscreteInputs(ctx, 0, h.DiscreteInputs)
+	}
+	if err == nil && len(h.Inputs) > 0 {
+		err = iw.WriteInputRegisters(ctx, 0, h.Inputs)
+	}
+	if err != nil {
+		return err
+	}
+	h.migrated = dst
+	h.Coils, h.DiscreteInputs, h.Inputs, h.Holdings = nil, nil, nil, nil
+	return nil
+}
+
+// readBits returns a copy of quantity bits of table starting at addr.
+func readBits(table []bool, addr, quantity uint16) ([]bool, error) {
+	if int(addr)+int(quantity) > len(table) {
+		return nil, ErrIllegalDataAddress
+	}
+	return append([]bool(nil), table[int(addr):int(addr)+int(quantity)]...), nil
+}
+
+// readRegisters returns a copy of quantity registers of table starting at
+// addr.
+func readRegisters(table []uint16, addr, quantity uint16) ([]uint16, error) {
+	if int(addr)+int(quantity) > len(table) {
+		return nil, ErrIllegalDataAddress
+	}
+	return append([]uint16(nil), table[int(addr):int(addr)+int(quantity)]...), nil
+}
+
+// appendRegisters appends the encoding of quantity registers of table
+// starting at addr to dst.
+func appendRegisters(dst []byte, table []uint16, addr, quantity uint16) ([]byte, error) {
+	if int(addr)+int(quantity) > len(table) {
+		return nil, ErrIllegalDataAddress
+	}
+	for _, v := range table[int(addr) : int(addr)+int(quantity)] {
+		dst = append(dst, byte(v>>8), byte(v))
+	}
+	return dst, nil
+}
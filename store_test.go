@@ -0,0 +1,238 @@
+package modbus
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// blockingStore blocks every read until the request context is done and
+// reports the context error on done.
+type blockingStore struct {
+	DataStore
+	done chan error
+}
+
+func (s *blockingStore) ReadHoldingRegisters(ctx context.Context, addr, quantity uint16) ([]uint16, error) {
+	<-ctx.Done()
+	s.done <- ctx.Err()
+	return nil, ctx.Err()
+}
+
+func TestStoreContextConnectionClosed(t *testing.T) {
+	req := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x03, 0x00, 0x00, 0x00, 0x01}
+
+	store := &blockingStore{done: make(chan error, 1)}
+	addr := startTestServer(t, &Server{Handler: &RegisterHandler{Store: store}})
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	if _, err := c.Write(req); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	c.Close()
+
+	select {
+	case err := <-store.done:
+		if err != context.Canceled {
+			t.Errorf("store saw %v; want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("store not cancelled after the connection closed")
+	}
+}
+
+func TestStoreContextWriteTimeout(t *testing.T) {
+	req := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x03, 0x00, 0x00, 0x00, 0x01}
+
+	store := &blockingStore{done: make(chan error, 1)}
+	srv := &Server{Handler: &RegisterHandler{Store: store}, WriteTimeout: 50 * time.Millisecond}
+	addr := startTestServer(t, srv)
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+	if _, err := c.Write(req); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	select {
+	case err := <-store.done:
+		if err != context.DeadlineExceeded {
+			t.Errorf("store saw %v; want %v", err, context.DeadlineExceeded)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("store not cancelled after WriteTimeout")
+	}
+}
+
+func TestServerPipelinedRequests(t *testing.T) {
+	read := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x03, 0x00, 0x00, 0x00, 0x01}
+	expected := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x05, 0xFF, 0x03, 0x02, 0x12, 0x34}
+
+	// the second request arrives while the first is being served
+	h := &RegisterHandler{Holdings: []uint16{0x1234}}
+	addr := startTestServer(t, &Server{Handler: slowHandler{h, 100 * time.Millisecond}})
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+
+	c.Write(read)
+	time.Sleep(20 * time.Millisecond)
+	c.Write(read)
+	for i := 0; i < 2; i++ {
+		resp := make([]byte, len(expected))
+		if _, err := io.ReadFull(c, resp); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if !bytes.Equal(resp, expected) {
+			t.Errorf("Incorrect Response")
+		}
+	}
+}
+
+// encodingStore serves holding registers only through
+// AppendHoldingRegisters.
+type encodingStore struct {
+	sliceStore
+}
+
+func (s encodingStore) ReadHoldingRegisters(ctx context.Context, addr, quantity uint16) ([]uint16, error) {
+	return nil, errors.New("ReadHoldingRegisters called")
+}
+
+func TestStoreRegisterEncoder(t *testing.T) {
+	req := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x03, 0x00, 0x6B, 0x00, 0x03}
+	expected := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x09, 0xFF, 0x03, 0x06, 0x02, 0x2B, 0x00, 0x00, 0x00, 0x64}
+
+	data := &RegisterHandler{Holdings: append(make([]uint16, 0x6B), 0x022B, 0x0000, 0x0064)}
+	h := &RegisterHandler{Store: encodingStore{sliceStore{data}}}
+	br := bufio.NewReader(bytes.NewReader(req))
+	bw := bytes.Buffer{}
+	r, _ := ReadFrame(br)
+	w := &testResponseWriter{req: r, w: bufio.NewWriter(&bw)}
+
+	h.ServeModbus(w, r)
+	w.w.Flush()
+
+	if !bytes.Equal(bw.Bytes(), expected) {
+		t.Errorf("response % X; want % X", bw.Bytes(), expected)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	h := &RegisterHandler{Coils: []bool{true, false}, Inputs: []uint16{5}, Holdings: []uint16{1, 2, 3}}
+	// a write hook wrapping the slices
+	watch := NewWatchStore(h.DataStore())
+	h.Store = watch
+	addr := startTestServer(t, &Server{Handler: h})
+
+	c, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.WriteSingleRegister(ctx, 1, 0, 7); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := &RegisterHandler{Coils: make([]bool, 2), Inputs: make([]uint16, 1), Holdings: make([]uint16, 3)}
+	if err := h.Migrate(ctx, dst.DataStore()); err != nil {
+		t.Fatal(err)
+	}
+	if h.Holdings != nil {
+		t.Errorf("slices kept after migration")
+	}
+
+	// values are preserved and served from the new store
+	if values, err := c.ReadHoldingRegisters(ctx, 1, 0, 3); err != nil || values[0] != 7 || values[2] != 3 {
+		t.Errorf("ReadHoldingRegisters = %v, %v", values, err)
+	}
+	if values, err := c.ReadInputRegisters(ctx, 1, 0, 1); err != nil || values[0] != 5 {
+		t.Errorf("ReadInputRegisters = %v, %v", values, err)
+	}
+	// writes reach the new store through the hook
+	done := make(chan bool, 1)
+	go func() {
+		_, changed, _ := watch.Wait(ctx, HoldingRegisterTable, 1, 1, 5*time.Second)
+		done <- changed
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if err := c.WriteSingleRegister(ctx, 1, 1, 9); err != nil {
+		t.Fatal(err)
+	}
+	if !<-done {
+		t.Errorf("write after migration not seen by the hook")
+	}
+	if dst.Holdings[1] != 9 || !dst.Coils[0] {
+		t.Errorf("new store holds %v, %v", dst.Holdings, dst.Coils)
+	}
+}
+
+// coilStore has no input tables.
+type coilStore struct {
+	DataStore
+}
+
+func TestMigrateInputsReadOnly(t *testing.T) {
+	h := &RegisterHandler{Inputs: []uint16{1}}
+	dst := coilStore{(&RegisterHandler{Inputs: make([]uint16, 1)}).DataStore()}
+	if err := h.Migrate(context.Background(), dst); err != ErrInputsReadOnly {
+		t.Errorf("err should be ErrInputsReadOnly not %v", err)
+	}
+	if h.Inputs == nil {
+		t.Errorf("slices dropped by a failed migration")
+	}
+}
+
+func TestStoreReadLastAddress(t *testing.T) {
+	ctx := context.Background()
+	h := &RegisterHandler{
+		Coils:          make([]bool, 0x10000),
+		DiscreteInputs: make([]bool, 0x10000),
+		Inputs:         make([]uint16, 0x10000),
+		Holdings:       make([]uint16, 0x10000),
+	}
+	h.Coils[0xFFFF], h.DiscreteInputs[0xFFFF], h.Inputs[0xFFFF], h.Holdings[0xFFFF] = true, true, 7, 9
+	store := h.DataStore()
+
+	// reads ending at address 65535
+	if bits, err := store.ReadCoils(ctx, 0xF830, 0x7D0); err != nil || len(bits) != 0x7D0 || !bits[0x7CF] {
+		t.Errorf("ReadCoils ending at 65535: %d bits, %v", len(bits), err)
+	}
+	if bits, err := store.ReadDiscreteInputs(ctx, 0xFFFF, 1); err != nil || !bits[0] {
+		t.Errorf("ReadDiscreteInputs of 65535 = %v, %v", bits, err)
+	}
+	if regs, err := store.ReadInputRegisters(ctx, 0xFF83, 0x7D); err != nil || len(regs) != 0x7D || regs[0x7C] != 7 {
+		t.Errorf("ReadInputRegisters ending at 65535: %d registers, %v", len(regs), err)
+	}
+	if regs, err := store.ReadHoldingRegisters(ctx, 0xFFFF, 1); err != nil || regs[0] != 9 {
+		t.Errorf("ReadHoldingRegisters of 65535 = %v, %v", regs, err)
+	}
+}
+
+func TestStoreRegisterEncoderLastAddress(t *testing.T) {
+	h := &RegisterHandler{Holdings: make([]uint16, 0x10000)}
+	h.Holdings[0xFFFF] = 0x1234
+	c := dialTestServer(t, h)
+	regs, err := c.ReadHoldingRegisters(context.Background(), 1, 0xFF83, 0x7D)
+	if err != nil || len(regs) != 0x7D || regs[0x7C] != 0x1234 {
+		t.Errorf("ReadHoldingRegisters ending at 65535: %d registers, %v", len(regs), err)
+	}
+}
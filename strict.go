@@ -0,0 +1,29 @@
+package modbus
+
+import "errors"
+
+// errWrongProtocol marks frames whose protocol identifier is not Modbus.
+var errWrongProtocol = errors.New("modbus: protocol identifier is not Modbus")
+
+// checkStrict validates f as Server.Strict requires. Errors carrying a
+// *ModbusError are answered with its exception; errWrongProtocol frames
+// are dropped unanswered, as the specification requires.
+func (s *Server) checkStrict(f *Frame) error {
+	if !s.Strict {
+		return nil
+	}
+	if f.header.Pid != TcpPid {
+		return errWrongProtocol
+	}
+	switch FunctionCode(f.header.Fcode) {
+	case ReadExceptionStatus, ReportSlaveId:
+		if len(f.data) != 0 {
+			return illegalValue("modbus: %v takes no data", FunctionCode(f.header.Fcode))
+		}
+		return nil
+	}
+	if p := newRequestPDU(FunctionCode(f.header.Fcode)); p != nil {
+		return p.UnmarshalBinary(f.data)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package modbus
+
+import (
+	"bytes"
+	"sync/atomic"
+	"testing"
+)
+
+func TestServerStrict(t *testing.T) {
+	h := &countingHandler{Handler: &RegisterHandler{Holdings: make([]uint16, 0x100)}}
+	addr := startTestServer(t, &Server{Handler: h, Strict: true})
+
+	// too many registers
+	req := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x03, 0x00, 0x00, 0x00, 0x7E}
+	expected := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x03, 0xFF, 0x83, byte(IllegalDataValue)}
+	if resp := exchange(t, addr, req, len(expected)); !bytes.Equal(resp, expected) {
+		t.Errorf("response % X; want % X", resp, expected)
+	}
+
+	// byte count inconsistent with the quantity
+	req = []byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x09, 0xFF, 0x10, 0x00, 0x00, 0x00, 0x01, 0x04, 0x00, 0x01}
+	expected = []byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x03, 0xFF, 0x90, byte(IllegalDataValue)}
+	if resp := exchange(t, addr, req, len(expected)); !bytes.Equal(resp, expected) {
+		t.Errorf("response % X; want % X", resp, expected)
+	}
+
+	// a frame of another protocol is dropped, the next one answered
+	req = []byte{
+		0x00, 0x03, 0x00, 0x01, 0x00, 0x06, 0xFF, 0x03, 0x00, 0x00, 0x00, 0x01,
+		0x00, 0x04, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x03, 0x00, 0x00, 0x00, 0x01,
+	}
+	expected = []byte{0x00, 0x04, 0x00, 0x00, 0x00, 0x05, 0xFF, 0x03, 0x02, 0x00, 0x00}
+	if resp := exchange(t, addr, req, len(expected)); !bytes.Equal(resp, expected) {
+		t.Errorf("response % X; want % X", resp, expected)
+	}
+
+	if n := atomic.LoadInt32(&h.n); n != 1 {
+		t.Errorf("handler served %d requests; want 1", n)
+	}
+}
@@ -0,0 +1,189 @@
+package modbus
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// A TenantMux is a Handler hosting many independent logical devices, or
+// tenants, in one process, as device emulation farms do. Each request is
+// attributed to a tenant by the routes added, by the port it arrived
+// on, the identity of the master's TLS certificate or its unit
+// identifier, and is served by the tenant's Handler, which should use a
+// DataStore of its own. Each tenant has its own quotas and counters.
+//
+// Routes name tenants already added. They are tried in the order they
+// were added and the first matching one is used, so that a unit range
+// can be routed apart within a port by adding it first. Requests
+// matching no route are answered with GatewayPathUnavailable.
+//
+// A TenantMux may serve several Servers, e.g. one per listener port.
+type TenantMux struct {
+	mu      sync.RWMutex
+	tenants map[string]*Tenant
+	routes  []tenantRoute
+}
+
+// A Tenant is a logical device, or group of devices, of a TenantMux.
+// Its fields must not be changed once it is added.
+type Tenant struct {
+	Name    string
+	Handler Handler
+
+	// MaxRequestsPerSecond, if positive, bounds the sustained rate of
+	// requests of the tenant; MaxBurst requests, at least 1, may
+	// arrive at once. Requests over the rate are answered with
+	// SlaveBusy.
+	MaxRequestsPerSecond float64
+	MaxBurst             int
+
+	// MaxConcurrent, if positive, bounds the number of requests of the
+	// tenant handled at once. Further requests are answered with
+	// SlaveBusy.
+	MaxConcurrent int
+
+	mu     sync.Mutex // guards the following
+	stats  TenantStats
+	bucket tokenBucket // requests permitted by the rate
+}
+
+// TenantStats holds the counters a TenantMux keeps for each tenant.
+type TenantStats struct {
+	Requests   uint64 // number of requests attributed to the tenant
+	Exceptions uint64 // number of those answered with an exception
+	Rejected   uint64 // number of those rejected by a quota
+	Active     int    // number of requests being handled
+}
+
+// A tenantRoute attributes the requests it matches to a tenant.
+type tenantRoute struct {
+	tenant *Tenant
+	match  func(info ConnInfo, uid uint8) bool
+}
+
+// NewTenantMux allocates and returns a new TenantMux.
+func NewTenantMux() *TenantMux { return &TenantMux{tenants: make(map[string]*Tenant)} }
+
+// Add adds the tenant t. It panics if a tenant of the same name exists.
+func (m *TenantMux) Add(t *Tenant) {
+	if t.Handler == nil {
+		panic("modbus: nil handler")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.tenants[t.Name]; ok {
+		panic("modbus: multiple tenants named " + t.Name)
+	}
+	m.tenants[t.Name] = t
+}
+
+func (m *TenantMux) route(tenant string, match func(info ConnInfo, uid uint8) bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.tenants[tenant]
+	if !ok {
+		panic("modbus: unknown tenant " + tenant)
+	}
+	m.routes = append(m.routes, tenantRoute{t, match})
+}
+
+// RoutePort attributes the requests arriving on the local port to
+// tenant.
+func (m *TenantMux) RoutePort(port int, tenant string) {
+	m.route(tenant, func(info ConnInfo, uid uint8) bool {
+		a, ok := info.LocalAddr.(*net.TCPAddr)
+		return ok && a.Port == port
+	})
+}
+
+// RouteIdentity attributes the requests of masters presenting a TLS
+// certificate whose subject common name is identity to tenant.
+func (m *TenantMux) RouteIdentity(identity string, tenant string) {
+	m.route(tenant, func(info ConnInfo, uid uint8) bool {
+		return info.TLS != nil && len(info.TLS.PeerCertificates) > 0 &&
+			info.TLS.PeerCertificates[0].Subject.CommonName == identity
+	})
+}
+
+// RouteUnits attributes the requests for unit identifiers first through
+// last inclusive to tenant.
+func (m *TenantMux) RouteUnits(first, last uint8, tenant string) {
+	m.route(tenant, func(info ConnInfo, uid uint8) bool {
+		return uid >= first && uid <= last
+	})
+}
+
+// Tenant returns the tenant attributed a request with uid arriving on the
+// connection info describes, or nil if there is none.
+func (m *TenantMux) Tenant(info ConnInfo, uid uint8) *Tenant {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, r := range m.routes {
+		if r.match(info, uid) {
+			return r.tenant
+		}
+	}
+	return nil
+}
+
+// Stats returns the counters of the tenant named. The boolean result
+// reports whether it exists.
+func (m *TenantMux) Stats(tenant string) (TenantStats, bool) {
+	m.mu.RLock()
+	t, ok := m.tenants[tenant]
+	m.mu.RUnlock()
+	if !ok {
+		return TenantStats{}, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stats, true
+}
+
+func (m *TenantMux) ServeModbus(w ResponseWriter, r *Frame) {
+	info, _ := ContextConnInfo(r.Context())
+	t := m.Tenant(info, r.header.Uid)
+	if t == nil {
+		w.WriteException(GatewayPathUnavailable)
+		return
+	}
+	if !t.admit(time.Now()) {
+		w.WriteException(SlaveBusy)
+		return
+	}
+	defer func() { t.done(w.Header().Fcode&0x80 != 0) }()
+	t.Handler.ServeModbus(w, r)
+}
+
+// admit counts a request and reports whether the tenant's quotas permit
+// it. A request admitted must be followed by a call to done.
+func (t *Tenant) admit(now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stats.Requests++
+	if t.MaxConcurrent > 0 && t.stats.Active >= t.MaxConcurrent {
+		t.reject()
+		return false
+	}
+	if t.MaxRequestsPerSecond > 0 && !t.bucket.take(now, t.MaxRequestsPerSecond, t.MaxBurst) {
+		t.reject()
+		return false
+	}
+	t.stats.Active++
+	return true
+}
+
+func (t *Tenant) reject() {
+	t.stats.Rejected++
+	t.stats.Exceptions++
+}
+
+func (t *Tenant) done(exception bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stats.Active--
+	if exception {
+		t.stats.Exceptions++
+	}
+}
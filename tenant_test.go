@@ -0,0 +1,123 @@
+package modbus
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTenantMux(t *testing.T) {
+	m := NewTenantMux()
+	m.Add(&Tenant{Name: "units", Handler: &RegisterHandler{Holdings: []uint16{1}}})
+	m.Add(&Tenant{Name: "port", Handler: &RegisterHandler{Holdings: []uint16{2}}})
+	m.Add(&Tenant{Name: "limited", Handler: &RegisterHandler{Holdings: []uint16{3}},
+		MaxRequestsPerSecond: 0.001, MaxBurst: 2})
+	m.RouteUnits(1, 9, "units")
+	m.RouteUnits(50, 50, "limited")
+
+	addrA := startTestServer(t, &Server{Handler: m})
+	addrB := startTestServer(t, &Server{Handler: m})
+	_, port, _ := net.SplitHostPort(addrB)
+	p, _ := strconv.Atoi(port)
+	m.RoutePort(p, "port")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	read := func(addr string, uid uint8) (uint16, error) {
+		c, err := Dial(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		values, err := c.ReadHoldingRegisters(ctx, uid, 0, 1)
+		if err != nil {
+			return 0, err
+		}
+		return values[0], nil
+	}
+
+	for _, tt := range []struct {
+		addr  string
+		uid   uint8
+		value uint16
+		err   error
+	}{
+		{addrA, 1, 1, nil},
+		{addrB, 9, 1, nil}, // the unit route comes first
+		{addrB, 20, 2, nil},
+		{addrA, 20, 0, ErrGatewayPathUnavailable},
+		{addrA, 50, 3, nil},
+		{addrB, 50, 3, nil},
+		{addrA, 50, 0, ErrSlaveBusy}, // over the burst
+	} {
+		v, err := read(tt.addr, tt.uid)
+		if !errors.Is(err, tt.err) || err == nil && v != tt.value {
+			t.Errorf("read unit %d at %s = %d, %v; want %d, %v", tt.uid, tt.addr, v, err, tt.value, tt.err)
+		}
+	}
+
+	// tenants have their own stores
+	c, err := Dial(addrA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.WriteSingleRegister(ctx, 1, 0, 7); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := read(addrB, 20); err != nil || v != 2 {
+		t.Errorf("port tenant reads %d, %v after a write to the units tenant", v, err)
+	}
+
+	if s, ok := m.Stats("units"); !ok || s.Requests != 3 || s.Exceptions != 0 || s.Active != 0 {
+		t.Errorf("units stats %+v, %v", s, ok)
+	}
+	if s, _ := m.Stats("limited"); s.Requests != 3 || s.Rejected != 1 || s.Exceptions != 1 {
+		t.Errorf("limited stats %+v", s)
+	}
+	if _, ok := m.Stats("nobody"); ok {
+		t.Errorf("stats of an unknown tenant")
+	}
+}
+
+func TestTenantIdentity(t *testing.T) {
+	m := NewTenantMux()
+	m.Add(&Tenant{Name: "plant", Handler: &RegisterHandler{}})
+	m.RouteIdentity("plant-3", "plant")
+
+	cert := func(cn string) ConnInfo {
+		return ConnInfo{TLS: &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: cn}}},
+		}}
+	}
+	if tn := m.Tenant(cert("plant-3"), 1); tn == nil || tn.Name != "plant" {
+		t.Errorf("certificate of plant-3 attributed to %v", tn)
+	}
+	if tn := m.Tenant(cert("plant-4"), 1); tn != nil {
+		t.Errorf("certificate of plant-4 attributed to %s", tn.Name)
+	}
+	if tn := m.Tenant(ConnInfo{}, 1); tn != nil {
+		t.Errorf("plain connection attributed to %s", tn.Name)
+	}
+}
+
+func TestTenantConcurrency(t *testing.T) {
+	tn := &Tenant{Name: "t", MaxConcurrent: 1}
+	now := time.Now()
+	if !tn.admit(now) {
+		t.Fatal("first request rejected")
+	}
+	if tn.admit(now) {
+		t.Errorf("second concurrent request admitted")
+	}
+	tn.done(false)
+	if !tn.admit(now) {
+		t.Errorf("request rejected after the first completed")
+	}
+}
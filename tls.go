@@ -0,0 +1,101 @@
+package modbus
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+)
+
+// RoleOID is the X.509 extension carrying the role of a Modbus/TCP
+// Security certificate, as defined by the Modbus/TCP Security protocol
+// specification. The extension value is an ASN.1 UTF8String.
+var RoleOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 50316, 802, 1}
+
+// A RoleLimit restricts the masters presenting certificates carrying a
+// given role.
+type RoleLimit struct {
+	// MaxConns is the maximum number of concurrent connections for the
+	// role. Further connections are closed after the TLS handshake.
+	// Zero means no limit.
+	MaxConns int
+
+	// Writable lists the ranges the role may write. Write requests
+	// outside every range are rejected. If nil, the role may not write
+	// at all; use AllWritable to allow every write.
+	Writable []AddressRange
+}
+
+// AllWritable, assigned to RoleLimit.Writable, allows every coil and
+// holding register to be written.
+var AllWritable = []AddressRange{
+	{CoilTable, 0x0000, 0xFFFF},
+	{HoldingRegisterTable, 0x0000, 0xFFFF},
+}
+
+// errRoleForbidden rejects requests not permitted by the role policy.
+var errRoleForbidden = errors.New("modbus: request not permitted for role")
+
+// Role returns the Modbus/TCP Security role carried by cert, or "" if it
+// has none.
+func Role(cert *x509.Certificate) (string, error) {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(RoleOID) {
+			continue
+		}
+		var role string
+		if _, err := asn1.UnmarshalWithParams(ext.Value, &role, "utf8"); err != nil {
+			return "", err
+		}
+		return role, nil
+	}
+	return "", nil
+}
+
+// acquireRole takes a connection slot for role, reporting whether the
+// role's MaxConns permits another connection.
+func (s *Server) acquireRole(role string) bool {
+	limit, ok := s.Roles[role]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ok && limit.MaxConns > 0 && s.roleConns[role] >= limit.MaxConns {
+		return false
+	}
+	if s.roleConns == nil {
+		s.roleConns = make(map[string]int)
+	}
+	s.roleConns[role]++
+	return true
+}
+
+func (s *Server) releaseRole(role string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.roleConns[role]--
+}
+
+// authorizeRole applies the Roles policy to a request on a TLS
+// connection. Requests on plain connections and servers without a role
+// policy are always permitted.
+func (s *Server) authorizeRole(info ConnInfo, f *Frame) error {
+	if s.Roles == nil || info.TLS == nil {
+		return nil
+	}
+	limit, ok := s.Roles[info.Role]
+	if !ok {
+		return errRoleForbidden
+	}
+	if !isWriteFunction(FunctionCode(f.header.Fcode)) {
+		return nil
+	}
+	t, addr, num, ok := writeTarget(f)
+	if !ok {
+		// let the handler reject the malformed request
+		return nil
+	}
+	for _, r := range limit.Writable {
+		if r.Contains(t, addr, num) {
+			return nil
+		}
+	}
+	return errRoleForbidden
+}
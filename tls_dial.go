@@ -0,0 +1,27 @@
+//go:build !modbus_notls
+
+package modbus
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+)
+
+// clientTLS performs the client side of the TLS handshake over conn,
+// verifying the slave as the host of addr unless config names another.
+func clientTLS(ctx context.Context, conn net.Conn, config *tls.Config, addr string) (net.Conn, error) {
+	if config.ServerName == "" {
+		config = config.Clone()
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		config.ServerName = host
+	}
+	tc := tls.Client(conn, config)
+	if err := tc.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+	return tc, nil
+}
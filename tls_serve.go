@@ -0,0 +1,104 @@
+//go:build !modbus_notls
+
+package modbus
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+func init() {
+	capabilities = append(capabilities, CapabilityTLS)
+}
+
+// ListenAndServeTLS listens on the TCP network address srv.Addr and then
+// calls ServeTLS to handle requests on incoming Modbus/TCP Security
+// connections. If srv.Addr is blank, ":802" is used.
+func (srv *Server) ListenAndServeTLS(certFile, keyFile string) error {
+	addr := srv.Addr
+	if addr == "" {
+		addr = ":802"
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return srv.ServeTLS(ln, certFile, keyFile)
+}
+
+// ServeTLS accepts incoming connections on the Listener l and serves
+// Modbus/TCP Security on them. Certificate and key files are loaded
+// unless srv.TLSConfig already carries certificates. As the
+// specification mandates mutual authentication, a client certificate is
+// required unless srv.TLSConfig.ClientAuth says otherwise.
+func (srv *Server) ServeTLS(l net.Listener, certFile, keyFile string) error {
+	tl, err := srv.TLSListener(l, certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	return srv.Serve(tl)
+}
+
+// TLSListener returns a Listener serving Modbus/TCP Security on the
+// connections of l, configured as ServeTLS describes, for passing to
+// Serve or ServeAll alongside plain listeners.
+func (srv *Server) TLSListener(l net.Listener, certFile, keyFile string) (net.Listener, error) {
+	config := &tls.Config{}
+	if srv.TLSConfig != nil {
+		config = srv.TLSConfig.Clone()
+	}
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+	if config.ClientAuth == tls.NoClientCert {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if len(config.Certificates) == 0 && config.GetCertificate == nil {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return wrappedListener{tls.NewListener(srv.wrapListener(l), config)}, nil
+}
+
+// handshake completes the TLS handshake of c if it is a Modbus/TCP
+// Security connection, recording the connection state and role in c.info and
+// taking a connection slot for the role. It returns false if the
+// connection must be closed.
+func (c *conn) handshake() bool {
+	tlsConn, ok := c.rwc.(*tls.Conn)
+	if !ok {
+		return true
+	}
+	if d := c.server.ReadTimeout; d != 0 {
+		c.rwc.SetReadDeadline(time.Now().Add(d))
+	}
+	if d := c.server.WriteTimeout; d != 0 {
+		c.rwc.SetWriteDeadline(time.Now().Add(d))
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		c.server.logf("modbus: TLS handshake error from %s: %v", c.remoteAddr, err)
+		return false
+	}
+
+	state := tlsConn.ConnectionState()
+	c.info.TLS = &state
+	if len(state.PeerCertificates) > 0 {
+		role, err := Role(state.PeerCertificates[0])
+		if err != nil {
+			c.server.logf("modbus: bad role extension from %s: %v", c.remoteAddr, err)
+			return false
+		}
+		c.info.Role = role
+	}
+
+	if !c.server.acquireRole(c.info.Role) {
+		c.server.logf("modbus: connection limit reached for role %q, closing %s", c.info.Role, c.remoteAddr)
+		return false
+	}
+	c.roleHeld = true
+	return true
+}
@@ -0,0 +1,39 @@
+//go:build modbus_notls
+
+package modbus
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+)
+
+var errNoTLS = fmt.Errorf("modbus: Modbus/TCP Security %w: built with modbus_notls", errors.ErrUnsupported)
+
+// ListenAndServeTLS fails, as Modbus/TCP Security was excluded from the
+// build.
+func (srv *Server) ListenAndServeTLS(certFile, keyFile string) error {
+	return errNoTLS
+}
+
+// ServeTLS fails, as Modbus/TCP Security was excluded from the build.
+func (srv *Server) ServeTLS(l net.Listener, certFile, keyFile string) error {
+	return errNoTLS
+}
+
+// TLSListener fails, as Modbus/TCP Security was excluded from the build.
+func (srv *Server) TLSListener(l net.Listener, certFile, keyFile string) (net.Listener, error) {
+	return nil, errNoTLS
+}
+
+// clientTLS fails, as Modbus/TCP Security was excluded from the build.
+func clientTLS(ctx context.Context, conn net.Conn, config *tls.Config, addr string) (net.Conn, error) {
+	return nil, errNoTLS
+}
+
+// handshake accepts every connection, none being TLS.
+func (c *conn) handshake() bool {
+	return true
+}
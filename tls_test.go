@@ -0,0 +1,303 @@
+//go:build !modbus_notls
+
+package modbus
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// testPKI holds a CA and the key pair for certificates issued by it.
+type testPKI struct {
+	t      *testing.T
+	key    *ecdsa.PrivateKey
+	ca     *x509.Certificate
+	pool   *x509.CertPool
+	serial int64
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &testPKI{t: t, key: key}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ca, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	p.pool = x509.NewCertPool()
+	p.pool.AddCert(p.ca)
+	p.serial = 1
+	return p
+}
+
+// issue returns a certificate for a server, or for a client carrying
+// role if role is non empty.
+func (p *testPKI) issue(server bool, role string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	p.serial++
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(p.serial),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	if server {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		tmpl.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	} else {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+	if role != "" {
+		value, err := asn1.MarshalWithParams(role, "utf8")
+		if err != nil {
+			p.t.Fatal(err)
+		}
+		tmpl.ExtraExtensions = []pkix.Extension{{Id: RoleOID, Value: value}}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, p.ca, &key.PublicKey, p.key)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func startTestTLSServer(t *testing.T, srv *Server, p *testPKI) string {
+	srv.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{p.issue(true, "")},
+		ClientCAs:    p.pool,
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go srv.ServeTLS(l, "", "")
+	return l.Addr().String()
+}
+
+func dialTestTLS(t *testing.T, addr string, p *testPKI, role string) *tls.Conn {
+	c, err := tls.Dial("tcp", addr, &tls.Config{
+		Certificates: []tls.Certificate{p.issue(false, role)},
+		RootCAs:      p.pool,
+	})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	return c
+}
+
+func tlsExchange(t *testing.T, c net.Conn, req []byte, n int) []byte {
+	if _, err := c.Write(req); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	resp := make([]byte, n)
+	if _, err := io.ReadFull(c, resp); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return resp
+}
+
+func TestRole(t *testing.T) {
+	p := newTestPKI(t)
+	cert, _ := x509.ParseCertificate(p.issue(false, "operator").Certificate[0])
+	if role, err := Role(cert); err != nil || role != "operator" {
+		t.Errorf("Role = %q, %v; want operator", role, err)
+	}
+	if role, err := Role(p.ca); err != nil || role != "" {
+		t.Errorf("Role = %q, %v; want empty", role, err)
+	}
+}
+
+func TestServerRoleWritable(t *testing.T) {
+	allowed := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x06, 0x00, 0x05, 0xBE, 0xEF}
+	denied := []byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x0B, 0xFF, 0x10, 0x00, 0x09, 0x00, 0x02, 0x04, 0x00, 0x01, 0x00, 0x02}
+	deniedExpected := []byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x03, 0xFF, 0x90, byte(IllegalDataAddress)}
+	read := []byte{0x00, 0x03, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x03, 0x00, 0x05, 0x00, 0x01}
+	readExpected := []byte{0x00, 0x03, 0x00, 0x00, 0x00, 0x05, 0xFF, 0x03, 0x02, 0xBE, 0xEF}
+
+	p := newTestPKI(t)
+	h := &RegisterHandler{Holdings: make([]uint16, 20)}
+	srv := &Server{
+		Handler: h,
+		Roles: map[string]RoleLimit{
+			"operator": {Writable: []AddressRange{{HoldingRegisterTable, 0, 9}}},
+			"viewer":   {},
+		},
+		AuthorizeException: IllegalDataAddress,
+	}
+	addr := startTestTLSServer(t, srv, p)
+
+	c := dialTestTLS(t, addr, p, "operator")
+	if resp := tlsExchange(t, c, allowed, len(allowed)); !bytes.Equal(resp, allowed) {
+		t.Errorf("Incorrect Response")
+	}
+	if resp := tlsExchange(t, c, denied, len(deniedExpected)); !bytes.Equal(resp, deniedExpected) {
+		t.Errorf("Incorrect Response")
+	}
+	if h.Holdings[9] != 0 || h.Holdings[10] != 0 {
+		t.Errorf("write straddling the writable range should not be applied")
+	}
+
+	v := dialTestTLS(t, addr, p, "viewer")
+	if resp := tlsExchange(t, v, read, len(readExpected)); !bytes.Equal(resp, readExpected) {
+		t.Errorf("Incorrect Response")
+	}
+	writeExpected := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x03, 0xFF, 0x86, byte(IllegalDataAddress)}
+	if resp := tlsExchange(t, v, allowed, len(writeExpected)); !bytes.Equal(resp, writeExpected) {
+		t.Errorf("Incorrect Response")
+	}
+
+	u := dialTestTLS(t, addr, p, "guest")
+	readDenied := []byte{0x00, 0x03, 0x00, 0x00, 0x00, 0x03, 0xFF, 0x83, byte(IllegalDataAddress)}
+	if resp := tlsExchange(t, u, read, len(readDenied)); !bytes.Equal(resp, readDenied) {
+		t.Errorf("unknown role should be rejected")
+	}
+}
+
+func TestServerRoleMaxConns(t *testing.T) {
+	read := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x03, 0x00, 0x00, 0x00, 0x01}
+	readExpected := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x05, 0xFF, 0x03, 0x02, 0x12, 0x34}
+
+	p := newTestPKI(t)
+	srv := &Server{
+		Handler:  &RegisterHandler{Holdings: []uint16{0x1234}},
+		Roles:    map[string]RoleLimit{"operator": {MaxConns: 1}},
+		ErrorLog: log.New(io.Discard, "", 0),
+	}
+	addr := startTestTLSServer(t, srv, p)
+
+	first := dialTestTLS(t, addr, p, "operator")
+	if resp := tlsExchange(t, first, read, len(readExpected)); !bytes.Equal(resp, readExpected) {
+		t.Errorf("Incorrect Response")
+	}
+
+	second := dialTestTLS(t, addr, p, "operator")
+	second.Write(read)
+	if _, err := io.ReadFull(second, make([]byte, len(readExpected))); err == nil {
+		t.Errorf("connection over MaxConns should be closed")
+	}
+
+	first.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c := dialTestTLS(t, addr, p, "operator")
+		c.Write(read)
+		resp := make([]byte, len(readExpected))
+		if _, err := io.ReadFull(c, resp); err == nil {
+			if !bytes.Equal(resp, readExpected) {
+				t.Errorf("Incorrect Response")
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("slot not released after connection closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServerServeAll(t *testing.T) {
+	read := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x03, 0x00, 0x00, 0x00, 0x01}
+	readExpected := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x05, 0xFF, 0x03, 0x02, 0x12, 0x34}
+
+	p := newTestPKI(t)
+	srv := &Server{
+		Handler:   &RegisterHandler{Holdings: []uint16{0x1234}},
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{p.issue(true, "")}, ClientCAs: p.pool},
+		ErrorLog:  log.New(io.Discard, "", 0),
+	}
+	plain, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	secure, err := srv.TLSListener(l, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- srv.ServeAll(plain, secure) }()
+
+	if resp := exchange(t, plain.Addr().String(), read, len(readExpected)); !bytes.Equal(resp, readExpected) {
+		t.Errorf("plain response % X; want % X", resp, readExpected)
+	}
+	c := dialTestTLS(t, secure.Addr().String(), p, "")
+	if resp := tlsExchange(t, c, read, len(readExpected)); !bytes.Equal(resp, readExpected) {
+		t.Errorf("secure response % X; want % X", resp, readExpected)
+	}
+
+	// closing one listener closes the other
+	plain.Close()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("ServeAll returned nil")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("ServeAll did not return")
+	}
+	if _, err := net.Dial("tcp", secure.Addr().String()); err == nil {
+		t.Errorf("secure listener still open")
+	}
+}
+
+func TestDialerTLS(t *testing.T) {
+	p := newTestPKI(t)
+	h := &RegisterHandler{Holdings: []uint16{0xBEEF}}
+	addr := startTestTLSServer(t, &Server{Handler: h}, p)
+
+	d := &Dialer{TLSConfig: &tls.Config{
+		Certificates: []tls.Certificate{p.issue(false, "")},
+		RootCAs:      p.pool,
+	}}
+	c, err := d.Dial(context.Background(), addr)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+	if v, err := c.ReadHoldingRegisters(context.Background(), 1, 0, 1); err != nil || v[0] != 0xBEEF {
+		t.Errorf("ReadHoldingRegisters = %v, %v", v, err)
+	}
+
+	// the slave's certificate must match the address dialled
+	d.TLSConfig.ServerName = "elsewhere"
+	if _, err := d.Dial(context.Background(), addr); err == nil {
+		t.Errorf("Dial verified a certificate for another name")
+	}
+}
@@ -0,0 +1,150 @@
+package modbus
+
+import "context"
+
+// A ClientTrace is a set of hooks run at stages of the transactions made
+// with a context carrying it, see WithClientTrace. They are run by
+// ClientConn, and ClientPool for ConnectStart and ConnectDone, so that
+// spans of a tracing system can be started and ended around Modbus
+// transactions. Any hook may be nil. Hooks may be called concurrently
+// from different goroutines and must not block.
+type ClientTrace struct {
+	// ConnectStart is called when a ClientPool begins dialing a new
+	// connection for the transaction.
+	ConnectStart func(network, addr string)
+
+	// ConnectDone is called when that dial completes, with its error.
+	ConnectDone func(network, addr string, err error)
+
+	// FrameWritten is called after the request has been written to the
+	// connection, or failed to be, with its header carrying the
+	// transaction identifier assigned.
+	FrameWritten func(h Header, err error)
+
+	// FrameRead is called when the response has been read.
+	FrameRead func(h Header)
+
+	// ExceptionReturned is called after FrameRead for exception
+	// responses, with the exception code.
+	ExceptionReturned func(h Header, code ExceptionCode)
+}
+
+type clientTraceKey struct{}
+
+// WithClientTrace returns a context based on ctx carrying trace.
+// Transactions made with it run the hooks of trace, and those of any
+// ClientTrace already carried by ctx after them.
+func WithClientTrace(ctx context.Context, trace *ClientTrace) context.Context {
+	if trace == nil {
+		panic("modbus: nil trace")
+	}
+	if old := ContextClientTrace(ctx); old != nil {
+		trace = trace.compose(old)
+	}
+	return context.WithValue(ctx, clientTraceKey{}, trace)
+}
+
+// ContextClientTrace returns the ClientTrace carried by ctx, or nil.
+func ContextClientTrace(ctx context.Context) *ClientTrace {
+	trace, _ := ctx.Value(clientTraceKey{}).(*ClientTrace)
+	return trace
+}
+
+// compose returns a ClientTrace running the hooks of t, then old.
+func (t *ClientTrace) compose(old *ClientTrace) *ClientTrace {
+	c := *t
+	if f, g := t.ConnectStart, old.ConnectStart; f == nil {
+		c.ConnectStart = g
+	} else if g != nil {
+		c.ConnectStart = func(network, addr string) { f(network, addr); g(network, addr) }
+	}
+	if f, g := t.ConnectDone, old.ConnectDone; f == nil {
+		c.ConnectDone = g
+	} else if g != nil {
+		c.ConnectDone = func(network, addr string, err error) { f(network, addr, err); g(network, addr, err) }
+	}
+	if f, g := t.FrameWritten, old.FrameWritten; f == nil {
+		c.FrameWritten = g
+	} else if g != nil {
+		c.FrameWritten = func(h Header, err error) { f(h, err); g(h, err) }
+	}
+	if f, g := t.FrameRead, old.FrameRead; f == nil {
+		c.FrameRead = g
+	} else if g != nil {
+		c.FrameRead = func(h Header) { f(h); g(h) }
+	}
+	if f, g := t.ExceptionReturned, old.ExceptionReturned; f == nil {
+		c.ExceptionReturned = g
+	} else if g != nil {
+		c.ExceptionReturned = func(h Header, code ExceptionCode) { f(h, code); g(h, code) }
+	}
+	return &c
+}
+
+// A ServerTrace is a set of hooks run by a Server at stages of the
+// connections and requests it serves, see Server.Trace. Any hook may be
+// nil. Hooks are called from the connection's goroutine, so they are
+// called concurrently for different connections, and delay the
+// connection while they run.
+type ServerTrace struct {
+	// ConnectStart is called when a connection is accepted, before
+	// any TLS handshake, so info.TLS is nil.
+	ConnectStart func(info ConnInfo)
+
+	// FrameRead is called when a request has been read, before it is
+	// checked by Strict or Authorize. A frame exceeding MaxFrameBytes
+	// is reported with its data unread. A non nil context returned,
+	// which must be derived from ctx, replaces the request's context,
+	// so that a span started here is seen by the later hooks and the
+	// handler.
+	FrameRead func(ctx context.Context, f *Frame) context.Context
+
+	// HandlerStart is called just before the request is passed to the
+	// handler. Requests rejected by Strict or Authorize do not reach
+	// it.
+	HandlerStart func(ctx context.Context, f *Frame)
+
+	// FrameWritten is called once the response has been written to the
+	// connection, with its header and the connection's write error, if
+	// any. Requests left unanswered have a zero header.
+	FrameWritten func(ctx context.Context, h Header, err error)
+
+	// ExceptionReturned is called after FrameWritten for exception
+	// responses, with the exception code.
+	ExceptionReturned func(ctx context.Context, h Header, code ExceptionCode)
+}
+
+// traceFrameRead runs the FrameRead hook for the request of w.
+func (s *Server) traceFrameRead(w *response) {
+	if s.Trace == nil || s.Trace.FrameRead == nil {
+		return
+	}
+	if ctx := s.Trace.FrameRead(w.req.Context(), w.req); ctx != nil {
+		w.req.ctx = ctx
+	}
+}
+
+// traceResponse runs the FrameWritten and ExceptionReturned hooks for the
+// response w has written.
+func (s *Server) traceResponse(w *response) {
+	if s.Trace == nil {
+		return
+	}
+	ctx := w.req.Context()
+	if f := s.Trace.FrameWritten; f != nil {
+		f(ctx, w.header, w.conn.werr)
+	}
+	if f := s.Trace.ExceptionReturned; f != nil && w.wroteHeader && w.header.Fcode&0x80 != 0 {
+		f(ctx, w.header, w.status)
+	}
+}
+
+// response runs the FrameRead and ExceptionReturned hooks for resp.
+func (t *ClientTrace) response(resp *Frame) {
+	if t.FrameRead != nil {
+		t.FrameRead(resp.header)
+	}
+	if t.ExceptionReturned != nil && resp.header.Fcode&0x80 != 0 && len(resp.data) > 0 {
+		t.ExceptionReturned(resp.header, ExceptionCode(resp.data[0]))
+	}
+}
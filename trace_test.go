@@ -0,0 +1,100 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type traceKey struct{}
+
+// traceLog collects the hooks run, in order.
+type traceLog struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (l *traceLog) add(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, fmt.Sprintf(format, args...))
+}
+
+func (l *traceLog) get() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.events...)
+}
+
+func TestTrace(t *testing.T) {
+	var slog, clog traceLog
+	srv := &Server{
+		Handler: &RegisterHandler{Holdings: []uint16{7}},
+		Trace: &ServerTrace{
+			ConnectStart: func(ConnInfo) { slog.add("connect") },
+			FrameRead: func(ctx context.Context, f *Frame) context.Context {
+				slog.add("read fc=%d", f.Header().Fcode)
+				return context.WithValue(ctx, traceKey{}, "span")
+			},
+			HandlerStart: func(ctx context.Context, f *Frame) {
+				slog.add("handler %v", ctx.Value(traceKey{}))
+			},
+			FrameWritten: func(ctx context.Context, h Header, err error) {
+				slog.add("written fc=%d err=%v", h.Fcode, err)
+			},
+			ExceptionReturned: func(ctx context.Context, h Header, code ExceptionCode) {
+				slog.add("exception %d %v", code, ctx.Value(traceKey{}))
+			},
+		},
+	}
+	addr := startTestServer(t, srv)
+
+	c := &Client{Transport: &ClientPool{Addr: addr, Size: 1}}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ctx = WithClientTrace(ctx, &ClientTrace{
+		ConnectStart: func(network, addr string) { clog.add("connect") },
+		ConnectDone:  func(network, addr string, err error) { clog.add("connected err=%v", err) },
+		FrameWritten: func(h Header, err error) { clog.add("written fc=%d err=%v", h.Fcode, err) },
+		FrameRead:    func(h Header) { clog.add("read fc=%d", h.Fcode) },
+		ExceptionReturned: func(h Header, code ExceptionCode) {
+			clog.add("exception %d", code)
+		},
+	})
+	ctx = WithClientTrace(ctx, &ClientTrace{
+		FrameRead: func(h Header) { clog.add("outer read") },
+	})
+
+	if _, err := c.ReadHoldingRegisters(ctx, 1, 0, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ReadHoldingRegisters(ctx, 1, 5, 1); !errors.Is(err, ErrIllegalDataAddress) {
+		t.Fatalf("err should be ErrIllegalDataAddress not %v", err)
+	}
+
+	expected := []string{
+		"connect", "connected err=<nil>",
+		"written fc=3 err=<nil>", "outer read", "read fc=3",
+		"written fc=3 err=<nil>", "outer read", "read fc=131", "exception 2",
+	}
+	if events := clog.get(); !reflect.DeepEqual(events, expected) {
+		t.Errorf("client events %q; want %q", events, expected)
+	}
+	expected = []string{
+		"connect",
+		"read fc=3", "handler span", "written fc=3 err=<nil>",
+		"read fc=3", "handler span", "written fc=131 err=<nil>", "exception 2 span",
+	}
+	// the server's hooks run after the response is sent
+	for deadline := time.Now().Add(time.Second); len(slog.get()) < len(expected) && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if events := slog.get(); !reflect.DeepEqual(events, expected) {
+		t.Errorf("server events %q; want %q", events, expected)
+	}
+}
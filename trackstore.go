@@ -0,0 +1,188 @@
+package modbus
+
+import (
+	"context"
+	"math"
+	"sync"
+)
+
+// A TrackedStore is a DataStore keeping the minimum, maximum and rolling
+// average of selected input and holding registers of the DataStore it
+// wraps, as values are written through it. It is a cheap way to add
+// telemetry to emulated sensors.
+//
+// Input registers are tracked only when written with WriteInputRegisters,
+// which requires the wrapped store to implement InputWriter.
+type TrackedStore struct {
+	DataStore
+
+	mu      sync.Mutex
+	tracked map[Location]*tracker
+}
+
+// TrackOptions configure the tracking of a register.
+type TrackOptions struct {
+	// Window is the number of most recent values averaged. If zero,
+	// 10 is used.
+	Window int
+
+	// Companion, if non nil, is the first of three registers of the
+	// same table presenting the minimum, maximum and rolling average,
+	// rounded to the nearest integer, to masters. They must exist in
+	// the wrapped store; their stored values are replaced on read.
+	Companion *uint16
+}
+
+// RegisterStats summarise the values written to a tracked register.
+type RegisterStats struct {
+	Samples uint64  // number of values written
+	Min     uint16  // smallest value written
+	Max     uint16  // largest value written
+	Average float64 // average of the last Window values
+}
+
+type tracker struct {
+	stats     RegisterStats
+	window    []uint16 // ring of the last values
+	next      int      // index of the next value in window
+	companion *uint16
+}
+
+func (tr *tracker) add(v uint16) {
+	s := &tr.stats
+	if s.Samples == 0 || v < s.Min {
+		s.Min = v
+	}
+	if s.Samples == 0 || v > s.Max {
+		s.Max = v
+	}
+	s.Samples++
+
+	n := int(s.Samples)
+	if n > len(tr.window) {
+		n = len(tr.window)
+	}
+	tr.window[tr.next] = v
+	tr.next = (tr.next + 1) % len(tr.window)
+	sum := 0.0
+	for _, w := range tr.window[:n] {
+		sum += float64(w)
+	}
+	s.Average = sum / float64(n)
+}
+
+// companionValue returns the value of the companion register at offset i.
+func (tr *tracker) companionValue(i int) uint16 {
+	switch i {
+	case 0:
+		return tr.stats.Min
+	case 1:
+		return tr.stats.Max
+	}
+	return uint16(math.Round(tr.stats.Average))
+}
+
+// NewTrackedStore returns a TrackedStore wrapping s.
+func NewTrackedStore(s DataStore) *TrackedStore {
+	return &TrackedStore{DataStore: s, tracked: make(map[Location]*tracker)}
+}
+
+// Track starts tracking the register at addr of table t, which must be
+// InputRegisterTable or HoldingRegisterTable. Tracking a register again
+// resets its statistics.
+func (s *TrackedStore) Track(t Table, addr uint16, opts TrackOptions) {
+	if t != InputRegisterTable && t != HoldingRegisterTable {
+		panic("modbus: only registers can be tracked")
+	}
+	n := opts.Window
+	if n <= 0 {
+		n = 10
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tracked[Location{t, addr}] = &tracker{window: make([]uint16, n), companion: opts.Companion}
+}
+
+// Stats returns the statistics of the register at addr of table t. The
+// boolean result reports whether the register is tracked.
+func (s *TrackedStore) Stats(t Table, addr uint16) (RegisterStats, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tr, ok := s.tracked[Location{t, addr}]
+	if !ok {
+		return RegisterStats{}, false
+	}
+	return tr.stats, true
+}
+
+func (s *TrackedStore) record(t Table, addr uint16, values []uint16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, v := range values {
+		if tr, ok := s.tracked[Location{t, addr + uint16(i)}]; ok {
+			tr.add(v)
+		}
+	}
+}
+
+// overlay replaces the values of companion registers within the read of
+// values at addr of table t.
+func (s *TrackedStore) overlay(t Table, addr uint16, values []uint16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for loc, tr := range s.tracked {
+		if loc.Table != t || tr.companion == nil {
+			continue
+		}
+		for i := 0; i < 3; i++ {
+			a := int(*tr.companion) + i
+			if a >= int(addr) && a < int(addr)+len(values) {
+				values[a-int(addr)] = tr.companionValue(i)
+			}
+		}
+	}
+}
+
+func (s *TrackedStore) ReadInputRegisters(ctx context.Context, addr, quantity uint16) ([]uint16, error) {
+	values, err := s.DataStore.ReadInputRegisters(ctx, addr, quantity)
+	if err == nil {
+		s.overlay(InputRegisterTable, addr, values)
+	}
+	return values, err
+}
+
+func (s *TrackedStore) ReadHoldingRegisters(ctx context.Context, addr, quantity uint16) ([]uint16, error) {
+	values, err := s.DataStore.ReadHoldingRegisters(ctx, addr, quantity)
+	if err == nil {
+		s.overlay(HoldingRegisterTable, addr, values)
+	}
+	return values, err
+}
+
+func (s *TrackedStore) WriteHoldingRegisters(ctx context.Context, addr uint16, values []uint16) error {
+	if err := s.DataStore.WriteHoldingRegisters(ctx, addr, values); err != nil {
+		return err
+	}
+	s.record(HoldingRegisterTable, addr, values)
+	return nil
+}
+
+func (s *TrackedStore) WriteDiscreteInputs(ctx context.Context, addr uint16, values []bool) error {
+	iw, ok := s.DataStore.(InputWriter)
+	if !ok {
+		return ErrInputsReadOnly
+	}
+	return iw.WriteDiscreteInputs(ctx, addr, values)
+}
+
+func (s *TrackedStore) WriteInputRegisters(ctx context.Context, addr uint16, values []uint16) error {
+	iw, ok := s.DataStore.(InputWriter)
+	if !ok {
+		return ErrInputsReadOnly
+	}
+	if err := iw.WriteInputRegisters(ctx, addr, values); err != nil {
+		return err
+	}
+	s.record(InputRegisterTable, addr, values)
+	return nil
+}
@@ -0,0 +1,59 @@
+package modbus
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestTrackedStore(t *testing.T) {
+	ctx := context.Background()
+	h := &RegisterHandler{Inputs: make([]uint16, 8), Holdings: make([]uint16, 2)}
+	companion := uint16(4)
+	s := NewTrackedStore(h.DataStore())
+	s.Track(InputRegisterTable, 1, TrackOptions{Window: 2, Companion: &companion})
+	s.Track(HoldingRegisterTable, 0, TrackOptions{})
+	h.Store = s
+
+	for _, v := range []uint16{10, 40, 20} {
+		if err := s.WriteInputRegisters(ctx, 0, []uint16{0, v}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats, ok := s.Stats(InputRegisterTable, 1)
+	if !ok {
+		t.Fatalf("register should be tracked")
+	}
+	if expected := (RegisterStats{Samples: 3, Min: 10, Max: 40, Average: 30}); stats != expected {
+		t.Errorf("Stats = %+v; want %+v", stats, expected)
+	}
+	if _, ok := s.Stats(InputRegisterTable, 0); ok {
+		t.Errorf("register 0 should not be tracked")
+	}
+
+	// companions are presented to masters
+	values, err := s.ReadInputRegisters(ctx, 3, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(values, []uint16{0, 10, 40, 30}) {
+		t.Errorf("ReadInputRegisters = %v", values)
+	}
+
+	// master writes through the handler are tracked too
+	req := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x06, 0x00, 0x00, 0x00, 0x07}
+	br := bufio.NewReader(bytes.NewReader(req))
+	bw := bytes.Buffer{}
+	r, _ := ReadFrame(br)
+	w := &testResponseWriter{req: r, w: bufio.NewWriter(&bw)}
+	h.ServeModbus(w, r)
+	w.w.Flush()
+	if !bytes.Equal(bw.Bytes(), req) {
+		t.Errorf("Incorrect Response")
+	}
+	if stats, _ := s.Stats(HoldingRegisterTable, 0); stats.Samples != 1 || stats.Max != 7 {
+		t.Errorf("Stats = %+v after master write", stats)
+	}
+}
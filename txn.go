@@ -0,0 +1,130 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// A Transactor is a DataStore able to apply a set of changes all or
+// nothing: either the New value of every change is written or none is,
+// and reads see the values of none or all of them. ApplyAtomic uses it.
+type Transactor interface {
+	Transact(ctx context.Context, changes []Change) error
+}
+
+// ApplyAtomic writes the New value of every change to store, all or
+// nothing. If store is a Transactor, its Transact method applies them.
+// Otherwise the values replaced are read first, and the changes are
+// written as ApplyPatch does; if a write fails, the writes made are
+// undone, the failing one included if it changed values, before its
+// error is returned. Old values are not checked.
+//
+// Without a Transactor, reads of store may see some of the changes
+// while they are being written or undone, and undoing them may fail in
+// turn, which is reported alongside the error of the write.
+func ApplyAtomic(ctx context.Context, store DataStore, changes []Change) error {
+	if t, ok := store.(Transactor); ok {
+		return t.Transact(ctx, changes)
+	}
+	runs := patchRuns(changes)
+	olds := make([][]Change, len(runs))
+	for i, run := range runs {
+		old, err := readRun(ctx, store, run)
+		if err != nil {
+			return err
+		}
+		olds[i] = old
+	}
+	for i, run := range runs {
+		if err := applyRun(ctx, store, run); err != nil {
+			if rerr := rollback(ctx, store, olds[:i+1]); rerr != nil {
+				return fmt.Errorf("%w (rollback failed: %v)", err, rerr)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Transact applies changes to the store of h all or nothing, as
+// ApplyAtomic does, for applications updating related values together,
+// such as the two registers of a 32-bit value and a coil flagging it
+// valid. It is serialised with Mask Write Register requests, and the
+// writes of masters checked by OnWrite or made atomically. OnWrite is
+// not called.
+func (h *RegisterHandler) Transact(ctx context.Context, changes []Change) error {
+	h.rmw.Lock()
+	defer h.rmw.Unlock()
+	return ApplyAtomic(ctx, h.DataStore(), changes)
+}
+
+// writeChanges returns the changes writing values to table t from addr.
+func writeChanges(t Table, addr uint16, values []uint16) []Change {
+	changes := make([]Change, len(values))
+	for i, v := range values {
+		changes[i] = Change{Table: t, Address: addr + uint16(i), New: v}
+	}
+	return changes
+}
+
+// rollback writes back the values of olds, the runs written, the last of
+// them having failed, in reverse order. Of the failing run, only the
+// values it changed are written back.
+func rollback(ctx context.Context, store DataStore, olds [][]Change) error {
+	ctx = context.WithoutCancel(ctx) // ctx ending may have failed the write
+	last := olds[len(olds)-1]
+	now, err := readRun(ctx, store, last)
+	if err != nil {
+		return err
+	}
+	var changed []Change
+	for i, c := range now {
+		if c.New != last[i].New {
+			changed = append(changed, last[i])
+		}
+	}
+	var errs []error
+	undo := append(olds[:len(olds)-1:len(olds)-1], patchRuns(changed)...)
+	for i := len(undo) - 1; i >= 0; i-- {
+		if err := applyRun(ctx, store, undo[i]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// readRun returns the changes of run with their New values replaced by
+// the values held by store.
+func readRun(ctx context.Context, store DataStore, run []Change) ([]Change, error) {
+	t, addr, n := run[0].Table, run[0].Address, uint16(len(run))
+	var values []uint16
+	var err error
+	switch t {
+	case CoilTable, DiscreteInputTable:
+		var bits []bool
+		if t == CoilTable {
+			bits, err = store.ReadCoils(ctx, addr, n)
+		} else {
+			bits, err = store.ReadDiscreteInputs(ctx, addr, n)
+		}
+		values = bitsToValues(bits)
+	case HoldingRegisterTable:
+		values, err = store.ReadHoldingRegisters(ctx, addr, n)
+	case InputRegisterTable:
+		values, err = store.ReadInputRegisters(ctx, addr, n)
+	default:
+		return nil, fmt.Errorf("modbus: unknown table %d", uint8(t))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(values) < len(run) {
+		return nil, fmt.Errorf("modbus: store returned %d values of %d at %v %d", len(values), n, t, addr)
+	}
+	old := make([]Change, len(run))
+	for i, c := range run {
+		old[i] = Change{Table: t, Address: c.Address, Old: c.New, New: values[i]}
+	}
+	return old, nil
+}
@@ -0,0 +1,115 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// partialStore is a DataStore whose writes of holding registers reaching
+// address 10 or above fail after writing the registers below it.
+type partialStore struct {
+	DataStore
+}
+
+var errPartial = errors.New("backend down")
+
+func (s partialStore) WriteHoldingRegisters(ctx context.Context, addr uint16, values []uint16) error {
+	if end := int(addr) + len(values); end > 10 {
+		if addr < 10 {
+			s.DataStore.WriteHoldingRegisters(ctx, addr, values[:10-addr])
+		}
+		return errPartial
+	}
+	return s.DataStore.WriteHoldingRegisters(ctx, addr, values)
+}
+
+func newPartialStore(t *testing.T) partialStore {
+	s := new(SegmentedStore)
+	if err := s.AddRange(HoldingRegisterTable, 0, 16); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddRange(CoilTable, 0, 2); err != nil {
+		t.Fatal(err)
+	}
+	return partialStore{s}
+}
+
+func TestTransact(t *testing.T) {
+	ctx := context.Background()
+	seg := new(SegmentedStore)
+	seg.AddRange(HoldingRegisterTable, 0, 4)
+	seg.AddRange(CoilTable, 0, 2)
+	for _, h := range []*RegisterHandler{
+		{Holdings: make([]uint16, 4), Coils: make([]bool, 2)},
+		{Store: seg},
+	} {
+		store := h.DataStore()
+		err := h.Transact(ctx, []Change{
+			{Table: HoldingRegisterTable, Address: 1, New: 5},
+			{Table: CoilTable, Address: 1, New: 1},
+			{Table: HoldingRegisterTable, Address: 9, New: 7},
+		})
+		if !errors.Is(err, ErrIllegalDataAddress) {
+			t.Errorf("%T: Transact outside the store = %v", store, err)
+		}
+		regs, _ := store.ReadHoldingRegisters(ctx, 0, 4)
+		coils, _ := store.ReadCoils(ctx, 0, 2)
+		if !reflect.DeepEqual(regs, []uint16{0, 0, 0, 0}) || !reflect.DeepEqual(coils, []bool{false, false}) {
+			t.Errorf("%T: after failed Transact: %v %v", store, regs, coils)
+		}
+
+		err = h.Transact(ctx, []Change{
+			{Table: HoldingRegisterTable, Address: 1, New: 5},
+			{Table: HoldingRegisterTable, Address: 2, New: 6},
+			{Table: CoilTable, Address: 1, New: 1},
+		})
+		regs, _ = store.ReadHoldingRegisters(ctx, 0, 4)
+		coils, _ = store.ReadCoils(ctx, 0, 2)
+		if err != nil || !reflect.DeepEqual(regs, []uint16{0, 5, 6, 0}) || !reflect.DeepEqual(coils, []bool{false, true}) {
+			t.Errorf("%T: Transact = %v; then %v %v", store, err, regs, coils)
+		}
+	}
+}
+
+func TestApplyAtomicRollback(t *testing.T) {
+	ctx := context.Background()
+	store := newPartialStore(t)
+	store.WriteHoldingRegisters(ctx, 6, []uint16{1, 2, 3, 4})
+
+	err := ApplyAtomic(ctx, store, []Change{
+		{Table: CoilTable, Address: 0, New: 1},
+		{Table: HoldingRegisterTable, Address: 2, New: 20},
+		{Table: HoldingRegisterTable, Address: 8, New: 80},
+		{Table: HoldingRegisterTable, Address: 9, New: 90},
+		{Table: HoldingRegisterTable, Address: 10, New: 100},
+	})
+	if !errors.Is(err, errPartial) {
+		t.Errorf("ApplyAtomic = %v; want %v", err, errPartial)
+	}
+	regs, _ := store.ReadHoldingRegisters(ctx, 0, 16)
+	coils, _ := store.ReadCoils(ctx, 0, 1)
+	if want := []uint16{0, 0, 0, 0, 0, 0, 1, 2, 3, 4, 0, 0, 0, 0, 0, 0}; !reflect.DeepEqual(regs, want) || coils[0] {
+		t.Errorf("after rollback: %v %v; want %v [false]", regs, coils, want)
+	}
+}
+
+func TestRegisterHandlerAtomicWrites(t *testing.T) {
+	ctx := context.Background()
+	for _, atomic := range []bool{false, true} {
+		store := newPartialStore(t)
+		c := dialTestServer(t, &RegisterHandler{Store: store, AtomicWrites: atomic})
+		if err := c.WriteMultipleRegisters(ctx, 1, 8, []uint16{1, 2, 3}); !errors.Is(err, ErrSlaveFailure) {
+			t.Errorf("AtomicWrites %v: WriteMultipleRegisters = %v", atomic, err)
+		}
+		regs, _ := store.ReadHoldingRegisters(ctx, 8, 2)
+		want := []uint16{1, 2}
+		if atomic {
+			want = []uint16{0, 0}
+		}
+		if !reflect.DeepEqual(regs, want) {
+			t.Errorf("AtomicWrites %v: registers after failed write = %v; want %v", atomic, regs, want)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package modbus
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mubeta06/gomodbus/values"
+)
+
+// The typed methods of Client read and write values spanning consecutive
+// holding registers, laid out as order says. Values of input registers
+// are read with ReadInputRegisters and decoded with the methods of
+// values.Order.
+
+// readHoldings reads n holding registers at addr of unit uid.
+func (c *Client) readHoldings(ctx context.Context, uid uint8, addr uint16, n int) ([]uint16, error) {
+	regs, err := c.ReadHoldingRegisters(ctx, uid, addr, uint16(n))
+	if err != nil {
+		return nil, err
+	}
+	if len(regs) != n {
+		return nil, fmt.Errorf("modbus: read %d registers; want %d", len(regs), n)
+	}
+	return regs, nil
+}
+
+// ReadUint32 reads the uint32 held in the two holding registers at addr
+// of unit uid.
+func (c *Client) ReadUint32(ctx context.Context, uid uint8, addr uint16, order values.Order) (uint32, error) {
+	regs, err := c.readHoldings(ctx, uid, addr, 2)
+	if err != nil {
+		return 0, err
+	}
+	return order.Uint32(regs), nil
+}
+
+// ReadInt32 reads the int32 held in the two holding registers at addr of
+// unit uid.
+func (c *Client) ReadInt32(ctx context.Context, uid uint8, addr uint16, order values.Order) (int32, error) {
+	regs, err := c.readHoldings(ctx, uid, addr, 2)
+	if err != nil {
+		return 0, err
+	}
+	return order.Int32(regs), nil
+}
+
+// ReadFloat32 reads the float32 held in the two holding registers at addr
+// of unit uid.
+func (c *Client) ReadFloat32(ctx context.Context, uid uint8, addr uint16, order values.Order) (float32, error) {
+	regs, err := c.readHoldings(ctx, uid, addr, 2)
+	if err != nil {
+		return 0, err
+	}
+	return order.Float32(regs), nil
+}
+
+// ReadUint64 reads the uint64 held in the four holding registers at addr
+// of unit uid.
+func (c *Client) ReadUint64(ctx context.Context, uid uint8, addr uint16, order values.Order) (uint64, error) {
+	regs, err := c.readHoldings(ctx, uid, addr, 4)
+	if err != nil {
+		return 0, err
+	}
+	return order.Uint64(regs), nil
+}
+
+// ReadInt64 reads the int64 held in the four holding registers at addr of
+// unit uid.
+func (c *Client) ReadInt64(ctx context.Context, uid uint8, addr uint16, order values.Order) (int64, error) {
+	regs, err := c.readHoldings(ctx, uid, addr, 4)
+	if err != nil {
+		return 0, err
+	}
+	return order.Int64(regs), nil
+}
+
+// ReadFloat64 reads the float64 held in the four holding registers at
+// addr of unit uid.
+func (c *Client) ReadFloat64(ctx context.Context, uid uint8, addr uint16, order values.Order) (float64, error) {
+	regs, err := c.readHoldings(ctx, uid, addr, 4)
+	if err != nil {
+		return 0, err
+	}
+	return order.Float64(regs), nil
+}
+
+// ReadString reads a string of at most length bytes held two bytes per
+// holding register from addr of unit uid. The string ends at the first
+// NUL byte.
+func (c *Client) ReadString(ctx context.Context, uid uint8, addr, length uint16, order values.Order) (string, error) {
+	regs, err := c.readHoldings(ctx, uid, addr, (int(length)+1)/2)
+	if err != nil {
+		return "", err
+	}
+	s := order.ASCII(regs)
+	if len(s) > int(length) {
+		s = s[:length]
+	}
+	return s, nil
+}
+
+// WriteUint32 writes v to the two holding registers at addr of unit uid
+// with a single request.
+func (c *Client) WriteUint32(ctx context.Context, uid uint8, addr uint16, v uint32, order values.Order) error {
+	regs := make([]uint16, 2)
+	order.PutUint32(regs, v)
+	return c.WriteMultipleRegisters(ctx, uid, addr, regs)
+}
+
+// WriteInt32 writes v to the two holding registers at addr of unit uid
+// with a single request.
+func (c *Client) WriteInt32(ctx context.Context, uid uint8, addr uint16, v int32, order values.Order) error {
+	regs := make([]uint16, 2)
+	order.PutInt32(regs, v)
+	return c.WriteMultipleRegisters(ctx, uid, addr, regs)
+}
+
+// WriteFloat32 writes v to the two holding registers at addr of unit uid
+// with a single request.
+func (c *Client) WriteFloat32(ctx context.Context, uid uint8, addr uint16, v float32, order values.Order) error {
+	regs := make([]uint16, 2)
+	order.PutFloat32(regs, v)
+	return c.WriteMultipleRegisters(ctx, uid, addr, regs)
+}
+
+// WriteUint64 writes v to the four holding registers at addr of unit uid
+// with a single request.
+func (c *Client) WriteUint64(ctx context.Context, uid uint8, addr uint16, v uint64, order values.Order) error {
+	regs := make([]uint16, 4)
+	order.PutUint64(regs, v)
+	return c.WriteMultipleRegisters(ctx, uid, addr, regs)
+}
+
+// WriteInt64 writes v to the four holding registers at addr of unit uid
+// with a single request.
+func (c *Client) WriteInt64(ctx context.Context, uid uint8, addr uint16, v int64, order values.Order) error {
+	regs := make([]uint16, 4)
+	order.PutInt64(regs, v)
+	return c.WriteMultipleRegisters(ctx, uid, addr, regs)
+}
+
+// WriteFloat64 writes v to the four holding registers at addr of unit uid
+// with a single request.
+func (c *Client) WriteFloat64(ctx context.Context, uid uint8, addr uint16, v float64, order values.Order) error {
+	regs := make([]uint16, 4)
+	order.PutFloat64(regs, v)
+	return c.WriteMultipleRegisters(ctx, uid, addr, regs)
+}
+
+// WriteString writes s, padded with NUL bytes to length bytes, two bytes
+// per holding register from addr of unit uid with a single request. It
+// fails without writing if s is longer than length.
+func (c *Client) WriteString(ctx context.Context, uid uint8, addr, length uint16, s string, order values.Order) error {
+	if len(s) > int(length) {
+		return fmt.Errorf("modbus: string of %d bytes exceeds length %d", len(s), length)
+	}
+	regs := make([]uint16, (int(length)+1)/2)
+	order.PutASCII(regs, s)
+	return c.WriteMultipleRegisters(ctx, uid, addr, regs)
+}
@@ -0,0 +1,54 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/mubeta06/gomodbus/values"
+)
+
+func TestClientTyped(t *testing.T) {
+	h := &RegisterHandler{Holdings: make([]uint16, 16)}
+	c := dialTestServer(t, h)
+	ctx := context.Background()
+
+	if err := c.WriteFloat32(ctx, 1, 0, 1.5, values.CDAB); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []uint16{0x0000, 0x3FC0}; !reflect.DeepEqual(h.Holdings[:2], expected) {
+		t.Errorf("float32 written as %04X; want %04X", h.Holdings[:2], expected)
+	}
+	if v, err := c.ReadFloat32(ctx, 1, 0, values.CDAB); err != nil || v != 1.5 {
+		t.Errorf("ReadFloat32 = %v, %v; want 1.5", v, err)
+	}
+	if err := c.WriteInt64(ctx, 1, 2, -2, values.ABCD); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.ReadInt64(ctx, 1, 2, values.ABCD); err != nil || v != -2 {
+		t.Errorf("ReadInt64 = %v, %v; want -2", v, err)
+	}
+	if v, err := c.ReadUint32(ctx, 1, 4, values.ABCD); err != nil || v != 0xFFFFFFFE {
+		t.Errorf("ReadUint32 = %#x, %v; want 0xfffffffe", v, err)
+	}
+
+	if err := c.WriteString(ctx, 1, 8, 7, "pump", values.ABCD); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []uint16{0x7075, 0x6D70, 0, 0}; !reflect.DeepEqual(h.Holdings[8:12], expected) {
+		t.Errorf("string written as %04X; want %04X", h.Holdings[8:12], expected)
+	}
+	if s, err := c.ReadString(ctx, 1, 8, 7, values.ABCD); err != nil || s != "pump" {
+		t.Errorf("ReadString = %q, %v; want pump", s, err)
+	}
+	if s, err := c.ReadString(ctx, 1, 8, 3, values.ABCD); err != nil || s != "pum" {
+		t.Errorf("ReadString of 3 bytes = %q, %v; want pum", s, err)
+	}
+	if err := c.WriteString(ctx, 1, 8, 3, "pump", values.ABCD); err == nil {
+		t.Errorf("string longer than its length written")
+	}
+	if _, err := c.ReadFloat64(ctx, 1, 14, values.ABCD); !errors.Is(err, ErrIllegalDataAddress) {
+		t.Errorf("ReadFloat64 past the registers = %v; want %v", err, ErrIllegalDataAddress)
+	}
+}
@@ -0,0 +1,34 @@
+package modbus
+
+import "errors"
+
+// errOtherUnit marks requests addressed to a unit the server does not
+// answer as.
+var errOtherUnit = errors.New("modbus: request addressed to another unit")
+
+// NoUnitUid is the unit identifier of requests addressed to a Modbus TCP
+// device itself rather than to a unit behind it, which every Server
+// answers regardless of UnitIDs.
+const NoUnitUid uint8 = 0xFF
+
+// checkUnit checks that f is addressed to one of s.UnitIDs. Errors
+// carrying a *ModbusError are answered with its exception;
+// errOtherUnit requests are dropped unanswered.
+func (s *Server) checkUnit(f *Frame) error {
+	if len(s.UnitIDs) == 0 {
+		return nil
+	}
+	uid := f.header.Uid
+	if uid == NoUnitUid || uid == BroadcastUid || uid == s.HealthUnit {
+		return nil
+	}
+	for _, id := range s.UnitIDs {
+		if uid == id {
+			return nil
+		}
+	}
+	if s.RejectOtherUnits {
+		return ErrGatewayTargetFailed
+	}
+	return errOtherUnit
+}
@@ -0,0 +1,39 @@
+package modbus
+
+import (
+	"bytes"
+	"sync/atomic"
+	"testing"
+)
+
+func TestServerUnitIDs(t *testing.T) {
+	h := &countingHandler{Handler: &RegisterHandler{Holdings: make([]uint16, 0x10)}}
+	addr := startTestServer(t, &Server{Handler: h, UnitIDs: []uint8{3, 7}})
+
+	// a request to another unit is dropped, the next one answered
+	req := []byte{
+		0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x05, 0x03, 0x00, 0x00, 0x00, 0x01,
+		0x00, 0x02, 0x00, 0x00, 0x00, 0x06, 0x07, 0x03, 0x00, 0x00, 0x00, 0x01,
+	}
+	expected := []byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x05, 0x07, 0x03, 0x02, 0x00, 0x00}
+	if resp := exchange(t, addr, req, len(expected)); !bytes.Equal(resp, expected) {
+		t.Errorf("response % X; want % X", resp, expected)
+	}
+
+	// the device itself is always answered
+	req = []byte{0x00, 0x03, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x03, 0x00, 0x00, 0x00, 0x01}
+	expected = []byte{0x00, 0x03, 0x00, 0x00, 0x00, 0x05, 0xFF, 0x03, 0x02, 0x00, 0x00}
+	if resp := exchange(t, addr, req, len(expected)); !bytes.Equal(resp, expected) {
+		t.Errorf("response % X; want % X", resp, expected)
+	}
+	if n := atomic.LoadInt32(&h.n); n != 2 {
+		t.Errorf("handler served %d requests; want 2", n)
+	}
+
+	addr = startTestServer(t, &Server{Handler: h, UnitIDs: []uint8{3}, RejectOtherUnits: true})
+	req = []byte{0x00, 0x04, 0x00, 0x00, 0x00, 0x06, 0x05, 0x03, 0x00, 0x00, 0x00, 0x01}
+	expected = []byte{0x00, 0x04, 0x00, 0x00, 0x00, 0x03, 0x05, 0x83, byte(GatewayTargetFailed)}
+	if resp := exchange(t, addr, req, len(expected)); !bytes.Equal(resp, expected) {
+		t.Errorf("response % X; want % X", resp, expected)
+	}
+}
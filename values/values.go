@@ -0,0 +1,171 @@
+// Package values converts between Go values and the 16 bit registers of
+// the Modbus data model.
+//
+// Modbus itself only defines single registers, transmitted most
+// significant byte first. Devices storing wider values across several
+// registers disagree on the order of the registers and of the bytes
+// within them; an Order names one of the four layouts found in practice
+// after the order in which the bytes A (most significant) to D of a 32
+// bit value appear.
+package values
+
+import (
+	"math"
+	"strings"
+)
+
+// An Order is a layout of multi register values.
+type Order uint8
+
+const (
+	ABCD Order = iota // most significant word first, big-endian registers
+	DCBA              // least significant word first, byte swapped registers
+	BADC              // most significant word first, byte swapped registers
+	CDAB              // least significant word first, big-endian registers
+)
+
+var orderName = map[Order]string{
+	ABCD: "ABCD",
+	DCBA: "DCBA",
+	BADC: "BADC",
+	CDAB: "CDAB",
+}
+
+func (o Order) String() string {
+	return orderName[o]
+}
+
+// wordSwap reports whether o stores the least significant word first.
+func (o Order) wordSwap() bool { return o == DCBA || o == CDAB }
+
+// byteSwap reports whether o stores the least significant byte of each
+// register first.
+func (o Order) byteSwap() bool { return o == DCBA || o == BADC }
+
+// put stores the n low registers of bits in regs.
+func (o Order) put(regs []uint16, bits uint64, n int) {
+	_ = regs[n-1] // bounds check
+	for i := 0; i < n; i++ {
+		r := uint16(bits >> (16 * (n - 1 - i)))
+		if o.byteSwap() {
+			r = r>>8 | r<<8
+		}
+		if o.wordSwap() {
+			regs[n-1-i] = r
+		} else {
+			regs[i] = r
+		}
+	}
+}
+
+// get loads a value of n registers from regs.
+func (o Order) get(regs []uint16, n int) uint64 {
+	_ = regs[n-1] // bounds check
+	var bits uint64
+	for i := 0; i < n; i++ {
+		r := regs[i]
+		if o.wordSwap() {
+			r = regs[n-1-i]
+		}
+		if o.byteSwap() {
+			r = r>>8 | r<<8
+		}
+		bits = bits<<16 | uint64(r)
+	}
+	return bits
+}
+
+// Uint16 decodes the register regs[0].
+func (o Order) Uint16(regs []uint16) uint16 { return uint16(o.get(regs, 1)) }
+
+// PutUint16 encodes v into regs[0].
+func (o Order) PutUint16(regs []uint16, v uint16) { o.put(regs, uint64(v), 1) }
+
+// Int16 decodes the register regs[0].
+func (o Order) Int16(regs []uint16) int16 { return int16(o.get(regs, 1)) }
+
+// PutInt16 encodes v into regs[0].
+func (o Order) PutInt16(regs []uint16, v int16) { o.put(regs, uint64(uint16(v)), 1) }
+
+// Uint32 decodes the registers regs[0:2].
+func (o Order) Uint32(regs []uint16) uint32 { return uint32(o.get(regs, 2)) }
+
+// PutUint32 encodes v into regs[0:2].
+func (o Order) PutUint32(regs []uint16, v uint32) { o.put(regs, uint64(v), 2) }
+
+// Int32 decodes the registers regs[0:2].
+func (o Order) Int32(regs []uint16) int32 { return int32(o.get(regs, 2)) }
+
+// PutInt32 encodes v into regs[0:2].
+func (o Order) PutInt32(regs []uint16, v int32) { o.put(regs, uint64(uint32(v)), 2) }
+
+// Float32 decodes the IEEE 754 value in regs[0:2].
+func (o Order) Float32(regs []uint16) float32 {
+	return math.Float32frombits(uint32(o.get(regs, 2)))
+}
+
+// PutFloat32 encodes v into regs[0:2] in IEEE 754 format.
+func (o Order) PutFloat32(regs []uint16, v float32) {
+	o.put(regs, uint64(math.Float32bits(v)), 2)
+}
+
+// Uint64 decodes the registers regs[0:4].
+func (o Order) Uint64(regs []uint16) uint64 { return o.get(regs, 4) }
+
+// PutUint64 encodes v into regs[0:4].
+func (o Order) PutUint64(regs []uint16, v uint64) { o.put(regs, v, 4) }
+
+// Int64 decodes the registers regs[0:4].
+func (o Order) Int64(regs []uint16) int64 { return int64(o.get(regs, 4)) }
+
+// PutInt64 encodes v into regs[0:4].
+func (o Order) PutInt64(regs []uint16, v int64) { o.put(regs, uint64(v), 4) }
+
+// Float64 decodes the IEEE 754 value in regs[0:4].
+func (o Order) Float64(regs []uint16) float64 {
+	return math.Float64frombits(o.get(regs, 4))
+}
+
+// PutFloat64 encodes v into regs[0:4] in IEEE 754 format.
+func (o Order) PutFloat64(regs []uint16, v float64) {
+	o.put(regs, math.Float64bits(v), 4)
+}
+
+// ASCII decodes a string of two bytes per register, stopping at the
+// first NUL byte. Strings are sequences of registers, so only the byte
+// order within registers applies.
+func (o Order) ASCII(regs []uint16) string {
+	var b strings.Builder
+	for _, r := range regs {
+		hi, lo := byte(r>>8), byte(r)
+		if o.byteSwap() {
+			hi, lo = lo, hi
+		}
+		for _, c := range [2]byte{hi, lo} {
+			if c == 0 {
+				return b.String()
+			}
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// PutASCII encodes s two bytes per register into regs, padding with NUL
+// bytes. It reports whether s fit; if not, s is truncated.
+func (o Order) PutASCII(regs []uint16, s string) bool {
+	for i := range regs {
+		var hi, lo byte
+		if 2*i < len(s) {
+			hi = s[2*i]
+		}
+		if 2*i+1 < len(s) {
+			lo = s[2*i+1]
+		}
+		if o.byteSwap() {
+			hi, lo = lo, hi
+		}
+		regs[i] = uint16(hi)<<8 | uint16(lo)
+	}
+	return len(s) <= 2*len(regs)
+}
@@ -0,0 +1,83 @@
+package values
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOrders(t *testing.T) {
+	tests := []struct {
+		o    Order
+		regs []uint16
+	}{
+		{ABCD, []uint16{0x1122, 0x3344}},
+		{DCBA, []uint16{0x4433, 0x2211}},
+		{BADC, []uint16{0x2211, 0x4433}},
+		{CDAB, []uint16{0x3344, 0x1122}},
+	}
+	for _, tt := range tests {
+		regs := make([]uint16, 2)
+		tt.o.PutUint32(regs, 0x11223344)
+		if !reflect.DeepEqual(regs, tt.regs) {
+			t.Errorf("%v PutUint32 = %04X; want %04X", tt.o, regs, tt.regs)
+		}
+		if v := tt.o.Uint32(regs); v != 0x11223344 {
+			t.Errorf("%v Uint32 = 0x%08X", tt.o, v)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, o := range []Order{ABCD, DCBA, BADC, CDAB} {
+		regs := make([]uint16, 4)
+		if o.PutInt16(regs, -300); o.Int16(regs) != -300 {
+			t.Errorf("%v int16 round trip", o)
+		}
+		if o.PutInt32(regs, -70000); o.Int32(regs) != -70000 {
+			t.Errorf("%v int32 round trip", o)
+		}
+		if o.PutFloat32(regs, 3.25); o.Float32(regs) != 3.25 {
+			t.Errorf("%v float32 round trip", o)
+		}
+		if o.PutInt64(regs, -1<<40); o.Int64(regs) != -1<<40 {
+			t.Errorf("%v int64 round trip", o)
+		}
+		if o.PutFloat64(regs, -2.5e100); o.Float64(regs) != -2.5e100 {
+			t.Errorf("%v float64 round trip", o)
+		}
+	}
+
+	regs := make([]uint16, 4)
+	ABCD.PutFloat64(regs, 1.5)
+	if !reflect.DeepEqual(regs, []uint16{0x3FF8, 0, 0, 0}) {
+		t.Errorf("ABCD PutFloat64 = %04X", regs)
+	}
+	CDAB.PutUint64(regs, 0x1111222233334444)
+	if !reflect.DeepEqual(regs, []uint16{0x4444, 0x3333, 0x2222, 0x1111}) {
+		t.Errorf("CDAB PutUint64 = %04X", regs)
+	}
+}
+
+func TestASCII(t *testing.T) {
+	regs := make([]uint16, 3)
+	if !ABCD.PutASCII(regs, "PUMP1") {
+		t.Errorf("PutASCII should report the string fit")
+	}
+	if !reflect.DeepEqual(regs, []uint16{0x5055, 0x4D50, 0x3100}) {
+		t.Errorf("PutASCII = %04X", regs)
+	}
+	if s := ABCD.ASCII(regs); s != "PUMP1" {
+		t.Errorf("ASCII = %q", s)
+	}
+
+	BADC.PutASCII(regs, "PUMP1")
+	if regs[0] != 0x5550 {
+		t.Errorf("BADC PutASCII = %04X", regs)
+	}
+	if s := BADC.ASCII(regs); s != "PUMP1" {
+		t.Errorf("BADC ASCII = %q", s)
+	}
+	if ABCD.PutASCII(regs, "PUMPSTATION") {
+		t.Errorf("PutASCII should report truncation")
+	}
+}
@@ -0,0 +1,117 @@
+package modbus
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// WarnThresholds configure warnings about masters whose behaviour, while
+// valid, suggests a misconfiguration: requests for unusually many values
+// or a single value written unusually often. Warnings never fail a
+// request; they help to spot such masters before enforcing hard limits.
+type WarnThresholds struct {
+	// Quantity, if positive, warns about requests reading or writing
+	// more than Quantity coils or registers.
+	Quantity int
+
+	// WriteRate, if positive, warns once a second about every coil or
+	// register of a unit written more than WriteRate times within that
+	// second.
+	WriteRate int
+
+	// Warn, if non nil, is called for every warning. Otherwise warnings
+	// are logged to the Server's ErrorLog. Warn is called from the
+	// connection's goroutine before the request is handled and must
+	// not retain the Frame.
+	Warn func(Warning)
+}
+
+// A Warning describes a request exceeding one of the Server's
+// WarnThresholds.
+type Warning struct {
+	Conn   ConnInfo
+	Frame  *Frame
+	Reason string
+}
+
+// writeRates counts writes per unit and location within the current
+// second.
+type writeRates struct {
+	mu     sync.Mutex
+	window time.Time // start of the current second
+	counts map[unitLocation]int
+}
+
+type unitLocation struct {
+	uid uint8
+	loc Location
+}
+
+// add counts a write of num values at addr of table t by unit uid,
+// returning the first location whose count just exceeded limit.
+func (r *writeRates) add(now time.Time, uid uint8, t Table, addr, num uint16, limit int) (Location, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if now.Sub(r.window) >= time.Second || r.counts == nil {
+		r.window = now
+		r.counts = make(map[unitLocation]int)
+	}
+	var exceeded Location
+	var ok bool
+	for i := 0; i < int(num); i++ {
+		key := unitLocation{uid, Location{t, addr + uint16(i)}}
+		r.counts[key]++
+		if r.counts[key] == limit+1 && !ok {
+			exceeded, ok = key.loc, true
+		}
+	}
+	return exceeded, ok
+}
+
+// requestQuantity returns the number of coils or registers read or
+// written by the request f, or 0 if f is not a well formed request.
+func requestQuantity(f *Frame) int {
+	if _, _, num, ok := writeTarget(f); ok {
+		if FunctionCode(f.header.Fcode) == WriteAndReadRegisters {
+			if read := int(f.data[2])<<8 | int(f.data[3]); read > int(num) {
+				return read
+			}
+		}
+		return int(num)
+	}
+	switch FunctionCode(f.header.Fcode) {
+	case ReadCoils, ReadDiscreteInputs, ReadHoldingRegisters, ReadInputRegisters:
+		if r, err := NewRequest(f); err == nil {
+			return int(r.Number())
+		}
+	}
+	return 0
+}
+
+// checkWarnings reports the ways the request f exceeds s.Warnings.
+func (s *Server) checkWarnings(info ConnInfo, f *Frame) {
+	th := &s.Warnings
+	if th.Quantity > 0 {
+		if n := requestQuantity(f); n > th.Quantity {
+			s.warn(info, f, fmt.Sprintf("quantity %d exceeds %d", n, th.Quantity))
+		}
+	}
+	if th.WriteRate > 0 {
+		if t, addr, num, ok := writeTarget(f); ok {
+			if loc, ok := s.writeRates.add(time.Now(), f.header.Uid, t, addr, num, th.WriteRate); ok {
+				s.warn(info, f, fmt.Sprintf("%v written more than %d times per second", loc, th.WriteRate))
+			}
+		}
+	}
+}
+
+func (s *Server) warn(info ConnInfo, f *Frame, reason string) {
+	if s.Warnings.Warn != nil {
+		s.Warnings.Warn(Warning{Conn: info, Frame: f, Reason: reason})
+		return
+	}
+	h := f.header
+	s.logf("modbus: warning: request from %s: tid=%d uid=%d fc=%v: %s",
+		info.RemoteAddr, h.Tid, h.Uid, FunctionCode(h.Fcode), reason)
+}
@@ -0,0 +1,51 @@
+package modbus
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestServerWarnings(t *testing.T) {
+	read := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x03, 0x00, 0x00, 0x00, 0x03}
+	readExpected := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x09, 0xFF, 0x03, 0x06, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	write := []byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x06, 0x00, 0x01, 0xBE, 0xEF}
+
+	var mu sync.Mutex
+	var reasons []string
+	srv := &Server{
+		Handler: &RegisterHandler{Holdings: make([]uint16, 3)},
+		Warnings: WarnThresholds{
+			Quantity:  2,
+			WriteRate: 2,
+			Warn: func(w Warning) {
+				mu.Lock()
+				defer mu.Unlock()
+				reasons = append(reasons, w.Reason)
+			},
+		},
+	}
+	addr := startTestServer(t, srv)
+
+	if resp := exchange(t, addr, read, len(readExpected)); !bytes.Equal(resp, readExpected) {
+		t.Errorf("request over the quantity threshold should still be served")
+	}
+	for i := 0; i < 4; i++ {
+		if resp := exchange(t, addr, write, len(write)); !bytes.Equal(resp, write) {
+			t.Errorf("Incorrect Response")
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reasons) != 2 {
+		t.Fatalf("got warnings %q; want 2", reasons)
+	}
+	if !strings.Contains(reasons[0], "quantity 3") {
+		t.Errorf("unexpected quantity warning %q", reasons[0])
+	}
+	if !strings.Contains(reasons[1], "holding registers[1]") {
+		t.Errorf("unexpected write rate warning %q", reasons[1])
+	}
+}
@@ -0,0 +1,340 @@
+package modbus
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// WaitForChange is the function code of an extension, in the user
+// defined range of the specification, letting a master wait for a range
+// of registers to change instead of polling it. Its request carries the
+// function code reading the range, Read Holding Registers or Read Input
+// Registers, the address and quantity, and the longest wait in
+// milliseconds. The response carries 1 if a register changed or 0 if the
+// wait timed out, then the current values of the range as Read Holding
+// Registers does. It is served by WatchStore.Middleware and issued by
+// Client.WaitForChange; other devices answer it with IllegalFunction.
+const WaitForChange FunctionCode = 0x41
+
+// A WatchStore is a DataStore noticing the writes made through it, so
+// that requests can wait for registers to change and the application can
+// subscribe to the writes of masters, see Subscribe. Registers changed by
+// other paths to the wrapped store are not noticed.
+type WatchStore struct {
+	DataStore
+
+	mu      sync.Mutex
+	changed chan struct{} // closed and replaced by every write
+	subs    []*subscription
+}
+
+// A ChangeEvent reports a write to coils or holding registers seen by a
+// subscriber of a WatchStore. It covers the part of the write within the
+// subscribed range: Values are the values written from Address on, 0 or
+// 1 for coils. Writes leaving the values unchanged are reported too.
+type ChangeEvent struct {
+	Table   Table
+	Address uint16
+	Values  []uint16
+}
+
+// subscriptionBuffer is the number of events held for a subscriber.
+const subscriptionBuffer = 64
+
+type subscription struct {
+	table        Table
+	start, count uint16
+	c            chan ChangeEvent
+}
+
+// NewWatchStore returns a WatchStore wrapping s.
+func NewWatchStore(s DataStore) *WatchStore {
+	return &WatchStore{DataStore: s, changed: make(chan struct{})}
+}
+
+// writes returns a channel closed by the next write.
+func (s *WatchStore) writes() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.changed
+}
+
+func (s *WatchStore) notify() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// checkWatchTable returns an error unless registers of table t can be
+// waited for.
+func checkWatchTable(t Table) error {
+	if t != InputRegisterTable && t != HoldingRegisterTable {
+		return fmt.Errorf("modbus: %v cannot be watched, only registers", t)
+	}
+	return nil
+}
+
+func (s *WatchStore) readRegisters(ctx context.Context, t Table, addr, quantity uint16) ([]uint16, error) {
+	if t == InputRegisterTable {
+		return s.DataStore.ReadInputRegisters(ctx, addr, quantity)
+	}
+	return s.DataStore.ReadHoldingRegisters(ctx, addr, quantity)
+}
+
+// Wait waits for any of the quantity registers at addr of table t,
+// InputRegisterTable or HoldingRegisterTable, to differ from their
+// values when Wait was called, or for timeout to elapse. It returns the
+// registers' values and whether they changed. Writes leaving the values
+// unchanged do not end the wait. Other tables cannot be watched.
+func (s *WatchStore) Wait(ctx context.Context, t Table, addr, quantity uint16, timeout time.Duration) ([]uint16, bool, error) {
+	if err := checkWatchTable(t); err != nil {
+		return nil, false, err
+	}
+	// take the channel before reading, so no write is missed
+	writes := s.writes()
+	old, err := s.readRegisters(ctx, t, addr, quantity)
+	if err != nil {
+		return nil, false, err
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-writes:
+		case <-timer.C:
+			return old, false, nil
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+		writes = s.writes()
+		values, err := s.readRegisters(ctx, t, addr, quantity)
+		if err != nil {
+			return nil, false, err
+		}
+		for i := range values {
+			if values[i] != old[i] {
+				return values, true, nil
+			}
+		}
+	}
+}
+
+// Subscribe returns a channel receiving a ChangeEvent for every write
+// to the count coils or holding registers of table t starting at start.
+// Events are sent once the write has been applied. A subscriber falling
+// more than 64 events behind misses the later ones rather than hold up
+// the masters writing. The channel is closed by Unsubscribe.
+func (s *WatchStore) Subscribe(t Table, start, count uint16) <-chan ChangeEvent {
+	if t != CoilTable && t != HoldingRegisterTable {
+		panic("modbus: only coils and holding registers can be subscribed to")
+	}
+	sub := &subscription{table: t, start: start, count: count, c: make(chan ChangeEvent, subscriptionBuffer)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subs = append(s.subs, sub)
+	return sub.c
+}
+
+// Unsubscribe stops the events sent to c, a channel returned by
+// Subscribe, and closes it.
+func (s *WatchStore) Unsubscribe(c <-chan ChangeEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, sub := range s.subs {
+		if sub.c == c {
+			close(sub.c)
+			s.subs = append(s.subs[:i:i], s.subs[i+1:]...)
+			return
+		}
+	}
+}
+
+// publish sends the write of values at addr of table t to the
+// subscribers of the addresses written.
+func (s *WatchStore) publish(t Table, addr uint16, values []uint16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	end := int(addr) + len(values)
+	for _, sub := range s.subs {
+		from, to := int(sub.start), int(sub.start)+int(sub.count)
+		if sub.table != t || to <= int(addr) || end <= from {
+			continue
+		}
+		from, to = max(from, int(addr)), min(to, end)
+		e := ChangeEvent{Table: t, Address: uint16(from), Values: values[from-int(addr) : to-int(addr) : to-int(addr)]}
+		select {
+		case sub.c <- e:
+		default:
+		}
+	}
+}
+
+func (s *WatchStore) WriteCoils(ctx context.Context, addr uint16, values []bool) error {
+	if err := s.DataStore.WriteCoils(ctx, addr, values); err != nil {
+		return err
+	}
+	s.notify()
+	if s.subscribed() {
+		regs := make([]uint16, len(values))
+		for i, v := range values {
+			if v {
+				regs[i] = 1
+			}
+		}
+		s.publish(CoilTable, addr, regs)
+	}
+	return nil
+}
+
+func (s *WatchStore) WriteHoldingRegisters(ctx context.Context, addr uint16, values []uint16) error {
+	if err := s.DataStore.WriteHoldingRegisters(ctx, addr, values); err != nil {
+		return err
+	}
+	s.notify()
+	if s.subscribed() {
+		s.publish(HoldingRegisterTable, addr, append([]uint16(nil), values...))
+	}
+	return nil
+}
+
+func (s *WatchStore) subscribed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.subs) > 0
+}
+
+func (s *WatchStore) WriteDiscreteInputs(ctx context.Context, addr uint16, values []bool) error {
+	iw, ok := s.DataStore.(InputWriter)
+	if !ok {
+		return ErrInputsReadOnly
+	}
+	if err := iw.WriteDiscreteInputs(ctx, addr, values); err != nil {
+		return err
+	}
+	s.notify()
+	return nil
+}
+
+func (s *WatchStore) WriteInputRegisters(ctx context.Context, addr uint16, values []uint16) error {
+	iw, ok := s.DataStore.(InputWriter)
+	if !ok {
+		return ErrInputsReadOnly
+	}
+	if err := iw.WriteInputRegisters(ctx, addr, values); err != nil {
+		return err
+	}
+	s.notify()
+	return nil
+}
+
+// A WaitForChangeRequest is the request of the WaitForChange extension.
+type WaitForChangeRequest struct {
+	Table    Table // InputRegisterTable or HoldingRegisterTable
+	Addr     uint16
+	Quantity uint16
+	Timeout  time.Duration // whole milliseconds, at most 65535
+}
+
+func (r *WaitForChangeRequest) FunctionCode() FunctionCode { return WaitForChange }
+
+func (r *WaitForChangeRequest) MarshalBinary() ([]byte, error) {
+	if err := checkWatchTable(r.Table); err != nil {
+		return nil, err
+	}
+	fcode := ReadHoldingRegisters
+	if r.Table == InputRegisterTable {
+		fcode = ReadInputRegisters
+	}
+	ms := r.Timeout / time.Millisecond
+	if ms > 0xFFFF {
+		ms = 0xFFFF
+	}
+	data := make([]byte, 7)
+	data[0] = byte(fcode)
+	copy(data[1:], addrQuantity(r.Addr, r.Quantity))
+	binary.BigEndian.PutUint16(data[5:], uint16(ms))
+	return data, nil
+}
+
+func (r *WaitForChangeRequest) UnmarshalBinary(data []byte) error {
+	if len(data) != 7 {
+		return illegalValue("modbus: wait for change length %d", len(data))
+	}
+	switch FunctionCode(data[0]) {
+	case ReadHoldingRegisters:
+		r.Table = HoldingRegisterTable
+	case ReadInputRegisters:
+		r.Table = InputRegisterTable
+	default:
+		return illegalValue("modbus: wait for change of %v", FunctionCode(data[0]))
+	}
+	var rr readRequest
+	if err := rr.unmarshal(data[1:5], MaxReadRegisters); err != nil {
+		return err
+	}
+	r.Addr, r.Quantity = rr.Addr, rr.Quantity
+	r.Timeout = time.Duration(binary.BigEndian.Uint16(data[5:])) * time.Millisecond
+	return nil
+}
+
+// Middleware is a Middleware answering WaitForChange requests from s,
+// and passing other requests to the handler. A master waiting holds the
+// connection's handler, so Server.WriteTimeout, if set, and the master's
+// transaction timeout must exceed the waits requested.
+func (s *WatchStore) Middleware(h Handler) Handler {
+	return HandlerFunc(func(w ResponseWriter, r *Frame) {
+		if FunctionCode(r.header.Fcode) != WaitForChange {
+			h.ServeModbus(w, r)
+			return
+		}
+		var req WaitForChangeRequest
+		if err := req.UnmarshalBinary(r.data); err != nil {
+			WriteError(w, err)
+			return
+		}
+		values, changed, err := s.Wait(r.Context(), req.Table, req.Addr, req.Quantity, req.Timeout)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		data := make([]byte, 2+2*len(values))
+		if changed {
+			data[0] = 1
+		}
+		data[1] = byte(2 * len(values))
+		encodeRegisters(data[2:], values)
+		w.Write(data)
+	})
+}
+
+// WaitForChange waits up to timeout for any of quantity registers at
+// addr of table t, InputRegisterTable or HoldingRegisterTable, to
+// change, using the WaitForChange extension. It returns their values and
+// whether they changed. The slave must serve the extension, see
+// WatchStore.Middleware, and ctx and the transport's timeout must allow
+// for the wait. Other tables cannot be waited for.
+func (c *Client) WaitForChange(ctx context.Context, uid uint8, t Table, addr, quantity uint16, timeout time.Duration) ([]uint16, bool, error) {
+	if err := checkWatchTable(t); err != nil {
+		return nil, false, err
+	}
+	addr, err := c.Addressing.Protocol(t, addr)
+	if err != nil {
+		return nil, false, err
+	}
+	req, err := NewPDUFrame(uid, &WaitForChangeRequest{Table: t, Addr: addr, Quantity: quantity, Timeout: timeout})
+	if err != nil {
+		return nil, false, err
+	}
+	data, err := c.send(ctx, req)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(data) != 2+2*int(quantity) || int(data[1]) != 2*int(quantity) || data[0] > 1 {
+		return nil, false, errMalformedResponse
+	}
+	return decodeRegisters(data[2:]), data[0] == 1, nil
+}
@@ -0,0 +1,179 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWaitForChange(t *testing.T) {
+	h := &RegisterHandler{Holdings: []uint16{1, 2, 3, 4}}
+	store := NewWatchStore(h.DataStore())
+	h.Store = store
+	srv := &Server{Handler: h}
+	srv.Use(store.Middleware)
+	addr := startTestServer(t, srv)
+
+	waiter, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer waiter.Close()
+	writer, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// nothing changes
+	start := time.Now()
+	values, changed, err := waiter.WaitForChange(ctx, 1, HoldingRegisterTable, 1, 2, 50*time.Millisecond)
+	if err != nil || changed || !reflect.DeepEqual(values, []uint16{2, 3}) {
+		t.Errorf("WaitForChange = %v, %v, %v; want [2 3], false", values, changed, err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("wait timed out after %v", d)
+	}
+
+	type result struct {
+		values  []uint16
+		changed bool
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		values, changed, err := waiter.WaitForChange(ctx, 1, HoldingRegisterTable, 1, 2, 5*time.Second)
+		done <- result{values, changed, err}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	// neither a write outside the range nor one of the same value ends
+	// the wait
+	if err := writer.WriteSingleRegister(ctx, 1, 0, 9); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.WriteSingleRegister(ctx, 1, 2, 3); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case r := <-done:
+		t.Fatalf("wait ended early: %+v", r)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := writer.WriteSingleRegister(ctx, 1, 2, 7); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case r := <-done:
+		if r.err != nil || !r.changed || !reflect.DeepEqual(r.values, []uint16{2, 7}) {
+			t.Errorf("WaitForChange = %+v; want [2 7], true", r)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("wait not ended by the change")
+	}
+
+	// validated like a read
+	if _, _, err := waiter.WaitForChange(ctx, 1, HoldingRegisterTable, 3, 2, time.Second); !errors.Is(err, ErrIllegalDataAddress) {
+		t.Errorf("err should be ErrIllegalDataAddress not %v", err)
+	}
+	if _, _, err := writer.WaitForChange(ctx, 1, HoldingRegisterTable, 0, 0, time.Second); !errors.Is(err, ErrIllegalDataValue) {
+		t.Errorf("err should be ErrIllegalDataValue not %v", err)
+	}
+}
+
+func TestWaitForChangeUnsupported(t *testing.T) {
+	addr := startTestServer(t, &Server{Handler: &RegisterHandler{Inputs: []uint16{1}}})
+	c, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, _, err := c.WaitForChange(ctx, 1, InputRegisterTable, 0, 1, time.Second); !errors.Is(err, ErrIllegalFunction) {
+		t.Errorf("err should be ErrIllegalFunction not %v", err)
+	}
+	// not sent, so not answered with an exception
+	if _, _, err := c.WaitForChange(ctx, 1, CoilTable, 0, 1, time.Second); err == nil || errors.Is(err, ErrIllegalFunction) {
+		t.Errorf("WaitForChange of coils = %v; want an error before sending", err)
+	}
+}
+
+func TestWaitForChangeRequest(t *testing.T) {
+	req := WaitForChangeRequest{Table: InputRegisterTable, Addr: 0x10, Quantity: 3, Timeout: 1500 * time.Millisecond}
+	data, _ := req.MarshalBinary()
+	expected := []byte{0x04, 0x00, 0x10, 0x00, 0x03, 0x05, 0xDC}
+	if !reflect.DeepEqual(data, expected) {
+		t.Errorf("MarshalBinary = % X; want % X", data, expected)
+	}
+	var got WaitForChangeRequest
+	if err := got.UnmarshalBinary(data); err != nil || got != req {
+		t.Errorf("UnmarshalBinary = %+v, %v", got, err)
+	}
+	data[0] = byte(ReadCoils)
+	if err := got.UnmarshalBinary(data); !errors.Is(err, ErrIllegalDataValue) {
+		t.Errorf("err should be ErrIllegalDataValue not %v", err)
+	}
+	req.Table = DiscreteInputTable
+	if _, err := req.MarshalBinary(); err == nil {
+		t.Errorf("MarshalBinary of discrete inputs succeeded")
+	}
+	s := NewWatchStore((&RegisterHandler{Coils: []bool{false}}).DataStore())
+	if _, _, err := s.Wait(context.Background(), CoilTable, 0, 1, time.Second); err == nil {
+		t.Errorf("Wait for coils succeeded")
+	}
+}
+
+func TestWatchStoreSubscribe(t *testing.T) {
+	h := &RegisterHandler{Coils: make([]bool, 16), Holdings: make([]uint16, 10)}
+	store := NewWatchStore(h.DataStore())
+	h.Store = store
+	addr := startTestServer(t, &Server{Handler: h})
+	regs := store.Subscribe(HoldingRegisterTable, 2, 3)
+	coils := store.Subscribe(CoilTable, 8, 8)
+
+	c, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.WriteMultipleRegisters(ctx, 1, 0, []uint16{1, 2, 3, 4}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WriteSingleRegister(ctx, 1, 9, 5); err != nil { // outside the range
+		t.Fatal(err)
+	}
+	if err := c.WriteMultipleCoils(ctx, 1, 6, []bool{true, true, true}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case e := <-regs:
+		if want := (ChangeEvent{HoldingRegisterTable, 2, []uint16{3, 4}}); !reflect.DeepEqual(e, want) {
+			t.Errorf("register event %+v; want %+v", e, want)
+		}
+	case <-ctx.Done():
+		t.Fatal("no register event")
+	}
+	select {
+	case e := <-coils:
+		if want := (ChangeEvent{CoilTable, 8, []uint16{1}}); !reflect.DeepEqual(e, want) {
+			t.Errorf("coil event %+v; want %+v", e, want)
+		}
+	case <-ctx.Done():
+		t.Fatal("no coil event")
+	}
+
+	store.Unsubscribe(regs)
+	if err := c.WriteSingleRegister(ctx, 1, 2, 6); err != nil {
+		t.Fatal(err)
+	}
+	if e, ok := <-regs; ok {
+		t.Errorf("event %+v after Unsubscribe", e)
+	}
+}
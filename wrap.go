@@ -0,0 +1,53 @@
+package modbus
+
+import "net"
+
+// A ConnWrapper wraps a connection in a custom link layer, such as a
+// proprietary encryption or compression scheme, before Modbus frames are
+// exchanged over it. It may fail, for instance if a handshake with the
+// peer fails, in which case the connection is closed.
+type ConnWrapper func(net.Conn) (net.Conn, error)
+
+// A wrappedListener is a Listener whose connections a Server's
+// TCPKeepAlive and ConnWrapper are already applied to, below TLS.
+type wrappedListener struct {
+	net.Listener
+}
+
+// wrapListener returns l with srv.TCPKeepAlive and srv.ConnWrapper
+// applied to its connections, unless it is a wrappedListener.
+func (srv *Server) wrapListener(l net.Listener) net.Listener {
+	if _, ok := l.(wrappedListener); ok {
+		return l
+	}
+	if srv.TCPKeepAlive != 0 {
+		l = tcpKeepAliveListener{l, srv.TCPKeepAlive}
+	}
+	if srv.ConnWrapper == nil {
+		return l
+	}
+	return &wrappingListener{l, srv}
+}
+
+// A wrappingListener applies a Server's ConnWrapper to the connections
+// it accepts. Connections the wrapper rejects are closed and logged, and
+// accepting continues.
+type wrappingListener struct {
+	net.Listener
+	srv *Server
+}
+
+func (l *wrappingListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		wrapped, err := l.srv.ConnWrapper(c)
+		if err == nil {
+			return wrapped, nil
+		}
+		l.srv.logf("modbus: wrapping connection from %s: %v", c.RemoteAddr(), err)
+		c.Close()
+	}
+}
@@ -0,0 +1,87 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+)
+
+// xorConn scrambles every byte exchanged, standing in for a custom link
+// layer.
+type xorConn struct {
+	net.Conn
+}
+
+func (c xorConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	for i := range p[:n] {
+		p[i] ^= 0x5A
+	}
+	return n, err
+}
+
+func (c xorConn) Write(p []byte) (int, error) {
+	q := make([]byte, len(p))
+	for i := range p {
+		q[i] = p[i] ^ 0x5A
+	}
+	return c.Conn.Write(q)
+}
+
+func xorWrapper(c net.Conn) (net.Conn, error) { return xorConn{c}, nil }
+
+func TestConnWrapper(t *testing.T) {
+	srv := &Server{
+		Handler:     &RegisterHandler{Holdings: []uint16{0x1234}},
+		ConnWrapper: xorWrapper,
+	}
+	addr := startTestServer(t, srv)
+
+	c := &Client{Transport: &ClientPool{Addr: addr, ConnWrapper: xorWrapper}}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	values, err := c.ReadHoldingRegisters(ctx, 1, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values[0] != 0x1234 {
+		t.Errorf("read 0x%04X; want 0x1234", values[0])
+	}
+}
+
+func TestConnWrapperError(t *testing.T) {
+	read := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0xFF, 0x03, 0x00, 0x00, 0x00, 0x01}
+
+	reject := true
+	srv := &Server{
+		Handler:  &RegisterHandler{Holdings: []uint16{0x1234}},
+		ErrorLog: log.New(io.Discard, "", 0),
+		ConnWrapper: func(c net.Conn) (net.Conn, error) {
+			if reject {
+				reject = false
+				return nil, errors.New("handshake failed")
+			}
+			return c, nil
+		},
+	}
+	addr := startTestServer(t, srv)
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	c.Write(read)
+	if _, err := io.ReadFull(c, make([]byte, 11)); err == nil {
+		t.Errorf("rejected connection should be closed")
+	}
+
+	// the server keeps accepting
+	exchange(t, addr, read, 11)
+}